@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"hello-wasm-enclave/internal/drain"
+	"hello-wasm-enclave/internal/logging"
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Bound on an operator request to each enclave replica
+const adminTimeout = 30 * time.Second
+
+// adminServer exposes operations on the running host and its enclaves to
+// operators, on a listener of its own that clients are not meant to reach.
+// Every request carries the admin token as a bearer token.
+//
+//	GET    /admin/modules               compiled modules each enclave caches
+//	DELETE /admin/modules[?module_hash=H]  evict them, or only those of H
+//	DELETE /admin/responses             forget the host's cached responses
+//	GET    /admin/sessions              sessions alive in each enclave
+//	DELETE /admin/sessions/HANDLE       kill the session of HANDLE
+//	POST   /admin/drain                 drain and exit, as on SIGTERM
+//	GET    /admin/metrics               Prometheus metrics
+//	POST   /admin/signing-key           rotate each enclave's receipt signing key
+//	POST   /admin/reload-auth           read -auth-file again
+//
+// Enclave operations go to every replica, or to the one of ?enclave=ID.
+type adminServer struct {
+	host        *HostService
+	tokenSHA256 []byte
+	drainer     *drain.Drainer
+}
+
+// adminReplicaResult is what one enclave replica answered
+type adminReplicaResult struct {
+	CachedModules []protocol.CachedModule `json:"cached_modules,omitempty"`
+	Sessions      []protocol.SessionInfo  `json:"sessions,omitempty"`
+	Evicted       int                     `json:"evicted,omitempty"`
+	SigningKey    string                  `json:"signing_key,omitempty"`
+	Attestation   string                  `json:"attestation,omitempty"`
+	Error         string                  `json:"error,omitempty"`
+}
+
+type adminEnclavesResponse struct {
+	Enclaves map[string]adminReplicaResult `json:"enclaves"`
+}
+
+type adminCountResponse struct {
+	Count int `json:"count"`
+}
+
+type adminStatusResponse struct {
+	Status string `json:"status"`
+}
+
+// serveAdmin listens on addr and serves the admin API until the listener
+// fails. Draining does not stop it, so that operators can watch a drain.
+func serveAdmin(addr string, host *HostService, tokenSHA256 string, tlsConfig *tls.Config, drainer *drain.Drainer) error {
+	digest, err := hex.DecodeString(tokenSHA256)
+	if err != nil || len(digest) != sha256.Size {
+		return fmt.Errorf("-admin-token-sha256 must be the hex SHA-256 of the admin token")
+	}
+	s := &adminServer{host: host, tokenSHA256: digest, drainer: drainer}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/modules", s.handleModules)
+	mux.HandleFunc("/admin/responses", s.handleResponses)
+	mux.HandleFunc("/admin/sessions", s.handleSessions)
+	mux.HandleFunc("/admin/sessions/", s.handleSession)
+	mux.HandleFunc("/admin/drain", s.handleDrain)
+	mux.Handle("/admin/metrics", promhttp.Handler())
+	mux.HandleFunc("/admin/signing-key", s.handleSigningKey)
+	mux.HandleFunc("/admin/reload-auth", s.handleReloadAuth)
+
+	server := &http.Server{Addr: addr, Handler: s.authorized(mux), TLSConfig: tlsConfig}
+	if tlsConfig != nil {
+		log.Printf("Listening for HTTPS operators on %s", addr)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("Listening for HTTP operators on %s", addr)
+		err = server.ListenAndServe()
+	}
+	return err
+}
+
+// authorized refuses requests that lack the admin token
+func (s *adminServer) authorized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		digest := sha256.Sum256([]byte(bearerToken(r)))
+		if subtle.ConstantTimeCompare(digest[:], s.tokenSHA256) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeHTTPError(w, http.StatusUnauthorized, "missing or invalid admin token")
+			return
+		}
+		slog.Info("Admin request", "method", r.Method, "path", r.URL.Path, "remote", clientAddr(r.RemoteAddr))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *adminServer) handleModules(w http.ResponseWriter, r *http.Request) {
+	var req protocol.WASMRequest
+	switch r.Method {
+	case http.MethodGet:
+		req = protocol.WASMRequest{Type: protocol.RequestTypeListModules}
+	case http.MethodDelete:
+		req = protocol.WASMRequest{Type: protocol.RequestTypeEvictModules, ModuleHash: r.URL.Query().Get("module_hash")}
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if response, status, ok := s.fanOut(w, r, req); ok {
+		writeJSON(w, status, response)
+	}
+}
+
+func (s *adminServer) handleResponses(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+	purged := s.host.cache.purge()
+	slog.Info("Purged the response cache", "responses", purged)
+	writeJSON(w, http.StatusOK, adminCountResponse{Count: purged})
+}
+
+func (s *adminServer) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if response, status, ok := s.fanOut(w, r, protocol.WASMRequest{Type: protocol.RequestTypeListSessions}); ok {
+		writeJSON(w, status, response)
+	}
+}
+
+func (s *adminServer) handleSession(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+	handle := strings.TrimPrefix(r.URL.Path, "/admin/sessions/")
+	if handle == "" || strings.Contains(handle, "/") {
+		writeHTTPError(w, http.StatusNotFound, "not found")
+		return
+	}
+	response, status, ok := s.fanOut(w, r, protocol.WASMRequest{Type: protocol.RequestTypeKillSession, SessionHandle: handle})
+	if !ok {
+		return
+	}
+	killed := 0
+	for _, result := range response.Enclaves {
+		killed += result.Evicted
+	}
+	if status == http.StatusOK && killed == 0 {
+		writeHTTPError(w, http.StatusNotFound, fmt.Sprintf("no session %s", handle))
+		return
+	}
+	writeJSON(w, status, response)
+}
+
+func (s *adminServer) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	s.drainer.Request()
+	writeJSON(w, http.StatusAccepted, adminStatusResponse{Status: "draining"})
+}
+
+func (s *adminServer) handleSigningKey(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if response, status, ok := s.fanOut(w, r, protocol.WASMRequest{Type: protocol.RequestTypeRotateSigningKey}); ok {
+		writeJSON(w, status, response)
+	}
+}
+
+func (s *adminServer) handleReloadAuth(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	clients, err := s.host.auth.reload()
+	if err != nil {
+		log.Printf("Failed to reload the clients file: %v", err)
+		writeHTTPError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("Requiring API tokens for %d clients", clients)
+	writeJSON(w, http.StatusOK, adminCountResponse{Count: clients})
+}
+
+// fanOut sends req to every enclave replica, or the one ?enclave= names,
+// and returns what each answered, with 502 when some replica failed. It
+// answers r itself, and returns false, when there is no such replica.
+func (s *adminServer) fanOut(w http.ResponseWriter, r *http.Request, req protocol.WASMRequest) (adminEnclavesResponse, int, bool) {
+	replicas := s.host.enclaves.byID
+	if id := r.URL.Query().Get("enclave"); id != "" {
+		replica, ok := replicas[id]
+		if !ok {
+			writeHTTPError(w, http.StatusNotFound, fmt.Sprintf("no enclave replica %s", id))
+			return adminEnclavesResponse{}, 0, false
+		}
+		replicas = map[string]*enclaveReplica{id: replica}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+	req.CorrelationID = logging.NewCorrelationID()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	response := adminEnclavesResponse{Enclaves: make(map[string]adminReplicaResult, len(replicas))}
+	status := http.StatusOK
+	for id, replica := range replicas {
+		wg.Add(1)
+		go func(id string, replica *enclaveReplica) {
+			defer wg.Done()
+			result := s.forward(ctx, replica, req)
+			mu.Lock()
+			defer mu.Unlock()
+			response.Enclaves[id] = result
+			if result.Error != "" {
+				status = http.StatusBadGateway
+			}
+		}(id, replica)
+	}
+	wg.Wait()
+	return response, status, true
+}
+
+// forward sends an operator request to a single replica
+func (s *adminServer) forward(ctx context.Context, replica *enclaveReplica, req protocol.WASMRequest) adminReplicaResult {
+	req.RequestID = s.host.nextRequestID()
+	logger := logging.ForRequest(req.CorrelationID, req.RequestID).With("replica", replica.id)
+	answer, err := s.host.tryForward(ctx, replica, req)
+	if err != nil {
+		logger.Warn("Admin request failed", "type", req.Type, "error", err)
+		return adminReplicaResult{Error: err.Error()}
+	}
+	// A rotated key that could not be attested is still in use, so it is
+	// reported along with the error
+	result := adminReplicaResult{
+		CachedModules: answer.CachedModules,
+		Sessions:      answer.Sessions,
+		Evicted:       answer.Evicted,
+		SigningKey:    answer.SigningKey,
+		Attestation:   answer.Attestation,
+		Error:         answer.Error,
+	}
+	if answer.Error != "" {
+		logger.Warn("Admin request failed", "type", req.Type, "error", answer.Error)
+	} else {
+		logger.Info("Admin request done", "type", req.Type, "evicted", answer.Evicted)
+	}
+	return result
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// Defaults for -queue-length, -queue-timeout and -retry-after
+	defaultHostQueueLength = 64
+	defaultQueueTimeout    = 10 * time.Second
+	defaultRetryAfter      = time.Second
+)
+
+// overloadError is a request the host shed instead of forwarding, because
+// every enclave connection stayed busy
+type overloadError struct {
+	reason string
+}
+
+func (e *overloadError) Error() string {
+	return "host is overloaded: " + e.reason
+}
+
+// admissionControl bounds how many requests the host holds for the enclave:
+// one per pooled connection plus a queue of queueLength waiting for one.
+// Requests beyond that, or that wait longer than queueTimeout, are shed
+// with a hint to retry after retryAfter.
+type admissionControl struct {
+	admitted     chan struct{}
+	queueLength  int
+	queueTimeout time.Duration
+	retryAfter   time.Duration
+}
+
+func newAdmissionControl(poolSize, queueLength int, queueTimeout, retryAfter time.Duration) *admissionControl {
+	return &admissionControl{
+		admitted:     make(chan struct{}, poolSize+queueLength),
+		queueLength:  queueLength,
+		queueTimeout: queueTimeout,
+		retryAfter:   retryAfter,
+	}
+}
+
+// admit reserves room for one request and returns the function that frees
+// it again. It fails at once when the queue is full.
+func (a *admissionControl) admit() (func(), error) {
+	select {
+	case a.admitted <- struct{}{}:
+	default:
+		shedRequests.WithLabelValues("queue_full").Inc()
+		return nil, &overloadError{reason: fmt.Sprintf("%d requests already queued", a.queueLength)}
+	}
+	return func() { <-a.admitted }, nil
+}
+
+// checkout takes a connection from the pool, waiting in the queue for at
+// most queueTimeout when none is free, or until ctx ends
+func (a *admissionControl) checkout(ctx context.Context, pool *EnclavePool) (*enclaveConn, error) {
+	var slot *enclaveConn
+	select {
+	case slot = <-pool.slots:
+	default:
+		queuedRequests.Inc()
+		start := time.Now()
+		timer := time.NewTimer(a.queueTimeout)
+		select {
+		case slot = <-pool.slots:
+			timer.Stop()
+		case <-timer.C:
+			queuedRequests.Dec()
+			shedRequests.WithLabelValues("queue_timeout").Inc()
+			return nil, &overloadError{reason: fmt.Sprintf("no enclave connection became free within %v", a.queueTimeout)}
+		case <-ctx.Done():
+			timer.Stop()
+			queuedRequests.Dec()
+			return nil, ctx.Err()
+		}
+		queuedRequests.Dec()
+		queueWait.Observe(time.Since(start).Seconds())
+	}
+	return pool.connect(slot)
+}
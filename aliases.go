@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	errUnknownModule = errors.New("module is not registered")
+	errAliasConflict = errors.New("alias has moved")
+)
+
+// aliasPattern is what alias names look like; module IDs, which executions
+// may name in their place, do not count
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// isModuleID reports whether id has the form of a module ID
+func isModuleID(id string) bool {
+	decoded, err := hex.DecodeString(id)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// SetAlias points tenant's alias at the registered module id, and returns
+// the module it pointed at before, if any. Executions see either module,
+// never neither. With previous set, the alias is only moved if it still
+// points there, so that two rollouts cannot undo each other unnoticed.
+func (r *ModuleRegistry) SetAlias(tenant, alias, id, previous string) (string, error) {
+	if !aliasPattern.MatchString(alias) || isModuleID(alias) {
+		return "", fmt.Errorf("invalid alias %q: use up to 128 letters, digits, '.', '_' and '-', and no module ID", alias)
+	}
+	r.aliasing.Lock()
+	defer r.aliasing.Unlock()
+	r.mu.RLock()
+	_, registered := r.modules[tenant][id]
+	current := r.aliases[tenant][alias]
+	r.mu.RUnlock()
+	if !registered {
+		return "", fmt.Errorf("%w: %s", errUnknownModule, id)
+	}
+	if previous != "" && current != previous {
+		return current, fmt.Errorf("%w: %s points at %q, not %s", errAliasConflict, alias, current, previous)
+	}
+	if err := r.changeAlias(tenant, alias, id); err != nil {
+		return "", err
+	}
+	return current, nil
+}
+
+// DeleteAlias removes tenant's alias, and returns the module it pointed at,
+// or false when there is no such alias
+func (r *ModuleRegistry) DeleteAlias(tenant, alias string) (string, bool, error) {
+	r.aliasing.Lock()
+	defer r.aliasing.Unlock()
+	r.mu.RLock()
+	current, ok := r.aliases[tenant][alias]
+	r.mu.RUnlock()
+	if !ok {
+		return "", false, nil
+	}
+	return current, true, r.changeAlias(tenant, alias, "")
+}
+
+// Aliases returns the aliases of tenant and the modules they point at
+func (r *ModuleRegistry) Aliases(tenant string) map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	aliases := make(map[string]string, len(r.aliases[tenant]))
+	for alias, id := range r.aliases[tenant] {
+		aliases[alias] = id
+	}
+	return aliases
+}
+
+// changeAlias points an alias at id, or removes it when id is empty, and
+// stores the result before executions see it; the caller holds r.aliasing
+func (r *ModuleRegistry) changeAlias(tenant, alias, id string) error {
+	r.mu.RLock()
+	changed := make(map[string]map[string]string, len(r.aliases)+1)
+	for name, aliases := range r.aliases {
+		changed[name] = make(map[string]string, len(aliases)+1)
+		for a, target := range aliases {
+			changed[name][a] = target
+		}
+	}
+	r.mu.RUnlock()
+	if changed[tenant] == nil {
+		changed[tenant] = make(map[string]string)
+	}
+	if id == "" {
+		delete(changed[tenant], alias)
+	} else {
+		changed[tenant][alias] = id
+	}
+	if r.store != nil {
+		if err := r.store.putAliases(changed); err != nil {
+			return fmt.Errorf("%w: %v", errModuleStore, err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.aliases = changed
+	return nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"hello-wasm-enclave/internal/iamauth"
+	"hello-wasm-enclave/internal/protocol"
+)
+
+const (
+	// How long a verified IAM identity is remembered, so STS is not asked on
+	// every request; signed requests stay valid at STS for 15 minutes
+	iamIdentityTTL = 5 * time.Minute
+	// Bound on a GetCallerIdentity response body
+	maxSTSResponseSize = 64 << 10
+)
+
+// anyName in a client's functions, modules or secrets allows every one
+const anyName = "*"
+
+// authClient is one entry of the -auth-file: a client identified by a static
+// bearer token or an IAM ARN, and what it may run. Lists left out allow
+// nothing; "*" allows anything. Clients naming a tenant share its
+// namespace and quotas (see tenant).
+//
+//	clients:
+//	  - name: ci
+//	    token_sha256: 6b86b273ff34fce19d6b804eff5a3f5747ada4eaa22f1d49c01e52ddb7875b4b
+//	    functions: [square, add]
+//	    modules: ["*"]
+//	    tenant: payments
+//	  - name: deployer
+//	    arn: arn:aws:iam::123456789012:role/deployer
+//	    functions: ["*"]
+//	    modules: [c1b5...]
+//	    secrets: [API_KEY]
+type authClient struct {
+	Name string `yaml:"name"`
+	// Hex SHA-256 of the token, so the file holds no usable credentials
+	TokenSHA256 string `yaml:"token_sha256"`
+	// Caller ARN; an IAM role also matches sessions of it
+	ARN string `yaml:"arn"`
+	// Exported function names, or TABLE[INDEX] for calls through a table
+	Functions []string `yaml:"functions"`
+	// Module hashes (the SHA-256 of wasm_code, also its module_id), or
+	// names of modules preloaded into the enclave
+	Modules []string `yaml:"modules"`
+	// Names of plaintext, KMS, referenced and listed secrets. Names inside
+	// encrypted_secrets are hidden from the host, so those need "*".
+	Secrets []string `yaml:"secrets"`
+	// Tenant the client belongs to, one of the file's tenants
+	Tenant string `yaml:"tenant"`
+	// May point the tenant's module aliases at other modules
+	Admin bool `yaml:"admin"`
+}
+
+type authFile struct {
+	Clients []*authClient `yaml:"clients"`
+	Tenants []*tenant     `yaml:"tenants"`
+}
+
+// authError is a request without valid credentials, or with credentials
+// that do not allow it
+type authError struct {
+	code    string
+	message string
+}
+
+func (e *authError) Error() string {
+	return e.message
+}
+
+func unauthenticated(format string, args ...interface{}) error {
+	return &authError{code: protocol.ErrorCodeUnauthenticated, message: fmt.Sprintf(format, args...)}
+}
+
+func forbidden(format string, args ...interface{}) error {
+	return &authError{code: protocol.ErrorCodeForbidden, message: fmt.Sprintf(format, args...)}
+}
+
+// Authenticator checks the API tokens of host requests. A nil Authenticator
+// lets every request through.
+type Authenticator struct {
+	// Guards the clients and tenants, which reload replaces
+	clients  sync.RWMutex
+	byToken  map[string]*authClient
+	byARN    map[string]*authClient
+	tenants  map[string]*tenant
+	path     string
+	audience string
+	sts      *http.Client
+
+	mu       sync.Mutex
+	verified map[string]verifiedIdentity
+}
+
+type verifiedIdentity struct {
+	arn     string
+	expires time.Time
+}
+
+// loadAuthenticator reads the clients file. IAM tokens must be signed for
+// audience.
+func loadAuthenticator(path, audience string) (*Authenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth file: %v", err)
+	}
+	var file authFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse auth file %s: %v", path, err)
+	}
+
+	a := &Authenticator{
+		byToken:  make(map[string]*authClient),
+		byARN:    make(map[string]*authClient),
+		path:     path,
+		audience: audience,
+		sts:      &http.Client{Timeout: 10 * time.Second},
+		verified: make(map[string]verifiedIdentity),
+	}
+	for i, client := range file.Clients {
+		switch {
+		case client.Name == "":
+			return nil, fmt.Errorf("auth file client %d has no name", i+1)
+		case (client.TokenSHA256 == "") == (client.ARN == ""):
+			return nil, fmt.Errorf("auth file client %s needs exactly one of token_sha256 and arn", client.Name)
+		case client.TokenSHA256 != "":
+			digest := strings.ToLower(client.TokenSHA256)
+			if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("auth file client %s: token_sha256 is not a SHA-256 digest", client.Name)
+			}
+			a.byToken[digest] = client
+		default:
+			a.byARN[client.ARN] = client
+		}
+	}
+	if len(file.Clients) == 0 {
+		return nil, fmt.Errorf("auth file %s lists no clients", path)
+	}
+	if a.tenants, err = loadTenants(file.Tenants, file.Clients); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// authenticate returns the client a token belongs to and the identity to
+// tag its requests with
+func (a *Authenticator) authenticate(token string) (*authClient, string, error) {
+	if a == nil {
+		return nil, "", nil
+	}
+	if token == "" {
+		return nil, "", unauthenticated("an API token is required")
+	}
+
+	if strings.HasPrefix(token, iamauth.TokenPrefix) {
+		arn, err := a.verifyIAM(token)
+		if err != nil {
+			return nil, "", unauthenticated("IAM authentication failed: %v", err)
+		}
+		a.clients.RLock()
+		client := a.byARN[arn]
+		if client == nil {
+			client = a.byARN[roleARN(arn)]
+		}
+		a.clients.RUnlock()
+		if client == nil {
+			return nil, "", forbidden("%s is not an authorized client", arn)
+		}
+		return client, arn, nil
+	}
+
+	digest := sha256.Sum256([]byte(token))
+	a.clients.RLock()
+	client := a.byToken[hex.EncodeToString(digest[:])]
+	a.clients.RUnlock()
+	if client == nil {
+		return nil, "", unauthenticated("invalid API token")
+	}
+	return client, "token:" + client.Name, nil
+}
+
+// reload reads the clients file again, so that clients, what they may run
+// and their tenants change without a restart, and returns how many clients
+// it lists. Tenants start their rate limits over; module quotas keep the
+// values the host started with.
+func (a *Authenticator) reload() (int, error) {
+	if a == nil {
+		return 0, fmt.Errorf("the host has no -auth-file")
+	}
+	fresh, err := loadAuthenticator(a.path, a.audience)
+	if err != nil {
+		return 0, err
+	}
+	a.clients.Lock()
+	defer a.clients.Unlock()
+	a.byToken, a.byARN, a.tenants = fresh.byToken, fresh.byARN, fresh.tenants
+	return len(a.byToken) + len(a.byARN), nil
+}
+
+// verifyIAM replays a signed GetCallerIdentity request to STS and returns
+// the caller's ARN
+func (a *Authenticator) verifyIAM(token string) (string, error) {
+	key := sha256.Sum256([]byte(token))
+	cacheKey := hex.EncodeToString(key[:])
+	now := time.Now()
+	a.mu.Lock()
+	cached, ok := a.verified[cacheKey]
+	if ok && now.After(cached.expires) {
+		delete(a.verified, cacheKey)
+		ok = false
+	}
+	a.mu.Unlock()
+	if ok {
+		return cached.arn, nil
+	}
+
+	signed, err := iamauth.ParseToken(token, a.audience)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, signed.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	for name, value := range signed.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := a.sts.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("STS request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSTSResponseSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read STS response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("STS rejected the signed request (%d)", resp.StatusCode)
+	}
+
+	var identity struct {
+		ARN string `xml:"GetCallerIdentityResult>Arn"`
+	}
+	if err := xml.Unmarshal(body, &identity); err != nil || identity.ARN == "" {
+		return "", fmt.Errorf("unexpected STS response")
+	}
+
+	a.mu.Lock()
+	// Expired entries are dropped when looked up again; tokens of clients
+	// that stopped calling linger until the map is cleared here
+	if len(a.verified) > 10000 {
+		a.verified = make(map[string]verifiedIdentity)
+	}
+	a.verified[cacheKey] = verifiedIdentity{arn: identity.ARN, expires: now.Add(iamIdentityTTL)}
+	a.mu.Unlock()
+	return identity.ARN, nil
+}
+
+// roleARN turns the ARN of an assumed-role session into that of its role:
+// arn:aws:sts::ACCOUNT:assumed-role/ROLE/SESSION becomes
+// arn:aws:iam::ACCOUNT:role/ROLE
+func roleARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[2] != "sts" || !strings.HasPrefix(parts[5], "assumed-role/") {
+		return arn
+	}
+	resource := strings.Split(strings.TrimPrefix(parts[5], "assumed-role/"), "/")
+	return fmt.Sprintf("%s:%s:iam::%s:role/%s", parts[0], parts[1], parts[4], resource[0])
+}
+
+// authorize checks that the client may make req. Only executions, jobs and
+// sessions are restricted; key requests just need authentication.
+func (c *authClient) authorize(req protocol.WASMRequest) error {
+	if c == nil {
+		return nil
+	}
+	switch req.Type {
+	case "", protocol.RequestTypeSubmitJob, protocol.RequestTypeCreateSession, protocol.RequestTypePrecompile, protocol.RequestTypeDescribeModule, protocol.RequestTypeUploadBegin:
+		// The module of an upload was checked when the upload began
+		if module := requestModule(req); req.UploadID == "" && !allows(c.Modules, module) {
+			return forbidden("client %s may not run module %s", c.Name, module)
+		}
+		if err := c.authorizeSecrets(req); err != nil {
+			return err
+		}
+	}
+	for _, call := range req.FunctionCalls() {
+		if target := call.Target(); target != "" && !allows(c.Functions, target) {
+			return forbidden("client %s may not call %s", c.Name, target)
+		}
+	}
+	return nil
+}
+
+func (c *authClient) authorizeSecrets(req protocol.WASMRequest) error {
+	if req.EncryptedSecrets != "" && !allows(c.Secrets, anyName) {
+		return forbidden("client %s may not send encrypted secrets", c.Name)
+	}
+	for _, secrets := range []map[string]string{req.Secrets, req.KMSSecrets, req.SecretRefs} {
+		for name := range secrets {
+			if !allows(c.Secrets, name) {
+				return forbidden("client %s may not request secret %s", c.Name, name)
+			}
+		}
+	}
+	for _, secret := range req.SecretList {
+		if !allows(c.Secrets, secret.Name) {
+			return forbidden("client %s may not request secret %s", c.Name, secret.Name)
+		}
+	}
+	return nil
+}
+
+func allows(names []string, name string) bool {
+	for _, allowed := range names {
+		if allowed == anyName || allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// requestModule names the module of req as clients' and enclaves' module
+// lists do: by module_name, or by the hash of the wasm_code it sends or
+// begins to upload
+func requestModule(req protocol.WASMRequest) string {
+	switch {
+	case req.ModuleName != "":
+		return req.ModuleName
+	case req.Type == protocol.RequestTypeUploadBegin:
+		return strings.ToLower(req.ModuleHash)
+	}
+	return moduleHash(req.WASMCode)
+}
+
+// moduleHash is the hex SHA-256 of wasm_code, as in module IDs and receipts
+func moduleHash(wasmCode string) string {
+	digest := sha256.Sum256([]byte(wasmCode))
+	return hex.EncodeToString(digest[:])
+}
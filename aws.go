@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"hello-wasm-enclave/internal/sigv4"
+)
+
+const (
+	// EC2 instance metadata service (IMDSv2)
+	imdsEndpoint = "http://169.254.169.254/latest"
+	// Refresh instance credentials this long before they expire
+	credentialRefreshMargin = 5 * time.Minute
+)
+
+// CredentialProvider resolves the host's AWS credentials, first from the
+// standard environment variables and otherwise from the instance role. They
+// sign the host's own AWS calls and are passed to the enclave for KMS.
+type CredentialProvider struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	cached  *sigv4.Credentials
+	expires time.Time
+}
+
+func NewCredentialProvider() *CredentialProvider {
+	return &CredentialProvider{client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Credentials returns current credentials, refreshing them when needed
+func (p *CredentialProvider) Credentials() (*sigv4.Credentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		creds := &sigv4.Credentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			Region:          envRegion(),
+		}
+		if creds.Region == "" {
+			return nil, fmt.Errorf("AWS_REGION must be set alongside AWS_ACCESS_KEY_ID")
+		}
+		return creds, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached != nil && time.Now().Before(p.expires.Add(-credentialRefreshMargin)) {
+		return p.cached, nil
+	}
+
+	creds, expires, err := p.fetchInstanceCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance credentials: %v", err)
+	}
+	p.cached = creds
+	p.expires = expires
+	log.Printf("Loaded instance role credentials (expire %s)", expires.Format(time.RFC3339))
+	return creds, nil
+}
+
+func envRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+func (p *CredentialProvider) fetchInstanceCredentials() (*sigv4.Credentials, time.Time, error) {
+	tokenReq, err := http.NewRequest(http.MethodPut, imdsEndpoint+"/api/token", nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	tokenReq.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := p.imdsCall(tokenReq)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("IMDS token request failed: %v", err)
+	}
+
+	get := func(path string) (string, error) {
+		req, err := http.NewRequest(http.MethodGet, imdsEndpoint+path, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+		return p.imdsCall(req)
+	}
+
+	roles, err := get("/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("no instance role: %v", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+
+	raw, err := get("/meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var roleCreds struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal([]byte(raw), &roleCreds); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid role credentials: %v", err)
+	}
+
+	region := envRegion()
+	if region == "" {
+		if region, err = get("/meta-data/placement/region"); err != nil {
+			return nil, time.Time{}, fmt.Errorf("failed to determine region: %v", err)
+		}
+	}
+
+	return &sigv4.Credentials{
+		AccessKeyID:     roleCreds.AccessKeyID,
+		SecretAccessKey: roleCreds.SecretAccessKey,
+		SessionToken:    roleCreds.Token,
+		Region:          strings.TrimSpace(region),
+	}, roleCreds.Expiration, nil
+}
+
+func (p *CredentialProvider) imdsCall(req *http.Request) (string, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s returned %d", req.Method, req.URL.Path, resp.StatusCode)
+	}
+	return string(body), nil
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/transport"
+	"hello-wasm-enclave/internal/wire"
+)
+
+// Name of the only backend when -enclave-cid and -enclave-port describe it
+const defaultBackendName = "default"
+
+const (
+	// Separates the replica ID from the enclave's own ID in the session and
+	// job IDs of a multi-enclave host
+	sessionBackendSeparator = protocol.ReplicaIDSeparator
+	// Separates the backend name from the CID in the ID of a replica
+	replicaSeparator = "/"
+)
+
+// enclaveBackend is a set of enclaves running the same image, at different
+// CIDs. Requests go to the least loaded replica that is up, and move to
+// another when one fails, so a crashed enclave does not take its modules
+// offline.
+type enclaveBackend struct {
+	name string
+	// Module hashes or preloaded module names this enclave runs, "*" for any
+	modules  []string
+	replicas []*enclaveReplica
+	// Rotates the replica preferred among equally loaded ones
+	next atomic.Uint64
+}
+
+// enclaveReplica is one enclave of a backend. Each has its own connection
+// pool, admission control and health checks, so a busy or restarting
+// enclave does not hold up requests for the others.
+type enclaveReplica struct {
+	// The backend name, followed by /CID when the backend has several
+	// replicas; clients name a replica by it to reach the same enclave again
+	id        string
+	cid       uint32
+	pool      *EnclavePool
+	admission *admissionControl
+	inFlight  atomic.Int64
+	// Set when a request failed to reach the enclave, until it answers again
+	down atomic.Bool
+	// Fails requests fast once too many in a row failed; nil when disabled
+	breaker *circuitBreaker
+}
+
+// backendConfig is one entry of the -enclaves file; replicas of the same
+// image are listed under cids:
+//
+//	enclaves:
+//	  - name: payments
+//	    cids: [16, 17]
+//	    modules: [c1b5..., pricing]
+//	  - name: general
+//	    cid: 18
+//	    port: 8080
+//	    modules: ["*"]
+type backendConfig struct {
+	Name string   `yaml:"name"`
+	CID  uint32   `yaml:"cid"`
+	CIDs []uint32 `yaml:"cids"`
+	// vsock port of the enclave's listener; protocol.EnclavePort if unset
+	Port uint32 `yaml:"port"`
+	// Module hashes (the SHA-256 of wasm_code) or names of modules
+	// preloaded into the enclave; "*" allows any
+	Modules []string `yaml:"modules"`
+}
+
+type backendsFile struct {
+	Enclaves []backendConfig `yaml:"enclaves"`
+}
+
+// loadBackends reads the -enclaves file
+func loadBackends(path string) ([]backendConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read enclaves file: %v", err)
+	}
+	var file backendsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse enclaves file %s: %v", path, err)
+	}
+	if len(file.Enclaves) == 0 {
+		return nil, fmt.Errorf("enclaves file %s lists no enclaves", path)
+	}
+	names := map[string]bool{}
+	cids := map[uint32]string{}
+	for i, backend := range file.Enclaves {
+		switch {
+		case backend.Name == "":
+			return nil, fmt.Errorf("enclaves file entry %d has no name", i+1)
+		case strings.Contains(backend.Name, sessionBackendSeparator) || strings.Contains(backend.Name, replicaSeparator):
+			return nil, fmt.Errorf("enclave name %q may not contain %q or %q", backend.Name, sessionBackendSeparator, replicaSeparator)
+		case names[backend.Name]:
+			return nil, fmt.Errorf("enclave %s is listed twice", backend.Name)
+		case (backend.CID == 0) == (len(backend.CIDs) == 0):
+			return nil, fmt.Errorf("enclave %s needs exactly one of cid and cids", backend.Name)
+		case len(backend.Modules) == 0:
+			return nil, fmt.Errorf("enclave %s lists no modules", backend.Name)
+		}
+		names[backend.Name] = true
+		if backend.CID != 0 {
+			file.Enclaves[i].CIDs = []uint32{backend.CID}
+		}
+		for _, cid := range file.Enclaves[i].CIDs {
+			if other, ok := cids[cid]; ok {
+				return nil, fmt.Errorf("CID %d is listed for both %s and %s", cid, other, backend.Name)
+			}
+			cids[cid] = backend.Name
+		}
+		if backend.Port == 0 {
+			file.Enclaves[i].Port = protocol.EnclavePort
+		}
+	}
+	return file.Enclaves, nil
+}
+
+// enclaveRouter picks the enclave each request goes to. Requests naming an
+// enclave go there. Otherwise executions, precompiles and new sessions go
+// to the first enclave, in configuration order, that runs their module;
+// calls to a session go to the replica that holds it; and the rest (keys,
+// hello, audit logs) go to the first enclave.
+type enclaveRouter struct {
+	backends []*enclaveBackend
+	byName   map[string]*enclaveBackend
+	byID     map[string]*enclaveReplica
+	// Suggested to clients whose request was shed, by any replica
+	retryAfter time.Duration
+}
+
+// newEnclaveRouter sets up the backends of configs, which must have their
+// CIDs and ports filled in as by loadBackends
+func newEnclaveRouter(configs []backendConfig, t *transport.Transport, poolSize int, framed bool, dialOptions wire.DialOptions, queueLength int, queueTimeout, retryAfter time.Duration) *enclaveRouter {
+	r := &enclaveRouter{
+		byName:     make(map[string]*enclaveBackend),
+		byID:       make(map[string]*enclaveReplica),
+		retryAfter: retryAfter,
+	}
+	for _, config := range configs {
+		backend := &enclaveBackend{name: config.Name, modules: config.Modules}
+		for _, cid := range config.CIDs {
+			id := config.Name
+			if len(config.CIDs) > 1 {
+				id = fmt.Sprintf("%s%s%d", config.Name, replicaSeparator, cid)
+			}
+			replica := &enclaveReplica{
+				id:        id,
+				cid:       cid,
+				pool:      NewEnclavePool(id, t, cid, config.Port, poolSize, framed, dialOptions),
+				admission: newAdmissionControl(poolSize, queueLength, queueTimeout, retryAfter),
+			}
+			enclaveUp.WithLabelValues(id).Set(1)
+			backend.replicas = append(backend.replicas, replica)
+			r.byID[id] = replica
+		}
+		r.backends = append(r.backends, backend)
+		r.byName[backend.name] = backend
+	}
+	return r
+}
+
+// multiple reports whether responses name their replica and session IDs
+// carry it. A single enclave's responses pass through unchanged.
+func (r *enclaveRouter) multiple() bool {
+	return len(r.byID) > 1
+}
+
+func unroutable(format string, args ...interface{}) error {
+	return &protocol.ValidationError{Code: protocol.ErrorCodeInvalidRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// route returns the backend of req, and the replica it must go to when it
+// named one, calls a session or continues an upload. A session or upload ID
+// of a multi-enclave host is turned back into the enclave's own.
+func (r *enclaveRouter) route(req *protocol.WASMRequest) (*enclaveBackend, *enclaveReplica, error) {
+	var backend *enclaveBackend
+	var pinned *enclaveReplica
+	if req.Enclave != "" {
+		name, _, _ := strings.Cut(req.Enclave, replicaSeparator)
+		backend = r.byName[name]
+		pinned = r.byID[req.Enclave]
+		if backend == nil || (pinned == nil && name != req.Enclave) {
+			return nil, nil, unroutable("unknown enclave %s", req.Enclave)
+		}
+		if len(backend.replicas) == 1 {
+			pinned = backend.replicas[0]
+		}
+		req.Enclave = ""
+	}
+
+	// Chunks, commits and the requests running an upload go where it
+	// began, which was routed by its module
+	if req.UploadID != "" {
+		if !r.multiple() {
+			return r.backends[0], pinned, nil
+		}
+		backend, replica, upload, err := r.owner("upload", req.UploadID, pinned)
+		req.UploadID = upload
+		return backend, replica, err
+	}
+
+	switch req.Type {
+	case "", protocol.RequestTypeSubmitJob, protocol.RequestTypeCreateSession, protocol.RequestTypePrecompile, protocol.RequestTypeDescribeModule, protocol.RequestTypeUploadBegin:
+		module := requestModule(*req)
+		if backend != nil {
+			if !allows(backend.modules, module) {
+				return nil, nil, &protocol.ValidationError{Code: protocol.ErrorCodePolicy, Message: fmt.Sprintf("enclave %s does not run module %s", backend.name, module)}
+			}
+			return backend, pinned, nil
+		}
+		for _, candidate := range r.backends {
+			if allows(candidate.modules, module) {
+				return candidate, nil, nil
+			}
+		}
+		return nil, nil, &protocol.ValidationError{Code: protocol.ErrorCodePolicy, Message: fmt.Sprintf("no enclave runs module %s", module)}
+	case protocol.RequestTypeCallSession, protocol.RequestTypeDestroySession, protocol.RequestTypeReadMemory, protocol.RequestTypeWriteMemory, protocol.RequestTypeGetGlobal, protocol.RequestTypeSetGlobal:
+		if !r.multiple() {
+			break
+		}
+		backend, replica, session, err := r.owner("session", req.SessionID, pinned)
+		req.SessionID = session
+		return backend, replica, err
+	case protocol.RequestTypeJobStatus, protocol.RequestTypeJobResult, protocol.RequestTypeCancelJob:
+		if !r.multiple() {
+			break
+		}
+		backend, replica, job, err := r.owner("job", req.JobID, pinned)
+		req.JobID = job
+		return backend, replica, err
+	}
+	if backend == nil {
+		backend = r.backends[0]
+	}
+	return backend, pinned, nil
+}
+
+// owner returns the replica a session or job ID a client holds belongs to,
+// and the enclave's own ID for it
+func (r *enclaveRouter) owner(kind, clientID string, pinned *enclaveReplica) (*enclaveBackend, *enclaveReplica, string, error) {
+	id, own, ok := strings.Cut(clientID, sessionBackendSeparator)
+	replica := r.byID[id]
+	if !ok || replica == nil {
+		return nil, nil, clientID, unroutable("%s %s does not belong to any enclave", kind, clientID)
+	}
+	if pinned != nil && pinned != replica {
+		return nil, nil, clientID, unroutable("%s %s belongs to enclave %s, not %s", kind, clientID, id, pinned.id)
+	}
+	name, _, _ := strings.Cut(id, replicaSeparator)
+	return r.byName[name], replica, own, nil
+}
+
+// sessionID is the ID a client holds for a session or job of replica
+func (r *enclaveRouter) sessionID(replica *enclaveReplica, id string) string {
+	if id == "" || !r.multiple() {
+		return id
+	}
+	return replica.id + sessionBackendSeparator + id
+}
+
+// pick returns the replica for the next attempt at a request: the least
+// loaded of those that are up and were not tried yet, rotating among equals.
+// When every replica is down, the request is tried on them anyway, since
+// one may have come back; only those whose circuit is open are skipped,
+// and pick returns nil when that is all of them.
+func (b *enclaveBackend) pick(tried map[*enclaveReplica]bool) *enclaveReplica {
+	start := b.next.Add(1)
+	var best, again *enclaveReplica
+	for i := range b.replicas {
+		replica := b.replicas[(start+uint64(i))%uint64(len(b.replicas))]
+		if replica.breaker.isOpen() {
+			continue
+		}
+		if tried[replica] {
+			if again == nil {
+				again = replica
+			}
+			continue
+		}
+		if best == nil || replica.preferredTo(best) {
+			best = replica
+		}
+	}
+	if best == nil {
+		// Every replica failed this request once; go round again
+		return again
+	}
+	return best
+}
+
+func (r *enclaveReplica) preferredTo(other *enclaveReplica) bool {
+	if up, otherUp := !r.down.Load(), !other.down.Load(); up != otherUp {
+		return up
+	}
+	return r.inFlight.Load() < other.inFlight.Load()
+}
+
+// markDown takes the replica out of rotation until it answers again
+func (r *enclaveReplica) markDown(err error) {
+	if !r.down.Swap(true) {
+		log.Printf("Enclave %s is down, preferring other replicas until it answers: %v", r.id, err)
+		enclaveUp.WithLabelValues(r.id).Set(0)
+	}
+	if r.breaker.failed(err) {
+		r.openCircuit(err)
+	}
+}
+
+func (r *enclaveReplica) markUp() {
+	if r.down.Swap(false) {
+		log.Printf("Enclave %s is answering again", r.id)
+		enclaveUp.WithLabelValues(r.id).Set(1)
+	}
+	if r.breaker.succeeded() {
+		log.Printf("Enclave %s circuit closed", r.id)
+		enclaveCircuitOpen.WithLabelValues(r.id).Set(0)
+	}
+}
+
+// StartHealthCheck watches every replica. Idle connections of replicas
+// that are up are pinged, so a restarted enclave or a connection that died
+// while idle is noticed before a client request hits it, and the replica is
+// redialed straight away; replicas that are down are asked for their health
+// until they answer.
+func (r *enclaveRouter) StartHealthCheck(interval, timeout time.Duration, maxMisses int) {
+	for _, replica := range r.byID {
+		go replica.watch(interval, timeout, maxMisses)
+	}
+}
+
+func (r *enclaveReplica) watch(interval, timeout time.Duration, maxMisses int) {
+	// Restarts are told by the uptime reported after this first check
+	r.pool.CheckHealth(timeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !r.down.Load() {
+			if !r.pool.pingIdle(timeout, maxMisses) {
+				continue
+			}
+			if _, err := r.pool.CheckHealth(timeout); err != nil {
+				r.markDown(err)
+			}
+			continue
+		}
+		if _, err := r.pool.CheckHealth(timeout); err == nil {
+			r.markUp()
+		}
+	}
+}
+
+// backendHealth is the outcome of a readiness check of one replica
+type backendHealth struct {
+	Status  string                 `json:"status"`
+	Enclave *protocol.HealthStatus `json:"enclave,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// checkHealth asks every replica for its health status at once, so a slow
+// one delays the answer by no more than timeout. It returns the status by
+// replica ID, and an error naming the backends none of whose replicas
+// answered.
+func (r *enclaveRouter) checkHealth(timeout time.Duration) (map[string]backendHealth, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	health := make(map[string]backendHealth, len(r.byID))
+	for _, replica := range r.byID {
+		wg.Add(1)
+		go func(replica *enclaveReplica) {
+			defer wg.Done()
+			result := backendHealth{Status: "ready"}
+			status, err := replica.pool.CheckHealth(timeout)
+			if err != nil {
+				// Not marked down: the replica may just be busy
+				result = backendHealth{Status: "unavailable", Error: err.Error()}
+			} else {
+				replica.markUp()
+			}
+			result.Enclave = status
+			mu.Lock()
+			health[replica.id] = result
+			mu.Unlock()
+		}(replica)
+	}
+	wg.Wait()
+
+	var unavailable []string
+	for _, backend := range r.backends {
+		ready := false
+		for _, replica := range backend.replicas {
+			ready = ready || health[replica.id].Error == ""
+		}
+		if !ready {
+			unavailable = append(unavailable, backend.name)
+		}
+	}
+	if len(unavailable) > 0 {
+		sort.Strings(unavailable)
+		return health, fmt.Errorf("no replica of enclaves %s answers", strings.Join(unavailable, ", "))
+	}
+	return health, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// unavailableError fails a request at once, without dialing, because the
+// circuit of every replica it could go to is open
+type unavailableError struct {
+	replica  string
+	failures int
+	since    time.Time
+	lastErr  error
+	// When the replica is probed next
+	retryAfter time.Duration
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("enclave %s unavailable: %d attempts in a row failed to reach it, the last %v ago: %v",
+		e.replica, e.failures, time.Since(e.since).Round(time.Second), e.lastErr)
+}
+
+// circuitBreaker opens once threshold requests or health checks in a row
+// failed to reach a replica. While it is open, requests for the replica
+// fail fast rather than each waiting out connects to an enclave that is
+// gone; the replica is probed every probeInterval, and the circuit closes
+// as soon as it answers.
+type circuitBreaker struct {
+	threshold     int
+	probeInterval time.Duration
+	probeTimeout  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	lastErr  error
+	lastAt   time.Time
+	open     bool
+	// When the next probe runs, while open
+	nextProbe time.Time
+}
+
+// failed counts a failure to reach the replica, and reports whether it
+// opened the circuit
+func (b *circuitBreaker) failed(err error) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastErr, b.lastAt = err, time.Now()
+	if b.open || b.failures < b.threshold {
+		return false
+	}
+	b.open = true
+	b.nextProbe = b.lastAt.Add(b.probeInterval)
+	return true
+}
+
+// succeeded resets the failures, and reports whether it closed the circuit
+func (b *circuitBreaker) succeeded() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	closed := b.open
+	b.open = false
+	return closed
+}
+
+func (b *circuitBreaker) isOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+// openError returns the error requests for replica fail with, or nil when
+// its circuit is closed
+func (b *circuitBreaker) openError(replica string) *unavailableError {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return nil
+	}
+	retryAfter := time.Until(b.nextProbe)
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+	return &unavailableError{replica: replica, failures: b.failures, since: b.lastAt, lastErr: b.lastErr, retryAfter: retryAfter}
+}
+
+// StartCircuitBreakers opens the circuit of a replica after threshold
+// failures in a row to reach it, probing it every probeInterval with a
+// health check of up to probeTimeout until it answers
+func (r *enclaveRouter) StartCircuitBreakers(threshold int, probeInterval, probeTimeout time.Duration) {
+	for _, replica := range r.byID {
+		replica.breaker = &circuitBreaker{threshold: threshold, probeInterval: probeInterval, probeTimeout: probeTimeout}
+		enclaveCircuitOpen.WithLabelValues(replica.id).Set(0)
+	}
+}
+
+// openCircuit stops requests for the replica, and probes it until it
+// answers again
+func (r *enclaveReplica) openCircuit(err error) {
+	log.Printf("Enclave %s circuit open after %d failures in a row, failing its requests fast and probing it every %v: %v",
+		r.id, r.breaker.threshold, r.breaker.probeInterval, err)
+	enclaveCircuitOpen.WithLabelValues(r.id).Set(1)
+	go r.probe()
+}
+
+func (r *enclaveReplica) probe() {
+	b := r.breaker
+	ticker := time.NewTicker(b.probeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		b.mu.Lock()
+		open := b.open
+		b.nextProbe = time.Now().Add(b.probeInterval)
+		b.mu.Unlock()
+		if !open {
+			return
+		}
+		if _, err := r.pool.CheckHealth(b.probeTimeout); err != nil {
+			b.failed(err)
+			continue
+		}
+		r.markUp()
+		return
+	}
+}
+
+// unavailable returns the error a request fails with when pick found no
+// replica whose circuit is closed: that of the replica which failed last
+func (b *enclaveBackend) unavailable() error {
+	var latest *unavailableError
+	for _, replica := range b.replicas {
+		err := replica.breaker.openError(replica.id)
+		if err != nil && (latest == nil || err.since.After(latest.since)) {
+			latest = err
+		}
+	}
+	if latest == nil {
+		// Closed again since pick looked
+		return fmt.Errorf("no replica of enclave %s is available", b.name)
+	}
+	return latest
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Default for -cache-ttl
+const defaultCacheTTL = 5 * time.Minute
+
+// responseCache remembers the responses to requests whose clients flagged
+// them deterministic, so repeating one does not take an enclave round trip.
+// Entries live for ttl and the least recently used are evicted beyond size.
+type responseCache struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	// Most recently used first
+	order *list.List
+}
+
+type cacheEntry struct {
+	key      [sha256.Size]byte
+	response protocol.WASMResponse
+	expires  time.Time
+}
+
+// cacheKey is what a deterministic result depends on. Secrets are only
+// present as their values, references or ciphertexts, all hashed into the
+// key with the rest. The module signature is part of it so that a request
+// the enclave's policy would refuse is not answered from the cache, and the
+// tenant so that tenants do not see each other's results. Whether to attest,
+// and the nonce, are part of it too: a response bound to one nonce, or
+// without a document, must not answer a request wanting another.
+type cacheKey struct {
+	Type             string                       `json:"type"`
+	WASMCode         string                       `json:"wasm_code"`
+	ModuleName       string                       `json:"module_name"`
+	UploadID         string                       `json:"upload_id"`
+	Format           string                       `json:"format"`
+	ModuleSignature  string                       `json:"module_signature"`
+	Calls            []protocol.Call              `json:"calls"`
+	MaxFuel          uint64                       `json:"max_fuel"`
+	MaxMemoryPages   uint32                       `json:"max_memory_pages"`
+	MaxTableElements uint32                       `json:"max_table_elements"`
+	TimeoutMS        int64                        `json:"timeout_ms"`
+	Features         []string                     `json:"features"`
+	Secrets          map[string]string            `json:"secrets"`
+	EncryptedSecrets string                       `json:"encrypted_secrets"`
+	KMSSecrets       map[string]string            `json:"kms_secrets"`
+	SecretRefs       map[string]string            `json:"secret_refs"`
+	SecretList       []protocol.Secret            `json:"secret_list"`
+	KMSContexts      map[string]map[string]string `json:"kms_encryption_contexts"`
+	Enclave          string                       `json:"enclave"`
+	Tenant           string                       `json:"tenant"`
+	Attest           bool                         `json:"attest"`
+	Nonce            string                       `json:"nonce"`
+}
+
+// newResponseCache returns a cache of size entries, or nil when size is
+// zero, which caches nothing
+func newResponseCache(size int, ttl time.Duration) *responseCache {
+	if size <= 0 {
+		return nil
+	}
+	return &responseCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// key returns the cache key of req, or false when its response may not be
+// cached: only deterministic executions qualify, attestations are bound
+// to the nonce of one request, and a cached response would skip the
+// progress of a stream_emits request
+func (c *responseCache) key(req protocol.WASMRequest) ([sha256.Size]byte, bool) {
+	if c == nil || !req.Deterministic || req.Type != protocol.RequestTypeExecute || req.Attest || req.StreamEmits {
+		return [sha256.Size]byte{}, false
+	}
+	digest, err := requestDigest(req)
+	return digest, err == nil
+}
+
+// requestDigest hashes what the result of an execution depends on, so that
+// requests asking for the same execution hash alike
+func requestDigest(req protocol.WASMRequest) ([sha256.Size]byte, error) {
+	// Maps encode with sorted keys, so equal requests encode alike
+	encoded, err := json.Marshal(cacheKey{
+		Type:             req.Type,
+		WASMCode:         req.WASMCode,
+		ModuleName:       req.ModuleName,
+		UploadID:         req.UploadID,
+		Format:           req.Format,
+		ModuleSignature:  req.ModuleSignature,
+		Calls:            req.FunctionCalls(),
+		MaxFuel:          req.MaxFuel,
+		MaxMemoryPages:   req.MaxMemoryPages,
+		MaxTableElements: req.MaxTableElements,
+		TimeoutMS:        req.TimeoutMS,
+		Features:         req.Features,
+		Secrets:          req.Secrets,
+		EncryptedSecrets: req.EncryptedSecrets,
+		KMSSecrets:       req.KMSSecrets,
+		SecretRefs:       req.SecretRefs,
+		SecretList:       req.SecretList,
+		KMSContexts:      req.KMSEncryptionContexts,
+		Enclave:          req.Enclave,
+		Tenant:           req.Tenant,
+		Attest:           req.Attest,
+		Nonce:            req.Nonce,
+	})
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(encoded), nil
+}
+
+// get returns the response cached under key
+func (c *responseCache) get(key [sha256.Size]byte) (protocol.WASMResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return protocol.WASMResponse{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		responseCacheEntries.Set(float64(c.order.Len()))
+		return protocol.WASMResponse{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry.response, true
+}
+
+// put caches a response under key if it is a success. Failures are not
+// cached, since limits and overload depend on more than the request, nor are
+// results of modules that fetched URLs, whose contents may change.
+func (c *responseCache) put(key [sha256.Size]byte, response protocol.WASMResponse) {
+	if response.Error != "" || len(response.Fetches) > 0 {
+		return
+	}
+	for _, result := range response.Results {
+		if result.Error != "" {
+			return
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cacheEntry{key: key, response: response, expires: time.Now().Add(c.ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	responseCacheEntries.Set(float64(c.order.Len()))
+}
+
+// purge drops every cached response and returns how many there were
+func (c *responseCache) purge() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	purged := c.order.Len()
+	c.entries = make(map[[sha256.Size]byte]*list.Element)
+	c.order.Init()
+	responseCacheEntries.Set(0)
+	return purged
+}
+
+// forwardCached answers a deterministic request from the cache, or forwards
+// it and caches the response
+func (h *HostService) forwardCached(ctx context.Context, logger *slog.Logger, req protocol.WASMRequest) (protocol.WASMResponse, error) {
+	key, cacheable := h.cache.key(req)
+	if !cacheable {
+		return h.forward(ctx, logger, req)
+	}
+	if response, ok := h.cache.get(key); ok {
+		cacheLookups.WithLabelValues("hit").Inc()
+		logger.Info("Answering from the response cache")
+		response.RequestID = req.RequestID
+		response.Cached = true
+		return response, nil
+	}
+	cacheLookups.WithLabelValues("miss").Inc()
+
+	response, err := h.forward(ctx, logger, req)
+	if err == nil {
+		h.cache.put(key, response)
+	}
+	return response, err
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"hello-wasm-enclave/internal/drain"
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/sigv4"
+)
+
+const (
+	// Defaults for -callback-retries and -callback-poll-interval
+	defaultCallbackRetries      = 5
+	defaultCallbackPollInterval = time.Second
+	// Polls of a job in a row that may fail to reach the enclave before its
+	// delivery is given up
+	maxCallbackPollFailures = 10
+	// Backoff between attempts at delivering a result
+	initialCallbackBackoff = time.Second
+	// Upper bound on the response body read from a callback or SQS
+	maxCallbackResponseSize = 64 << 10
+)
+
+// callbacks delivers the results of jobs submitted with a callback: once
+// the host has forwarded the submit_job request, it polls the job on the
+// enclave and, when it finishes, sends the response there, as a client
+// polling for it would have got it with its receipt signed by the enclave.
+// HTTPS callbacks are POSTed the response as JSON; SQS queues are sent it
+// as the body of a message. Only callbacks under one of the allowed
+// prefixes are accepted, since the host makes the requests.
+type callbacks struct {
+	allowed      []string
+	retries      int
+	pollInterval time.Duration
+	client       *http.Client
+	credentials  *CredentialProvider
+	// Deliveries count as requests in flight, so a drain waits for them
+	drainer *drain.Drainer
+}
+
+// newCallbacks returns callbacks delivering to the comma-separated
+// prefixes in allowlist, or nil when it is empty, which refuses callbacks
+func newCallbacks(allowlist string, retries int, pollInterval time.Duration, credentials *CredentialProvider, drainer *drain.Drainer) (*callbacks, error) {
+	var allowed []string
+	for _, prefix := range strings.Split(allowlist, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix == "" {
+			continue
+		}
+		if !strings.HasPrefix(prefix, "https://") && !strings.HasPrefix(prefix, "arn:aws:sqs:") {
+			return nil, fmt.Errorf("callback prefix %q is neither an https URL nor an SQS ARN", prefix)
+		}
+		allowed = append(allowed, prefix)
+	}
+	if len(allowed) == 0 {
+		return nil, nil
+	}
+	if retries < 0 || pollInterval <= 0 {
+		return nil, fmt.Errorf("-callback-retries must not be negative and -callback-poll-interval must be positive")
+	}
+	return &callbacks{
+		allowed:      allowed,
+		retries:      retries,
+		pollInterval: pollInterval,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			// A redirect could lead anywhere, allowed or not
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		credentials: credentials,
+		drainer:     drainer,
+	}, nil
+}
+
+// allow checks that results may be delivered to callback
+func (c *callbacks) allow(callback string) error {
+	if c == nil {
+		return &protocol.ValidationError{Code: protocol.ErrorCodePolicy, Message: "this host does not deliver results to callbacks"}
+	}
+	for _, prefix := range c.allowed {
+		if strings.HasPrefix(callback, prefix) {
+			return nil
+		}
+	}
+	return &protocol.ValidationError{Code: protocol.ErrorCodePolicy, Message: fmt.Sprintf("callback %s is not allowed", callback)}
+}
+
+// forwardJob forwards a request, and when it submits a job with a callback,
+// starts delivering the job's result to it
+func (h *HostService) forwardJob(ctx context.Context, logger *slog.Logger, req protocol.WASMRequest) (protocol.WASMResponse, error) {
+	if req.Callback == "" {
+		return h.forwardCached(ctx, logger, req)
+	}
+	callback := req.Callback
+	req.Callback = ""
+	if err := h.callbacks.allow(callback); err != nil {
+		return protocol.WASMResponse{}, err
+	}
+
+	response, err := h.forward(ctx, logger, req)
+	if err != nil || response.Error != "" {
+		return response, err
+	}
+	if !h.callbacks.drainer.Start() {
+		logger.Warn("Not delivering job result while shutting down", "job_id", response.JobID)
+		return response, nil
+	}
+	go func() {
+		defer h.callbacks.drainer.Done()
+		h.deliverJob(logger.With("job_id", response.JobID), req.Tenant, req.RequestID, response.JobID, callback)
+	}()
+	return response, nil
+}
+
+// deliverJob waits for a job to finish and delivers its result to callback
+func (h *HostService) deliverJob(logger *slog.Logger, tenant, requestID, jobID, callback string) {
+	result, err := h.awaitJob(logger, tenant, requestID, jobID)
+	if err == nil {
+		err = h.callbacks.deliver(logger, callback, result)
+	}
+	if err != nil {
+		callbackDeliveries.WithLabelValues("failed").Inc()
+		logger.Error("Failed to deliver job result", "callback", callback, "error", err)
+		return
+	}
+	callbackDeliveries.WithLabelValues("delivered").Inc()
+	logger.Info("Delivered job result", "callback", callback)
+}
+
+// awaitJob polls a job of tenant until it is no longer running and returns
+// its result
+func (h *HostService) awaitJob(logger *slog.Logger, tenant, requestID, jobID string) (protocol.WASMResponse, error) {
+	ctx := context.Background()
+	failures := 0
+	for {
+		time.Sleep(h.callbacks.pollInterval)
+		status, err := h.forward(ctx, logger, protocol.WASMRequest{Type: protocol.RequestTypeJobStatus, RequestID: requestID, JobID: jobID, Tenant: tenant})
+		if err != nil {
+			if failures++; failures >= maxCallbackPollFailures {
+				return protocol.WASMResponse{}, fmt.Errorf("job status unavailable after %d attempts: %v", failures, err)
+			}
+			continue
+		}
+		failures = 0
+		if status.Error != "" || status.Job == nil {
+			return protocol.WASMResponse{}, fmt.Errorf("job status: %s", status.Error)
+		}
+		if status.Job.State != protocol.JobRunning {
+			break
+		}
+	}
+	result, err := h.forward(ctx, logger, protocol.WASMRequest{Type: protocol.RequestTypeJobResult, RequestID: requestID, JobID: jobID, Tenant: tenant})
+	if err != nil {
+		return protocol.WASMResponse{}, fmt.Errorf("job result: %v", err)
+	}
+	return result, nil
+}
+
+// deliver sends a job's result to callback, retrying with exponential
+// backoff on failures worth retrying
+func (c *callbacks) deliver(logger *slog.Logger, callback string, result protocol.WASMResponse) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	backoff := initialCallbackBackoff
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		if strings.HasPrefix(callback, "arn:") {
+			retry, err = c.sendSQS(callback, body)
+		} else {
+			retry, err = c.post(callback, result.JobID, body)
+		}
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= c.retries {
+			return fmt.Errorf("giving up after %d attempts: %v", attempt+1, err)
+		}
+		logger.Warn("Job result delivery failed, retrying", "attempt", attempt+1, "error", err, "backoff", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post POSTs a result to an HTTPS callback, reporting whether a failure is
+// worth retrying
+func (c *callbacks) post(url, jobID string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Job-ID", jobID)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxCallbackResponseSize))
+	if resp.StatusCode/100 != 2 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("callback returned %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+// sendSQS sends a result as a message to the SQS queue named by an ARN,
+// reporting whether a failure is worth retrying
+func (c *callbacks) sendSQS(arn string, body []byte) (bool, error) {
+	// arn:aws:sqs:region:account:queue
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[3] == "" || parts[4] == "" || parts[5] == "" {
+		return false, fmt.Errorf("invalid SQS queue ARN %q", arn)
+	}
+	region, account, queue := parts[3], parts[4], parts[5]
+	creds, err := c.credentials.Credentials()
+	if err != nil {
+		return true, err
+	}
+	signing := *creds
+	signing.Region = region
+
+	endpoint := fmt.Sprintf("https://sqs.%s.amazonaws.com/", region)
+	input, err := json.Marshal(map[string]string{
+		"QueueUrl":    endpoint + account + "/" + queue,
+		"MessageBody": string(body),
+	})
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(input))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS.SendMessage")
+	sigv4.SignRequest(req, input, signing, "sqs", time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("SendMessage request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxCallbackResponseSize))
+	if resp.StatusCode != http.StatusOK {
+		var awsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(respBody, &awsErr)
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("SendMessage returned %d: %s %s", resp.StatusCode, awsErr.Type, awsErr.Message)
+	}
+	return false, nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"hello-wasm-enclave/internal/sigv4"
+)
+
+// Most metrics one PutMetricData call takes
+const maxCloudWatchMetricBatch = 1000
+
+// cloudWatchMetrics publishes what the host's Prometheus metrics counted in
+// each interval to CloudWatch with PutMetricData, so that dashboards and
+// alarms work without a Prometheus server or a CloudWatch agent:
+//
+//	Requests, Errors             requests forwarded, and those that failed (Count)
+//	ErrorRate                    Errors out of Requests (Percent)
+//	ShedRequests                 requests shed by admission control (Count)
+//	EnclaveRoundTripTime         round trips to the enclave (Milliseconds)
+//	CompileTime, ExecuteTime     as the enclave reports them (Milliseconds)
+//	EnclaveRestarts              enclave restarts, by Enclave (Count)
+//	EnclavesDown                 replicas skipped until they answer (Count)
+type cloudWatchMetrics struct {
+	namespace   string
+	interval    time.Duration
+	credentials *CredentialProvider
+	gatherer    prometheus.Gatherer
+	client      *http.Client
+
+	// Counter values and histogram bucket counts last published, by series
+	published map[string]float64
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// cloudWatchDatum is one metric of a PutMetricData call: either a value, or
+// a distribution of values with how often each was seen
+type cloudWatchDatum struct {
+	name       string
+	unit       string
+	dimensions [][2]string
+	value      float64
+	values     []float64
+	counts     []float64
+}
+
+// newCloudWatchMetrics starts publishing to namespace every interval, or
+// returns nil when namespace is empty
+func newCloudWatchMetrics(namespace string, interval time.Duration, credentials *CredentialProvider) (*cloudWatchMetrics, error) {
+	if namespace == "" {
+		return nil, nil
+	}
+	if interval < time.Second {
+		return nil, fmt.Errorf("-cloudwatch-interval must be at least 1s")
+	}
+	c := &cloudWatchMetrics{
+		namespace:   namespace,
+		interval:    interval,
+		credentials: credentials,
+		gatherer:    prometheus.DefaultGatherer,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		published:   make(map[string]float64),
+		done:        make(chan struct{}),
+	}
+	c.wg.Add(1)
+	go c.run()
+	return c, nil
+}
+
+// shutdown publishes what was counted since the last interval, giving up
+// after timeout
+func (c *cloudWatchMetrics) shutdown(timeout time.Duration) {
+	if c == nil {
+		return
+	}
+	close(c.done)
+	stopped := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+	}
+}
+
+func (c *cloudWatchMetrics) run() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.publish()
+		case <-c.done:
+			c.publish()
+			return
+		}
+	}
+}
+
+func (c *cloudWatchMetrics) publish() {
+	data, err := c.collect()
+	if err != nil {
+		slog.Warn("Failed to gather metrics for CloudWatch", "error", err)
+		return
+	}
+	now := time.Now()
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxCloudWatchMetricBatch {
+			n = maxCloudWatchMetricBatch
+		}
+		if err := c.put(data[:n], now); err != nil {
+			slog.Warn("Failed to publish metrics to CloudWatch", "namespace", c.namespace, "metrics", n, "error", err)
+		}
+		data = data[n:]
+	}
+}
+
+// collect returns the metrics of the interval since the last collect
+func (c *cloudWatchMetrics) collect() ([]cloudWatchDatum, error) {
+	families, err := c.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+
+	var requests, errors float64
+	for _, series := range c.counterDeltas(byName["wasm_host_requests_total"]) {
+		requests += series.delta
+		if series.labels["outcome"] != "ok" {
+			errors += series.delta
+		}
+	}
+	var shed float64
+	for _, series := range c.counterDeltas(byName["wasm_host_shed_requests_total"]) {
+		shed += series.delta
+	}
+	data := []cloudWatchDatum{
+		{name: "Requests", unit: "Count", value: requests},
+		{name: "Errors", unit: "Count", value: errors},
+		{name: "ShedRequests", unit: "Count", value: shed},
+	}
+	if requests > 0 {
+		data = append(data, cloudWatchDatum{name: "ErrorRate", unit: "Percent", value: 100 * errors / requests})
+	}
+	for _, latency := range []struct{ family, name string }{
+		{"wasm_host_enclave_round_trip_seconds", "EnclaveRoundTripTime"},
+		{"wasm_host_enclave_compile_seconds", "CompileTime"},
+		{"wasm_host_enclave_execute_seconds", "ExecuteTime"},
+	} {
+		if values, counts := c.histogramDelta(byName[latency.family], 1000); len(values) > 0 {
+			data = append(data, cloudWatchDatum{name: latency.name, unit: "Milliseconds", values: values, counts: counts})
+		}
+	}
+	for _, series := range c.counterDeltas(byName["wasm_host_enclave_restarts_total"]) {
+		data = append(data, cloudWatchDatum{name: "EnclaveRestarts", unit: "Count", dimensions: [][2]string{{"Enclave", series.labels["enclave"]}}, value: series.delta})
+	}
+	down := 0
+	if family := byName["wasm_host_enclave_up"]; family != nil {
+		for _, m := range family.GetMetric() {
+			if m.GetGauge().GetValue() == 0 {
+				down++
+			}
+		}
+	}
+	data = append(data, cloudWatchDatum{name: "EnclavesDown", unit: "Count", value: float64(down)})
+	return data, nil
+}
+
+type counterDelta struct {
+	labels map[string]string
+	delta  float64
+}
+
+// counterDeltas returns how much each series of family counted since it was
+// last published
+func (c *cloudWatchMetrics) counterDeltas(family *dto.MetricFamily) []counterDelta {
+	if family == nil {
+		return nil
+	}
+	deltas := make([]counterDelta, 0, len(family.GetMetric()))
+	for _, m := range family.GetMetric() {
+		labels := make(map[string]string, len(m.GetLabel()))
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		key := seriesKey(family.GetName(), m)
+		value := m.GetCounter().GetValue()
+		deltas = append(deltas, counterDelta{labels: labels, delta: value - c.published[key]})
+		c.published[key] = value
+	}
+	return deltas
+}
+
+// histogramDelta returns the observations of family since it was last
+// published, as its buckets' midpoints scaled by scale and how many fell
+// in each
+func (c *cloudWatchMetrics) histogramDelta(family *dto.MetricFamily, scale float64) (values, counts []float64) {
+	if family == nil {
+		return nil, nil
+	}
+	for _, m := range family.GetMetric() {
+		key := seriesKey(family.GetName(), m)
+		lower, below := 0.0, 0.0
+		for _, bucket := range m.GetHistogram().GetBucket() {
+			bucketKey := key + " le=" + strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64)
+			cumulative := float64(bucket.GetCumulativeCount())
+			delta := cumulative - c.published[bucketKey]
+			c.published[bucketKey] = cumulative
+			upper := bucket.GetUpperBound()
+			if n := delta - below; n > 0 {
+				values = append(values, scale*(lower+upper)/2)
+				counts = append(counts, n)
+			}
+			lower, below = upper, delta
+		}
+		// Observations above the last bucket are counted at its bound
+		total := float64(m.GetHistogram().GetSampleCount())
+		delta := total - c.published[key]
+		c.published[key] = total
+		if n := delta - below; n > 0 {
+			values = append(values, scale*lower)
+			counts = append(counts, n)
+		}
+	}
+	return values, counts
+}
+
+func seriesKey(name string, m *dto.Metric) string {
+	pairs := make([]string, 0, len(m.GetLabel()))
+	for _, label := range m.GetLabel() {
+		pairs = append(pairs, label.GetName()+"="+label.GetValue())
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// put sends data with PutMetricData, stamped with at
+func (c *cloudWatchMetrics) put(data []cloudWatchDatum, at time.Time) error {
+	creds, err := c.credentials.Credentials()
+	if err != nil {
+		return err
+	}
+	form := url.Values{
+		"Action":    {"PutMetricData"},
+		"Version":   {"2010-08-01"},
+		"Namespace": {c.namespace},
+	}
+	timestamp := at.UTC().Format(time.RFC3339)
+	for i, datum := range data {
+		prefix := fmt.Sprintf("MetricData.member.%d.", i+1)
+		form.Set(prefix+"MetricName", datum.name)
+		form.Set(prefix+"Unit", datum.unit)
+		form.Set(prefix+"Timestamp", timestamp)
+		for j, dimension := range datum.dimensions {
+			form.Set(fmt.Sprintf("%sDimensions.member.%d.Name", prefix, j+1), dimension[0])
+			form.Set(fmt.Sprintf("%sDimensions.member.%d.Value", prefix, j+1), dimension[1])
+		}
+		if datum.values == nil {
+			form.Set(prefix+"Value", formatMetric(datum.value))
+			continue
+		}
+		for j := range datum.values {
+			form.Set(fmt.Sprintf("%sValues.member.%d", prefix, j+1), formatMetric(datum.values[j]))
+			form.Set(fmt.Sprintf("%sCounts.member.%d", prefix, j+1), formatMetric(datum.counts[j]))
+		}
+	}
+	body := []byte(form.Encode())
+
+	endpoint := fmt.Sprintf("https://monitoring.%s.amazonaws.com/", creds.Region)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	sigv4.SignRequest(req, body, *creds, "monitoring", time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("PutMetricData request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		var awsErr struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		xml.Unmarshal(respBody, &awsErr)
+		return fmt.Errorf("PutMetricData returned %d: %s %s", resp.StatusCode, awsErr.Code, awsErr.Message)
+	}
+	return nil
+}
+
+func formatMetric(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		v = 0
+	}
+	return strconv.FormatFloat(v, 'g', 10, 64)
+}
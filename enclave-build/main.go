@@ -0,0 +1,197 @@
+// enclave-build builds the enclave server and its image file reproducibly,
+// and prints the PCRs an enclave booted from the image reports, so clients
+// can pin them with wasm-client -expected-pcr0 before the image is deployed.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"hello-wasm-enclave/internal/config"
+	"hello-wasm-enclave/internal/eif"
+)
+
+// Exit codes, as wasm-client's
+const (
+	exitFailed   = 1
+	exitUsage    = 2
+	exitMismatch = 6
+)
+
+// buildFlags make the enclave server the same bytes wherever it is built
+// with the same toolchain: paths, VCS stamps, build IDs and symbols are left
+// out, and it links statically, wasmtime's C library included, so it runs
+// in an image holding nothing else
+var buildFlags = []string{
+	"-trimpath",
+	"-buildvcs=false",
+	"-tags", "netgo,osusergo",
+	"-ldflags", "-s -w -buildid= -linkmode external -extldflags -static",
+}
+
+var commands = map[string]string{
+	"binary": "build the enclave server into -o and print its SHA-256",
+	"eif":    "build the Docker image and the image file -eif from it, and print its PCRs",
+	"pcrs":   "FILE  print the PCRs of an image file",
+}
+
+func main() {
+	output := flag.String("o", "bin/enclave-server", "where binary writes the enclave server")
+	eifPath := flag.String("eif", "wasm-executor-enclave.eif", "image file eif writes")
+	tag := flag.String("tag", "wasm-executor-enclave:latest", "Docker image eif builds and converts")
+	baseImage := flag.String("base-image", "", "image the Dockerfile builds on, pinned by digest (e.g. public.ecr.aws/amazonlinux/amazonlinux:2023@sha256:...) so rebuilds install the same packages; the Dockerfile's tag when empty")
+	wabtSHA256 := flag.String("wabt-sha256", "", "SHA-256 the wabt release archive the Dockerfile downloads must have, checked against the release page; eif needs it")
+	epoch := flag.Int64("source-date-epoch", -1, "timestamp, in Unix seconds, of every file in the image; that of the latest commit when negative")
+	expectedPCR0 := flag.String("expected-pcr0", "", "hex PCR0 the image must measure, such as that of an earlier build, to check that a build reproduces it")
+	jsonOutput := flag.Bool("json", false, "print the PCRs as JSON")
+	flag.Usage = usage
+	if err := config.Parse(flag.CommandLine, "ENCLAVE_BUILD", os.Args[1:]); err != nil {
+		log.Printf("Invalid configuration: %v", err)
+		os.Exit(exitUsage)
+	}
+	args := flag.Args()
+	if len(args) == 0 || commands[args[0]] == "" || (args[0] == "pcrs") != (len(args) == 2) || len(args) > 2 {
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	switch args[0] {
+	case "binary":
+		if err := buildBinary(*output); err != nil {
+			log.Printf("Build failed: %v", err)
+			os.Exit(exitFailed)
+		}
+		sum, err := fileDigest(*output)
+		if err != nil {
+			log.Printf("Build failed: %v", err)
+			os.Exit(exitFailed)
+		}
+		fmt.Printf("%s  %s\n", sum, *output)
+		return
+	case "eif":
+		if *epoch < 0 {
+			var err error
+			if *epoch, err = commitTime(); err != nil {
+				log.Printf("Failed to date the build, set -source-date-epoch: %v", err)
+				os.Exit(exitFailed)
+			}
+		}
+		if err := buildImage(*tag, *baseImage, *wabtSHA256, *epoch, *eifPath); err != nil {
+			log.Printf("Build failed: %v", err)
+			os.Exit(exitFailed)
+		}
+	case "pcrs":
+		*eifPath = args[1]
+	}
+
+	measurements, err := measure(*eifPath)
+	if err != nil {
+		log.Printf("Failed to measure %s: %v", *eifPath, err)
+		os.Exit(exitFailed)
+	}
+	if *jsonOutput {
+		out, _ := json.MarshalIndent(measurements, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("PCR0 %s\nPCR1 %s\nPCR2 %s\n", measurements.PCR0, measurements.PCR1, measurements.PCR2)
+		if measurements.Signed {
+			log.Println("The image is signed; PCR8 measures its signing certificate")
+		}
+	}
+	if *expectedPCR0 != "" && !strings.EqualFold(*expectedPCR0, measurements.PCR0) {
+		log.Printf("PCR0 is %s, expected %s: the build did not reproduce the image", measurements.PCR0, *expectedPCR0)
+		os.Exit(exitMismatch)
+	}
+}
+
+// buildBinary builds the enclave server into output with buildFlags
+func buildBinary(output string) error {
+	args := append([]string{"build"}, buildFlags...)
+	args = append(args, "-o", output, "./enclave")
+	cmd := exec.Command("go", args...)
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=1", "GOOS=linux", "GOFLAGS=-mod=readonly")
+	log.Printf("Building the enclave server with %s", runtime.Version())
+	return run(cmd)
+}
+
+// buildImage builds the Docker image with every timestamp set to epoch and
+// converts it into an image file with nitro-cli
+func buildImage(tag, baseImage, wabtSHA256 string, epoch int64, eifPath string) error {
+	if wabtSHA256 == "" {
+		return fmt.Errorf("-wabt-sha256 is needed to verify the wabt download")
+	}
+	args := []string{"buildx", "build",
+		"--build-arg", "SOURCE_DATE_EPOCH=" + strconv.FormatInt(epoch, 10),
+		"--build-arg", "WABT_SHA256=" + wabtSHA256,
+		"--output", "type=docker,name=" + tag + ",rewrite-timestamp=true",
+	}
+	if baseImage != "" {
+		args = append(args, "--build-arg", "BASE_IMAGE="+baseImage)
+	}
+	if err := run(exec.Command("docker", append(args, ".")...)); err != nil {
+		return fmt.Errorf("docker: %v", err)
+	}
+	if err := run(exec.Command("nitro-cli", "build-enclave", "--docker-uri", tag, "--output-file", eifPath)); err != nil {
+		return fmt.Errorf("nitro-cli: %v", err)
+	}
+	return nil
+}
+
+// run runs cmd, its output going to stderr so that stdout carries only
+// digests and PCRs
+func run(cmd *exec.Cmd) error {
+	log.Printf("Running %s", strings.Join(cmd.Args, " "))
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// commitTime returns the commit time of HEAD
+func commitTime() (int64, error) {
+	out, err := exec.Command("git", "log", "-1", "--format=%ct").Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func measure(path string) (eif.Measurements, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return eif.Measurements{}, err
+	}
+	defer f.Close()
+	return eif.Measure(f)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-o FILE] [-eif FILE] [-base-image IMAGE] [-expected-pcr0 HEX] COMMAND [ARG]\n\nCommands:\n", os.Args[0])
+	for _, name := range []string{"binary", "eif", "pcrs"} {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, commands[name])
+	}
+	fmt.Fprintln(os.Stderr, "\nFlags:")
+	flag.PrintDefaults()
+}
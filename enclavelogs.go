@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"hello-wasm-enclave/internal/sigv4"
+	"hello-wasm-enclave/internal/transport"
+)
+
+// Bounds on following an enclave's log channel: the longest record read,
+// and the delays between attempts to reach it again
+const (
+	maxEnclaveLogRecord = 256 << 10
+	minEnclaveLogRedial = time.Second
+	maxEnclaveLogRedial = 30 * time.Second
+)
+
+// CloudWatch Logs limits: PutLogEvents takes at most 10,000 events and 1
+// MiB, counting 26 bytes for each event. Records are sent every
+// cloudWatchFlushPeriod, and those beyond maxCloudWatchQueued dropped.
+const (
+	cloudWatchLogsTarget     = "Logs_20140328."
+	cloudWatchFlushPeriod    = 5 * time.Second
+	maxCloudWatchBatchEvents = 10000
+	maxCloudWatchBatchBytes  = 1 << 20
+	cloudWatchEventOverhead  = 26
+	maxCloudWatchQueued      = 50000
+)
+
+// enclaveLogSink takes the records that enclave replicas log
+type enclaveLogSink interface {
+	write(replica string, at time.Time, record []byte)
+	shutdown(ctx context.Context)
+}
+
+// enclaveLogs follows the log channel of every enclave replica, and hands
+// the records at or above level, marked with the replica, to a sink
+type enclaveLogs struct {
+	transport *transport.Transport
+	port      uint32
+	level     slog.Level
+	sink      enclaveLogSink
+}
+
+// newEnclaveLogs returns the follower of the enclaves' logs to destination,
+// "stdout" or "cloudwatch:GROUP", or nil when destination is empty
+func newEnclaveLogs(destination, level string, t *transport.Transport, port uint32, credentials *CredentialProvider) (*enclaveLogs, error) {
+	if destination == "" {
+		return nil, nil
+	}
+	l := &enclaveLogs{transport: t, port: port}
+	if err := l.level.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid -enclave-log-level %q: use debug, info, warn or error", level)
+	}
+	switch {
+	case destination == "stdout":
+		l.sink = &stdoutLogs{}
+	case strings.HasPrefix(destination, "cloudwatch:") && len(destination) > len("cloudwatch:"):
+		l.sink = newCloudWatchLogs(strings.TrimPrefix(destination, "cloudwatch:"), credentials)
+	default:
+		return nil, fmt.Errorf("invalid -enclave-logs %q: use stdout or cloudwatch:GROUP", destination)
+	}
+	return l, nil
+}
+
+// follow starts following every replica of enclaves
+func (l *enclaveLogs) follow(enclaves *enclaveRouter) {
+	if l == nil {
+		return
+	}
+	for _, replica := range enclaves.byID {
+		go l.followReplica(replica)
+	}
+}
+
+// followReplica reads the records of replica for as long as the host runs,
+// reaching it again whenever its log channel closes
+func (l *enclaveLogs) followReplica(replica *enclaveReplica) {
+	delay := minEnclaveLogRedial
+	for {
+		conn, err := l.transport.Dial(replica.cid, l.port)
+		if err != nil {
+			slog.Debug("Enclave log channel unreachable", "replica", replica.id, "error", err)
+		} else {
+			slog.Info("Following enclave logs", "replica", replica.id, "port", l.port)
+			delay = minEnclaveLogRedial
+			err = l.read(replica.id, conn)
+			conn.Close()
+			slog.Warn("Enclave log channel closed", "replica", replica.id, "error", err)
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxEnclaveLogRedial {
+			delay = maxEnclaveLogRedial
+		}
+	}
+}
+
+// read hands the records arriving on r to the sink until r ends
+func (l *enclaveLogs) read(replica string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxEnclaveLogRecord)
+	for scanner.Scan() {
+		var header struct {
+			Time  time.Time  `json:"time"`
+			Level slog.Level `json:"level"`
+		}
+		record := scanner.Bytes()
+		if len(record) < 2 || record[0] != '{' || json.Unmarshal(record, &header) != nil {
+			continue
+		}
+		if header.Level < l.level {
+			continue
+		}
+		l.sink.write(replica, header.Time, withReplica(replica, record))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// shutdown writes out the records still held for the sink
+func (l *enclaveLogs) shutdown(timeout time.Duration) {
+	if l == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	l.sink.shutdown(ctx)
+}
+
+// withReplica returns the JSON object record with an "enclave" field
+// naming replica first
+func withReplica(replica string, record []byte) []byte {
+	name, _ := json.Marshal(replica)
+	marked := make([]byte, 0, len(record)+len(name)+12)
+	marked = append(marked, `{"enclave":`...)
+	marked = append(marked, name...)
+	if rest := bytes.TrimSpace(record[1:]); len(rest) > 0 && rest[0] != '}' {
+		marked = append(marked, ',')
+	}
+	return append(marked, record[1:]...)
+}
+
+// stdoutLogs writes records to the host's standard output, one per line
+type stdoutLogs struct {
+	mu sync.Mutex
+}
+
+func (s *stdoutLogs) write(replica string, at time.Time, record []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	os.Stdout.Write(append(record, '\n'))
+}
+
+func (s *stdoutLogs) shutdown(ctx context.Context) {}
+
+// cloudWatchLogs sends records to a CloudWatch Logs group, in a stream for
+// each replica, in batches. Records beyond what it holds while CloudWatch
+// is slow or down are dropped.
+type cloudWatchLogs struct {
+	group       string
+	credentials *CredentialProvider
+	client      *http.Client
+
+	mu      sync.Mutex
+	pending map[string][]cloudWatchEvent
+	queued  int
+	dropped int
+	// Streams known to exist; only the sending goroutine uses it
+	created map[string]bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+type cloudWatchEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+func newCloudWatchLogs(group string, credentials *CredentialProvider) *cloudWatchLogs {
+	c := &cloudWatchLogs{
+		group:       group,
+		credentials: credentials,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		pending:     make(map[string][]cloudWatchEvent),
+		created:     make(map[string]bool),
+		done:        make(chan struct{}),
+	}
+	c.wg.Add(1)
+	go c.run()
+	return c
+}
+
+func (c *cloudWatchLogs) write(replica string, at time.Time, record []byte) {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.queued >= maxCloudWatchQueued {
+		c.dropped++
+		return
+	}
+	c.pending[replica] = append(c.pending[replica], cloudWatchEvent{Timestamp: at.UnixMilli(), Message: string(record)})
+	c.queued++
+}
+
+func (c *cloudWatchLogs) shutdown(ctx context.Context) {
+	close(c.done)
+	stopped := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+	}
+}
+
+func (c *cloudWatchLogs) run() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(cloudWatchFlushPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-c.done:
+			c.flush()
+			return
+		}
+	}
+}
+
+// flush sends every record queued, a batch for each stream at a time
+func (c *cloudWatchLogs) flush() {
+	c.mu.Lock()
+	pending := c.pending
+	dropped := c.dropped
+	c.pending = make(map[string][]cloudWatchEvent)
+	c.queued, c.dropped = 0, 0
+	c.mu.Unlock()
+
+	if dropped > 0 {
+		slog.Warn("Dropped enclave log records CloudWatch Logs could not take in time", "records", dropped)
+	}
+	streams := make([]string, 0, len(pending))
+	for stream := range pending {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+	for _, stream := range streams {
+		events := pending[stream]
+		for len(events) > 0 {
+			n := cloudWatchBatch(events)
+			if err := c.put(stream, events[:n]); err != nil {
+				slog.Warn("Failed to send enclave log records to CloudWatch Logs", "group", c.group, "stream", stream, "records", len(events), "error", err)
+				break
+			}
+			events = events[n:]
+		}
+	}
+}
+
+// cloudWatchBatch returns how many of events one PutLogEvents call takes
+func cloudWatchBatch(events []cloudWatchEvent) int {
+	size := 0
+	for i, event := range events {
+		size += len(event.Message) + cloudWatchEventOverhead
+		if i == maxCloudWatchBatchEvents || (size > maxCloudWatchBatchBytes && i > 0) {
+			return i
+		}
+	}
+	return len(events)
+}
+
+// put sends events to stream, creating it first if need be
+func (c *cloudWatchLogs) put(stream string, events []cloudWatchEvent) error {
+	if !c.created[stream] {
+		err := c.call("CreateLogStream", map[string]interface{}{"logGroupName": c.group, "logStreamName": stream})
+		if err != nil && !strings.Contains(err.Error(), "ResourceAlreadyExistsException") {
+			return err
+		}
+		c.created[stream] = true
+	}
+	err := c.call("PutLogEvents", map[string]interface{}{"logGroupName": c.group, "logStreamName": stream, "logEvents": events})
+	if err != nil && strings.Contains(err.Error(), "ResourceNotFoundException") {
+		// The stream was deleted, and is created again next time
+		delete(c.created, stream)
+	}
+	return err
+}
+
+// call performs a CloudWatch Logs API action
+func (c *cloudWatchLogs) call(action string, input interface{}) error {
+	creds, err := c.credentials.Credentials()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("https://logs.%s.amazonaws.com/", creds.Region)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", cloudWatchLogsTarget+action)
+	sigv4.SignRequest(req, body, *creds, "logs", time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %v", action, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		var awsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(respBody, &awsErr)
+		return fmt.Errorf("%s returned %d: %s %s", action, resp.StatusCode, awsErr.Type, awsErr.Message)
+	}
+	return nil
+}
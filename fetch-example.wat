@@ -0,0 +1,14 @@
+(module
+  ;; Fetches an allowlisted URL through the enclave; the body's hash is
+  ;; reported in the response and covered by the attestation document
+  (import "env" "http_get" (func $http_get (param i32 i32 i32 i32) (result i32)))
+
+  (memory (export "memory") 2)
+  (data (i32.const 0) "https://api.example.com/v1/price")
+
+  ;; Returns the length of the fetched body, or the negative http_get status
+  (func $body_length (result i32)
+    ;; Up to 64 KiB of the body lands at offset 65536
+    (call $http_get (i32.const 0) (i32.const 32) (i32.const 65536) (i32.const 65536)))
+
+  (export "body_length" (func $body_length)))
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"hello-wasm-enclave/api/wasmpb"
+	"hello-wasm-enclave/internal/drain"
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/tracing"
+)
+
+// grpcServer exposes the host service over gRPC (see api/wasmpb/wasm.proto).
+// Requests are translated into the same WASMRequests the JSON listener
+// forwards, so both front ends behave identically. Only executions are
+// offered: sessions, jobs and uploads stay JSON-only, and the tenant and
+// idempotency key come from metadata (see the comment atop the proto).
+type grpcServer struct {
+	wasmpb.UnimplementedWasmExecutorServer
+	host    *HostService
+	modules *ModuleRegistry
+}
+
+// serveGRPC listens on addr and serves gRPC until the listener fails or the
+// host drains
+func serveGRPC(addr string, host *HostService, modules *ModuleRegistry, tlsConfig *tls.Config, drainer *drain.Drainer) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	var options []grpc.ServerOption
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if host.sizeLimits.MaxRequestBytes > 0 {
+		options = append(options, grpc.MaxRecvMsgSize(host.sizeLimits.MaxRequestBytes))
+	}
+	server := grpc.NewServer(options...)
+	wasmpb.RegisterWasmExecutorServer(server, &grpcServer{host: host, modules: modules})
+	// GracefulStop refuses new calls and waits for those running, which
+	// are cut off when the drain timeout runs out
+	drainer.OnDrain(func(ctx context.Context) {
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			server.Stop()
+		}
+	})
+
+	log.Printf("Listening for gRPC clients on %s", addr)
+	return server.Serve(listener)
+}
+
+func (s *grpcServer) ExecuteWasm(ctx context.Context, in *wasmpb.ExecuteWasmRequest) (*wasmpb.ExecuteWasmResponse, error) {
+	received := time.Now()
+	code := in.WasmCode
+	if in.ModuleId != "" {
+		if code != "" {
+			return nil, status.Error(codes.InvalidArgument, "set only one of wasm_code and module_id")
+		}
+		// Modules are registered per tenant, so this needs the token's
+		tenant, err := s.host.auth.tenantOf(metadataToken(ctx))
+		if err != nil {
+			var refused *authError
+			errors.As(err, &refused)
+			return nil, authStatusError(refused.code, refused.message)
+		}
+		registered, ok, err := s.modules.Get(tenant, in.ModuleId)
+		if err != nil {
+			log.Printf("Failed to fetch module %s: %v", in.ModuleId, err)
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "module %s is neither registered nor an alias", in.ModuleId)
+		}
+		code = registered
+	}
+	req := protocol.WASMRequest{
+		RequestID:        in.RequestId,
+		CorrelationID:    in.CorrelationId,
+		ResultType:       in.ResultType,
+		WASMCode:         code,
+		ModuleName:       in.ModuleName,
+		Deterministic:    in.Deterministic,
+		Format:           in.Format,
+		FunctionName:     in.FunctionName,
+		Args:             in.Args,
+		TimeoutMS:        in.TimeoutMs,
+		MaxFuel:          in.MaxFuel,
+		MaxMemoryPages:   in.MaxMemoryPages,
+		MaxTableElements: in.MaxTableElements,
+		Features:         in.Features,
+		Secrets:          in.Secrets,
+		EncryptedSecrets: in.EncryptedSecrets,
+		KMSSecrets:       in.KmsSecrets,
+		SecretRefs:       in.SecretRefs,
+		Attest:           in.Attest,
+		Enclave:          in.Enclave,
+	}
+	addr, clientID := peerClient(ctx)
+	req.ClientID = clientID
+	req.AuthToken = metadataToken(ctx)
+	req.IdempotencyKey = metadataIdempotencyKey(ctx)
+	req.ResultSpec = fromPBResultSpec(in.ResultSpec)
+	req.TypedArgs = fromPBValues(in.TypedArgs)
+	for _, secret := range in.SecretList {
+		req.SecretList = append(req.SecretList, protocol.Secret{
+			Name:         secret.Name,
+			Value:        secret.Value,
+			Ref:          secret.Ref,
+			ModuleSHA256: secret.ModuleSha256,
+		})
+	}
+	for _, call := range in.Calls {
+		req.Calls = append(req.Calls, protocol.Call{
+			FunctionName: call.FunctionName,
+			Args:         call.Args,
+			TypedArgs:    fromPBValues(call.TypedArgs),
+			ResultType:   call.ResultType,
+			ResultSpec:   fromPBResultSpec(call.ResultSpec),
+		})
+	}
+	if len(in.Nonce) > 0 {
+		req.Nonce = base64.StdEncoding.EncodeToString(in.Nonce)
+	}
+	if len(in.ModuleSignature) > 0 {
+		req.ModuleSignature = base64.StdEncoding.EncodeToString(in.ModuleSignature)
+	}
+	req.TraceParent = metadataTraceParent(ctx)
+	logger := correlate(&req)
+	if err := req.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	logger.Info("Received gRPC ExecuteWasm", "function", in.FunctionName, "args", in.Args)
+
+	ctx, span := s.host.startRequest(ctx, req.TraceParent, received)
+	defer span.End()
+	response, err := s.host.forwardToEnclave(ctx, addr, req)
+	if err != nil {
+		logger.Error("Failed to forward gRPC request to enclave", "error", err)
+		span.SetError(err.Error())
+		return nil, status.Errorf(codes.Unavailable, "enclave communication error (correlation_id %s): %v", req.CorrelationID, err)
+	}
+	span.SetError(response.Error)
+	// gRPC encodes the message; respond covers building it
+	_, respond := tracing.Start(ctx, "respond")
+	defer respond.End()
+	if err := authStatusError(response.ErrorCode, response.Error); err != nil {
+		return nil, err
+	}
+	if response.ErrorCode == protocol.ErrorCodeRequestTooLarge {
+		return nil, status.Error(codes.InvalidArgument, response.Error)
+	}
+
+	out := &wasmpb.ExecuteWasmResponse{
+		RequestId:       response.RequestID,
+		CorrelationId:   response.CorrelationID,
+		Stdout:          response.Stdout,
+		Stderr:          response.Stderr,
+		OutputTruncated: response.OutputTruncated,
+		Result:          response.Result,
+		Error:           response.Error,
+		ErrorCode:       response.ErrorCode,
+		FuelConsumed:    response.FuelConsumed,
+		RetryAfterMs:    response.RetryAfterMS,
+		Cached:          response.Cached,
+	}
+	out.ResultValue = toPBValue(response.ResultValue)
+	if response.Receipt != nil {
+		receipt, err := json.Marshal(response.Receipt)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to encode receipt: %v", err)
+		}
+		out.Receipt = string(receipt)
+	}
+	if m := response.Metadata; m != nil {
+		out.Metadata = &wasmpb.Metadata{
+			CompileUs:      m.CompileUS,
+			InstantiateUs:  m.InstantiateUS,
+			CallUs:         m.CallUS,
+			FuelConsumed:   m.FuelConsumed,
+			MemoryPages:    m.MemoryPages,
+			ModuleSource:   m.ModuleSource,
+			ModuleCacheHit: m.ModuleCacheHit,
+		}
+	}
+	for _, fetch := range response.Fetches {
+		out.Fetches = append(out.Fetches, &wasmpb.Fetch{Url: fetch.URL, Sha256: fetch.SHA256, Bytes: int64(fetch.Bytes)})
+	}
+	for _, result := range response.Results {
+		out.Results = append(out.Results, &wasmpb.CallResult{
+			Result:      result.Result,
+			ResultValue: toPBValue(result.ResultValue),
+			Error:       result.Error,
+			ErrorCode:   result.ErrorCode,
+		})
+	}
+	if response.Attestation != "" {
+		if out.Attestation, err = base64.StdEncoding.DecodeString(response.Attestation); err != nil {
+			return nil, status.Errorf(codes.Internal, "enclave returned a malformed attestation: %v", err)
+		}
+	}
+	return out, nil
+}
+
+// peerClient returns the address of the client of a call and, over mTLS,
+// its certificate identity
+func peerClient(ctx context.Context) (addr, clientID string) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", ""
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		clientID = tlsIdentity(&info.State)
+	}
+	return clientAddr(p.Addr.String()), clientID
+}
+
+// metadataToken returns the bearer token in a call's authorization metadata
+func metadataToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, value := range md.Get("authorization") {
+		if token := strings.TrimPrefix(value, "Bearer "); token != value {
+			return token
+		}
+	}
+	return ""
+}
+
+// metadataIdempotencyKey returns the idempotency key of an execution, which
+// travels in idempotency-key metadata as the message has no field for it
+func metadataIdempotencyKey(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get("idempotency-key"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// metadataTraceParent returns the W3C trace context of an execution, which
+// travels in traceparent metadata as the message has no field for it
+func metadataTraceParent(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get("traceparent"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// authStatusError turns a refusal by authentication into a gRPC status, and
+// returns nil for other error codes
+func authStatusError(code, message string) error {
+	switch code {
+	case protocol.ErrorCodeUnauthenticated:
+		return status.Error(codes.Unauthenticated, message)
+	case protocol.ErrorCodeForbidden:
+		return status.Error(codes.PermissionDenied, message)
+	}
+	return nil
+}
+
+func fromPBValues(values []*wasmpb.Value) []protocol.Value {
+	var out []protocol.Value
+	for _, v := range values {
+		out = append(out, protocol.Value{Type: v.Type, Value: v.Value})
+	}
+	return out
+}
+
+func fromPBResultSpec(spec *wasmpb.ResultSpec) *protocol.ResultSpec {
+	if spec == nil {
+		return nil
+	}
+	return &protocol.ResultSpec{Type: spec.Type, Layout: spec.Layout}
+}
+
+func toPBValue(v *protocol.Value) *wasmpb.Value {
+	if v == nil {
+		return nil
+	}
+	return &wasmpb.Value{Type: v.Type, Value: v.Value}
+}
+
+func (s *grpcServer) RegisterModule(ctx context.Context, in *wasmpb.RegisterModuleRequest) (*wasmpb.RegisterModuleResponse, error) {
+	tenant, err := s.host.auth.tenantOf(metadataToken(ctx))
+	if err != nil {
+		var refused *authError
+		errors.As(err, &refused)
+		return nil, authStatusError(refused.code, refused.message)
+	}
+	if err := s.host.sizeLimits.CheckWASM(in.WasmCode); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	id, err := s.modules.Register(tenant, in.WasmCode)
+	if errors.Is(err, errRegistryFull) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if errors.Is(err, errModuleStore) {
+		log.Printf("Failed to store module: %v", err)
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	log.Printf("Registered module %s (%d bytes)", id, len(in.WasmCode))
+	return &wasmpb.RegisterModuleResponse{ModuleId: id}, nil
+}
+
+func (s *grpcServer) GetAttestation(ctx context.Context, in *wasmpb.GetAttestationRequest) (*wasmpb.GetAttestationResponse, error) {
+	req := protocol.WASMRequest{Type: protocol.RequestTypePublicKey, Enclave: in.Enclave}
+	addr, clientID := peerClient(ctx)
+	req.ClientID = clientID
+	req.AuthToken = metadataToken(ctx)
+	if in.SigningKey {
+		req.Type = protocol.RequestTypeSigningKey
+	}
+	if len(in.Nonce) > 0 {
+		req.Nonce = base64.StdEncoding.EncodeToString(in.Nonce)
+	}
+
+	response, err := s.host.forwardToEnclave(ctx, addr, req)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "enclave communication error: %v", err)
+	}
+	if err := authStatusError(response.ErrorCode, response.Error); err != nil {
+		return nil, err
+	}
+	if response.ErrorCode == protocol.ErrorCodeRateLimited {
+		return nil, status.Error(codes.ResourceExhausted, response.Error)
+	}
+	encodedKey := response.PublicKey
+	if in.SigningKey {
+		encodedKey = response.SigningKey
+	}
+	if encodedKey == "" {
+		return nil, status.Errorf(codes.Internal, "enclave did not return a public key: %s", response.Error)
+	}
+	if response.Attestation == "" {
+		// Outside a Nitro enclave there is no NSM to attest with
+		return nil, status.Errorf(codes.FailedPrecondition, "enclave could not attest its key: %s", response.Error)
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "enclave returned a malformed public key: %v", err)
+	}
+	attestation, err := base64.StdEncoding.DecodeString(response.Attestation)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "enclave returned a malformed attestation: %v", err)
+	}
+	return &wasmpb.GetAttestationResponse{PublicKey: publicKey, Attestation: attestation}, nil
+}
+
+func (s *grpcServer) GetAuditLog(ctx context.Context, in *wasmpb.GetAuditLogRequest) (*wasmpb.GetAuditLogResponse, error) {
+	addr, clientID := peerClient(ctx)
+	req := protocol.WASMRequest{Type: protocol.RequestTypeAuditLog, Enclave: in.Enclave, ClientID: clientID, AuthToken: metadataToken(ctx)}
+	response, err := s.host.forwardToEnclave(ctx, addr, req)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "enclave communication error: %v", err)
+	}
+	if err := authStatusError(response.ErrorCode, response.Error); err != nil {
+		return nil, err
+	}
+	if response.ErrorCode == protocol.ErrorCodeRateLimited {
+		return nil, status.Error(codes.ResourceExhausted, response.Error)
+	}
+	if response.AuditLog == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "enclave did not return an audit log: %s", response.Error)
+	}
+	auditLog, err := json.Marshal(response.AuditLog)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode audit log: %v", err)
+	}
+	return &wasmpb.GetAuditLogResponse{AuditLog: string(auditLog)}, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// hello wraps the enclave's answer to a client's hello request in the
+// host's own. An enclave that predates the handshake answers with an error,
+// which only leaves the client without enclave capabilities.
+func (h *HostService) hello(logger *slog.Logger, req protocol.WASMRequest, response protocol.WASMResponse) protocol.WASMResponse {
+	logger.Info("Client hello", "client_version", req.ProtocolVersion, "version", protocol.ProtocolVersion)
+	if response.Hello == nil {
+		logger.Warn("Enclave does not answer hello requests", "error", response.Error)
+	}
+
+	operations := []string{protocol.OperationBatch, protocol.OperationSessions, protocol.OperationMemory, protocol.OperationGlobals, protocol.OperationJobs, protocol.OperationPrecompiled, protocol.OperationBoundSecrets, protocol.OperationUploads, protocol.OperationEmits, protocol.OperationProgress}
+	if h.moduleRegistration {
+		operations = append(operations, protocol.OperationRegister)
+	}
+	if h.oci != nil {
+		operations = append(operations, protocol.OperationOCI)
+	}
+	return protocol.WASMResponse{
+		RequestID: response.RequestID,
+		Hello: &protocol.Capabilities{
+			ProtocolVersion: protocol.ProtocolVersion,
+			RequestTypes:    protocol.RequestTypes,
+			ValueTypes:      protocol.ValueTypes,
+			Operations:      operations,
+			MaxCalls:        protocol.MaxCalls,
+			Enclave:         response.Hello,
+		},
+	}
+}
+
+// reportEnclave asks an enclave what it speaks over its first connection and
+// logs the answer, with the startup checks it failed, so a misbuilt image
+// shows when the host starts rather than on the first request
+func reportEnclave(id string, c *enclaveConn, timeout time.Duration) {
+	response, err := c.probe(protocol.WASMRequest{Type: protocol.RequestTypeHello, RequestID: "hello-" + id, ProtocolVersion: protocol.ProtocolVersion}, timeout)
+	if err != nil || response.Hello == nil {
+		log.Printf("Warning: enclave %s did not report its capabilities: %v", id, err)
+		return
+	}
+	hello := response.Hello
+	runtime := hello.Runtime
+	if runtime == "" || runtime == "wasmtime" {
+		runtime = "wasmtime " + hello.WasmtimeVersion
+	}
+	log.Printf("Enclave %s speaks protocol %d and runs executions on %s with features %v; operations: %v",
+		id, hello.ProtocolVersion, runtime, hello.WasmFeatures, hello.Operations)
+
+	failures := hello.SelfTest.Failures()
+	enclaveSelfTestFailures.WithLabelValues(id).Set(float64(len(failures)))
+	switch {
+	case hello.SelfTest == nil:
+		log.Printf("Enclave %s reported no self-test", id)
+	case len(failures) == 0:
+		log.Printf("Enclave %s passed its self-test", id)
+	default:
+		for _, check := range failures {
+			log.Printf("ERROR: Enclave %s failed its %s self-test: %s", id, check.Name, check.Error)
+		}
+	}
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"hello-wasm-enclave/internal/drain"
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/wire"
+)
+
+// correlationHeader may carry the correlation ID of an execute request; the
+// response always carries the one that was used
+const correlationHeader = "X-Correlation-ID"
+
+// idempotencyHeader may carry the idempotency_key of an execute request
+const idempotencyHeader = "Idempotency-Key"
+
+// traceparentHeader may carry the W3C trace context of an execute request
+const traceparentHeader = "Traceparent"
+
+// httpExecuteRequest is the body of POST /v1/execute: a WASMRequest that may
+// name a registered module, by ID or alias, instead of carrying its code
+type httpExecuteRequest struct {
+	protocol.WASMRequest
+	ModuleID string `json:"module_id,omitempty"`
+}
+
+type httpModuleRequest struct {
+	WASMCode string `json:"wasm_code"`
+}
+
+type httpModuleResponse struct {
+	ModuleID string `json:"module_id"`
+}
+
+// httpAliasRequest is the body of PUT /v1/aliases/NAME
+type httpAliasRequest struct {
+	ModuleID string `json:"module_id"`
+	// Module the alias must still point at for it to move
+	PreviousModuleID string `json:"previous_module_id,omitempty"`
+}
+
+type httpAliasResponse struct {
+	Alias            string `json:"alias"`
+	ModuleID         string `json:"module_id,omitempty"`
+	PreviousModuleID string `json:"previous_module_id,omitempty"`
+}
+
+type httpAliasesResponse struct {
+	Aliases map[string]string `json:"aliases"`
+}
+
+type httpHealthResponse struct {
+	Status  string                 `json:"status"`
+	Modules int                    `json:"modules"`
+	Enclave *protocol.HealthStatus `json:"enclave,omitempty"`
+	// Each enclave replica's readiness, on a host with several
+	Enclaves map[string]backendHealth `json:"enclaves,omitempty"`
+	Error    string                   `json:"error,omitempty"`
+}
+
+type httpErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// httpServer exposes the host service as a JSON REST API:
+//
+//	POST /v1/execute  run a function, body and response as on the TCP listener
+//	POST /v1/modules  register a module, returns its module_id
+//	GET  /v1/aliases  the tenant's module aliases and the module IDs they point at
+//	GET, PUT, DELETE /v1/aliases/NAME  read, point elsewhere or remove an alias; changes take an admin client
+//	GET  /healthz     liveness of the host process
+//	GET  /readyz      whether each enclave has a replica that answers, with their health status
+//	GET  /metrics     Prometheus metrics
+type httpServer struct {
+	host    *HostService
+	modules *ModuleRegistry
+	maxBody int64
+}
+
+// serveHTTP listens on addr and serves the REST API until the listener fails
+func serveHTTP(addr string, host *HostService, modules *ModuleRegistry, maxBody int64, tlsConfig *tls.Config, drainer *drain.Drainer) error {
+	s := &httpServer{host: host, modules: modules, maxBody: maxBody}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/execute", s.handleExecute)
+	mux.HandleFunc("/v1/modules", s.handleModules)
+	mux.HandleFunc("/v1/aliases", s.handleAliases)
+	mux.HandleFunc("/v1/aliases/", s.handleAlias)
+	mux.HandleFunc("/v1/hello", s.handleHello)
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{Addr: addr, Handler: mux, TLSConfig: tlsConfig}
+	// Shutdown stops listening and waits for handlers to finish
+	drainer.OnDrain(func(ctx context.Context) {
+		server.Shutdown(ctx)
+	})
+	var err error
+	if tlsConfig != nil {
+		log.Printf("Listening for HTTPS clients on %s", addr)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("Listening for HTTP clients on %s", addr)
+		err = server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *httpServer) handleExecute(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	received := time.Now()
+	var body httpExecuteRequest
+	if !s.decodeBody(w, r, &body) {
+		return
+	}
+	req := body.WASMRequest
+	req.Type = ""
+	req.ClientID = tlsIdentity(r.TLS)
+	if token := bearerToken(r); token != "" {
+		req.AuthToken = token
+	}
+	if req.CorrelationID == "" {
+		req.CorrelationID = r.Header.Get(correlationHeader)
+	}
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = r.Header.Get(idempotencyHeader)
+	}
+	if header := r.Header.Get(traceparentHeader); header != "" {
+		req.TraceParent = header
+	}
+	logger := correlate(&req)
+	w.Header().Set(correlationHeader, req.CorrelationID)
+
+	if body.ModuleID != "" {
+		if req.WASMCode != "" {
+			writeHTTPError(w, http.StatusBadRequest, "set only one of wasm_code and module_id")
+			return
+		}
+		// Modules are registered per tenant, so this needs the token's
+		tenant, err := s.host.auth.tenantOf(bearerToken(r))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeHTTPError(w, authStatus(err), err.Error())
+			return
+		}
+		code, ok, err := s.modules.Get(tenant, body.ModuleID)
+		if err != nil {
+			log.Printf("Failed to fetch module %s: %v", body.ModuleID, err)
+			writeHTTPError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		if !ok {
+			writeHTTPError(w, http.StatusNotFound, fmt.Sprintf("module %s is neither registered nor an alias", body.ModuleID))
+			return
+		}
+		req.WASMCode = code
+	}
+	if err := req.Validate(); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.Info("Received HTTP execute request", "function", req.FunctionName, "args", req.Args)
+
+	ctx, span := s.host.startRequest(r.Context(), req.TraceParent, received)
+	response, err := s.host.forwardToEnclave(ctx, clientAddr(r.RemoteAddr), req)
+	if err != nil {
+		logger.Error("Failed to forward HTTP request to enclave", "error", err)
+		response = protocol.WASMResponse{
+			RequestID:     req.RequestID,
+			CorrelationID: req.CorrelationID,
+			Error:         fmt.Sprintf("Enclave communication error: %v", err),
+		}
+		finishRequest(ctx, span, response.Error, func() { writeJSON(w, http.StatusBadGateway, response) })
+		return
+	}
+	if response.ErrorCode == protocol.ErrorCodeUnauthenticated {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	if response.RetryAfterMS > 0 {
+		// Retry-After is in whole seconds
+		w.Header().Set("Retry-After", strconv.FormatInt((response.RetryAfterMS+999)/1000, 10))
+	}
+	finishRequest(ctx, span, response.Error, func() { writeJSON(w, executeStatus(response), response) })
+}
+
+// executeStatus maps an enclave response onto an HTTP status code
+func executeStatus(response protocol.WASMResponse) int {
+	switch {
+	case response.Error == "":
+		return http.StatusOK
+	case response.ErrorCode == protocol.ErrorCodeTimeout, response.ErrorCode == protocol.ErrorCodeCancelled:
+		return http.StatusGatewayTimeout
+	case response.ErrorCode == protocol.ErrorCodePolicy:
+		return http.StatusForbidden
+	case response.ErrorCode == protocol.ErrorCodeOverloaded, response.ErrorCode == protocol.ErrorCodeShuttingDown, response.ErrorCode == protocol.ErrorCodeEnclaveUnavailable:
+		return http.StatusServiceUnavailable
+	case response.ErrorCode == protocol.ErrorCodeRateLimited:
+		return http.StatusTooManyRequests
+	case response.ErrorCode == protocol.ErrorCodeUnauthenticated:
+		return http.StatusUnauthorized
+	case response.ErrorCode == protocol.ErrorCodeForbidden:
+		return http.StatusForbidden
+	case response.ErrorCode == protocol.ErrorCodeRequestTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case response.ErrorCode == protocol.ErrorCodeModuleUnavailable:
+		return http.StatusBadGateway
+	case response.ErrorCode == protocol.ErrorCodeInternal:
+		return http.StatusInternalServerError
+	case response.ErrorCode == protocol.ErrorCodeInvalidRequest, response.ErrorCode == protocol.ErrorCodeSecretMissing:
+		return http.StatusBadRequest
+	default:
+		// The request reached the enclave but could not be executed: a
+		// trap, a bad module, or an exceeded limit
+		return http.StatusUnprocessableEntity
+	}
+}
+
+func (s *httpServer) handleModules(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	tenant, err := s.host.auth.tenantOf(bearerToken(r))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeHTTPError(w, authStatus(err), err.Error())
+		return
+	}
+
+	var body httpModuleRequest
+	if !s.decodeBody(w, r, &body) {
+		return
+	}
+	if err := s.host.sizeLimits.CheckWASM(body.WASMCode); err != nil {
+		writeHTTPError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	id, err := s.modules.Register(tenant, body.WASMCode)
+	if errors.Is(err, errRegistryFull) {
+		writeHTTPError(w, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+	if errors.Is(err, errModuleStore) {
+		log.Printf("Failed to store module: %v", err)
+		writeHTTPError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("Registered module %s (%d bytes)", id, len(body.WASMCode))
+	writeJSON(w, http.StatusCreated, httpModuleResponse{ModuleID: id})
+}
+
+// handleAliases lists the module aliases of the client's tenant
+func (s *httpServer) handleAliases(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	tenant, err := s.host.auth.tenantOf(bearerToken(r))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeHTTPError(w, authStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, httpAliasesResponse{Aliases: s.modules.Aliases(tenant)})
+}
+
+// handleAlias reads an alias, points it at another module, which is how
+// operators roll a module forward or back without changing clients, or
+// removes it
+func (s *httpServer) handleAlias(w http.ResponseWriter, r *http.Request) {
+	alias := strings.TrimPrefix(r.URL.Path, "/v1/aliases/")
+	authorize := s.host.auth.tenantOf
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodDelete:
+		authorize = s.host.auth.adminOf
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeHTTPError(w, http.StatusMethodNotAllowed, "use GET, PUT or DELETE")
+		return
+	}
+	tenant, err := authorize(bearerToken(r))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeHTTPError(w, authStatus(err), err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		id, ok := s.modules.Aliases(tenant)[alias]
+		if !ok {
+			writeHTTPError(w, http.StatusNotFound, fmt.Sprintf("alias %s does not exist", alias))
+			return
+		}
+		writeJSON(w, http.StatusOK, httpAliasResponse{Alias: alias, ModuleID: id})
+	case http.MethodDelete:
+		previous, ok, err := s.modules.DeleteAlias(tenant, alias)
+		if err != nil {
+			log.Printf("Failed to store aliases: %v", err)
+			writeHTTPError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		if !ok {
+			writeHTTPError(w, http.StatusNotFound, fmt.Sprintf("alias %s does not exist", alias))
+			return
+		}
+		log.Printf("Removed alias %s of module %s", alias, previous)
+		writeJSON(w, http.StatusOK, httpAliasResponse{Alias: alias, PreviousModuleID: previous})
+	case http.MethodPut:
+		var body httpAliasRequest
+		if !s.decodeBody(w, r, &body) {
+			return
+		}
+		previous, err := s.modules.SetAlias(tenant, alias, body.ModuleID, body.PreviousModuleID)
+		switch {
+		case errors.Is(err, errUnknownModule):
+			writeHTTPError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, errAliasConflict):
+			writeHTTPError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, errModuleStore):
+			log.Printf("Failed to store aliases: %v", err)
+			writeHTTPError(w, http.StatusBadGateway, err.Error())
+		case err != nil:
+			writeHTTPError(w, http.StatusBadRequest, err.Error())
+		default:
+			log.Printf("Pointed alias %s at module %s (was %q)", alias, body.ModuleID, previous)
+			writeJSON(w, http.StatusOK, httpAliasResponse{Alias: alias, ModuleID: body.ModuleID, PreviousModuleID: previous})
+		}
+	}
+}
+
+// handleHello reports what the host and the enclave behind it speak
+func (s *httpServer) handleHello(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	req := protocol.WASMRequest{
+		Type:            protocol.RequestTypeHello,
+		ProtocolVersion: protocol.ProtocolVersion,
+		ClientID:        tlsIdentity(r.TLS),
+		AuthToken:       bearerToken(r),
+		CorrelationID:   r.Header.Get(correlationHeader),
+	}
+	response, err := s.host.forwardToEnclave(r.Context(), clientAddr(r.RemoteAddr), req)
+	if err != nil {
+		log.Printf("Failed to forward hello to enclave: %v", err)
+		writeHTTPError(w, http.StatusBadGateway, fmt.Sprintf("Enclave communication error: %v", err))
+		return
+	}
+	w.Header().Set(correlationHeader, response.CorrelationID)
+	if response.ErrorCode == protocol.ErrorCodeUnauthenticated {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	if response.RetryAfterMS > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt((response.RetryAfterMS+999)/1000, 10))
+	}
+	if response.Hello == nil {
+		writeJSON(w, executeStatus(response), response)
+		return
+	}
+	writeJSON(w, http.StatusOK, response.Hello)
+}
+
+func (s *httpServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, httpHealthResponse{Status: "ok", Modules: s.modules.Len()})
+}
+
+// handleReady proxies a health check to each enclave replica, so load
+// balancer checks fail while the host cannot reach any replica of one of
+// its enclaves. A replica that is down while others answer only shows in
+// the details.
+func (s *httpServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	health, err := s.host.enclaveHealth()
+	response := httpHealthResponse{Status: "ready", Modules: s.modules.Len()}
+	status := http.StatusOK
+	for id, replica := range health {
+		if replica.Error != "" {
+			log.Printf("Readiness check of enclave %s failed: %s", id, replica.Error)
+		}
+	}
+	if err != nil {
+		response.Status = "unavailable"
+		response.Error = err.Error()
+		status = http.StatusServiceUnavailable
+	}
+	if len(health) == 1 {
+		// A single enclave reports as before there could be several
+		for _, replica := range health {
+			response.Enclave = replica.Enclave
+			if replica.Error != "" {
+				response.Error = replica.Error
+			}
+		}
+	} else {
+		response.Enclaves = health
+	}
+	writeJSON(w, status, response)
+}
+
+// decodeBody reads a size-limited JSON body into v, strictly if it asks to
+// be, writing the error response itself when it fails
+func (s *httpServer) decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBody))
+	var raw json.RawMessage
+	err := decoder.Decode(&raw)
+	if err == nil {
+		err = wire.Unmarshal(raw, v)
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeHTTPError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", s.maxBody))
+			return false
+		}
+		writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return false
+	}
+	return true
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return header[len(prefix):]
+}
+
+// authStatus is the HTTP status for a failed authentication
+func authStatus(err error) int {
+	var refused *authError
+	if errors.As(err, &refused) && refused.code == protocol.ErrorCodeForbidden {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Sprintf("use %s", method))
+	return false
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, httpErrorResponse{Error: message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write HTTP response: %v", err)
+	}
+}
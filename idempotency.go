@@ -0,0 +1,167 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Defaults for -idempotency-keys and -idempotency-ttl
+const (
+	defaultIdempotencyKeys = 10000
+	defaultIdempotencyTTL  = 24 * time.Hour
+)
+
+// idempotencyKeys remembers the responses to executions that carried an
+// idempotency_key, so that a client retrying one after a timeout gets the
+// original response instead of running a module with side effects, such as
+// signing, twice. Such executions run to the end even when their client
+// gives up on them, and a retry that arrives while one still runs waits for
+// it. Keys are kept apart by tenant and client, live for ttl, and the oldest
+// are forgotten beyond size.
+type idempotencyKeys struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries map[idempotencyID]*list.Element
+	// Oldest first
+	order *list.List
+}
+
+// idempotencyID is a key as its client chose it, within the client's tenant
+type idempotencyID struct {
+	tenant, client, key string
+}
+
+// idempotentExecution is the execution a key stands for. Once done is
+// closed it holds the outcome; unless remembered, the key was forgotten so
+// that a retry can run the execution again.
+type idempotentExecution struct {
+	id         idempotencyID
+	digest     [sha256.Size]byte
+	expires    time.Time
+	done       chan struct{}
+	response   protocol.WASMResponse
+	err        error
+	remembered bool
+}
+
+// newIdempotencyKeys returns a record of size keys, or nil when size is
+// zero, which ignores idempotency keys
+func newIdempotencyKeys(size int, ttl time.Duration) *idempotencyKeys {
+	if size <= 0 {
+		return nil
+	}
+	return &idempotencyKeys{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[idempotencyID]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// begin returns the execution of id, and whether the caller is to run it.
+// A key that was used for another request is an error.
+func (k *idempotencyKeys) begin(id idempotencyID, digest [sha256.Size]byte) (*idempotentExecution, bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	for k.order.Len() > 0 {
+		oldest := k.order.Front()
+		if entry := oldest.Value.(*idempotentExecution); now.Before(entry.expires) && k.order.Len() < k.size {
+			break
+		}
+		k.remove(oldest)
+	}
+
+	if element, ok := k.entries[id]; ok {
+		entry := element.Value.(*idempotentExecution)
+		if entry.digest != digest {
+			return nil, false, &protocol.ValidationError{Code: protocol.ErrorCodeInvalidRequest, Message: fmt.Sprintf("idempotency_key %q was used for a different request", id.key)}
+		}
+		return entry, false, nil
+	}
+	entry := &idempotentExecution{id: id, digest: digest, expires: now.Add(k.ttl), done: make(chan struct{})}
+	k.entries[id] = k.order.PushBack(entry)
+	idempotencyKeyEntries.Set(float64(k.order.Len()))
+	return entry, true, nil
+}
+
+// finish records the response of an execution the caller ran, or forgets
+// the key when the execution did not get to run, so that a retry can
+func (k *idempotencyKeys) finish(entry *idempotentExecution, response protocol.WASMResponse, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	entry.response, entry.err = response, err
+	switch {
+	case err != nil, response.ErrorCode == protocol.ErrorCodeOverloaded, response.ErrorCode == protocol.ErrorCodeShuttingDown, response.ErrorCode == protocol.ErrorCodeCancelled, response.ErrorCode == protocol.ErrorCodeEnclaveUnavailable:
+		if element, ok := k.entries[entry.id]; ok && element.Value == entry {
+			k.remove(element)
+		}
+	default:
+		entry.remembered = true
+	}
+	close(entry.done)
+}
+
+// remove forgets the key of element; the caller holds k.mu
+func (k *idempotencyKeys) remove(element *list.Element) {
+	k.order.Remove(element)
+	delete(k.entries, element.Value.(*idempotentExecution).id)
+	idempotencyKeyEntries.Set(float64(k.order.Len()))
+}
+
+// forwardIdempotent forwards a request, unless it carries an idempotency key
+// whose execution already ran, in which case it answers with that response
+func (h *HostService) forwardIdempotent(ctx context.Context, logger *slog.Logger, req protocol.WASMRequest) (protocol.WASMResponse, error) {
+	if h.idempotency == nil || req.IdempotencyKey == "" {
+		return h.forwardJob(ctx, logger, req)
+	}
+	// Clients learn the enclave from their first response, so a retry may
+	// name the enclave the original left to routing
+	unrouted := req
+	unrouted.Enclave = ""
+	digest, err := requestDigest(unrouted)
+	if err != nil {
+		return protocol.WASMResponse{}, err
+	}
+	id := idempotencyID{tenant: req.Tenant, client: req.ClientID, key: req.IdempotencyKey}
+	for {
+		entry, run, err := h.idempotency.begin(id, digest)
+		if err != nil {
+			return protocol.WASMResponse{}, err
+		}
+		if run {
+			go func() {
+				response, err := h.forwardJob(context.WithoutCancel(ctx), logger, req)
+				h.idempotency.finish(entry, response, err)
+			}()
+		}
+
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return protocol.WASMResponse{}, ctx.Err()
+		}
+		if run {
+			return entry.response, entry.err
+		}
+		// An execution that did not get to run was forgotten; try again
+		if !entry.remembered {
+			continue
+		}
+		idempotentReplays.Inc()
+		logger.Info("Answering a retry with the original response", "idempotency_key", req.IdempotencyKey)
+		response := entry.response
+		response.RequestID = req.RequestID
+		response.Replayed = true
+		return response, nil
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+func TestIdempotencyKeyRefusesChangedAttestation(t *testing.T) {
+	original := protocol.WASMRequest{
+		WASMCode:       "(module)",
+		FunctionName:   "run",
+		IdempotencyKey: "retry-1",
+		Attest:         true,
+		Nonce:          "bm9uY2UtMQ==",
+	}
+	digest := func(req protocol.WASMRequest) [sha256.Size]byte {
+		t.Helper()
+		d, err := requestDigest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	keys := newIdempotencyKeys(10, time.Hour)
+	id := idempotencyID{key: original.IdempotencyKey}
+	entry, run, err := keys.begin(id, digest(original))
+	if err != nil || !run {
+		t.Fatalf("first begin = %v, %v; want to run", run, err)
+	}
+	keys.finish(entry, protocol.WASMResponse{Attestation: "document"}, nil)
+
+	if _, run, err := keys.begin(id, digest(original)); err != nil || run {
+		t.Fatalf("identical retry = %v, %v; want the original response", run, err)
+	}
+	freshNonce := original
+	freshNonce.Nonce = "bm9uY2UtMg=="
+	if _, _, err := keys.begin(id, digest(freshNonce)); err == nil {
+		t.Fatal("retry with a fresh nonce was answered with a document bound to the old one")
+	}
+	unattested := original
+	unattested.Attest, unattested.Nonce = false, ""
+	if _, _, err := keys.begin(id, digest(unattested)); err == nil {
+		t.Fatal("retry without attest matched an attested execution")
+	}
+}
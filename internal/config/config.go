@@ -0,0 +1,117 @@
+// Package config lets every flag of a binary also be set from an environment
+// variable or an optional YAML file, so deployments can change CIDs, ports
+// and limits without recompiling or rewriting command lines.
+//
+// A flag's value is taken from, in order of precedence: the command line, the
+// environment variable PREFIX_FLAG_NAME (upper case, dashes as underscores),
+// the YAML file named by -config, and finally the flag's default. The YAML
+// file is a flat mapping keyed by flag name:
+//
+//	enclave-cid: 17
+//	pool-size: 8
+//	ping-interval: 30s
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Parse parses args into fs, then fills every flag not given on the command
+// line from the environment or the config file. It adds a -config flag to fs.
+func Parse(fs *flag.FlagSet, envPrefix string, args []string) error {
+	configPath := fs.String("config", "", "optional YAML file of flag values (env "+EnvName(envPrefix, "config")+")")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	path := *configPath
+	if !explicit["config"] {
+		path = os.Getenv(EnvName(envPrefix, "config"))
+	}
+	file, err := readFile(fs, path)
+	if err != nil {
+		return err
+	}
+
+	var setErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if setErr != nil || explicit[f.Name] || f.Name == "config" {
+			return
+		}
+		if value, ok := os.LookupEnv(EnvName(envPrefix, f.Name)); ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				setErr = fmt.Errorf("invalid value %q for %s: %v", value, EnvName(envPrefix, f.Name), err)
+			}
+			return
+		}
+		if values, ok := file[f.Name]; ok {
+			for _, value := range values {
+				if err := fs.Set(f.Name, value); err != nil {
+					setErr = fmt.Errorf("invalid value %q for %s in %s: %v", value, f.Name, path, err)
+					return
+				}
+			}
+		}
+	})
+	return setErr
+}
+
+// EnvName returns the environment variable that sets the named flag
+func EnvName(envPrefix, name string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// readFile loads a config file into the flag values it sets. Sequences set a
+// repeatable flag once per element and mappings once per KEY=VALUE pair.
+func readFile(fs *flag.FlagSet, path string) (map[string][]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	values := make(map[string][]string, len(raw))
+	for name, value := range raw {
+		if fs.Lookup(name) == nil || name == "config" {
+			return nil, fmt.Errorf("unknown setting %q in config file %s", name, path)
+		}
+		switch v := value.(type) {
+		case []interface{}:
+			for _, item := range v {
+				values[name] = append(values[name], fmt.Sprint(item))
+			}
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				values[name] = append(values[name], fmt.Sprintf("%s=%v", key, v[key]))
+			}
+		case nil:
+			values[name] = []string{""}
+		default:
+			values[name] = []string{fmt.Sprint(v)}
+		}
+	}
+	return values, nil
+}
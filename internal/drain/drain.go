@@ -0,0 +1,148 @@
+// Package drain shuts the host and the enclave down gracefully. When
+// SIGTERM or SIGINT arrives, a Drainer closes the listeners it tracks so no
+// new connections arrive, refuses new requests on connections already open,
+// and waits for the requests in flight to finish and send their responses,
+// up to a timeout, before the process exits.
+package drain
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultTimeout is how long requests in flight are given to finish
+const DefaultTimeout = 30 * time.Second
+
+// Drainer tracks listeners and requests in flight
+type Drainer struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	// Closed once draining with nothing in flight
+	idle    chan struct{}
+	closers []io.Closer
+	hooks   []func(ctx context.Context)
+	// Signalled by Request
+	requested chan struct{}
+}
+
+func New() *Drainer {
+	return &Drainer{idle: make(chan struct{}), requested: make(chan struct{}, 1)}
+}
+
+// Track closes c, typically a listener, when draining starts
+func (d *Drainer) Track(c io.Closer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closers = append(d.closers, c)
+}
+
+// OnDrain runs f when draining starts, for servers that stop gracefully on
+// their own such as http.Server. f must return once ctx is done.
+func (d *Drainer) OnDrain(f func(ctx context.Context)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks = append(d.hooks, f)
+}
+
+// Start marks a request as in flight. It returns false once draining has
+// started, in which case the request must be refused and Done not called.
+func (d *Drainer) Start() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inFlight++
+	return true
+}
+
+// Done marks a request started with Start as finished
+func (d *Drainer) Done() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	if d.draining && d.inFlight == 0 {
+		close(d.idle)
+	}
+}
+
+// Draining reports whether draining has started, so accept loops can tell
+// a closed listener from a failing one
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Run blocks until SIGTERM or SIGINT, or a Request, then drains for up to
+// timeout
+func (d *Drainer) Run(timeout time.Duration) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case sig := <-signals:
+		log.Printf("Received %v, draining requests for up to %v", sig, timeout)
+	case <-d.requested:
+		log.Printf("Drain requested, draining requests for up to %v", timeout)
+	}
+	signal.Stop(signals)
+	return d.Drain(timeout)
+}
+
+// Request makes Run drain as if a signal had arrived, for operators asking
+// for a drain through an admin API
+func (d *Drainer) Request() {
+	select {
+	case d.requested <- struct{}{}:
+	default:
+	}
+}
+
+// Drain stops accepting work and waits up to timeout for what is in flight
+func (d *Drainer) Drain(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	d.mu.Lock()
+	if d.draining {
+		d.mu.Unlock()
+		return fmt.Errorf("already draining")
+	}
+	d.draining = true
+	if d.inFlight == 0 {
+		close(d.idle)
+	}
+	closers, hooks := d.closers, d.hooks
+	d.mu.Unlock()
+
+	for _, c := range closers {
+		c.Close()
+	}
+	var hooksDone sync.WaitGroup
+	for _, hook := range hooks {
+		hooksDone.Add(1)
+		go func(hook func(context.Context)) {
+			defer hooksDone.Done()
+			hook(ctx)
+		}(hook)
+	}
+
+	select {
+	case <-d.idle:
+	case <-ctx.Done():
+		d.mu.Lock()
+		left := d.inFlight
+		d.mu.Unlock()
+		return fmt.Errorf("%d requests still in flight after %v", left, timeout)
+	}
+	hooksDone.Wait()
+	return nil
+}
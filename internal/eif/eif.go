@@ -0,0 +1,138 @@
+// Package eif reads Nitro enclave image files and computes the PCRs an
+// enclave booted from one reports, as nitro-cli describe-eif does, so a
+// build can print the values attestation policies pin without nitro-cli.
+//
+// An image is a header followed by sections: the kernel, its command line,
+// ramdisks, and optionally a signature and metadata. Each PCR is a SHA-384
+// extended once from zero with the digest of the sections it covers:
+//
+//	PCR0  kernel, command line and every ramdisk: the whole image
+//	PCR1  kernel, command line and the first ramdisk, which nitro-cli
+//	      builds with the init and NSM driver
+//	PCR2  the other ramdisks, which hold the application's filesystem
+//
+// The metadata section, holding build times and paths, is measured by none
+// of them.
+package eif
+
+import (
+	"bufio"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Section types of an image
+const (
+	sectionKernel    = 1
+	sectionCmdline   = 2
+	sectionRamdisk   = 3
+	sectionSignature = 4
+	sectionMetadata  = 5
+)
+
+// Sections an image header has room for
+const maxSections = 32
+
+var magic = [4]byte{'.', 'e', 'i', 'f'}
+
+var errNotImage = errors.New("not an enclave image file")
+
+// Measurements are the PCRs of an image, in hex as nitro-cli prints them
+type Measurements struct {
+	PCR0 string `json:"PCR0"`
+	PCR1 string `json:"PCR1"`
+	PCR2 string `json:"PCR2"`
+	// Whether the image carries a signing certificate, which PCR8 measures
+	Signed bool `json:"signed"`
+}
+
+// header is the start of an image, big-endian
+type header struct {
+	Magic          [4]byte
+	Version        uint16
+	Flags          uint16
+	DefaultMemory  uint64
+	DefaultCPUs    uint64
+	Reserved       uint16
+	NumSections    uint16
+	SectionOffsets [maxSections]uint64
+	SectionSizes   [maxSections]uint64
+	Unused         uint32
+	CRC32          uint32
+}
+
+type sectionHeader struct {
+	Type  uint16
+	Flags uint16
+	Size  uint64
+}
+
+// Measure reads an image from r and returns its PCRs
+func Measure(r io.Reader) (Measurements, error) {
+	var m Measurements
+	br := bufio.NewReader(r)
+	var h header
+	if err := binary.Read(br, binary.BigEndian, &h); err != nil {
+		return m, fmt.Errorf("failed to read image header: %v", err)
+	}
+	if h.Magic != magic {
+		return m, errNotImage
+	}
+	if h.NumSections > maxSections {
+		return m, fmt.Errorf("image header lists %d sections, at most %d fit", h.NumSections, maxSections)
+	}
+
+	image, bootstrap, app := sha512.New384(), sha512.New384(), sha512.New384()
+	ramdisks := 0
+	for i := 0; i < int(h.NumSections); i++ {
+		var s sectionHeader
+		if err := binary.Read(br, binary.BigEndian, &s); err != nil {
+			return m, fmt.Errorf("failed to read header of section %d: %v", i, err)
+		}
+		if s.Size != h.SectionSizes[i] {
+			return m, fmt.Errorf("section %d holds %d bytes, the image header says %d", i, s.Size, h.SectionSizes[i])
+		}
+		var measured io.Writer = io.Discard
+		switch s.Type {
+		case sectionKernel, sectionCmdline:
+			measured = io.MultiWriter(image, bootstrap)
+		case sectionRamdisk:
+			if ramdisks == 0 {
+				measured = io.MultiWriter(image, bootstrap)
+			} else {
+				measured = io.MultiWriter(image, app)
+			}
+			ramdisks++
+		case sectionSignature:
+			m.Signed = true
+		case sectionMetadata:
+			// Build times and paths; measured by no PCR
+		default:
+			return m, fmt.Errorf("section %d has unknown type %d", i, s.Type)
+		}
+		if _, err := io.CopyN(measured, br, int64(s.Size)); err != nil {
+			return m, fmt.Errorf("failed to read section %d: %v", i, err)
+		}
+	}
+	if ramdisks == 0 {
+		return m, fmt.Errorf("image has no ramdisk")
+	}
+
+	m.PCR0 = extend(image)
+	m.PCR1 = extend(bootstrap)
+	m.PCR2 = extend(app)
+	return m, nil
+}
+
+// extend returns a zeroed PCR extended with the digest of h
+func extend(h hash.Hash) string {
+	pcr := sha512.New384()
+	pcr.Write(make([]byte, sha512.Size384))
+	pcr.Write(h.Sum(nil))
+	return hex.EncodeToString(pcr.Sum(nil))
+}
@@ -0,0 +1,43 @@
+package enclave
+
+import (
+	"log/slog"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// adminResponse answers the operator requests the host makes for its admin
+// API. None of them reveals a secret or lets the host into a session: the
+// host could already refuse to forward work, which is all that evicting
+// modules or killing sessions amounts to, and a rotated signing key is as
+// attested as the first.
+func (s *EnclaveServer) adminResponse(logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	response := protocol.WASMResponse{RequestID: wasmReq.RequestID}
+	switch wasmReq.Type {
+	case protocol.RequestTypeListModules:
+		response.CachedModules = s.executor.modules.list()
+	case protocol.RequestTypeEvictModules:
+		response.Evicted = s.executor.modules.evict(wasmReq.ModuleHash)
+		logger.Info("Evicted compiled modules", "module_hash", wasmReq.ModuleHash, "evicted", response.Evicted)
+	case protocol.RequestTypeListSessions:
+		response.Sessions = s.sessions.list()
+	case protocol.RequestTypeKillSession:
+		// Handles are looked for on every replica, so not finding one is
+		// no error
+		if s.sessions.kill(wasmReq.SessionHandle) {
+			response.Evicted = 1
+			logger.Info("Session killed", "session_handle", wasmReq.SessionHandle)
+		}
+	case protocol.RequestTypeRotateSigningKey:
+		signer, err := NewReceiptSigner(s.entropy)
+		if err != nil {
+			logger.Error("Failed to rotate the signing key", "error", err)
+			response.Error = err.Error()
+			return response
+		}
+		s.signer.Store(signer)
+		logger.Info("Rotated the receipt signing key")
+		return s.signingKeyResponse(logger, wasmReq)
+	}
+	return response
+}
@@ -0,0 +1,46 @@
+package enclave
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// moduleAllowlist holds the modules an enclave may run, by the SHA-256 of
+// wasm_code exactly as sent (the module_hash of receipts, and what sha256sum
+// prints for the module file)
+type moduleAllowlist map[string]bool
+
+// loadAllowlist reads hex digests, one per line. Anything after a digest on
+// its line, blank lines and lines starting with # are ignored, so the output
+// of sha256sum can be used as is.
+func loadAllowlist(path string) (moduleAllowlist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open module allowlist: %v", err)
+	}
+	defer f.Close()
+
+	allowlist := moduleAllowlist{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		digest := strings.ToLower(fields[0])
+		if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != 32 {
+			return nil, fmt.Errorf("module allowlist line %d: %q is not a SHA-256 digest", line, fields[0])
+		}
+		allowlist[digest] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read module allowlist: %v", err)
+	}
+	if len(allowlist) == 0 {
+		return nil, fmt.Errorf("module allowlist %s is empty", path)
+	}
+	return allowlist, nil
+}
@@ -0,0 +1,267 @@
+package enclave
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Exported allocators tried, in order, for string and bytes arguments.
+// __wbindgen_malloc takes (size, align); the others take (size).
+var argAllocators = []string{"malloc", "__wbindgen_malloc", "alloc"}
+
+const (
+	// Alignment of buffers placed without an allocator
+	argAlignment = 8
+	// Largest string or bytes result returned to the client
+	maxResultBytes = 16 << 20
+)
+
+// CallResult is a function's return value: I32 for a plain i32 result, or
+// Value for anything else. Err is set when the call failed.
+type CallResult struct {
+	I32   int32
+	Value *protocol.Value
+	Err   error
+	// When the call began and how long it took, for tracing
+	Started  time.Time
+	Duration time.Duration
+}
+
+// marshalArgs converts typed arguments into call arguments. String and bytes
+// arguments are copied into the instance's exported memory and become a
+// (pointer, length) pair. The memory comes from an exported allocator when
+// the module has one; otherwise the memory is grown and the buffers are
+// placed in the new pages, which the module cannot have used yet. Refs
+// become references, funcref handles looked up in refs.
+func marshalArgs(logger *slog.Logger, store *wasmtime.Store, instance *wasmtime.Instance, refs *refHandles, args []protocol.Value) ([]interface{}, error) {
+	decoded := make([]interface{}, len(args))
+	var buffers int
+	for i, arg := range args {
+		value, err := arg.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %v", i, err)
+		}
+		if ref, ok := value.(protocol.Ref); ok {
+			if value, err = refs.reference(store, instance, ref); err != nil {
+				return nil, fmt.Errorf("argument %d: %v", i, err)
+			}
+		}
+		if _, ok := value.([]byte); ok {
+			buffers++
+		}
+		decoded[i] = value
+	}
+	if buffers == 0 {
+		return decoded, nil
+	}
+
+	export := instance.GetExport(store, secretMemoryExport)
+	if export == nil || export.Memory() == nil {
+		return nil, fmt.Errorf("string and bytes arguments require an exported %q memory", secretMemoryExport)
+	}
+	memory := export.Memory()
+
+	allocate, err := argAllocator(logger, store, instance, memory, decoded)
+	if err != nil {
+		return nil, err
+	}
+
+	callArgs := make([]interface{}, 0, len(decoded)+buffers)
+	for i, value := range decoded {
+		data, ok := value.([]byte)
+		if !ok {
+			callArgs = append(callArgs, value)
+			continue
+		}
+		ptr, err := allocate(len(data))
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %v", i, err)
+		}
+		// The allocator may have grown the memory, so look it up afresh
+		dest, ok := memoryRange(memory.UnsafeData(store), ptr, int32(len(data)))
+		if !ok {
+			return nil, fmt.Errorf("argument %d: allocated buffer at %d is out of bounds", i, ptr)
+		}
+		copy(dest, data)
+		callArgs = append(callArgs, ptr, int32(len(data)))
+	}
+	return callArgs, nil
+}
+
+// argAllocator returns a function that reserves size bytes of memory
+func argAllocator(logger *slog.Logger, store *wasmtime.Store, instance *wasmtime.Instance, memory *wasmtime.Memory, decoded []interface{}) (func(size int) (int32, error), error) {
+	for _, name := range argAllocators {
+		export := instance.GetExport(store, name)
+		if export == nil || export.Func() == nil {
+			continue
+		}
+		fn := export.Func()
+		params := fn.Type(store).Params()
+		results := fn.Type(store).Results()
+		if len(results) != 1 || results[0].Kind() != wasmtime.KindI32 || len(params) < 1 || len(params) > 2 {
+			continue
+		}
+		logger.Info("Allocating arguments with exported allocator", "allocator", name)
+		return func(size int) (int32, error) {
+			callArgs := []interface{}{int32(size)}
+			if len(params) == 2 {
+				callArgs = append(callArgs, int32(argAlignment))
+			}
+			ptr, err := fn.Call(store, callArgs...)
+			if err != nil {
+				return 0, fmt.Errorf("%s failed: %v", name, err)
+			}
+			p, ok := ptr.(int32)
+			if !ok || (p == 0 && size > 0) {
+				return 0, fmt.Errorf("%s could not allocate %d bytes", name, size)
+			}
+			return p, nil
+		}, nil
+	}
+
+	// No allocator: grow the memory once for all buffers
+	var total uint64
+	for _, value := range decoded {
+		if data, ok := value.([]byte); ok {
+			total += alignUp(uint64(len(data)), argAlignment)
+		}
+	}
+	base := uint64(memory.DataSize(store))
+	pages := (total + wasmPageSize - 1) / wasmPageSize
+	if base+pages*wasmPageSize > 1<<32 {
+		return nil, fmt.Errorf("arguments do not fit in 32-bit address space")
+	}
+	if _, err := memory.Grow(store, pages); err != nil {
+		return nil, resourceLimitError("no memory left for %d bytes of arguments: %v", total, err)
+	}
+	logger.Info("Placing arguments in new memory pages", "bytes", total, "offset", base)
+
+	next := base
+	return func(size int) (int32, error) {
+		ptr := next
+		next += alignUp(uint64(size), argAlignment)
+		return int32(uint32(ptr)), nil
+	}, nil
+}
+
+// readResult interprets what a call returned according to the result spec.
+// Without a type, a single result of any numeric type is accepted.
+func readResult(store *wasmtime.Store, instance *wasmtime.Instance, refs *refHandles, result interface{}, spec protocol.ResultSpec) (CallResult, error) {
+	if spec.Buffer() {
+		data, err := readBuffer(store, instance, result, spec.Layout)
+		if err != nil {
+			return CallResult{}, err
+		}
+		value := protocol.Value{Type: protocol.ValueString, Value: string(data)}
+		if spec.Type == protocol.ValueBytes {
+			value, _ = protocol.EncodeValue(data)
+		}
+		return CallResult{Value: &value}, nil
+	}
+
+	// Functions without results, such as a WASI _start, report 0
+	if result == nil && spec.Type == "" {
+		return CallResult{}, nil
+	}
+	if value, ok, err := refs.value(result, spec.Type); ok || err != nil {
+		if err == nil && spec.Type != "" && value.Type != spec.Type {
+			err = fmt.Errorf("function returned %s, not the requested %s", value.Type, spec.Type)
+		}
+		if err != nil {
+			return CallResult{}, err
+		}
+		return CallResult{Value: &value}, nil
+	}
+	if _, multi := result.([]wasmtime.Val); multi {
+		return CallResult{}, fmt.Errorf("function returns several values; set result_type to string or bytes to read a (pointer, length) pair")
+	}
+	return numericResult(result, spec)
+}
+
+// numericResult reports a number a function returned, which must be of the
+// type spec asks for, if any
+func numericResult(result interface{}, spec protocol.ResultSpec) (CallResult, error) {
+	if result == nil {
+		return CallResult{}, fmt.Errorf("function returns nothing, not the requested %s", spec.Type)
+	}
+	value, err := protocol.EncodeValue(result)
+	if err != nil {
+		return CallResult{}, fmt.Errorf("unexpected return type from WASM function: %T", result)
+	}
+	if spec.Type != "" && value.Type != spec.Type {
+		return CallResult{}, fmt.Errorf("function returned %s, not the requested %s", value.Type, spec.Type)
+	}
+	if i32, ok := result.(int32); ok {
+		return CallResult{I32: i32}, nil
+	}
+	return CallResult{Value: &value}, nil
+}
+
+// readBuffer copies out the memory region a result refers to
+func readBuffer(store *wasmtime.Store, instance *wasmtime.Instance, result interface{}, layout string) ([]byte, error) {
+	export := instance.GetExport(store, secretMemoryExport)
+	if export == nil || export.Memory() == nil {
+		return nil, fmt.Errorf("string and bytes results require an exported %q memory", secretMemoryExport)
+	}
+	data := export.Memory().UnsafeData(store)
+
+	var ptr, length uint32
+	if layout == "" || layout == protocol.LayoutPtrLen {
+		pair, ok := result.([]wasmtime.Val)
+		if !ok || len(pair) != 2 || pair[0].Kind() != wasmtime.KindI32 || pair[1].Kind() != wasmtime.KindI32 {
+			return nil, fmt.Errorf("a %s result must be returned as two i32s", protocol.LayoutPtrLen)
+		}
+		ptr, length = uint32(pair[0].I32()), uint32(pair[1].I32())
+	} else {
+		p, ok := result.(int32)
+		if !ok {
+			return nil, fmt.Errorf("a %s result must be returned as an i32 pointer", layout)
+		}
+		ptr = uint32(p)
+
+		switch layout {
+		case protocol.LayoutPtrLenIndirect:
+			header, ok := memoryRange(data, int32(ptr), 8)
+			if !ok {
+				return nil, fmt.Errorf("result header at %d is out of bounds", ptr)
+			}
+			ptr, length = binary.LittleEndian.Uint32(header), binary.LittleEndian.Uint32(header[4:])
+		case protocol.LayoutLengthPrefixed:
+			header, ok := memoryRange(data, int32(ptr), 4)
+			if !ok {
+				return nil, fmt.Errorf("result length at %d is out of bounds", ptr)
+			}
+			length = binary.LittleEndian.Uint32(header)
+			ptr += 4
+		case protocol.LayoutNulTerminated:
+			if uint64(ptr) >= uint64(len(data)) {
+				return nil, fmt.Errorf("result at %d is out of bounds", ptr)
+			}
+			end := bytes.IndexByte(data[ptr:], 0)
+			if end < 0 {
+				return nil, fmt.Errorf("result at %d is not NUL-terminated", ptr)
+			}
+			length = uint32(end)
+		}
+	}
+
+	if length > maxResultBytes {
+		return nil, fmt.Errorf("result of %d bytes exceeds the %d byte limit", length, maxResultBytes)
+	}
+	region, ok := memoryRange(data, int32(ptr), int32(length))
+	if !ok {
+		return nil, fmt.Errorf("result buffer at %d (%d bytes) is out of bounds", ptr, length)
+	}
+	return append([]byte(nil), region...), nil
+}
+
+func alignUp(n, alignment uint64) uint64 {
+	return (n + alignment - 1) / alignment * alignment
+}
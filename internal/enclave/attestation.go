@@ -0,0 +1,82 @@
+package enclave
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"hello-wasm-enclave/internal/nsm"
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Attester produces NSM attestation documents that bind a request and its
+// result, so a client can prove which enclave image computed what.
+//
+// The document's user_data is the request digest followed by the result
+// digest, each a SHA-256 of JSON: protocol.AttestationRequestDigest and
+// protocol.AttestationResultDigest say which fields each covers, secrets
+// never among them. A client-supplied nonce is passed through to the NSM
+// unchanged.
+type Attester struct {
+	nsm *nsm.Session
+}
+
+// NewAttester attests with session. Outside an enclave there is no NSM
+// session; the attester is still returned but every attestation request
+// fails.
+func NewAttester(session *nsm.Session) *Attester {
+	return &Attester{nsm: session}
+}
+
+// Attest returns a base64 encoded attestation document for the given exchange
+func (a *Attester) Attest(req protocol.WASMRequest, response protocol.WASMResponse) (string, error) {
+	nonce, err := decodeNonce(req.Nonce)
+	if err != nil {
+		return "", err
+	}
+
+	requestHash, err := protocol.AttestationRequestDigest(req)
+	if err != nil {
+		return "", err
+	}
+	resultHash, err := protocol.AttestationResultDigest(response)
+	if err != nil {
+		return "", err
+	}
+	userData := append(requestHash[:], resultHash[:]...)
+
+	return a.document(userData, nonce, nil)
+}
+
+// AttestPublicKey returns a base64 encoded attestation document whose
+// public_key field carries the given enclave public key
+func (a *Attester) AttestPublicKey(publicKey []byte, encodedNonce string) (string, error) {
+	nonce, err := decodeNonce(encodedNonce)
+	if err != nil {
+		return "", err
+	}
+	return a.document(nil, nonce, publicKey)
+}
+
+func (a *Attester) document(userData, nonce, publicKey []byte) (string, error) {
+	if a.nsm == nil {
+		return "", fmt.Errorf("NSM device not available")
+	}
+
+	document, err := a.nsm.Attestation(userData, nonce, publicKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(document), nil
+}
+
+// decodeNonce decodes an optional base64 nonce from a request
+func decodeNonce(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	nonce, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce encoding: %v", err)
+	}
+	return nonce, nil
+}
@@ -0,0 +1,106 @@
+package enclave
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Default for -audit-log-size
+const defaultAuditLogSize = 10000
+
+// auditLog keeps an entry per execution in enclave memory, dropping the
+// oldest beyond its size. Nothing is written outside the enclave, so the log
+// starts over with it; sequence numbers restart at zero, which tells a
+// restart apart from dropped entries.
+type auditLog struct {
+	mu      sync.Mutex
+	entries []protocol.AuditEntry
+	next    uint64
+	max     int
+}
+
+func newAuditLog(max int) *auditLog {
+	return &auditLog{max: max}
+}
+
+// record appends an entry, numbering and timestamping it
+func (l *auditLog) record(entry protocol.AuditEntry) {
+	if l.max <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.Sequence = l.next
+	entry.Timestamp = time.Now().UnixMilli()
+	l.next++
+	if len(l.entries) == l.max {
+		l.entries = l.entries[1:]
+	}
+	l.entries = append(l.entries, entry)
+}
+
+// export returns the entries held, unsigned
+func (l *auditLog) export() *protocol.AuditLog {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &protocol.AuditLog{
+		Entries:       append([]protocol.AuditEntry{}, l.entries...),
+		FirstSequence: l.next - uint64(len(l.entries)),
+		Timestamp:     time.Now().UnixMilli(),
+	}
+}
+
+// auditEntry describes an execution request and how it went
+func auditEntry(wasmReq protocol.WASMRequest, module string, err error) protocol.AuditEntry {
+	calls := wasmReq.FunctionCalls()
+	entry := protocol.AuditEntry{
+		ModuleHash: module,
+		Functions:  make([]string, len(calls)),
+		ArgsHash:   protocol.ArgsHash(calls),
+		ClientID:   wasmReq.ClientID,
+		Tenant:     wasmReq.Tenant,
+		SessionID:  wasmReq.SessionID,
+		Outcome:    protocol.AuditOutcomeSuccess,
+	}
+	for i, call := range calls {
+		entry.Functions[i] = call.Target()
+	}
+	if err != nil {
+		entry.Outcome = protocol.AuditOutcomeFailure
+		entry.ErrorCode = errorCode(err)
+	}
+	return entry
+}
+
+// SignAuditLog signs an exported audit log with the receipt key
+func (s *ReceiptSigner) SignAuditLog(log *protocol.AuditLog) error {
+	digest, err := log.Digest()
+	if err != nil {
+		return err
+	}
+	log.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.private, digest[:]))
+	return nil
+}
+
+// auditLogResponse returns the signed audit log
+func (s *EnclaveServer) auditLogResponse(logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	if s.audit.max <= 0 {
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: "the audit log is disabled"}
+	}
+	auditLog := s.audit.export()
+	if err := s.signer.Load().SignAuditLog(auditLog); err != nil {
+		logger.Error("Failed to sign audit log", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: fmt.Sprintf("failed to sign audit log: %v", err)}
+	}
+	logger.Info("Exported audit log", "entries", len(auditLog.Entries), "first_sequence", auditLog.FirstSequence)
+
+	response := protocol.WASMResponse{RequestID: wasmReq.RequestID, AuditLog: auditLog}
+	s.attest(logger, wasmReq, &response)
+	return response
+}
@@ -0,0 +1,235 @@
+package enclave
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// Minimal CMS (RFC 5652) EnvelopedData support, enough to open the
+// CiphertextForRecipient that KMS returns for attested Decrypt calls: a
+// KeyTransRecipientInfo wrapping an AES-256-CBC content key with RSA-OAEP.
+
+var (
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidAES256CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type cmsEnvelopedData struct {
+	Version              int
+	OriginatorInfo       asn1.RawValue              `asn1:"optional,tag:0"`
+	RecipientInfos       []cmsKeyTransRecipientInfo `asn1:"set"`
+	EncryptedContentInfo cmsEncryptedContentInfo
+	UnprotectedAttrs     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type cmsKeyTransRecipientInfo struct {
+	Version                int
+	RecipientIdentifier    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type cmsEncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// openEnvelopedData decrypts a CMS EnvelopedData blob. unwrapKey is given the
+// recipient's encrypted content key and must return the plaintext key.
+func openEnvelopedData(ber []byte, unwrapKey func([]byte) ([]byte, error)) ([]byte, error) {
+	der, err := berToDER(ber)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CMS encoding: %v", err)
+	}
+
+	var contentInfo cmsContentInfo
+	if _, err := asn1.Unmarshal(der, &contentInfo); err != nil {
+		return nil, fmt.Errorf("invalid CMS ContentInfo: %v", err)
+	}
+	if !contentInfo.ContentType.Equal(oidEnvelopedData) {
+		return nil, fmt.Errorf("unexpected CMS content type %v", contentInfo.ContentType)
+	}
+
+	var envelope cmsEnvelopedData
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid CMS EnvelopedData: %v", err)
+	}
+	if len(envelope.RecipientInfos) != 1 {
+		return nil, fmt.Errorf("expected one CMS recipient, found %d", len(envelope.RecipientInfos))
+	}
+
+	contentKey, err := unwrapKey(envelope.RecipientInfos[0].EncryptedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	info := envelope.EncryptedContentInfo
+	if !info.ContentEncryptionAlgorithm.Algorithm.Equal(oidAES256CBC) {
+		return nil, fmt.Errorf("unsupported CMS content encryption %v", info.ContentEncryptionAlgorithm.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(info.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("invalid AES-CBC parameters: %v", err)
+	}
+
+	ciphertext, err := octetStringContent(info.EncryptedContent)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CMS content key: %v", err)
+	}
+	if len(iv) != block.BlockSize() || len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, fmt.Errorf("malformed CMS ciphertext")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	// Strip PKCS#7 padding
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > block.BlockSize() ||
+		!bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, fmt.Errorf("invalid CMS content padding")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// octetStringContent returns the bytes of an implicitly tagged OCTET STRING,
+// which BER allows to be split into constructed chunks
+func octetStringContent(value asn1.RawValue) ([]byte, error) {
+	if !value.IsCompound {
+		return value.Bytes, nil
+	}
+
+	var content []byte
+	rest := value.Bytes
+	for len(rest) > 0 {
+		var chunk []byte
+		var err error
+		rest, err = asn1.Unmarshal(rest, &chunk)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CMS encrypted content: %v", err)
+		}
+		content = append(content, chunk...)
+	}
+	return content, nil
+}
+
+// berToDER rewrites indefinite-length BER encodings with definite lengths so
+// encoding/asn1 can parse them. Constructed strings are left as they are.
+func berToDER(ber []byte) ([]byte, error) {
+	der, rest, err := convertBER(ber)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d trailing bytes after BER value", len(rest))
+	}
+	return der, nil
+}
+
+func convertBER(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, fmt.Errorf("truncated BER value")
+	}
+
+	// Identifier octets, including high-tag-number form
+	pos := 1
+	if data[0]&0x1f == 0x1f {
+		for pos < len(data) && data[pos]&0x80 != 0 {
+			pos++
+		}
+		pos++
+	}
+	if pos >= len(data) {
+		return nil, nil, fmt.Errorf("truncated BER identifier")
+	}
+	identifier := data[:pos]
+	constructed := data[0]&0x20 != 0
+
+	lengthByte := data[pos]
+	pos++
+
+	if lengthByte == 0x80 {
+		if !constructed {
+			return nil, nil, fmt.Errorf("indefinite length on primitive BER value")
+		}
+		var children []byte
+		rest := data[pos:]
+		for {
+			if len(rest) < 2 {
+				return nil, nil, fmt.Errorf("missing BER end-of-contents")
+			}
+			if rest[0] == 0 && rest[1] == 0 {
+				return encodeTLV(identifier, children), rest[2:], nil
+			}
+			child, remaining, err := convertBER(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			children = append(children, child...)
+			rest = remaining
+		}
+	}
+
+	length := int(lengthByte)
+	if lengthByte&0x80 != 0 {
+		n := int(lengthByte & 0x7f)
+		if n > 4 || pos+n > len(data) {
+			return nil, nil, fmt.Errorf("invalid BER length")
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			length = length<<8 | int(data[pos+i])
+		}
+		pos += n
+	}
+	if length < 0 || pos+length > len(data) {
+		return nil, nil, fmt.Errorf("BER length exceeds input")
+	}
+	content := data[pos : pos+length]
+	rest := data[pos+length:]
+
+	if !constructed {
+		return encodeTLV(identifier, content), rest, nil
+	}
+
+	// Definite-length constructed values may still contain indefinite children
+	var children []byte
+	for len(content) > 0 {
+		child, remaining, err := convertBER(content)
+		if err != nil {
+			return nil, nil, err
+		}
+		children = append(children, child...)
+		content = remaining
+	}
+	return encodeTLV(identifier, children), rest, nil
+}
+
+func encodeTLV(identifier, content []byte) []byte {
+	out := append([]byte{}, identifier...)
+	if len(content) < 0x80 {
+		out = append(out, byte(len(content)))
+	} else {
+		var lengthBytes []byte
+		for n := len(content); n > 0; n >>= 8 {
+			lengthBytes = append([]byte{byte(n)}, lengthBytes...)
+		}
+		out = append(out, 0x80|byte(len(lengthBytes)))
+		out = append(out, lengthBytes...)
+	}
+	return append(out, content...)
+}
@@ -0,0 +1,24 @@
+package enclave
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A component binary shares the core module magic, but its version field
+// carries layer 1 where a core module has version 1
+var (
+	wasmMagic      = []byte{0x00, 'a', 's', 'm'}
+	componentLayer = []byte{0x01, 0x00}
+)
+
+// errComponentsUnsupported is returned for component requests. wasmtime-go
+// exposes only core modules, so there is no way to instantiate a component
+// or use the canonical ABI from here; requests have to send core modules and
+// use typed_args and result_spec for strings and bytes.
+var errComponentsUnsupported = fmt.Errorf("the component model is not supported by this enclave; send a core module")
+
+// isComponent reports whether a binary is a component rather than a module
+func isComponent(wasmBytes []byte) bool {
+	return len(wasmBytes) >= 8 && bytes.Equal(wasmBytes[:4], wasmMagic) && bytes.Equal(wasmBytes[6:8], componentLayer)
+}
@@ -0,0 +1,136 @@
+package enclave
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Describe compiles a module, or takes it from the caches, and lists its
+// imports and exports without instantiating it. Precompiled modules must
+// pass the module policy as they would to run, since loading them trusts
+// their machine code.
+func (w *WASMExecutor) Describe(ctx context.Context, logger *slog.Logger, wasmCode, signature string, limits ExecutionLimits) (*protocol.ModuleDescription, error) {
+	release, err := w.workers.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	module := w.preloaded.lookup(wasmCode).compiled(w.meterFuel, limits, nil)
+	cacheKey, cacheable := w.modules.key(wasmCode, w.meterFuel, limits)
+	if module == nil {
+		module = w.modules.lookup(cacheKey, cacheable)
+	}
+	if module == nil {
+		wasmBytes, err := decodeModule(logger, wasmCode, nil, limits, nil)
+		if err != nil {
+			return nil, err
+		}
+		engine := w.engines.get(limits.Features)
+		if isPrecompiled(wasmBytes) {
+			if err := w.policy.check(wasmCode, signature); err != nil {
+				return nil, err
+			}
+			module, err = w.deserialize(logger, engine, wasmBytes, limits)
+		} else {
+			module, err = compileModule(engine, wasmBytes, limits)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cacheable {
+			w.modules.put(cacheKey, module, moduleHash(wasmCode), limits.Tenant)
+		}
+	}
+
+	description := &protocol.ModuleDescription{
+		ModuleHash: moduleHash(wasmCode),
+		Imports:    []protocol.ModuleItem{},
+		Exports:    []protocol.ModuleItem{},
+	}
+	for _, imp := range module.Imports() {
+		item := describeExtern(imp.Type())
+		item.Module = imp.Module()
+		if name := imp.Name(); name != nil {
+			item.Name = *name
+		}
+		description.Imports = append(description.Imports, item)
+	}
+	for _, exp := range module.Exports() {
+		item := describeExtern(exp.Type())
+		item.Name = exp.Name()
+		description.Exports = append(description.Exports, item)
+	}
+	return description, nil
+}
+
+// describeExtern returns the kind and type of an import or export
+func describeExtern(ty *wasmtime.ExternType) protocol.ModuleItem {
+	switch {
+	case ty.FuncType() != nil:
+		f := ty.FuncType()
+		return protocol.ModuleItem{Kind: protocol.ItemFunc, Params: typeNames(f.Params()), Results: typeNames(f.Results())}
+	case ty.GlobalType() != nil:
+		g := ty.GlobalType()
+		return protocol.ModuleItem{Kind: protocol.ItemGlobal, Type: typeName(g.Content()), Mutable: g.Mutable()}
+	case ty.MemoryType() != nil:
+		m := ty.MemoryType()
+		item := protocol.ModuleItem{Kind: protocol.ItemMemory, Min: m.Minimum(), Memory64: m.Is64()}
+		if bounded, max := m.Maximum(); bounded {
+			item.Max = &max
+		}
+		return item
+	default:
+		t := ty.TableType()
+		item := protocol.ModuleItem{Kind: protocol.ItemTable, Type: typeName(t.Element()), Min: uint64(t.Minimum())}
+		if bounded, max := t.Maximum(); bounded {
+			max64 := uint64(max)
+			item.Max = &max64
+		}
+		return item
+	}
+}
+
+// typeName names a value type as requests and responses do, or as wasmtime
+// does for types they cannot carry
+func typeName(valType *wasmtime.ValType) string {
+	if name, ok := kindTypes[valType.Kind()]; ok {
+		return name
+	}
+	return valType.Kind().String()
+}
+
+func typeNames(valTypes []*wasmtime.ValType) []string {
+	var names []string
+	for _, valType := range valTypes {
+		names = append(names, typeName(valType))
+	}
+	return names
+}
+
+// describeResponse answers a describe_module request
+func (s *EnclaveServer) describeResponse(ctx context.Context, logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	limits, err := requestLimits(wasmReq, s.caps)
+	if err != nil {
+		logger.Warn("Rejecting request", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error()}
+	}
+
+	start := time.Now()
+	description, err := s.executor.Describe(ctx, logger, wasmReq.WASMCode, wasmReq.ModuleSignature, limits)
+	if err != nil {
+		logger.Warn("Describing module failed", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: errorCode(err)}
+	}
+	logger.Info("Described module", "imports", len(description.Imports), "exports", len(description.Exports))
+	return protocol.WASMResponse{
+		RequestID: wasmReq.RequestID,
+		Module:    description,
+		CompileUS: time.Since(start).Microseconds(),
+	}
+}
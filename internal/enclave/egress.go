@@ -0,0 +1,267 @@
+package enclave
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/transport"
+)
+
+const (
+	// Defaults for -fetch-max-bytes and -fetch-timeout
+	defaultFetchMaxBytes = 1 << 20
+	defaultFetchTimeout  = 5 * time.Second
+	// Bound on the distinct URLs one execution may fetch
+	maxFetchesPerExecution = 16
+
+	// http_get returns these instead of a body length
+	fetchNotAllowed = -1
+	fetchFailed     = -2
+	fetchTooLarge   = -3
+)
+
+// egressRule lets modules fetch https URLs on host whose path starts with
+// pathPrefix. The enclave has no network of its own, so connections to
+// host go to a vsock-proxy on the parent at port, which forwards to
+// host:443; TLS runs inside the enclave, so the host cannot read or alter
+// what is fetched.
+type egressRule struct {
+	host       string
+	pathPrefix string
+	port       uint32
+}
+
+// egressPolicy is the HTTPS GET access modules get through env.http_get.
+// A nil policy offers no such import.
+type egressPolicy struct {
+	rules    []egressRule
+	maxBytes int
+	client   *http.Client
+}
+
+// loadEgressPolicy reads one rule per line: an https URL prefix and the
+// parent vsock port that reaches its host, e.g.
+//
+//	https://api.example.com/v1/prices/ 8001
+//
+// Blank lines and lines starting with # are ignored.
+func loadEgressPolicy(path string, maxBytes int, timeout time.Duration, parent *transport.Transport) (*egressPolicy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open fetch allowlist: %v", err)
+	}
+	defer f.Close()
+
+	policy := &egressPolicy{maxBytes: maxBytes}
+	ports := map[string]uint32{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("fetch allowlist line %d: expected URL prefix and vsock port", line)
+		}
+		prefix, err := url.Parse(fields[0])
+		if err != nil || prefix.Scheme != "https" || prefix.Host == "" || prefix.User != nil || prefix.RawQuery != "" {
+			return nil, fmt.Errorf("fetch allowlist line %d: %q is not an https URL prefix", line, fields[0])
+		}
+		port, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("fetch allowlist line %d: invalid vsock port %q", line, fields[1])
+		}
+		host := strings.ToLower(prefix.Host)
+		if known, ok := ports[host]; ok && known != uint32(port) {
+			return nil, fmt.Errorf("fetch allowlist line %d: %s is already reached through port %d", line, host, known)
+		}
+		ports[host] = uint32(port)
+		pathPrefix := prefix.Path
+		if pathPrefix == "" {
+			pathPrefix = "/"
+		}
+		policy.rules = append(policy.rules, egressRule{host: host, pathPrefix: pathPrefix, port: uint32(port)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read fetch allowlist: %v", err)
+	}
+	if len(policy.rules) == 0 {
+		return nil, fmt.Errorf("fetch allowlist %s is empty", path)
+	}
+
+	httpTransport := &http.Transport{
+		// addr is always an allowlisted host:443, which maps to its proxy
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			port, ok := ports[strings.ToLower(host)]
+			if !ok {
+				return nil, fmt.Errorf("%s is not allowlisted", host)
+			}
+			return parent.Dial(parentCID, port)
+		},
+		TLSHandshakeTimeout: timeout,
+	}
+	policy.client = &http.Client{
+		Transport: httpTransport,
+		Timeout:   timeout,
+		// A redirect could lead off the allowlist
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return policy, nil
+}
+
+// allows reports whether a module may fetch rawURL
+func (p *egressPolicy) allows(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.User != nil || u.Port() != "" {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	for _, rule := range p.rules {
+		if rule.host == host && strings.HasPrefix(path, rule.pathPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// newLog returns the fetch state of one instance, or nil without a policy
+func (p *egressPolicy) newLog() *fetchLog {
+	if p == nil {
+		return nil
+	}
+	return &fetchLog{policy: p, bodies: make(map[string][]byte)}
+}
+
+// fetchLog remembers what one instance fetched. Each URL is fetched once,
+// so a module can ask for the length first and then for the body, and gets
+// the same bytes that were hashed for the attestation.
+type fetchLog struct {
+	policy *egressPolicy
+
+	mu      sync.Mutex
+	bodies  map[string][]byte
+	fetches []protocol.Fetch
+	taken   int
+}
+
+// define adds env.http_get to linker:
+//
+//	(import "env" "http_get" (func $http_get (param i32 i32 i32 i32) (result i32)))
+//
+// http_get(url_ptr, url_len, out_ptr, out_len) GETs the URL at url_ptr and
+// copies up to out_len bytes of the body into out_ptr. It returns the body's
+// full length, or -1 when the URL is not allowlisted, -2 when the fetch
+// failed or did not answer 200, and -3 when the body exceeds the enclave's
+// limit.
+func (l *fetchLog) define(logger *slog.Logger, linker *wasmtime.Linker, imports hostImports) error {
+	err := imports.funcWrap(linker, "http_get", func(caller *wasmtime.Caller, urlPtr, urlLen, outPtr, outLen int32) (int32, *wasmtime.Trap) {
+		memory, trap := callerMemory(caller)
+		if trap != nil {
+			return 0, trap
+		}
+		rawURL, ok := memoryRange(memory.UnsafeData(caller), urlPtr, urlLen)
+		if !ok {
+			return 0, wasmtime.NewTrap("http_get: URL out of bounds")
+		}
+
+		body, status := l.get(logger, string(rawURL))
+		if status < 0 {
+			return status, nil
+		}
+
+		// The fetch may have taken a while, but memory cannot have moved
+		// since no WASM code ran
+		out, ok := memoryRange(memory.UnsafeData(caller), outPtr, outLen)
+		if !ok {
+			return 0, wasmtime.NewTrap("http_get: output buffer out of bounds")
+		}
+		copy(out, body)
+		return int32(len(body)), nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to define http_get: %v", err)
+	}
+	return nil
+}
+
+// get returns the body of rawURL, fetching it on first use, or a negative
+// http_get status
+func (l *fetchLog) get(logger *slog.Logger, rawURL string) ([]byte, int32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if body, ok := l.bodies[rawURL]; ok {
+		return body, 0
+	}
+	if !l.policy.allows(rawURL) {
+		logger.Warn("Module requested a URL that is not allowlisted", "url", rawURL)
+		return nil, fetchNotAllowed
+	}
+	if len(l.bodies) >= maxFetchesPerExecution {
+		logger.Warn("Module exceeded its fetches", "url", rawURL, "max", maxFetchesPerExecution)
+		return nil, fetchNotAllowed
+	}
+
+	resp, err := l.policy.client.Get(rawURL)
+	if err != nil {
+		logger.Warn("Fetch failed", "url", rawURL, "error", err)
+		return nil, fetchFailed
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Warn("Fetch failed", "url", rawURL, "status", resp.StatusCode)
+		return nil, fetchFailed
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(l.policy.maxBytes)+1))
+	if err != nil {
+		logger.Warn("Fetch failed", "url", rawURL, "error", err)
+		return nil, fetchFailed
+	}
+	if len(body) > l.policy.maxBytes {
+		logger.Warn("Fetched body exceeds the limit", "url", rawURL, "max_bytes", l.policy.maxBytes)
+		return nil, fetchTooLarge
+	}
+
+	digest := sha256.Sum256(body)
+	l.bodies[rawURL] = body
+	l.fetches = append(l.fetches, protocol.Fetch{URL: rawURL, SHA256: hex.EncodeToString(digest[:]), Bytes: len(body)})
+	logger.Info("Fetched URL", "url", rawURL, "bytes", len(body))
+	return body, 0
+}
+
+// takeNew returns the fetches made since the last call
+func (l *fetchLog) takeNew() []protocol.Fetch {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fetches := l.fetches[l.taken:]
+	l.taken = len(l.fetches)
+	return fetches
+}
@@ -0,0 +1,108 @@
+package enclave
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+const (
+	// Bound on the bytes of one emit call, which is one progress response
+	maxEmitBytes = 64 << 10
+	// Bound on what one execution streams; later emits are dropped
+	maxEmittedBytes = 16 << 20
+)
+
+// emitter streams what a module passes to env.emit back to the client as
+// progress responses of its request. A nil emitter, for requests that did
+// not ask for stream_emits, drops what is emitted.
+type emitter struct {
+	requestID     string
+	correlationID string
+	send          func(protocol.WASMResponse) error
+
+	sent      int
+	truncated bool
+	// Set once sending failed; the client is gone and later emits are dropped
+	err error
+}
+
+type emitterKey struct{}
+
+// withEmitter returns ctx carrying the emitter of wasmReq, which sends its
+// progress responses with send
+func withEmitter(ctx context.Context, wasmReq protocol.WASMRequest, send func(protocol.WASMResponse) error) context.Context {
+	return context.WithValue(ctx, emitterKey{}, &emitter{
+		requestID:     wasmReq.RequestID,
+		correlationID: wasmReq.CorrelationID,
+		send:          send,
+	})
+}
+
+// emitterFrom returns the emitter in ctx, or nil
+func emitterFrom(ctx context.Context) *emitter {
+	e, _ := ctx.Value(emitterKey{}).(*emitter)
+	return e
+}
+
+// define adds env.emit to linker:
+//
+//	(import "env" "emit" (func $emit (param i32 i32)))
+//
+// emit(ptr, len) sends the bytes to the client at once, before the
+// execution ends. Out-of-bounds and oversized pieces trap; modules may
+// import emit whether or not the request streams.
+func (e *emitter) define(logger *slog.Logger, linker *wasmtime.Linker, imports hostImports) error {
+	err := imports.funcWrap(linker, "emit", func(caller *wasmtime.Caller, ptr, length int32) *wasmtime.Trap {
+		if uint32(length) > maxEmitBytes {
+			return wasmtime.NewTrap(fmt.Sprintf("emit: at most %d bytes per call", maxEmitBytes))
+		}
+		memory, trap := callerMemory(caller)
+		if trap != nil {
+			return trap
+		}
+		piece, ok := memoryRange(memory.UnsafeData(caller), ptr, length)
+		if !ok {
+			return wasmtime.NewTrap("emit: buffer out of bounds")
+		}
+		e.emit(logger, piece)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to define emit: %v", err)
+	}
+	return nil
+}
+
+// emit sends piece as a progress response. Memory cannot move while it is
+// written out, since no WASM code runs.
+func (e *emitter) emit(logger *slog.Logger, piece []byte) {
+	if e == nil || e.err != nil || e.truncated {
+		return
+	}
+	if e.sent+len(piece) > maxEmittedBytes {
+		logger.Warn("Dropping emits beyond the limit", "sent", e.sent, "limit", maxEmittedBytes)
+		e.truncated = true
+		return
+	}
+	e.sent += len(piece)
+	e.err = e.send(protocol.WASMResponse{
+		RequestID:     e.requestID,
+		CorrelationID: e.correlationID,
+		Progress:      true,
+		Emit:          base64.StdEncoding.EncodeToString(piece),
+	})
+	if e.err != nil {
+		logger.Warn("Failed to send emitted bytes", "error", e.err)
+	}
+}
+
+// wasTruncated reports whether emits were dropped for the limit
+func (e *emitter) wasTruncated() bool {
+	return e != nil && e.truncated
+}
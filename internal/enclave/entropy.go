@@ -0,0 +1,40 @@
+package enclave
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"hello-wasm-enclave/internal/nsm"
+)
+
+// mixedEntropy XORs bytes from the NSM's hardware source into bytes from
+// crypto/rand. The result is as unpredictable as the stronger of the two,
+// so neither a weak kernel pool early in boot nor a faulty NSM alone can
+// weaken keys generated from it.
+type mixedEntropy struct {
+	nsm *nsm.Session
+}
+
+// newEntropy returns the reader enclave keys and secure_random draw from:
+// crypto/rand mixed with the NSM, or crypto/rand alone outside an enclave
+func newEntropy(session *nsm.Session) io.Reader {
+	if session == nil {
+		return rand.Reader
+	}
+	return mixedEntropy{nsm: session}
+}
+
+func (m mixedEntropy) Read(p []byte) (int, error) {
+	if _, err := io.ReadFull(rand.Reader, p); err != nil {
+		return 0, err
+	}
+	hardware := make([]byte, len(p))
+	if _, err := io.ReadFull(m.nsm, hardware); err != nil {
+		return 0, fmt.Errorf("failed to read NSM entropy: %v", err)
+	}
+	for i := range p {
+		p[i] ^= hardware[i]
+	}
+	return len(p), nil
+}
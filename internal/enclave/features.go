@@ -0,0 +1,176 @@
+package enclave
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+const (
+	// Defaults for -wasm-features and -optional-wasm-features: what wasmtime
+	// enables on its own, and nothing more
+	defaultWasmFeatures         = "simd,bulk_memory,reference_types,multi_value"
+	defaultOptionalWasmFeatures = ""
+	// Memories a module may declare with multi-memory enabled
+	maxMultiMemories = 16
+)
+
+// featureSet is a set of WebAssembly proposals, one bit each
+type featureSet uint16
+
+const (
+	featureSIMD featureSet = 1 << iota
+	featureBulkMemory
+	featureReferenceTypes
+	featureMultiValue
+	featureMultiMemory
+	featureThreads
+)
+
+// features lists the proposals the enclave can enable, in reporting order
+var features = []struct {
+	name string
+	bit  featureSet
+	// Argument that makes wat2wasm accept the proposal, for those it does
+	// not enable by default
+	watFlag string
+}{
+	{protocol.FeatureSIMD, featureSIMD, ""},
+	{protocol.FeatureBulkMemory, featureBulkMemory, ""},
+	{protocol.FeatureReferenceTypes, featureReferenceTypes, ""},
+	{protocol.FeatureMultiValue, featureMultiValue, ""},
+	{protocol.FeatureMultiMemory, featureMultiMemory, "--enable-multi-memory"},
+	{protocol.FeatureThreads, featureThreads, "--enable-threads"},
+}
+
+// unsupportedFeatures explains the proposals that are known but cannot be
+// enabled
+var unsupportedFeatures = map[string]string{
+	protocol.FeatureMemory64: "64-bit memories cannot be held to the enclave's memory limits",
+	protocol.FeatureTailCall: "this wasmtime release does not implement tail calls",
+}
+
+// parseFeatures turns proposal names into a set
+func parseFeatures(names []string) (featureSet, error) {
+	var set featureSet
+next:
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		for _, f := range features {
+			if f.name == name {
+				set |= f.bit
+				continue next
+			}
+		}
+		if reason, ok := unsupportedFeatures[name]; ok {
+			return 0, fmt.Errorf("feature %s is not supported: %s", name, reason)
+		}
+		return 0, fmt.Errorf("unknown feature %q", name)
+	}
+	return set, nil
+}
+
+func (s featureSet) has(bit featureSet) bool {
+	return s&bit != 0
+}
+
+// names lists the proposals in s
+func (s featureSet) names() []string {
+	var names []string
+	for _, f := range features {
+		if s.has(f.bit) {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+func (s featureSet) String() string {
+	if s == 0 {
+		return "none"
+	}
+	return strings.Join(s.names(), ", ")
+}
+
+// watFlags are the wat2wasm arguments for the proposals in s
+func (s featureSet) watFlags() []string {
+	var flags []string
+	for _, f := range features {
+		if s.has(f.bit) && f.watFlag != "" {
+			flags = append(flags, f.watFlag)
+		}
+	}
+	return flags
+}
+
+// maxMemories is how many memories a module using s may declare
+func (s featureSet) maxMemories() int {
+	if s.has(featureMultiMemory) {
+		return maxMultiMemories
+	}
+	return maxMemories
+}
+
+// engines holds one engine per feature set in use. Modules only run in
+// stores of the engine that compiled them, so each set gets an engine of its
+// own, with its own epoch ticker.
+type engines struct {
+	meterFuel bool
+
+	mu    sync.Mutex
+	bySet map[featureSet]*wasmtime.Engine
+}
+
+func newEngines(meterFuel bool) *engines {
+	return &engines{meterFuel: meterFuel, bySet: make(map[featureSet]*wasmtime.Engine)}
+}
+
+// get returns the engine for set, creating it on first use
+func (e *engines) get(set featureSet) *wasmtime.Engine {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if engine, ok := e.bySet[set]; ok {
+		return engine
+	}
+
+	// Epoch interruption lets a ticker cancel executions past their deadline.
+	// Instances are allocated on demand. wasmtime-go v0.40.0, the version
+	// go.mod pins, exposes no pooling allocation strategy in its Config or
+	// its C API, so there are no max-instances or memory-pages settings to
+	// reserve instance memory up front until a release that has one is
+	// adopted.
+	config := wasmtime.NewConfig()
+	config.SetEpochInterruption(true)
+	config.SetConsumeFuel(e.meterFuel)
+	config.SetWasmSIMD(set.has(featureSIMD))
+	config.SetWasmBulkMemory(set.has(featureBulkMemory))
+	config.SetWasmReferenceTypes(set.has(featureReferenceTypes))
+	config.SetWasmMultiValue(set.has(featureMultiValue))
+	config.SetWasmMultiMemory(set.has(featureMultiMemory))
+	config.SetWasmThreads(set.has(featureThreads))
+	engine := wasmtime.NewEngineWithConfig(config)
+	go runEpochTicker(engine)
+
+	e.bySet[set] = engine
+	return engine
+}
+
+// requestFeatures returns the proposals a request runs with: the enclave
+// defaults plus those it asks for, which must be among the optional ones
+func requestFeatures(names []string, caps ResourceCaps) (featureSet, error) {
+	requested, err := parseFeatures(names)
+	if err != nil {
+		return 0, err
+	}
+	if extra := requested &^ (caps.DefaultFeatures | caps.OptionalFeatures); extra != 0 {
+		return 0, fmt.Errorf("features not available in this enclave: %v", extra)
+	}
+	return caps.DefaultFeatures | requested, nil
+}
@@ -0,0 +1,89 @@
+package enclave
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/logging"
+)
+
+// defineGlobalSecrets satisfies the global imports named after secrets, as
+// in
+//
+//	(import "env" "API_KEY" (global $api_key i32))
+//
+// with globals holding their values. WAT templates have these imports
+// rewritten into constants before compiling; binary, precompiled and
+// preloaded modules get them here, converted the same way, so a secret
+// reads the same whichever form the module was sent in.
+func defineGlobalSecrets(logger *slog.Logger, linker *wasmtime.Linker, store *wasmtime.Store, module *wasmtime.Module, secrets Secrets) error {
+	for _, imp := range module.Imports() {
+		// Memory secrets have globals of their own
+		if imp.Module() == secretPtrNamespace || imp.Module() == secretLenNamespace || imp.Name() == nil {
+			continue
+		}
+		globalType := imp.Type().GlobalType()
+		if globalType == nil {
+			continue
+		}
+		name := *imp.Name()
+		secret, ok := secrets[name]
+		if !ok {
+			continue
+		}
+
+		value, err := secretGlobalValue(secret.Bytes(), globalType.Content().Kind())
+		if err != nil {
+			return fmt.Errorf("failed to convert secret %s: %v", name, err)
+		}
+		global, err := wasmtime.NewGlobal(store, wasmtime.NewGlobalType(globalType.Content(), globalType.Mutable()), value)
+		if err != nil {
+			return fmt.Errorf("failed to create global for %s.%s: %v", imp.Module(), name, err)
+		}
+		if err := linker.Define(imp.Module(), name, global); err != nil {
+			return fmt.Errorf("failed to define %s.%s: %v", imp.Module(), name, err)
+		}
+		logger.Info("Satisfied import with secret global", "name", logging.SecretName(name), "type", globalType.Content().Kind().String())
+	}
+	return nil
+}
+
+// secretGlobalValue converts a secret as convertSecretToWASMValue does for
+// WAT, parsing the constant it would have written
+func secretGlobalValue(secret []byte, kind wasmtime.ValKind) (wasmtime.Val, error) {
+	var wasmType string
+	switch kind {
+	case wasmtime.KindI32:
+		wasmType = "i32"
+	case wasmtime.KindI64:
+		wasmType = "i64"
+	case wasmtime.KindF32:
+		wasmType = "f32"
+	case wasmtime.KindF64:
+		wasmType = "f64"
+	default:
+		return wasmtime.Val{}, fmt.Errorf("unsupported WASM type: %s", kind)
+	}
+	constant, err := convertSecretToWASMValue(secret, wasmType)
+	if err != nil {
+		return wasmtime.Val{}, err
+	}
+	defer clear(constant)
+
+	switch kind {
+	case wasmtime.KindI32:
+		n, err := strconv.ParseInt(string(constant), 10, 32)
+		return wasmtime.ValI32(int32(n)), err
+	case wasmtime.KindI64:
+		n, err := strconv.ParseInt(string(constant), 10, 64)
+		return wasmtime.ValI64(n), err
+	case wasmtime.KindF32:
+		f, err := strconv.ParseFloat(string(constant), 32)
+		return wasmtime.ValF32(float32(f)), err
+	}
+	f, err := strconv.ParseFloat(string(constant), 64)
+	return wasmtime.ValF64(f), err
+}
@@ -0,0 +1,84 @@
+package enclave
+
+import (
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+const wasmtimeModule = "github.com/bytecodealliance/wasmtime-go"
+
+// healthStats counts executions for health requests
+type healthStats struct {
+	started    time.Time
+	inFlight   atomic.Int64
+	executions atomic.Uint64
+	failures   atomic.Uint64
+	panics     atomic.Uint64
+}
+
+func newHealthStats() *healthStats {
+	return &healthStats{started: time.Now()}
+}
+
+// track marks an execution as running and returns the function that records
+// its outcome; only its first call counts
+func (h *healthStats) track() func(failed bool) {
+	h.inFlight.Add(1)
+	var recorded atomic.Bool
+	return func(failed bool) {
+		if recorded.Swap(true) {
+			return
+		}
+		h.inFlight.Add(-1)
+		h.executions.Add(1)
+		if failed {
+			h.failures.Add(1)
+		}
+	}
+}
+
+func (s *EnclaveServer) healthResponse(wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	return protocol.WASMResponse{
+		RequestID: wasmReq.RequestID,
+		Health: &protocol.HealthStatus{
+			UptimeSeconds:        int64(time.Since(s.health.started).Seconds()),
+			WasmtimeVersion:      wasmtimeVersion(),
+			FuelMetering:         s.executor.meterFuel,
+			InFlight:             s.health.inFlight.Load(),
+			Workers:              s.executor.workers.size(),
+			Queued:               s.executor.workers.queued(),
+			Executions:           s.health.executions.Load(),
+			Failures:             s.health.failures.Load(),
+			Sessions:             s.sessions.len(),
+			Jobs:                 s.jobs.len(),
+			PanicsRecovered:      s.health.panics.Load(),
+			WorkerRestarts:       s.executor.workers.restarts.Load(),
+			AllowedModules:       len(s.executor.policy.allowlist),
+			TrustedSigners:       len(s.executor.policy.signers),
+			WasmFeatures:         s.caps.DefaultFeatures.names(),
+			OptionalWasmFeatures: s.caps.OptionalFeatures.names(),
+			SelfTest:             s.selfTest,
+		},
+	}
+}
+
+// wasmtimeVersion reports the wasmtime-go module version compiled into the
+// binary
+func wasmtimeVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == wasmtimeModule {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,91 @@
+package enclave
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+)
+
+// Interval at which jobs record their progress for job_status
+const jobProgressInterval = time.Second
+
+// heartbeat reports on an execution every interval while it runs: how long
+// it has run and, when the enclave meters fuel, the fuel it has used. The
+// reports come from a goroutine of their own, so they keep coming while
+// the module computes, and tell a long execution from an enclave that
+// stopped answering. A nil heartbeat reports nothing.
+type heartbeat struct {
+	interval time.Duration
+	report   func(elapsed time.Duration, fuel uint64)
+
+	mu    sync.Mutex
+	store *wasmtime.Store
+}
+
+type heartbeatKey struct{}
+
+// withHeartbeat returns ctx whose execution calls report every interval
+func withHeartbeat(ctx context.Context, interval time.Duration, report func(elapsed time.Duration, fuel uint64)) context.Context {
+	return context.WithValue(ctx, heartbeatKey{}, &heartbeat{interval: interval, report: report})
+}
+
+// heartbeatFrom returns the heartbeat in ctx, or nil
+func heartbeatFrom(ctx context.Context) *heartbeat {
+	h, _ := ctx.Value(heartbeatKey{}).(*heartbeat)
+	return h
+}
+
+// start begins reporting and returns what stops it, which waits for a
+// report being sent so that none follows the response
+func (h *heartbeat) start() (stop func()) {
+	if h == nil {
+		return func() {}
+	}
+	started := time.Now()
+	quit := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.report(time.Since(started), h.fuel())
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		<-finished
+	}
+}
+
+// watch reports the fuel store uses from now on. Until the execution has a
+// store, reports carry only the time.
+func (h *heartbeat) watch(store *wasmtime.Store) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.store = store
+}
+
+// fuel reads the fuel of the running store, as the deadline sets its epoch
+// deadline, from outside the goroutine running it. Compiled code keeps its
+// fuel to itself between calls, so the count is as of the latest call or
+// return.
+func (h *heartbeat) fuel() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.store == nil {
+		return 0
+	}
+	fuel, _ := h.store.FuelConsumed()
+	return fuel
+}
@@ -0,0 +1,48 @@
+package enclave
+
+import (
+	"encoding/hex"
+	"log/slog"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// helloResponse tells a client what this enclave speaks
+func (s *EnclaveServer) helloResponse(logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	logger.Info("Client hello", "client_version", wasmReq.ProtocolVersion, "version", protocol.ProtocolVersion)
+
+	operations := []string{protocol.OperationBatch, protocol.OperationBoundSecrets, protocol.OperationEmits, protocol.OperationProgress}
+	if s.sessions.max > 0 {
+		operations = append(operations, protocol.OperationSessions, protocol.OperationMemory, protocol.OperationGlobals)
+	}
+	if s.jobs.max > 0 {
+		operations = append(operations, protocol.OperationJobs)
+	}
+	if s.moduleUpload && s.uploads.max > 0 {
+		operations = append(operations, protocol.OperationUploads)
+	}
+	if s.executor.policy.enforced() {
+		operations = append(operations, protocol.OperationPrecompiled)
+	}
+	// Only fails on requested features, which this has none of
+	defaults, _ := requestLimits(protocol.WASMRequest{}, s.caps)
+	hash := engineHash(s.executor.meterFuel, defaults)
+	return protocol.WASMResponse{
+		RequestID: wasmReq.RequestID,
+		Hello: &protocol.Capabilities{
+			ProtocolVersion:      protocol.ProtocolVersion,
+			RequestTypes:         protocol.RequestTypes,
+			ValueTypes:           protocol.ValueTypes,
+			Operations:           operations,
+			MaxCalls:             protocol.MaxCalls,
+			WasmtimeVersion:      wasmtimeVersion(),
+			WasmFeatures:         s.caps.DefaultFeatures.names(),
+			OptionalWasmFeatures: s.caps.OptionalFeatures.names(),
+			EngineHash:           hex.EncodeToString(hash[:]),
+			PreloadedModules:     s.executor.preloaded.names(),
+			ModuleUploadDisabled: !s.moduleUpload,
+			Runtime:              s.runtimeName,
+			SelfTest:             s.selfTest,
+		},
+	}
+}
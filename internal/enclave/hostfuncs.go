@@ -0,0 +1,119 @@
+package enclave
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/logging"
+)
+
+const (
+	// Namespace of the host functions offered to every module
+	hostFunctionNamespace = "env"
+
+	// get_secret returns this when the named secret was not provided
+	secretNotFound = -1
+)
+
+// newSecretLinker returns a linker serving one request's secrets to a
+// module. This works for binary modules as well as WAT, since nothing is
+// rewritten in the source. It defines:
+//
+//	(import "env" "get_secret" (func $get_secret (param i32 i32 i32 i32) (result i32)))
+//
+// get_secret(name_ptr, name_len, out_ptr, out_len) copies up to out_len bytes
+// of the secret named by the string at name_ptr into out_ptr and returns the
+// secret's full length, or -1 if there is no such secret. Calling it with
+// out_len 0 queries the length. Both buffers live in the caller's exported
+// "memory"; out-of-bounds pointers trap.
+//
+// Global imports named after secrets, and those placed through the
+// secret_ptr/secret_len namespaces, are defined on the linker too, when the
+// module uses them.
+func newSecretLinker(logger *slog.Logger, engine *wasmtime.Engine, store *wasmtime.Store, module *wasmtime.Module, imports hostImports, secrets Secrets, memSecrets *memorySecrets) (*wasmtime.Linker, error) {
+	linker := wasmtime.NewLinker(engine)
+
+	err := imports.funcWrap(linker, "get_secret", func(caller *wasmtime.Caller, namePtr, nameLen, outPtr, outLen int32) (int32, *wasmtime.Trap) {
+		memory, trap := callerMemory(caller)
+		if trap != nil {
+			return 0, trap
+		}
+		data := memory.UnsafeData(caller)
+
+		name, ok := memoryRange(data, namePtr, nameLen)
+		if !ok {
+			return 0, wasmtime.NewTrap("get_secret: name out of bounds")
+		}
+		out, ok := memoryRange(data, outPtr, outLen)
+		if !ok {
+			return 0, wasmtime.NewTrap("get_secret: output buffer out of bounds")
+		}
+
+		secret, exists := secrets[string(name)]
+		if !exists {
+			logger.Warn("Module requested unknown secret", "name", logging.SecretName(name))
+			return secretNotFound, nil
+		}
+		copy(out, secret.Bytes())
+		return int32(secret.Len()), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to define get_secret: %v", err)
+	}
+
+	if memSecrets != nil {
+		if err := memSecrets.define(linker, store, module, secrets); err != nil {
+			return nil, err
+		}
+	}
+	if err := defineGlobalSecrets(logger, linker, store, module, secrets); err != nil {
+		return nil, err
+	}
+
+	return linker, nil
+}
+
+// hostImports are the host functions a module imports, by name. Only those
+// are defined for it: each definition is a call into wasmtime that costs
+// more than running a small module.
+type hostImports map[string]bool
+
+// moduleHostImports returns the functions module imports from
+// hostFunctionNamespace
+func moduleHostImports(module *wasmtime.Module) hostImports {
+	imports := hostImports{}
+	for _, imp := range module.Imports() {
+		if name := imp.Name(); imp.Module() == hostFunctionNamespace && name != nil && imp.Type().FuncType() != nil {
+			imports[*name] = true
+		}
+	}
+	return imports
+}
+
+// funcWrap defines host function name on linker if the module imports it
+func (h hostImports) funcWrap(linker *wasmtime.Linker, name string, f interface{}) error {
+	if !h[name] {
+		return nil
+	}
+	return linker.FuncWrap(hostFunctionNamespace, name, f)
+}
+
+// callerMemory returns the calling module's exported memory
+func callerMemory(caller *wasmtime.Caller) (*wasmtime.Memory, *wasmtime.Trap) {
+	export := caller.GetExport(secretMemoryExport)
+	if export == nil || export.Memory() == nil {
+		return nil, wasmtime.NewTrap(fmt.Sprintf("host function requires an exported %q memory", secretMemoryExport))
+	}
+	return export.Memory(), nil
+}
+
+// memoryRange returns data[ptr:ptr+length] if it is in bounds
+func memoryRange(data []byte, ptr, length int32) ([]byte, bool) {
+	start, size := uint64(uint32(ptr)), uint64(uint32(length))
+	if start+size > uint64(len(data)) {
+		return nil, false
+	}
+	return data[start : start+size], true
+}
@@ -0,0 +1,47 @@
+package enclave
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// secretLenModule asks env.get_secret for the length of secret "k":
+//
+//	(module
+//	  (import "env" "get_secret" (func $get_secret (param i32 i32 i32 i32) (result i32)))
+//	  (memory (export "memory") 1)
+//	  (data (i32.const 0) "k")
+//	  (func (export "len") (result i32)
+//	    (call $get_secret (i32.const 0) (i32.const 1) (i32.const 0) (i32.const 0))))
+var secretLenModule = base64.StdEncoding.EncodeToString([]byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	0x01, 0x0d, 0x02, 0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f, 0x60, 0x00, 0x01, 0x7f,
+	0x02, 0x12, 0x01, 0x03, 'e', 'n', 'v', 0x0a, 'g', 'e', 't', '_', 's', 'e', 'c', 'r', 'e', 't', 0x00, 0x00,
+	0x03, 0x02, 0x01, 0x01,
+	0x05, 0x03, 0x01, 0x00, 0x01,
+	0x07, 0x10, 0x02, 0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00, 0x03, 'l', 'e', 'n', 0x00, 0x01,
+	0x0a, 0x0e, 0x01, 0x0c, 0x00, 0x41, 0x00, 0x41, 0x01, 0x41, 0x00, 0x41, 0x00, 0x10, 0x00, 0x0b,
+	0x0b, 0x07, 0x01, 0x00, 0x41, 0x00, 0x0b, 0x01, 'k',
+})
+
+// TestHostFunctionsDefinedForImports checks that a host function is still
+// linked into a module that imports it, though modules importing nothing
+// get none
+func TestHostFunctionsDefinedForImports(t *testing.T) {
+	executor, limits := newTestExecutor(t, 1)
+	secrets := Secrets{"k": NewSecretBuffer([]byte("hunter2"))}
+	defer secrets.Zero()
+	for i := 0; i < 2; i++ {
+		results, _, err := executor.ExecuteWASM(context.Background(), discardLogger, secretLenModule, "", []protocol.Call{{FunctionName: "len"}}, secrets, limits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].Err != nil || results[0].I32 != 7 {
+			t.Fatalf("len() = %+v, want the 7 bytes of secret k", results)
+		}
+	}
+	executeAdd(t, executor, limits)
+}
@@ -0,0 +1,90 @@
+package enclave
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"golang.org/x/crypto/sha3"
+)
+
+// Bound on the bytes one secure_random call may ask for, since each 256
+// bytes is a round trip to the NSM
+const maxRandomBytes = 64 << 10
+
+// defineHostLibrary adds hashing and randomness to linker, so modules need
+// not implement them in WASM:
+//
+//	(import "env" "sha256" (func $sha256 (param i32 i32 i32)))
+//	(import "env" "keccak256" (func $keccak256 (param i32 i32 i32)))
+//	(import "env" "secure_random" (func $secure_random (param i32 i32)))
+//
+// sha256(in_ptr, in_len, out_ptr) and keccak256 (the Ethereum variant of
+// SHA-3) write the 32-byte digest of the input to out_ptr.
+// secure_random(ptr, len) fills the buffer with bytes from random, mixed
+// with the NSM's inside an enclave. Out-of-bounds buffers trap, as does a secure_random
+// that cannot be served.
+func defineHostLibrary(linker *wasmtime.Linker, imports hostImports, random io.Reader) error {
+	if random == nil {
+		random = rand.Reader
+	}
+	digest := func(name string, sum func([]byte) []byte) func(*wasmtime.Caller, int32, int32, int32) *wasmtime.Trap {
+		return func(caller *wasmtime.Caller, inPtr, inLen, outPtr int32) *wasmtime.Trap {
+			memory, trap := callerMemory(caller)
+			if trap != nil {
+				return trap
+			}
+			data := memory.UnsafeData(caller)
+			in, ok := memoryRange(data, inPtr, inLen)
+			if !ok {
+				return wasmtime.NewTrap(name + ": input out of bounds")
+			}
+			out, ok := memoryRange(data, outPtr, 32)
+			if !ok {
+				return wasmtime.NewTrap(name + ": output buffer out of bounds")
+			}
+			copy(out, sum(in))
+			return nil
+		}
+	}
+	sha := digest("sha256", func(in []byte) []byte {
+		sum := sha256.Sum256(in)
+		return sum[:]
+	})
+	keccak := digest("keccak256", func(in []byte) []byte {
+		h := sha3.NewLegacyKeccak256()
+		h.Write(in)
+		return h.Sum(nil)
+	})
+	secureRandom := func(caller *wasmtime.Caller, ptr, length int32) *wasmtime.Trap {
+		if uint32(length) > maxRandomBytes {
+			return wasmtime.NewTrap(fmt.Sprintf("secure_random: at most %d bytes per call", maxRandomBytes))
+		}
+		memory, trap := callerMemory(caller)
+		if trap != nil {
+			return trap
+		}
+		buf, ok := memoryRange(memory.UnsafeData(caller), ptr, length)
+		if !ok {
+			return wasmtime.NewTrap("secure_random: buffer out of bounds")
+		}
+		// Memory cannot move while the NSM is asked, since no WASM code runs
+		if _, err := io.ReadFull(random, buf); err != nil {
+			return wasmtime.NewTrap(fmt.Sprintf("secure_random: %v", err))
+		}
+		return nil
+	}
+
+	for name, f := range map[string]interface{}{
+		"sha256":        sha,
+		"keccak256":     keccak,
+		"secure_random": secureRandom,
+	} {
+		if err := imports.funcWrap(linker, name, f); err != nil {
+			return fmt.Errorf("failed to define %s: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package enclave
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+const (
+	// Defaults for -job-ttl, -max-jobs and -max-job-timeout
+	defaultJobTTL        = 10 * time.Minute
+	defaultMaxJobs       = 64
+	defaultMaxJobTimeout = 10 * time.Minute
+)
+
+// Why the execution of a cancelled job stopped
+var errJobCancelled = errors.New("the job was cancelled")
+
+// job is an execution running in the background for a submit_job request.
+// It keeps its response until the table forgets it, so that polling for the
+// result may be retried.
+type job struct {
+	// Only requests of the tenant that submitted the job reach it
+	tenant    string
+	cancel    context.CancelCauseFunc
+	submitted time.Time
+	// Closed once response is set
+	done chan struct{}
+
+	mu       sync.Mutex
+	finished time.Time
+	response *protocol.WASMResponse
+	// Fuel used so far by the running execution
+	fuel uint64
+}
+
+// progress records the heartbeat of a running job
+func (j *job) progress(elapsed time.Duration, fuel uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.fuel = fuel
+}
+
+// finish records the response of a job
+func (j *job) finish(response protocol.WASMResponse) {
+	j.mu.Lock()
+	j.response = &response
+	j.finished = time.Now()
+	j.mu.Unlock()
+	close(j.done)
+	j.cancel(nil)
+}
+
+// result returns the response of a job, or nil while it runs
+func (j *job) result() *protocol.WASMResponse {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.response
+}
+
+func (j *job) status() *protocol.JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.response == nil {
+		return &protocol.JobStatus{State: protocol.JobRunning, ElapsedMS: time.Since(j.submitted).Milliseconds(), FuelConsumed: j.fuel}
+	}
+	status := &protocol.JobStatus{
+		State:        protocol.JobSucceeded,
+		ElapsedMS:    j.finished.Sub(j.submitted).Milliseconds(),
+		Error:        j.response.Error,
+		ErrorCode:    j.response.ErrorCode,
+		FuelConsumed: j.response.FuelConsumed,
+	}
+	switch {
+	case j.response.ErrorCode == protocol.ErrorCodeCancelled:
+		status.State = protocol.JobCancelled
+	case j.response.Error != "":
+		status.State = protocol.JobFailed
+	}
+	return status
+}
+
+// jobTable holds the jobs running and the results of finished ones, which
+// are forgotten once they have been kept for the TTL. Each tenant may have
+// max jobs, and sees only its own.
+type jobTable struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+	ttl  time.Duration
+	max  int
+	// Largest timeout_ms a job may ask for
+	timeout time.Duration
+}
+
+func newJobTable(ttl time.Duration, max int, timeout time.Duration) *jobTable {
+	t := &jobTable{
+		jobs:    make(map[string]*job),
+		ttl:     ttl,
+		max:     max,
+		timeout: timeout,
+	}
+	if ttl > 0 {
+		go t.expire()
+	}
+	return t
+}
+
+// add registers a job and returns its ID
+func (t *jobTable) add(j *job) (string, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %v", err)
+	}
+	id := hex.EncodeToString(raw[:])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkCapacity(j.tenant); err != nil {
+		return "", err
+	}
+	t.jobs[id] = j
+	return id, nil
+}
+
+// checkCapacity fails when no job can be added for tenant; the caller holds
+// t.mu
+func (t *jobTable) checkCapacity(tenant string) error {
+	if t.max == 0 {
+		return fmt.Errorf("jobs are disabled in this enclave")
+	}
+	held := 0
+	for _, j := range t.jobs {
+		if j.tenant == tenant {
+			held++
+		}
+	}
+	if held >= t.max {
+		return resourceLimitError("%d jobs are already running or holding results", held)
+	}
+	return nil
+}
+
+// hasCapacity reports whether a job could be added for tenant right now, so
+// that requests are turned away before their secrets are decrypted
+func (t *jobTable) hasCapacity(tenant string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.checkCapacity(tenant)
+}
+
+// get returns a job of tenant
+func (t *jobTable) get(tenant, id string) (*job, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	j, ok := t.jobs[id]
+	if !ok || j.tenant != tenant {
+		return nil, false
+	}
+	return j, true
+}
+
+func (t *jobTable) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.jobs)
+}
+
+// expire forgets jobs that finished longer than the TTL ago. Running jobs
+// are left alone; their timeout ends them.
+func (t *jobTable) expire() {
+	interval := t.ttl / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.mu.Lock()
+		for id, j := range t.jobs {
+			j.mu.Lock()
+			expired := j.response != nil && time.Since(j.finished) > t.ttl
+			j.mu.Unlock()
+			if expired {
+				delete(t.jobs, id)
+				slog.Info("Job expired", "job_id", id)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// submitJob starts an execution in the background and answers with its job
+// ID. The request is checked, and its secrets decrypted, before it is
+// answered; only running it is left for later. Jobs count as requests in
+// flight, so a drain waits for them as it does for any other.
+func (s *EnclaveServer) submitJob(ctx context.Context, logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	if err := s.jobs.hasCapacity(wasmReq.Tenant); err != nil {
+		logger.Warn("Rejecting job", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: errorCode(err)}
+	}
+
+	// Jobs may run for longer than requests answered directly
+	caps := s.caps
+	caps.MaxTimeout = s.jobs.timeout
+	limits, secrets, module, rejection := s.prepareExecution(ctx, logger, wasmReq, caps)
+	if rejection != nil {
+		return *rejection
+	}
+
+	if !s.drainer.Start() {
+		secrets.Zero()
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: "enclave is shutting down", ErrorCode: protocol.ErrorCodeShuttingDown}
+	}
+	jobCtx, cancel := context.WithCancelCause(context.Background())
+	j := &job{tenant: wasmReq.Tenant, cancel: cancel, submitted: time.Now(), done: make(chan struct{})}
+	// The fuel of a running job is there for job_status to report
+	jobCtx = withHeartbeat(jobCtx, jobProgressInterval, j.progress)
+	id, err := s.jobs.add(j)
+	if err != nil {
+		cancel(nil)
+		s.drainer.Done()
+		secrets.Zero()
+		logger.Warn("Rejecting job", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: errorCode(err)}
+	}
+
+	jobLogger := logger.With("job_id", id)
+	go func() {
+		defer s.drainer.Done()
+		defer secrets.Zero()
+		j.finish(s.recoverResponse(jobLogger, wasmReq, func() protocol.WASMResponse {
+			return s.execute(jobCtx, jobLogger, wasmReq, module, secrets, limits)
+		}))
+		jobLogger.Info("Job finished")
+	}()
+
+	logger.Info("Job submitted", "job_id", id)
+	return protocol.WASMResponse{RequestID: wasmReq.RequestID, JobID: id, Job: j.status()}
+}
+
+func (s *EnclaveServer) jobStatus(wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	j, ok := s.jobs.get(wasmReq.Tenant, wasmReq.JobID)
+	if !ok {
+		return unknownJob(wasmReq)
+	}
+	return protocol.WASMResponse{RequestID: wasmReq.RequestID, JobID: wasmReq.JobID, Job: j.status()}
+}
+
+// jobResult answers with the response of a finished job, as it would have
+// been sent had the request been executed directly
+func (s *EnclaveServer) jobResult(wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	j, ok := s.jobs.get(wasmReq.Tenant, wasmReq.JobID)
+	if !ok {
+		return unknownJob(wasmReq)
+	}
+	result := j.result()
+	if result == nil {
+		return protocol.WASMResponse{
+			RequestID: wasmReq.RequestID,
+			JobID:     wasmReq.JobID,
+			Job:       j.status(),
+			Error:     fmt.Sprintf("job %s is still running", wasmReq.JobID),
+			ErrorCode: protocol.ErrorCodeJobRunning,
+		}
+	}
+	response := *result
+	response.RequestID = wasmReq.RequestID
+	response.JobID = wasmReq.JobID
+	return response
+}
+
+// cancelJob stops a running job, waiting for its execution to be
+// interrupted, and reports how the job ended. A job that already finished
+// keeps its result.
+func (s *EnclaveServer) cancelJob(ctx context.Context, logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	j, ok := s.jobs.get(wasmReq.Tenant, wasmReq.JobID)
+	if !ok {
+		return unknownJob(wasmReq)
+	}
+	j.cancel(errJobCancelled)
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+	}
+	logger.Info("Job cancelled", "job_id", wasmReq.JobID)
+	return protocol.WASMResponse{RequestID: wasmReq.RequestID, JobID: wasmReq.JobID, Job: j.status()}
+}
+
+func unknownJob(wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: fmt.Sprintf("unknown job %s", wasmReq.JobID)}
+}
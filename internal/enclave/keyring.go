@@ -0,0 +1,236 @@
+package enclave
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/logging"
+	"hello-wasm-enclave/internal/protocol"
+)
+
+const (
+	// moduleKeyName names the keys every module gets, derived inside the
+	// enclave from a boot-time seed and the module's hash, so no two
+	// modules share them and no one outside the enclave knows them
+	moduleKeyName = "module"
+
+	// The crypto host functions return these instead of 0
+	keyNotFound = -1
+	keyInvalid  = -2
+)
+
+// newKeySeed returns the seed module keys are derived from. Like the other
+// enclave keys it is generated at startup and never leaves enclave memory,
+// so module keys change when the enclave restarts.
+func newKeySeed(random io.Reader) ([]byte, error) {
+	seed := make([]byte, 32)
+	if _, err := io.ReadFull(random, seed); err != nil {
+		return nil, fmt.Errorf("failed to generate module key seed: %v", err)
+	}
+	return seed, nil
+}
+
+// keyring holds the keys of one instance. Modules use them by name through
+// the crypto host functions but can never read them: besides the module
+// key, secrets of the request named key:NAME become key NAME and are
+// withheld from get_secret, templates and memory placement. Ed25519 and
+// X25519 keys are base64 32-byte seeds and private keys; HMAC keys are the
+// secret's bytes.
+type keyring struct {
+	keys map[string][]byte
+	// Derives the module key, for the module's hash; nil without a seed
+	seed   []byte
+	module string
+}
+
+// splitKeys separates the key:NAME secrets of a request from the others.
+// Keys share the bytes of their secrets, so wiping the secrets wipes them.
+func splitKeys(secrets Secrets) (Secrets, map[string][]byte) {
+	var keys map[string][]byte
+	for name := range secrets {
+		if strings.HasPrefix(name, protocol.KeySecretPrefix) {
+			keys = make(map[string][]byte)
+			break
+		}
+	}
+	if keys == nil {
+		return secrets, nil
+	}
+	plain := make(Secrets, len(secrets))
+	for name, value := range secrets {
+		if keyName, ok := strings.CutPrefix(name, protocol.KeySecretPrefix); ok {
+			keys[keyName] = value.Bytes()
+		} else {
+			plain[name] = value
+		}
+	}
+	return plain, keys
+}
+
+// key returns the material of a key of kind, which is also the label the
+// module key is derived under
+func (k *keyring) key(name, kind string) ([]byte, int32) {
+	if name == moduleKeyName && k.seed != nil {
+		mac := hmac.New(sha256.New, k.seed)
+		mac.Write([]byte(kind + "\x00" + k.module))
+		return mac.Sum(nil), 0
+	}
+	raw, ok := k.keys[name]
+	if !ok {
+		return nil, keyNotFound
+	}
+	if kind == "hmac" {
+		return raw, 0
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(raw))
+	if err != nil || len(decoded) != 32 {
+		return nil, keyInvalid
+	}
+	return decoded, 0
+}
+
+func (k *keyring) ed25519Key(name string) (ed25519.PrivateKey, int32) {
+	seed, status := k.key(name, "ed25519")
+	if status != 0 {
+		return nil, status
+	}
+	return ed25519.NewKeyFromSeed(seed), 0
+}
+
+func (k *keyring) x25519Key(name string) (*ecdh.PrivateKey, int32) {
+	raw, status := k.key(name, "x25519")
+	if status != 0 {
+		return nil, status
+	}
+	private, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, keyInvalid
+	}
+	return private, 0
+}
+
+// define adds the crypto functions to linker. Each names its key by the
+// string at key_ptr, writes a fixed-size output to out_ptr and returns 0, or
+// -1 when there is no such key and -2 when the key is malformed:
+//
+//	(import "env" "ed25519_sign" (func (param i32 i32 i32 i32 i32) (result i32)))
+//	(import "env" "ed25519_public_key" (func (param i32 i32 i32) (result i32)))
+//	(import "env" "hmac_sha256" (func (param i32 i32 i32 i32 i32) (result i32)))
+//	(import "env" "x25519_dh" (func (param i32 i32 i32 i32) (result i32)))
+//	(import "env" "x25519_public_key" (func (param i32 i32 i32) (result i32)))
+//
+// ed25519_sign(key_ptr, key_len, msg_ptr, msg_len, out_ptr) writes a 64-byte
+// signature of the message; hmac_sha256 takes the same arguments and writes
+// a 32-byte MAC. x25519_dh(key_ptr, key_len, peer_ptr, out_ptr) reads a
+// 32-byte peer public key and writes the 32-byte shared secret. The
+// public_key functions write the 32-byte public half, which a module can
+// return under an attestation document to prove where its key lives.
+func (k *keyring) define(logger *slog.Logger, linker *wasmtime.Linker, imports hostImports) error {
+	sign := func(caller *wasmtime.Caller, keyPtr, keyLen, msgPtr, msgLen, outPtr int32) (int32, *wasmtime.Trap) {
+		return k.call(logger, caller, "ed25519_sign", keyPtr, keyLen, msgPtr, msgLen, outPtr, ed25519.SignatureSize, func(name string, msg []byte) ([]byte, int32) {
+			private, status := k.ed25519Key(name)
+			if status != 0 {
+				return nil, status
+			}
+			return ed25519.Sign(private, msg), 0
+		})
+	}
+	signingPublicKey := func(caller *wasmtime.Caller, keyPtr, keyLen, outPtr int32) (int32, *wasmtime.Trap) {
+		return k.call(logger, caller, "ed25519_public_key", keyPtr, keyLen, 0, 0, outPtr, ed25519.PublicKeySize, func(name string, _ []byte) ([]byte, int32) {
+			private, status := k.ed25519Key(name)
+			if status != 0 {
+				return nil, status
+			}
+			return private.Public().(ed25519.PublicKey), 0
+		})
+	}
+	mac := func(caller *wasmtime.Caller, keyPtr, keyLen, msgPtr, msgLen, outPtr int32) (int32, *wasmtime.Trap) {
+		return k.call(logger, caller, "hmac_sha256", keyPtr, keyLen, msgPtr, msgLen, outPtr, sha256.Size, func(name string, msg []byte) ([]byte, int32) {
+			key, status := k.key(name, "hmac")
+			if status != 0 {
+				return nil, status
+			}
+			h := hmac.New(sha256.New, key)
+			h.Write(msg)
+			return h.Sum(nil), 0
+		})
+	}
+	dh := func(caller *wasmtime.Caller, keyPtr, keyLen, peerPtr, outPtr int32) (int32, *wasmtime.Trap) {
+		return k.call(logger, caller, "x25519_dh", keyPtr, keyLen, peerPtr, 32, outPtr, 32, func(name string, peer []byte) ([]byte, int32) {
+			private, status := k.x25519Key(name)
+			if status != 0 {
+				return nil, status
+			}
+			public, err := ecdh.X25519().NewPublicKey(peer)
+			if err != nil {
+				return nil, keyInvalid
+			}
+			shared, err := private.ECDH(public)
+			if err != nil {
+				return nil, keyInvalid
+			}
+			return shared, 0
+		})
+	}
+	dhPublicKey := func(caller *wasmtime.Caller, keyPtr, keyLen, outPtr int32) (int32, *wasmtime.Trap) {
+		return k.call(logger, caller, "x25519_public_key", keyPtr, keyLen, 0, 0, outPtr, 32, func(name string, _ []byte) ([]byte, int32) {
+			private, status := k.x25519Key(name)
+			if status != 0 {
+				return nil, status
+			}
+			return private.PublicKey().Bytes(), 0
+		})
+	}
+
+	for name, f := range map[string]interface{}{
+		"ed25519_sign":       sign,
+		"ed25519_public_key": signingPublicKey,
+		"hmac_sha256":        mac,
+		"x25519_dh":          dh,
+		"x25519_public_key":  dhPublicKey,
+	} {
+		if err := imports.funcWrap(linker, name, f); err != nil {
+			return fmt.Errorf("failed to define %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// call runs one crypto function: it reads the key name and input from the
+// caller's memory, and copies the output of compute, outLen bytes, to outPtr
+func (k *keyring) call(logger *slog.Logger, caller *wasmtime.Caller, function string, keyPtr, keyLen, inPtr, inLen, outPtr, outLen int32, compute func(name string, in []byte) ([]byte, int32)) (int32, *wasmtime.Trap) {
+	memory, trap := callerMemory(caller)
+	if trap != nil {
+		return 0, trap
+	}
+	data := memory.UnsafeData(caller)
+	name, ok := memoryRange(data, keyPtr, keyLen)
+	if !ok {
+		return 0, wasmtime.NewTrap(function + ": key name out of bounds")
+	}
+	in, ok := memoryRange(data, inPtr, inLen)
+	if !ok {
+		return 0, wasmtime.NewTrap(function + ": input out of bounds")
+	}
+	out, ok := memoryRange(data, outPtr, outLen)
+	if !ok {
+		return 0, wasmtime.NewTrap(function + ": output buffer out of bounds")
+	}
+
+	result, status := compute(string(name), in)
+	if status != 0 {
+		logger.Warn("Crypto host function failed", "function", function, "key", logging.SecretName(name), "status", status)
+		return status, nil
+	}
+	copy(out, result)
+	return 0, nil
+}
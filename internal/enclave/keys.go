@@ -0,0 +1,135 @@
+package enclave
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Size of the ephemeral RSA key generated at enclave startup
+const enclaveKeyBits = 2048
+
+// EnclaveKey is an ephemeral keypair that only exists in enclave memory.
+// Its public half is published inside an attestation document, so clients
+// can encrypt secrets that the host forwards but can never read.
+//
+// Encrypted secrets are a base64 envelope of:
+//
+//	RSA-OAEP-SHA256(aes_key) || nonce (12 bytes) || AES-256-GCM(aes_key, nonce, json(secrets))
+//
+// where the RSA ciphertext is exactly the key size (256 bytes). Each value
+// of the JSON object is the secret as a string, or an object of the secret
+// "value" and the hex "module_sha256" of the only module it may be injected
+// into, which the host cannot strip as it can entries of secret_list.
+type EnclaveKey struct {
+	private   *rsa.PrivateKey
+	publicDER []byte
+}
+
+func NewEnclaveKey(random io.Reader) (*EnclaveKey, error) {
+	private, err := rsa.GenerateKey(random, enclaveKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate enclave key: %v", err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode enclave public key: %v", err)
+	}
+
+	return &EnclaveKey{private: private, publicDER: publicDER}, nil
+}
+
+// PublicKeyDER returns the PKIX DER encoding of the public key
+func (k *EnclaveKey) PublicKeyDER() []byte {
+	return k.publicDER
+}
+
+// DecryptSecrets opens an envelope produced against this key, refusing it
+// if it seals a secret to a module other than module
+func (k *EnclaveKey) DecryptSecrets(envelope, module string) (Secrets, error) {
+	sealed, err := base64.StdEncoding.DecodeString(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted secrets encoding: %v", err)
+	}
+
+	keySize := k.private.Size()
+	if len(sealed) < keySize+12 {
+		return nil, fmt.Errorf("encrypted secrets envelope too short: %d bytes", len(sealed))
+	}
+
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, k.private, sealed[:keySize], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap secrets key: %v", err)
+	}
+	defer clear(aesKey)
+
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets key: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize AES-GCM: %v", err)
+	}
+
+	nonce := sealed[keySize : keySize+gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, sealed[keySize+gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secrets: %v", err)
+	}
+	defer clear(plaintext)
+
+	var sealedSecrets map[string]sealedSecret
+	err = json.Unmarshal(plaintext, &sealedSecrets)
+	secrets := make(Secrets, len(sealedSecrets))
+	for name, secret := range sealedSecrets {
+		if secret.value != nil {
+			secrets[name] = secret.value
+		}
+	}
+	if err != nil {
+		secrets.Zero()
+		return nil, fmt.Errorf("decrypted secrets are not a JSON object: %v", err)
+	}
+	for name, secret := range sealedSecrets {
+		if secret.module != "" && !strings.EqualFold(secret.module, module) {
+			secrets.Zero()
+			return nil, policyError("secret %s is sealed to module %s, not %s", name, secret.module, module)
+		}
+	}
+	return secrets, nil
+}
+
+// sealedSecret is one value of an encrypted secrets envelope
+type sealedSecret struct {
+	value  *SecretBuffer
+	module string
+}
+
+// UnmarshalJSON reads a secret sealed as a string, or as an object binding
+// it to a module
+func (s *sealedSecret) UnmarshalJSON(data []byte) error {
+	if len(data) == 0 || data[0] != '{' {
+		s.value = new(SecretBuffer)
+		return s.value.UnmarshalJSON(data)
+	}
+	var bound struct {
+		Value        *SecretBuffer `json:"value"`
+		ModuleSHA256 string        `json:"module_sha256"`
+	}
+	err := json.Unmarshal(data, &bound)
+	s.value, s.module = bound.Value, bound.ModuleSHA256
+	if err == nil && s.value == nil {
+		err = fmt.Errorf("sealed secret has no value")
+	}
+	return err
+}
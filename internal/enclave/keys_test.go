@@ -0,0 +1,55 @@
+package enclave
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+// seal encrypts plaintext to key as wasm-client does
+func seal(t *testing.T, key *EnclaveKey, plaintext string) string {
+	t.Helper()
+	aesKey := make([]byte, 32)
+	rand.Read(aesKey)
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &key.private.PublicKey, aesKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(aesKey)
+	gcm, _ := cipher.NewGCM(block)
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	sealed := gcm.Seal(append(wrapped, nonce...), nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed)
+}
+
+func TestDecryptSecretsEnforcesSealedModule(t *testing.T) {
+	key, err := NewEnclaveKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	module, other := moduleHash("(module)"), moduleHash("(module (func))")
+	envelope := seal(t, key, `{"unbound":"a","bound":{"name":"bound","value":"b","module_sha256":"`+module+`"}}`)
+
+	secrets, err := key.DecryptSecrets(envelope, module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secrets.Zero()
+	if got := string(secrets["unbound"].Bytes()); got != "a" {
+		t.Errorf("unbound = %q, want a", got)
+	}
+	if got := string(secrets["bound"].Bytes()); got != "b" {
+		t.Errorf("bound = %q, want b", got)
+	}
+
+	// The host dropping the secret list cannot move the secret to another
+	// module
+	if _, err := key.DecryptSecrets(envelope, other); errorCode(err) == "" {
+		t.Fatalf("opening for another module: %v, want a policy error", err)
+	}
+}
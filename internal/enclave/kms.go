@@ -0,0 +1,167 @@
+package enclave
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/sigv4"
+	"hello-wasm-enclave/internal/transport"
+)
+
+const (
+	// The parent instance is always reachable at this CID from the enclave
+	parentCID = 3
+	// Upper bound on a KMS response body
+	maxKMSResponseSize = 1 << 20
+)
+
+// KMSProvider decrypts KMS ciphertexts from inside the enclave. Calls go to
+// KMS through a vsock-proxy on the parent instance, so the host only relays
+// TLS bytes, and every Decrypt carries an attestation document for the
+// enclave key. KMS then re-encrypts the plaintext to that key, which lets key
+// policies with kms:RecipientAttestation conditions gate access on the image.
+type KMSProvider struct {
+	attester  *Attester
+	key       *EnclaveKey
+	client    *http.Client
+	proxyPort uint32
+}
+
+func NewKMSProvider(attester *Attester, key *EnclaveKey, parent *transport.Transport, proxyPort uint32) *KMSProvider {
+	httpTransport := &http.Transport{
+		// Whatever host name is requested, the bytes go to the local vsock-proxy
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return parent.Dial(parentCID, proxyPort)
+		},
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return &KMSProvider{
+		attester:  attester,
+		key:       key,
+		client:    &http.Client{Transport: httpTransport, Timeout: 30 * time.Second},
+		proxyPort: proxyPort,
+	}
+}
+
+type kmsRecipient struct {
+	KeyEncryptionAlgorithm string `json:"KeyEncryptionAlgorithm"`
+	AttestationDocument    string `json:"AttestationDocument"`
+}
+
+type kmsDecryptRequest struct {
+	CiphertextBlob    string            `json:"CiphertextBlob"`
+	EncryptionContext map[string]string `json:"EncryptionContext,omitempty"`
+	Recipient         kmsRecipient      `json:"Recipient"`
+}
+
+type kmsDecryptResponse struct {
+	KeyID                  string `json:"KeyId"`
+	CiphertextForRecipient string `json:"CiphertextForRecipient"`
+}
+
+type kmsError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// DecryptSecrets decrypts each named base64 KMS ciphertext, using the
+// encryption context registered for that name if there is one. A ciphertext
+// encrypted under a context with protocol.KMSModuleContextKey only decrypts
+// with that context, which is refused unless it names module. The calls are
+// abandoned when ctx ends.
+func (p *KMSProvider) DecryptSecrets(ctx context.Context, ciphertexts map[string]string, contexts map[string]map[string]string, module string, creds *sigv4.Credentials) (Secrets, error) {
+	for name := range ciphertexts {
+		if bound, ok := contexts[name][protocol.KMSModuleContextKey]; ok && !strings.EqualFold(bound, module) {
+			return nil, policyError("secret %s is sealed to module %s, not %s", name, bound, module)
+		}
+	}
+	if creds == nil || creds.AccessKeyID == "" || creds.Region == "" {
+		return nil, fmt.Errorf("KMS secrets require AWS credentials and region from the host")
+	}
+
+	// One attestation document covers every Decrypt in this request
+	attestation, err := p.attester.AttestPublicKey(p.key.PublicKeyDER(), "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to attest enclave key for KMS: %v", err)
+	}
+
+	secrets := make(Secrets, len(ciphertexts))
+	for name, ciphertext := range ciphertexts {
+		plaintext, err := p.decrypt(ctx, ciphertext, contexts[name], attestation, *creds)
+		if err != nil {
+			secrets.Zero()
+			return nil, fmt.Errorf("KMS decrypt of %s failed: %v", name, err)
+		}
+		secrets[name] = NewSecretBuffer(plaintext)
+	}
+	return secrets, nil
+}
+
+func (p *KMSProvider) decrypt(ctx context.Context, ciphertext string, encryptionContext map[string]string, attestation string, creds sigv4.Credentials) ([]byte, error) {
+	body, err := json.Marshal(kmsDecryptRequest{
+		CiphertextBlob:    ciphertext,
+		EncryptionContext: encryptionContext,
+		Recipient: kmsRecipient{
+			KeyEncryptionAlgorithm: "RSAES_OAEP_SHA_256",
+			AttestationDocument:    attestation,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("https://kms.%s.amazonaws.com/", creds.Region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+	sigv4.SignRequest(req, body, creds, "kms", time.Now())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request via vsock-proxy port %d failed: %v", p.proxyPort, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxKMSResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KMS response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var kmsErr kmsError
+		json.Unmarshal(respBody, &kmsErr)
+		return nil, fmt.Errorf("KMS returned %d: %s %s", resp.StatusCode, kmsErr.Type, kmsErr.Message)
+	}
+
+	var decrypted kmsDecryptResponse
+	if err := json.Unmarshal(respBody, &decrypted); err != nil {
+		return nil, fmt.Errorf("invalid KMS response: %v", err)
+	}
+	if decrypted.CiphertextForRecipient == "" {
+		return nil, fmt.Errorf("KMS response has no CiphertextForRecipient")
+	}
+
+	envelope, err := base64.StdEncoding.DecodeString(decrypted.CiphertextForRecipient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CiphertextForRecipient encoding: %v", err)
+	}
+
+	return openEnvelopedData(envelope, func(wrapped []byte) ([]byte, error) {
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, p.key.private, wrapped, nil)
+	})
+}
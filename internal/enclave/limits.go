@@ -0,0 +1,279 @@
+package enclave
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+const (
+	// The engine epoch advances this often; timeouts are rounded up to it
+	epochTick = 10 * time.Millisecond
+	// How soon a cancelled execution is interrupted
+	deadlineLease = 200 * time.Millisecond
+	// Defaults for -default-timeout and -max-timeout
+	defaultExecutionTimeout = 5 * time.Second
+	maxExecutionTimeout     = 60 * time.Second
+	// Fuel given to requests without max_fuel when metering is enabled; the
+	// timeout still bounds them
+	unmeteredFuel = math.MaxInt64
+	// Without multi-memory a module has at most one memory
+	maxMemories = 1
+)
+
+// ExecutionLimits bounds the resources a single execution may use. Each
+// request instantiates exactly one module in a store of its own, so there is
+// no separate limit on instances.
+type ExecutionLimits struct {
+	Timeout time.Duration
+	// Zero means no fuel limit
+	MaxFuel uint64
+	// Memory is measured in 64 KiB pages, tables in elements
+	MaxMemoryPages   uint32
+	MaxTableElements uint32
+	MaxTables        int
+	// Bytes kept of each of stdout and stderr
+	MaxOutputBytes int
+	// WebAssembly proposals the module is compiled with
+	Features featureSet
+	// Tenant the module is compiled for; tenants do not share cached modules
+	Tenant string
+}
+
+// ResourceCaps are the enclave-wide ceilings for time, memory and tables,
+// which requests may lower but not raise
+type ResourceCaps struct {
+	// DefaultTimeout applies to requests that do not set timeout_ms, which
+	// may not exceed MaxTimeout
+	DefaultTimeout   time.Duration
+	MaxTimeout       time.Duration
+	MaxMemoryPages   uint32
+	MaxTableElements uint32
+	MaxTables        int
+	MaxOutputBytes   int
+	// DefaultFeatures are enabled for every request; requests may add any of
+	// OptionalFeatures
+	DefaultFeatures  featureSet
+	OptionalFeatures featureSet
+}
+
+// LimitError is an execution stopped by one of its limits, or refused by
+// policy; Code is reported to the client in error_code
+type LimitError struct {
+	Code    string
+	Message string
+}
+
+func (e *LimitError) Error() string {
+	return e.Message
+}
+
+func resourceLimitError(format string, args ...interface{}) error {
+	return &LimitError{Code: protocol.ErrorCodeResourceLimit, Message: "resource limit exceeded: " + fmt.Sprintf(format, args...)}
+}
+
+// errorCode returns the error_code for an execution error, if it has one
+func errorCode(err error) string {
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		return limitErr.Code
+	}
+	return ""
+}
+
+// ExecutionStats reports the resources an execution used and, for WASI
+// modules, what it printed
+type ExecutionStats struct {
+	FuelConsumed uint64
+	// Time spent turning the code into a module, and running it:
+	// instantiating it, then making the calls
+	CompileTime     time.Duration
+	ExecuteTime     time.Duration
+	InstantiateTime time.Duration
+	CallTime        time.Duration
+	// When compiling and instantiating began, for tracing; zero when the
+	// execution did not get that far
+	CompileStart     time.Time
+	InstantiateStart time.Time
+	// Pages of the exported memories when the execution ended
+	MemoryPages uint64
+	// One of the protocol.ModuleSource values
+	ModuleSource string
+
+	Stdout          string
+	Stderr          string
+	OutputTruncated bool
+	// Emits were dropped for the limit on what one execution streams
+	EmitsTruncated bool
+
+	// What the module fetched through env.http_get
+	Fetches []protocol.Fetch
+}
+
+// metadata reports the stats to the client
+func (s ExecutionStats) metadata() *protocol.Metadata {
+	return &protocol.Metadata{
+		CompileUS:      s.CompileTime.Microseconds(),
+		InstantiateUS:  s.InstantiateTime.Microseconds(),
+		CallUS:         s.CallTime.Microseconds(),
+		FuelConsumed:   s.FuelConsumed,
+		MemoryPages:    s.MemoryPages,
+		ModuleSource:   s.ModuleSource,
+		ModuleCacheHit: s.ModuleSource == protocol.ModuleSourcePreloaded || s.ModuleSource == protocol.ModuleSourceCached || s.ModuleSource == protocol.ModuleSourceSession,
+	}
+}
+
+// memoryPages returns the size of an instance's exported memories. Memories
+// it keeps to itself cannot be seen from outside.
+func memoryPages(store *wasmtime.Store, instance *wasmtime.Instance) uint64 {
+	var pages uint64
+	for _, export := range instance.Exports(store) {
+		if memory := export.Memory(); memory != nil {
+			pages += memory.Size(store)
+		}
+	}
+	return pages
+}
+
+// requestLimits derives the execution limits for a request
+func requestLimits(wasmReq protocol.WASMRequest, caps ResourceCaps) (ExecutionLimits, error) {
+	limits := ExecutionLimits{
+		Timeout:          caps.DefaultTimeout,
+		MaxFuel:          wasmReq.MaxFuel,
+		MaxMemoryPages:   caps.MaxMemoryPages,
+		MaxTableElements: caps.MaxTableElements,
+		MaxTables:        caps.MaxTables,
+		MaxOutputBytes:   caps.MaxOutputBytes,
+		Tenant:           wasmReq.Tenant,
+	}
+	if wasmReq.MaxMemoryPages > 0 && wasmReq.MaxMemoryPages < limits.MaxMemoryPages {
+		limits.MaxMemoryPages = wasmReq.MaxMemoryPages
+	}
+	if wasmReq.MaxTableElements > 0 && wasmReq.MaxTableElements < limits.MaxTableElements {
+		limits.MaxTableElements = wasmReq.MaxTableElements
+	}
+	features, err := requestFeatures(wasmReq.Features, caps)
+	if err != nil {
+		return limits, err
+	}
+	limits.Features = features
+	if wasmReq.TimeoutMS > 0 {
+		limits.Timeout = time.Duration(wasmReq.TimeoutMS) * time.Millisecond
+		if limits.Timeout > caps.MaxTimeout {
+			return limits, fmt.Errorf("timeout_ms exceeds the maximum of %d", caps.MaxTimeout.Milliseconds())
+		}
+	}
+	return limits, nil
+}
+
+// epochDeadline converts a timeout into a number of epoch ticks
+func epochDeadline(timeout time.Duration) uint64 {
+	ticks := uint64((timeout + epochTick - 1) / epochTick)
+	if ticks == 0 {
+		ticks = 1
+	}
+	return ticks
+}
+
+// runEpochTicker advances the engine epoch forever so stores past their
+// deadline are interrupted
+func runEpochTicker(engine *wasmtime.Engine) {
+	ticker := time.NewTicker(epochTick)
+	defer ticker.Stop()
+	for range ticker.C {
+		engine.IncrementEpoch()
+	}
+}
+
+// executionDeadline interrupts a store once its timeout passes or its ctx
+// ends. Compiled code caches the epoch deadline and only reads it again once
+// the epoch reaches the cached value, so a deadline moved earlier would go
+// unnoticed by a running loop. Instead the store gets a short lease that is
+// renewed every tick until the timeout, and left to lapse when ctx ends.
+type executionDeadline struct {
+	ctx      context.Context
+	store    *wasmtime.Store
+	quit     chan struct{}
+	finished chan struct{}
+}
+
+func newDeadline(ctx context.Context, store *wasmtime.Store) *executionDeadline {
+	return &executionDeadline{ctx: ctx, store: store}
+}
+
+// start arms the deadline timeout from now
+func (d *executionDeadline) start(timeout time.Duration) {
+	d.stop()
+	end := time.Now().Add(timeout)
+	renew := func() {
+		remaining := time.Until(end)
+		if remaining > deadlineLease {
+			remaining = deadlineLease
+		}
+		d.store.SetEpochDeadline(epochDeadline(remaining))
+	}
+	renew()
+	if d.ctx.Done() == nil || timeout <= deadlineLease {
+		return
+	}
+
+	d.quit = make(chan struct{})
+	d.finished = make(chan struct{})
+	go func(quit, finished chan struct{}) {
+		defer close(finished)
+		ticker := time.NewTicker(epochTick)
+		defer ticker.Stop()
+		// The lease set last runs exactly to the timeout
+		for time.Until(end) > deadlineLease {
+			select {
+			case <-ticker.C:
+				renew()
+			case <-d.ctx.Done():
+				return
+			case <-quit:
+				return
+			}
+		}
+	}(d.quit, d.finished)
+}
+
+// stop ends the renewals. It must be called before the store runs anything
+// the deadline does not cover.
+func (d *executionDeadline) stop() {
+	if d.quit == nil {
+		return
+	}
+	close(d.quit)
+	<-d.finished
+	d.quit, d.finished = nil, nil
+}
+
+// cancelledError is returned for work given up because ctx ended
+func cancelledError(ctx context.Context) error {
+	return &LimitError{Code: protocol.ErrorCodeCancelled, Message: fmt.Sprintf("execution cancelled: %v", context.Cause(ctx))}
+}
+
+// cancelled reports an execution interrupted because ctx ended as
+// cancelled rather than timed out
+func cancelled(ctx context.Context, err error) error {
+	if ctx.Err() != nil && errorCode(err) == protocol.ErrorCodeTimeout {
+		return cancelledError(ctx)
+	}
+	return err
+}
+
+// isInterrupt reports whether err is the trap raised at an epoch deadline
+func isInterrupt(err error) bool {
+	var trap *wasmtime.Trap
+	if !errors.As(err, &trap) {
+		return false
+	}
+	code := trap.Code()
+	return code != nil && *code == wasmtime.Interrupt
+}
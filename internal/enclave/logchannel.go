@@ -0,0 +1,124 @@
+package enclave
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Bounds on the log records held for the host: those written while it is
+// away, and those written faster than it reads
+const (
+	logBacklogRecords = 256
+	logQueueRecords   = 1024
+)
+
+// logChannel ships the enclave's log records, one JSON line each, to the
+// host on a port of their own, since the console of an enclave is only
+// readable with nitro-cli. Records written while no host follows them are
+// kept, up to a backlog, for the next one; those it does not read in time are
+// dropped and counted, so logging never holds up a request.
+type logChannel struct {
+	mu      sync.Mutex
+	backlog [][]byte
+	host    chan []byte
+	dropped int
+}
+
+func newLogChannel() *logChannel {
+	return &logChannel{}
+}
+
+// Write takes one record. It must not log, as it runs within the logger.
+func (c *logChannel) Write(p []byte) (int, error) {
+	record := append([]byte(nil), p...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.host == nil {
+		if len(c.backlog) == logBacklogRecords {
+			c.backlog = c.backlog[1:]
+		}
+		c.backlog = append(c.backlog, record)
+		return len(p), nil
+	}
+	select {
+	case c.host <- record:
+	default:
+		c.dropped++
+	}
+	return len(p), nil
+}
+
+// serve hands the records to each host that connects to listener, the
+// latest one replacing any before it
+func (c *logChannel) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("ERROR: Log channel listener failed: %v", err)
+			return
+		}
+		c.mu.Lock()
+		if c.host != nil {
+			close(c.host)
+		}
+		host := make(chan []byte, logQueueRecords)
+		backlog := c.backlog
+		c.host, c.backlog = host, nil
+		c.mu.Unlock()
+		go c.stream(conn, host, backlog)
+	}
+}
+
+// stream writes backlog and then the records sent to host to conn, until
+// the host goes away or another replaces it
+func (c *logChannel) stream(conn net.Conn, host chan []byte, backlog [][]byte) {
+	defer conn.Close()
+	err := writeRecords(conn, backlog)
+	for err == nil {
+		record, ok := <-host
+		if !ok {
+			return
+		}
+		err = writeRecords(conn, [][]byte{record})
+		if dropped := c.takeDropped(); err == nil && dropped > 0 {
+			err = writeRecords(conn, [][]byte{droppedRecord(dropped)})
+		}
+	}
+	c.mu.Lock()
+	if c.host == host {
+		c.host = nil
+	}
+	c.mu.Unlock()
+	log.Printf("Host stopped following the log channel: %v", err)
+}
+
+func (c *logChannel) takeDropped() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dropped := c.dropped
+	c.dropped = 0
+	return dropped
+}
+
+func writeRecords(conn net.Conn, records [][]byte) error {
+	for _, record := range records {
+		if _, err := conn.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// droppedRecord is the record telling the host how many it missed
+func droppedRecord(dropped int) []byte {
+	record, _ := json.Marshal(map[string]interface{}{
+		"time":    time.Now(),
+		"level":   "WARN",
+		"msg":     "Dropped log records the host did not read in time",
+		"records": dropped,
+	})
+	return append(record, '\n')
+}
@@ -0,0 +1,95 @@
+package enclave
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"hello-wasm-enclave/internal/nsm"
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Default for -module-pcr: the first PCR the image leaves to the enclave
+const defaultModulePCR = 16
+
+// moduleMeasurements extends a PCR with the digest of every module before
+// it first runs, so attestation documents commit to all modules this
+// enclave ran since it started. Each module is extended once, however
+// often it runs. A nil moduleMeasurements measures nothing.
+type moduleMeasurements struct {
+	nsm *nsm.Session
+	pcr uint16
+
+	mu       sync.Mutex
+	measured map[string]bool
+	modules  []string
+	value    []byte
+}
+
+// newModuleMeasurements checks that pcr is still as the enclave booted
+// with it: unlocked and zeroed, so its value is the replay of what is
+// extended from here on
+func newModuleMeasurements(session *nsm.Session, pcr uint16) (*moduleMeasurements, error) {
+	state, err := session.DescribePCR(pcr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PCR%d: %v", pcr, err)
+	}
+	if state.Lock {
+		return nil, fmt.Errorf("PCR%d is locked", pcr)
+	}
+	if !bytes.Equal(state.Data, make([]byte, len(state.Data))) {
+		return nil, fmt.Errorf("PCR%d was already extended", pcr)
+	}
+	return &moduleMeasurements{nsm: session, pcr: pcr, measured: make(map[string]bool), value: state.Data}, nil
+}
+
+// measure extends the PCR with module, a hex SHA-256 digest, unless it was
+// before. A module that could not be measured must not run, or attestation
+// documents would leave it out.
+func (m *moduleMeasurements) measure(logger *slog.Logger, module string) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.measured[module] {
+		return nil
+	}
+	digest, err := hex.DecodeString(module)
+	if err != nil {
+		return err
+	}
+	value, err := m.nsm.ExtendPCR(m.pcr, digest)
+	if err != nil {
+		return fmt.Errorf("failed to measure module into PCR%d: %v", m.pcr, err)
+	}
+	m.measured[module] = true
+	m.modules = append(m.modules, module)
+	m.value = value
+	logger.Info("Extended PCR with module", "pcr", m.pcr, "module", module, "modules", len(m.modules))
+	return nil
+}
+
+// moduleMeasurementsResponse lists the modules measured so far. An
+// attestation document is taken before any other module can be measured,
+// so its PCR matches the list.
+func (s *EnclaveServer) moduleMeasurementsResponse(logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	m := s.executor.measurements
+	if m == nil {
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: "module measurements are disabled"}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	response := protocol.WASMResponse{
+		RequestID: wasmReq.RequestID,
+		ModuleMeasurements: &protocol.ModuleMeasurements{
+			PCR:     int(m.pcr),
+			Modules: append([]string{}, m.modules...),
+			Value:   hex.EncodeToString(m.value),
+		},
+	}
+	s.attest(logger, wasmReq, &response)
+	return response
+}
@@ -0,0 +1,159 @@
+package enclave
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/bytecodealliance/wasmtime-go"
+)
+
+const (
+	// Import namespaces through which a module asks for a secret's location
+	// in linear memory instead of a hashed i32:
+	//
+	//	(import "secret_ptr" "DB_PASSWORD" (global $pw_ptr i32))
+	//	(import "secret_len" "DB_PASSWORD" (global $pw_len i32))
+	secretPtrNamespace = "secret_ptr"
+	secretLenNamespace = "secret_len"
+
+	// The module must export its memory under this name
+	secretMemoryExport = "memory"
+
+	wasmPageSize = 65536
+	// Pages in the 4 GiB a 32-bit memory can address
+	maxWasmPages = 65536
+	// Alignment of each secret within the injected region
+	secretAlignment = 8
+)
+
+// memorySecrets places string secrets bit-for-bit into the module's exported
+// memory. The region starts right after the memory's initial size, which the
+// module cannot have used yet, and is created by growing the memory after
+// instantiation. Secrets are therefore readable from exported functions but
+// not from a start function.
+type memorySecrets struct {
+	base    uint64
+	offsets map[string]uint32
+	data    []byte
+	pages   uint64
+}
+
+// planMemorySecrets lays out the secrets a module imports through the
+// secret_ptr/secret_len namespaces. It returns nil if there are none.
+func planMemorySecrets(logger *slog.Logger, module *wasmtime.Module, secrets Secrets) (*memorySecrets, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, imp := range module.Imports() {
+		if imp.Module() != secretPtrNamespace && imp.Module() != secretLenNamespace {
+			continue
+		}
+		if imp.Name() == nil {
+			return nil, fmt.Errorf("secret import in %s has no name", imp.Module())
+		}
+		name := *imp.Name()
+		global := imp.Type().GlobalType()
+		if global == nil || global.Content().Kind() != wasmtime.KindI32 || global.Mutable() {
+			return nil, fmt.Errorf("secret import %s.%s must be an immutable i32 global", imp.Module(), name)
+		}
+		if _, ok := secrets[name]; !ok {
+			return nil, fmt.Errorf("secret %s requested by %s import was not provided", name, imp.Module())
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	var memoryType *wasmtime.MemoryType
+	for _, export := range module.Exports() {
+		if export.Name() == secretMemoryExport {
+			memoryType = export.Type().MemoryType()
+		}
+	}
+	if memoryType == nil {
+		return nil, fmt.Errorf("module imports memory secrets but does not export %q memory", secretMemoryExport)
+	}
+	if memoryType.Is64() {
+		return nil, fmt.Errorf("memory secrets are not supported for 64-bit memories")
+	}
+
+	layout := &memorySecrets{
+		base:    memoryType.Minimum() * wasmPageSize,
+		offsets: make(map[string]uint32),
+	}
+	for _, name := range names {
+		for len(layout.data)%secretAlignment != 0 {
+			layout.data = append(layout.data, 0)
+		}
+		layout.offsets[name] = uint32(len(layout.data))
+		layout.data = append(layout.data, secrets[name].Bytes()...)
+	}
+	layout.pages = (uint64(len(layout.data)) + wasmPageSize - 1) / wasmPageSize
+	if layout.pages == 0 {
+		layout.pages = 1
+	}
+
+	if layout.base+layout.pages*wasmPageSize > 1<<32 {
+		return nil, fmt.Errorf("memory secrets do not fit in 32-bit address space")
+	}
+	if hasMax, max := memoryType.Maximum(); hasMax && memoryType.Minimum()+layout.pages > max {
+		return nil, fmt.Errorf("module memory maximum (%d pages) leaves no room for %d pages of secrets", max, layout.pages)
+	}
+
+	logger.Info("Placing secrets in linear memory", "count", len(names), "bytes", len(layout.data), "offset", layout.base)
+	return layout, nil
+}
+
+// globalFor returns the value a secret_ptr/secret_len import resolves to
+func (m *memorySecrets) globalFor(store *wasmtime.Store, namespace, name string, secrets Secrets) (*wasmtime.Global, error) {
+	var value int32
+	if namespace == secretPtrNamespace {
+		value = int32(uint32(m.base) + m.offsets[name])
+	} else {
+		value = int32(secrets[name].Len())
+	}
+	return wasmtime.NewGlobal(store, wasmtime.NewGlobalType(wasmtime.NewValType(wasmtime.KindI32), false), wasmtime.ValI32(value))
+}
+
+// define registers a global on the linker for every secret_ptr/secret_len
+// import of the module
+func (m *memorySecrets) define(linker *wasmtime.Linker, store *wasmtime.Store, module *wasmtime.Module, secrets Secrets) error {
+	for _, imp := range module.Imports() {
+		if imp.Module() != secretPtrNamespace && imp.Module() != secretLenNamespace {
+			continue
+		}
+		name := *imp.Name()
+		global, err := m.globalFor(store, imp.Module(), name, secrets)
+		if err != nil {
+			return fmt.Errorf("failed to create global for %s.%s: %v", imp.Module(), name, err)
+		}
+		if err := linker.Define(imp.Module(), name, global); err != nil {
+			return fmt.Errorf("failed to define %s.%s: %v", imp.Module(), name, err)
+		}
+	}
+	return nil
+}
+
+// write grows the instance's memory and copies the secrets into place,
+// wiping the copy they were laid out in
+func (m *memorySecrets) write(store *wasmtime.Store, instance *wasmtime.Instance) error {
+	export := instance.GetExport(store, secretMemoryExport)
+	if export == nil || export.Memory() == nil {
+		return fmt.Errorf("module does not export %q memory", secretMemoryExport)
+	}
+	memory := export.Memory()
+
+	if memory.Size(store)*wasmPageSize != m.base {
+		return fmt.Errorf("memory grew during instantiation; cannot place secrets")
+	}
+	if _, err := memory.Grow(store, m.pages); err != nil {
+		return fmt.Errorf("failed to grow memory for secrets: %v", err)
+	}
+
+	copy(memory.UnsafeData(store)[m.base:], m.data)
+	clear(m.data)
+	return nil
+}
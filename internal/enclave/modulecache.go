@@ -0,0 +1,147 @@
+package enclave
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// moduleCache keeps the modules compiled for recent requests, so that a
+// binary sent again and again is compiled once rather than per request. Only
+// base64 binaries and precompiled modules are kept: WAT may have secrets
+// injected into it, which must not outlive the request. Each tenant has
+// modules of its own, so that how fast a request compiles tells nothing of
+// what other tenants ran.
+//
+// Stores cannot be reused the same way. Instances and their memories live
+// as long as the store they were made in, so a pooled store would hand one
+// request's state, secrets included, to the next. A module holds none.
+type moduleCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	// Most recently used first
+	order *list.List
+}
+
+type moduleCacheEntry struct {
+	key    [sha256.Size]byte
+	module *wasmtime.Module
+	// What operators see of the entry
+	moduleHash, tenant string
+	hits               uint64
+	lastUsed           time.Time
+}
+
+// newModuleCache returns a cache of size modules, or nil when size is zero,
+// which caches nothing
+func newModuleCache(size int) *moduleCache {
+	if size <= 0 {
+		return nil
+	}
+	return &moduleCache{
+		size:    size,
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// key returns the cache key of wasm_code compiled with limits, or false when
+// it may not be cached
+func (c *moduleCache) key(wasmCode string, meterFuel bool, limits ExecutionLimits) ([sha256.Size]byte, bool) {
+	if c == nil || isWATText(wasmCode) {
+		return [sha256.Size]byte{}, false
+	}
+	engine := engineHash(meterFuel, limits)
+	hash := sha256.New()
+	hash.Write(engine[:])
+	// Tenant names are length-prefixed so that none runs into the code
+	hash.Write([]byte(fmt.Sprintf("%d:%s", len(limits.Tenant), limits.Tenant)))
+	hash.Write([]byte(wasmCode))
+	var key [sha256.Size]byte
+	hash.Sum(key[:0])
+	return key, true
+}
+
+// lookup returns the module cached under key, if it is cacheable
+func (c *moduleCache) lookup(key [sha256.Size]byte, cacheable bool) *wasmtime.Module {
+	if !cacheable {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(element)
+	entry := element.Value.(*moduleCacheEntry)
+	entry.hits++
+	entry.lastUsed = time.Now()
+	return entry.module
+}
+
+// put caches a module of tenant under key, evicting the least recently used
+// beyond size
+func (c *moduleCache) put(key [sha256.Size]byte, module *wasmtime.Module, moduleHash, tenant string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&moduleCacheEntry{key: key, module: module, moduleHash: moduleHash, tenant: tenant, lastUsed: time.Now()})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*moduleCacheEntry).key)
+	}
+}
+
+// list describes the cached modules, most recently used first
+func (c *moduleCache) list() []protocol.CachedModule {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	modules := make([]protocol.CachedModule, 0, c.order.Len())
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*moduleCacheEntry)
+		modules = append(modules, protocol.CachedModule{
+			ModuleHash: entry.moduleHash,
+			Tenant:     entry.tenant,
+			Hits:       entry.hits,
+			LastUsed:   entry.lastUsed.UnixMilli(),
+		})
+	}
+	return modules
+}
+
+// evict drops the compiled copies of the module of moduleHash, or every
+// module when it is empty, and returns how many it dropped
+func (c *moduleCache) evict(moduleHash string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	evicted := 0
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		if entry := element.Value.(*moduleCacheEntry); moduleHash == "" || entry.moduleHash == moduleHash {
+			c.order.Remove(element)
+			delete(c.entries, entry.key)
+			evicted++
+		}
+		element = next
+	}
+	return evicted
+}
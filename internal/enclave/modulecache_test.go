@@ -0,0 +1,139 @@
+package enclave
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/wire"
+)
+
+// addModule is the smallest useful module, sent as base64 like a client
+// would:
+//
+//	(module (func (export "add") (param i32 i32) (result i32)
+//	  local.get 0 local.get 1 i32.add))
+var addModule = base64.StdEncoding.EncodeToString([]byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	0x01, 0x07, 0x01, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f,
+	0x03, 0x02, 0x01, 0x00,
+	0x07, 0x07, 0x01, 0x03, 'a', 'd', 'd', 0x00, 0x00,
+	0x0a, 0x09, 0x01, 0x07, 0x00, 0x20, 0x00, 0x20, 0x01, 0x6a, 0x0b,
+})
+
+var addCalls = []protocol.Call{{FunctionName: "add", Args: []int32{2, 3}}}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// newTestExecutor returns an executor as Run configures it by default,
+// caching up to cacheSize compiled modules, and the limits of a request
+// that sets none
+func newTestExecutor(tb testing.TB, cacheSize int) (*WASMExecutor, ExecutionLimits) {
+	tb.Helper()
+	executor := NewWASMExecutor(false, modulePolicy{}, newWorkerPool(runtime.NumCPU(), defaultQueueLength), nil)
+	executor.modules = newModuleCache(cacheSize)
+	features, err := parseFeatures(strings.Split(defaultWasmFeatures, ","))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	limits, err := requestLimits(protocol.WASMRequest{}, ResourceCaps{
+		DefaultTimeout:   defaultExecutionTimeout,
+		MaxTimeout:       maxExecutionTimeout,
+		MaxMemoryPages:   1024,
+		MaxTableElements: 10000,
+		MaxTables:        1,
+		MaxOutputBytes:   defaultMaxOutputBytes,
+		DefaultFeatures:  features,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return executor, limits
+}
+
+func executeAdd(tb testing.TB, executor *WASMExecutor, limits ExecutionLimits) ExecutionStats {
+	results, stats, err := executor.ExecuteWASM(context.Background(), discardLogger, addModule, "", addCalls, nil, limits)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err != nil || results[0].I32 != 5 {
+		tb.Fatalf("add(2, 3) = %+v, want 5", results)
+	}
+	return stats
+}
+
+func TestModuleCacheReusesCompiledModules(t *testing.T) {
+	executor, limits := newTestExecutor(t, 1)
+	if source := executeAdd(t, executor, limits).ModuleSource; source != protocol.ModuleSourceCompiled {
+		t.Fatalf("first execution module source = %q, want %q", source, protocol.ModuleSourceCompiled)
+	}
+	if source := executeAdd(t, executor, limits).ModuleSource; source != protocol.ModuleSourceCached {
+		t.Fatalf("second execution module source = %q, want %q", source, protocol.ModuleSourceCached)
+	}
+
+	other := limits
+	other.Tenant = "other"
+	if source := executeAdd(t, executor, other).ModuleSource; source != protocol.ModuleSourceCompiled {
+		t.Fatalf("another tenant's module source = %q, want %q", source, protocol.ModuleSourceCompiled)
+	}
+	if cached := executor.modules.list(); len(cached) != 1 || cached[0].Tenant != "other" {
+		t.Fatalf("cached modules = %+v, want only the other tenant's", cached)
+	}
+}
+
+// BenchmarkExecute runs small modules from every CPU at once, as the enclave
+// does under load, and reports requests per second. Uncached executions
+// compile the module each time; cached ones reuse the first compile.
+func BenchmarkExecute(b *testing.B) {
+	for _, bench := range []struct {
+		name      string
+		cacheSize int
+	}{
+		{"uncached", 0},
+		{"cached", 16},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			executor, limits := newTestExecutor(b, bench.cacheSize)
+			executeAdd(b, executor, limits)
+			b.ReportAllocs()
+			b.ResetTimer()
+			start := time.Now()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					executeAdd(b, executor, limits)
+				}
+			})
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "rps")
+		})
+	}
+}
+
+// BenchmarkFrameRoundTrip encodes and decodes an execute request the size of
+// a small module's, whose frames are read into pooled buffers
+func BenchmarkFrameRoundTrip(b *testing.B) {
+	request := protocol.WASMRequest{
+		Type:     protocol.RequestTypeExecute,
+		WASMCode: addModule,
+		Calls:    addCalls,
+	}
+	var conn bytes.Buffer
+	writer, reader := wire.NewFrameWriter(&conn), wire.NewFrameReader(&conn)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := writer.Encode(request); err != nil {
+			b.Fatal(err)
+		}
+		var decoded protocol.WASMRequest
+		if err := reader.Decode(&decoded); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package enclave
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Bound on the goroutine dump logged when the workers are restarted
+const maxGoroutineDump = 64 << 10
+
+// PanicError is a panic recovered while handling a request, with the stack
+// it was raised on. Clients are told only that the enclave failed; the panic
+// and its stack go to the log.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("internal error: %v", e.Value)
+}
+
+// newPanicError captures the stack of a panic; it must be called from the
+// deferred function that recovered value
+func newPanicError(value interface{}) *PanicError {
+	return &PanicError{Value: value, Stack: debug.Stack()}
+}
+
+// recoverResponse returns what handle answers, or an internal error when
+// handle panics, so one request cannot bring down the enclave for every
+// tenant
+func (s *EnclaveServer) recoverResponse(logger *slog.Logger, wasmReq protocol.WASMRequest, handle func() protocol.WASMResponse) (response protocol.WASMResponse) {
+	defer func() {
+		if value := recover(); value != nil {
+			err := newPanicError(value)
+			s.health.panics.Add(1)
+			logger.Error("Recovered from a panic handling the request", "type", wasmReq.Type, "error", err, "stack", string(err.Stack))
+			response = protocol.WASMResponse{
+				RequestID: wasmReq.RequestID,
+				Error:     "internal error: the enclave failed while handling the request",
+				ErrorCode: protocol.ErrorCodeInternal,
+			}
+		}
+	}()
+	return handle()
+}
+
+// goroutineDump returns the stacks of every goroutine, cut short at
+// maxGoroutineDump bytes
+func goroutineDump() string {
+	buf := make([]byte, maxGoroutineDump)
+	return string(buf[:runtime.Stack(buf, true)])
+}
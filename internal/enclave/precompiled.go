@@ -0,0 +1,121 @@
+package enclave
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// A precompiled module is wasmtime's serialization of a compiled module
+// behind a header of its own:
+//
+//	magic        4 bytes   "\x00wpc"
+//	engine hash  32 bytes  engineHash of the enclave that compiled it
+//	module       the rest  wasmtime serialization
+//
+// The header cannot be confused with a module or component, which start
+// with "\x00asm".
+var precompiledMagic = []byte{0x00, 'w', 'p', 'c'}
+
+const precompiledHeaderSize = 4 + sha256.Size
+
+// isPrecompiled reports whether a binary is a precompiled module
+func isPrecompiled(wasmBytes []byte) bool {
+	return len(wasmBytes) >= precompiledHeaderSize && bytes.Equal(wasmBytes[:4], precompiledMagic)
+}
+
+// engineHash identifies what a precompiled module depends on: the wasmtime
+// build and engine configuration it runs on, and the memory and table limits
+// compiled into it. Modules only run where the hash matches.
+func engineHash(meterFuel bool, limits ExecutionLimits) [sha256.Size]byte {
+	return sha256.Sum256([]byte(fmt.Sprintf("wasmtime=%s features=%s fuel=%v max_memory_pages=%d max_table_elements=%d max_tables=%d",
+		wasmtimeVersion(), limits.Features, meterFuel, limits.MaxMemoryPages, limits.MaxTableElements, limits.MaxTables)))
+}
+
+// Precompile compiles a module with the request's features and limits and
+// serializes it, so that later requests skip compilation
+func (w *WASMExecutor) Precompile(ctx context.Context, logger *slog.Logger, wasmCode string, limits ExecutionLimits) ([]byte, error) {
+	release, err := w.workers.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	wasmBytes, err := decodeModule(logger, wasmCode, nil, limits, nil)
+	if err != nil {
+		return nil, err
+	}
+	if isPrecompiled(wasmBytes) {
+		return nil, fmt.Errorf("module is already precompiled")
+	}
+	module, err := compileModule(w.engines.get(limits.Features), wasmBytes, limits)
+	if err != nil {
+		return nil, err
+	}
+	serialized, err := module.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize module: %v", err)
+	}
+
+	hash := engineHash(w.meterFuel, limits)
+	precompiled := make([]byte, 0, precompiledHeaderSize+len(serialized))
+	precompiled = append(precompiled, precompiledMagic...)
+	precompiled = append(precompiled, hash[:]...)
+	precompiled = append(precompiled, serialized...)
+	logger.Info("Precompiled module", "wasm_bytes", len(wasmBytes), "precompiled_bytes", len(precompiled), "engine_hash", hex.EncodeToString(hash[:]))
+	return precompiled, nil
+}
+
+// deserialize loads a precompiled module. wasmtime runs the machine code in
+// it without verifying it, so it must have passed a module policy, and the
+// limits compiled into it cannot be changed, so the request's must be those
+// it was compiled with.
+func (w *WASMExecutor) deserialize(logger *slog.Logger, engine *wasmtime.Engine, precompiled []byte, limits ExecutionLimits) (*wasmtime.Module, error) {
+	if !w.policy.enforced() {
+		return nil, policyError("precompiled modules require -module-allowlist or -trusted-signers")
+	}
+	want := engineHash(w.meterFuel, limits)
+	if got := precompiled[4:precompiledHeaderSize]; !bytes.Equal(got, want[:]) {
+		return nil, fmt.Errorf("module was precompiled for engine %x, this request runs on %x; precompile it again with the same features and limits", got, want)
+	}
+
+	module, err := wasmtime.NewModuleDeserialize(engine, precompiled[precompiledHeaderSize:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize precompiled module: %v", err)
+	}
+	logger.Info("Loaded precompiled module", "bytes", len(precompiled))
+	return module, nil
+}
+
+// precompileResponse answers a precompile request with the module for
+// requests with the same features and limits to run
+func (s *EnclaveServer) precompileResponse(ctx context.Context, logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	limits, err := requestLimits(wasmReq, s.caps)
+	if err != nil {
+		logger.Warn("Rejecting request", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error()}
+	}
+
+	start := time.Now()
+	precompiled, err := s.executor.Precompile(ctx, logger, wasmReq.WASMCode, limits)
+	if err != nil {
+		logger.Warn("Precompilation failed", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: errorCode(err)}
+	}
+	hash := engineHash(s.executor.meterFuel, limits)
+	return protocol.WASMResponse{
+		RequestID:   wasmReq.RequestID,
+		Precompiled: base64.StdEncoding.EncodeToString(precompiled),
+		EngineHash:  hex.EncodeToString(hash[:]),
+		CompileUS:   time.Since(start).Microseconds(),
+	}
+}
@@ -0,0 +1,155 @@
+package enclave
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// preloadedModule is a module baked into the enclave image, compiled at
+// startup for requests with the default features and limits
+type preloadedModule struct {
+	name string
+	// wasm_code the module runs as: the WAT text, or the base64 binary
+	code   string
+	module *wasmtime.Module
+	// engineHash of the limits module was compiled with
+	engine [sha256.Size]byte
+}
+
+// preloadedModules are the modules of -modules-dir, by name and by the
+// moduleHash of their code. Being part of the image they are covered by its
+// measurement, so the module policy does not apply to them. A nil
+// *preloadedModules holds none.
+type preloadedModules struct {
+	byName map[string]*preloadedModule
+	byHash map[string]*preloadedModule
+}
+
+// loadPreloadedModules compiles every .wasm and .wat file in dir, naming
+// each module after its file without the extension
+func loadPreloadedModules(dir string, engine *wasmtime.Engine, meterFuel bool, limits ExecutionLimits) (*preloadedModules, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read modules directory: %v", err)
+	}
+
+	logger := slog.Default()
+	modules := &preloadedModules{byName: map[string]*preloadedModule{}, byHash: map[string]*preloadedModule{}}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".wasm" && ext != ".wat") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ext)
+		if _, exists := modules.byName[name]; exists {
+			return nil, fmt.Errorf("module %s is in %s as both .wasm and .wat", name, dir)
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read module %s: %v", name, err)
+		}
+
+		code := string(content)
+		if ext == ".wasm" {
+			code = base64.StdEncoding.EncodeToString(content)
+		}
+		wasmBytes, err := decodeModule(logger, code, nil, limits, nil)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %v", name, err)
+		}
+		module, err := compileModule(engine, wasmBytes, limits)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %v", name, err)
+		}
+
+		preloaded := &preloadedModule{name: name, code: code, module: module, engine: engineHash(meterFuel, limits)}
+		modules.byName[name] = preloaded
+		modules.byHash[moduleHash(code)] = preloaded
+	}
+	if len(modules.byName) == 0 {
+		return nil, fmt.Errorf("modules directory %s holds no .wasm or .wat files", dir)
+	}
+	return modules, nil
+}
+
+// code returns the wasm_code of the module called name
+func (m *preloadedModules) code(name string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	preloaded, ok := m.byName[name]
+	if !ok {
+		return "", false
+	}
+	return preloaded.code, true
+}
+
+// lookup returns the preloaded module whose code is wasmCode, or nil
+func (m *preloadedModules) lookup(wasmCode string) *preloadedModule {
+	if m == nil {
+		return nil
+	}
+	return m.byHash[moduleHash(wasmCode)]
+}
+
+// names returns the names of the preloaded modules in order
+func (m *preloadedModules) names() []string {
+	if m == nil {
+		return nil
+	}
+	names := make([]string, 0, len(m.byName))
+	for name := range m.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compiled returns the module compiled at startup when it suits a request:
+// the request runs with the limits it was compiled with, and no secrets
+// need injecting into its WAT text
+func (p *preloadedModule) compiled(meterFuel bool, limits ExecutionLimits, secrets Secrets) *wasmtime.Module {
+	if p == nil || (len(secrets) > 0 && isWATText(p.code)) || engineHash(meterFuel, limits) != p.engine {
+		return nil
+	}
+	return p.module
+}
+
+// resolveModule replaces the module_name of a request with the code of that
+// preloaded module, and its upload_id with the code uploaded, and refuses
+// code sent in wasm_code when uploads are disabled
+func (s *EnclaveServer) resolveModule(wasmReq *protocol.WASMRequest) error {
+	if wasmReq.ModuleRef != "" {
+		return fmt.Errorf("module_ref is pulled by the host; send the module in wasm_code")
+	}
+	if wasmReq.UploadID != "" {
+		code, err := s.uploads.code(wasmReq.Tenant, wasmReq.UploadID)
+		if err != nil {
+			return err
+		}
+		wasmReq.WASMCode, wasmReq.UploadID = code, ""
+		return nil
+	}
+	if wasmReq.ModuleName != "" {
+		code, ok := s.executor.preloaded.code(wasmReq.ModuleName)
+		if !ok {
+			return fmt.Errorf("module %s is not preloaded", wasmReq.ModuleName)
+		}
+		wasmReq.WASMCode = code
+		return nil
+	}
+	if wasmReq.WASMCode != "" && !s.moduleUpload {
+		return policyError("module upload is disabled; run a preloaded module by module_name")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package enclave
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Most funcref handles one instance hands out
+const maxFuncHandles = 1024
+
+// externHandle is what an externref made from a client's handle holds
+type externHandle string
+
+// refHandles names the funcrefs an instance's functions returned, so that
+// later calls on the same instance can pass them back. Externrefs need no
+// such table: the client's handle travels inside the reference.
+type refHandles struct {
+	funcs []*wasmtime.Func
+}
+
+// funcHandle returns the handle of a funcref result
+func (h *refHandles) funcHandle(f *wasmtime.Func) (string, error) {
+	if len(h.funcs) >= maxFuncHandles {
+		return "", resourceLimitError("the instance returned more than %d funcrefs", maxFuncHandles)
+	}
+	h.funcs = append(h.funcs, f)
+	return "#" + strconv.Itoa(len(h.funcs)-1), nil
+}
+
+// reference turns a ref argument into the value passed to the module. A
+// funcref argument is "#N" for a handle, or else an exported function name.
+func (h *refHandles) reference(store *wasmtime.Store, instance *wasmtime.Instance, ref protocol.Ref) (wasmtime.Val, error) {
+	if ref.Type == protocol.ValueExternref {
+		if ref.Handle == "" {
+			return wasmtime.ValExternref(nil), nil
+		}
+		return wasmtime.ValExternref(externHandle(ref.Handle)), nil
+	}
+
+	if ref.Handle == "" {
+		return wasmtime.ValFuncref(nil), nil
+	}
+	if strings.HasPrefix(ref.Handle, "#") {
+		n, err := strconv.Atoi(ref.Handle[1:])
+		if err != nil || n < 0 || n >= len(h.funcs) {
+			return wasmtime.Val{}, fmt.Errorf("unknown funcref handle %s", ref.Handle)
+		}
+		return wasmtime.ValFuncref(h.funcs[n]), nil
+	}
+	export := instance.GetExport(store, ref.Handle)
+	if export == nil || export.Func() == nil {
+		return wasmtime.Val{}, fmt.Errorf("funcref %s is neither a handle nor an exported function", ref.Handle)
+	}
+	return wasmtime.ValFuncref(export.Func()), nil
+}
+
+// value returns a ref result as a Value, or false when result is no ref.
+// Null refs come back as nil, so they are only recognized when the call
+// asked for a ref type.
+func (h *refHandles) value(result interface{}, resultType string) (protocol.Value, bool, error) {
+	switch x := result.(type) {
+	case *wasmtime.Func:
+		if x == nil {
+			return protocol.Value{Type: protocol.ValueFuncref}, true, nil
+		}
+		handle, err := h.funcHandle(x)
+		return protocol.Value{Type: protocol.ValueFuncref, Value: handle}, true, err
+	case externHandle:
+		return protocol.Value{Type: protocol.ValueExternref, Value: string(x)}, true, nil
+	case nil:
+		if resultType == protocol.ValueExternref || resultType == protocol.ValueFuncref {
+			return protocol.Value{Type: resultType}, true, nil
+		}
+	}
+	return protocol.Value{}, false, nil
+}
+
+// lookupFunction returns the function a call calls: an export, or an element
+// of an exported table
+func lookupFunction(store *wasmtime.Store, instance *wasmtime.Instance, call protocol.Call) (*wasmtime.Func, error) {
+	if call.Table == "" {
+		export := instance.GetExport(store, call.FunctionName)
+		if export == nil {
+			return nil, fmt.Errorf("function '%s' not found in WASM module", call.FunctionName)
+		}
+		if export.Func() == nil {
+			return nil, fmt.Errorf("'%s' is not a function", call.FunctionName)
+		}
+		return export.Func(), nil
+	}
+
+	export := instance.GetExport(store, call.Table)
+	if export == nil || export.Table() == nil {
+		return nil, fmt.Errorf("table '%s' not found in WASM module", call.Table)
+	}
+	table := export.Table()
+	if kind := table.Type(store).Element().Kind(); kind != wasmtime.KindFuncref {
+		return nil, fmt.Errorf("table '%s' does not hold functions", call.Table)
+	}
+	if size := table.Size(store); call.TableIndex >= size {
+		return nil, fmt.Errorf("index %d is outside table '%s' of %d elements", call.TableIndex, call.Table, size)
+	}
+	element, err := table.Get(store, call.TableIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", call.Target(), err)
+	}
+	f := element.Funcref()
+	if f == nil {
+		return nil, fmt.Errorf("%s is null", call.Target())
+	}
+	return f, nil
+}
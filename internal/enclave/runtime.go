@@ -0,0 +1,38 @@
+package enclave
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Runtimes -runtime selects between
+const (
+	runtimeWasmtime = "wasmtime"
+	runtimeWazero   = "wazero"
+)
+
+// Runtime runs executions: a module instantiated once and called in order,
+// as WASMExecutor.ExecuteWASM describes. Executions and jobs go through the
+// runtime -runtime selects; sessions, and describing and precompiling
+// modules, always use wasmtime.
+type Runtime interface {
+	ExecuteWASM(ctx context.Context, logger *slog.Logger, wasmCode, signature string, calls []protocol.Call, secrets Secrets, limits ExecutionLimits) ([]CallResult, ExecutionStats, error)
+}
+
+// newRuntime returns the runtime called name. Other runtimes share the
+// module policy, workers and preloaded modules of executor.
+func newRuntime(name string, executor *WASMExecutor) (Runtime, error) {
+	switch name {
+	case runtimeWasmtime:
+		return executor, nil
+	case runtimeWazero:
+		if executor.meterFuel {
+			return nil, fmt.Errorf("the wazero runtime does not meter fuel; leave out -fuel-metering")
+		}
+		return &wazeroRuntime{executor: executor}, nil
+	}
+	return nil, fmt.Errorf("unknown runtime %q; use %s or %s", name, runtimeWasmtime, runtimeWazero)
+}
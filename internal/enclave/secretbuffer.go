@@ -0,0 +1,77 @@
+package enclave
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// SecretBuffer holds the bytes of one secret so that they can be wiped once
+// the execution they were sent for is over. Go strings cannot be wiped, so
+// secrets stay bytes from where they are decrypted to where they are
+// injected, and are never written to disk: WAT templates they are injected
+// into go to wat2wasm through pipes, not temporary files.
+//
+// Wiping is best effort. Secrets the host sent in the clear were in the
+// request it read, and a compiled module keeps the constants and data
+// segments made of them until it is freed.
+type SecretBuffer struct {
+	b []byte
+}
+
+// NewSecretBuffer takes ownership of b, which Zero wipes
+func NewSecretBuffer(b []byte) *SecretBuffer {
+	return &SecretBuffer{b: b}
+}
+
+// Bytes returns the secret, valid until Zero
+func (s *SecretBuffer) Bytes() []byte {
+	return s.b
+}
+
+func (s *SecretBuffer) Len() int {
+	return len(s.b)
+}
+
+// Zero overwrites the secret and forgets it
+func (s *SecretBuffer) Zero() {
+	clear(s.b)
+	s.b = nil
+}
+
+// String keeps secrets out of anything formatted with %s or %v
+func (s *SecretBuffer) String() string {
+	return "[REDACTED]"
+}
+
+// UnmarshalJSON reads a JSON string straight into bytes, without making a
+// string of it unless it has escapes to decode
+func (s *SecretBuffer) UnmarshalJSON(data []byte) error {
+	if len(data) >= 2 && data[0] == '"' && bytes.IndexByte(data, '\\') < 0 {
+		s.b = append([]byte(nil), data[1:len(data)-1]...)
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	s.b = []byte(value)
+	return nil
+}
+
+// Secrets are the secrets of one request, by name
+type Secrets map[string]*SecretBuffer
+
+// set stores secret under name, wiping any secret it replaces
+func (s Secrets) set(name string, secret *SecretBuffer) {
+	if old, ok := s[name]; ok {
+		old.Zero()
+	}
+	s[name] = secret
+}
+
+// Zero wipes every secret
+func (s Secrets) Zero() {
+	for _, secret := range s {
+		secret.Zero()
+	}
+}
@@ -0,0 +1,99 @@
+package enclave
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretBufferZeroWipesBytes(t *testing.T) {
+	secret := []byte("hunter2")
+	buffer := NewSecretBuffer(secret)
+	buffer.Zero()
+	if !bytes.Equal(secret, make([]byte, len(secret))) {
+		t.Fatalf("secret after Zero = %q, want all zeros", secret)
+	}
+}
+
+func TestSecretBufferUseAfterZero(t *testing.T) {
+	buffer := NewSecretBuffer([]byte("hunter2"))
+	buffer.Zero()
+	if buffer.Bytes() != nil || buffer.Len() != 0 {
+		t.Fatalf("buffer after Zero = %q (%d bytes), want nothing", buffer.Bytes(), buffer.Len())
+	}
+	// A second wipe, as when a request's secrets are zeroed after one was
+	// replaced, finds nothing to wipe
+	buffer.Zero()
+	if got := fmt.Sprintf("%s %v", buffer, buffer); got != "[REDACTED] [REDACTED]" {
+		t.Fatalf("formatted buffer = %q, want it redacted", got)
+	}
+}
+
+func TestSecretsSetWipesReplacedSecret(t *testing.T) {
+	old := []byte("old-key")
+	secrets := Secrets{}
+	secrets.set("API_KEY", NewSecretBuffer(old))
+	secrets.set("API_KEY", NewSecretBuffer([]byte("new-key")))
+	if !bytes.Equal(old, make([]byte, len(old))) {
+		t.Fatalf("replaced secret = %q, want all zeros", old)
+	}
+
+	current := secrets["API_KEY"].Bytes()
+	secrets.Zero()
+	if !bytes.Equal(current, make([]byte, len(current))) {
+		t.Fatalf("secret after Secrets.Zero = %q, want all zeros", current)
+	}
+}
+
+func TestSecretBufferUnmarshalJSON(t *testing.T) {
+	for _, test := range []struct{ json, want string }{
+		{`"plain"`, "plain"},
+		{`"with \"escapes\"\n"`, "with \"escapes\"\n"},
+	} {
+		var buffer SecretBuffer
+		if err := buffer.UnmarshalJSON([]byte(test.json)); err != nil {
+			t.Fatalf("UnmarshalJSON(%s): %v", test.json, err)
+		}
+		if string(buffer.Bytes()) != test.want {
+			t.Fatalf("UnmarshalJSON(%s) = %q, want %q", test.json, buffer.Bytes(), test.want)
+		}
+	}
+}
+
+// TestCompileWATWithSecretsWritesNoFiles runs a template with a secret
+// through a stand-in wat2wasm that echoes its input, and checks that nothing
+// reached the temporary directory on the way
+func TestCompileWATWithSecretsWritesNoFiles(t *testing.T) {
+	bin := t.TempDir()
+	stub := "#!/bin/sh\nexec /bin/cat\n"
+	if err := os.WriteFile(filepath.Join(bin, "wat2wasm"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	const template = `(module (memory 1) (data (i32.const 0) "{{API_KEY}}\00"))`
+	secrets := Secrets{"API_KEY": NewSecretBuffer([]byte("s3cr3t"))}
+	wat, err := injectSecretsIntoWAT(discardLogger, template, secrets, newSecretUse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wasm, err := compileWATToWASM(wat, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(wasm, []byte("s3cr3t")) {
+		t.Fatalf("compiled output %q does not carry the injected secret", wasm)
+	}
+
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("compiling left %d files in the temporary directory, want none", len(entries))
+	}
+}
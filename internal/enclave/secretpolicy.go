@@ -0,0 +1,164 @@
+package enclave
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// anySecret stands for the secrets a policy does not name
+const anySecret = "*"
+
+// secretRule restricts where one secret is released. A nil set does not
+// restrict.
+type secretRule struct {
+	// Hashes of the modules the secret may be injected into
+	modules map[string]bool
+	// Functions that may be called while the secret is in the module
+	functions map[string]bool
+	// Tenants whose requests may carry the secret
+	tenants map[string]bool
+}
+
+// secretPolicy holds the release rules of secrets by name; the rule under
+// anySecret covers secrets without one of their own. A nil policy releases
+// every secret to every module.
+type secretPolicy map[string]*secretRule
+
+// loadSecretPolicy reads one rule per line: a secret name, or *, and its
+// conditions, e.g.
+//
+//	API_KEY module=c1b5... module=scorer function=secure_compute tenant=payments
+//
+// module= names a module by the SHA-256 of its wasm_code, or a preloaded
+// module by name; function= names an exported function; tenant= names a
+// tenant the host puts clients in. The secret is only injected into one of
+// its modules, only for calls to its functions, and only for requests of
+// its tenants.
+// Lines for the same secret add to its rule. Blank lines and lines starting
+// with # are ignored.
+func loadSecretPolicy(path string, preloaded *preloadedModules) (secretPolicy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open secret policy: %v", err)
+	}
+	defer f.Close()
+
+	policy := secretPolicy{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		rule := policy[fields[0]]
+		if rule == nil {
+			rule = &secretRule{}
+			policy[fields[0]] = rule
+		}
+		for _, condition := range fields[1:] {
+			kind, value, ok := strings.Cut(condition, "=")
+			switch {
+			case !ok || value == "":
+				return nil, fmt.Errorf("secret policy line %d: %q is not module=..., function=... or tenant=...", line, condition)
+			case kind == "module":
+				hash, err := policyModuleHash(value, preloaded)
+				if err != nil {
+					return nil, fmt.Errorf("secret policy line %d: %v", line, err)
+				}
+				if rule.modules == nil {
+					rule.modules = map[string]bool{}
+				}
+				rule.modules[hash] = true
+			case kind == "function":
+				if rule.functions == nil {
+					rule.functions = map[string]bool{}
+				}
+				rule.functions[value] = true
+			case kind == "tenant":
+				if rule.tenants == nil {
+					rule.tenants = map[string]bool{}
+				}
+				rule.tenants[value] = true
+			default:
+				return nil, fmt.Errorf("secret policy line %d: unknown condition %q", line, kind)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read secret policy: %v", err)
+	}
+	if len(policy) == 0 {
+		return nil, fmt.Errorf("secret policy %s is empty", path)
+	}
+	return policy, nil
+}
+
+// policyModuleHash turns the module of a condition into its hash
+func policyModuleHash(module string, preloaded *preloadedModules) (string, error) {
+	if code, ok := preloaded.code(module); ok {
+		return moduleHash(code), nil
+	}
+	digest := strings.ToLower(module)
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != 32 {
+		return "", fmt.Errorf("%q is neither a SHA-256 digest nor a preloaded module", module)
+	}
+	return digest, nil
+}
+
+// check refuses to release the named secrets of a tenant's request to a
+// module, identified by its hash, unless their rules allow it and every
+// call. Calls may be left out to check only the module, as when a session is
+// created.
+func (p secretPolicy) check(tenant, module string, calls []protocol.Call, names []string) error {
+	if p == nil {
+		return nil
+	}
+	for _, name := range names {
+		rule := p[name]
+		if rule == nil {
+			rule = p[anySecret]
+		}
+		if rule == nil {
+			continue
+		}
+		if rule.tenants != nil && !rule.tenants[tenant] {
+			return policyError("secret %s is not released to tenant %q", name, tenant)
+		}
+		if rule.modules != nil && !rule.modules[module] {
+			return policyError("secret %s is not released to module %s", name, module)
+		}
+		for _, call := range calls {
+			if rule.functions != nil && !rule.functions[call.Target()] {
+				return policyError("secret %s is not released to calls of %s", name, call.Target())
+			}
+		}
+	}
+	return nil
+}
+
+// checkBindings refuses to inject the secrets of a request's secret list
+// bound to a module other than module
+func checkBindings(list []protocol.Secret, module string) error {
+	for _, secret := range list {
+		if secret.ModuleSHA256 != "" && !strings.EqualFold(secret.ModuleSHA256, module) {
+			return policyError("secret %s is bound to module %s, not %s", secret.Name, secret.ModuleSHA256, module)
+		}
+	}
+	return nil
+}
+
+// secretNames returns the names of secrets in order
+func secretNames(secrets Secrets) []string {
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,110 @@
+package enclave
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/logging"
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// secretUse records which secrets a module refers to, through global or
+// secret_ptr/secret_len imports and data placeholders, and whether the
+// request provided them. Only secrets define globals, so every global
+// import a compiled module is left with names one.
+type secretUse struct {
+	used map[string]bool
+	// Secrets missing for an import cannot be linked; a placeholder left
+	// without its secret only stays in the data as written
+	missingImports      map[string]bool
+	missingPlaceholders map[string]bool
+	// The module looks secrets up by name at run time through get_secret,
+	// so which of them it uses cannot be told in advance
+	dynamic bool
+}
+
+func newSecretUse() *secretUse {
+	return &secretUse{
+		used:                make(map[string]bool),
+		missingImports:      make(map[string]bool),
+		missingPlaceholders: make(map[string]bool),
+	}
+}
+
+// importSecret records an import of secret name
+func (u *secretUse) importSecret(name string, provided bool) {
+	u.record(u.missingImports, name, provided)
+}
+
+// placeSecret records a {{name}} placeholder
+func (u *secretUse) placeSecret(name string, provided bool) {
+	u.record(u.missingPlaceholders, name, provided)
+}
+
+func (u *secretUse) record(missing map[string]bool, name string, provided bool) {
+	if u == nil {
+		return
+	}
+	if provided {
+		u.used[name] = true
+	} else {
+		missing[name] = true
+	}
+}
+
+// scanModule records the secrets a compiled module imports
+func (u *secretUse) scanModule(module *wasmtime.Module, secrets Secrets) {
+	for _, imp := range module.Imports() {
+		if imp.Name() == nil {
+			continue
+		}
+		name := *imp.Name()
+		switch {
+		case imp.Module() == hostFunctionNamespace && name == "get_secret" && imp.Type().FuncType() != nil:
+			u.dynamic = true
+		case imp.Type().GlobalType() != nil:
+			_, provided := secrets[name]
+			u.importSecret(name, provided)
+		}
+	}
+}
+
+// check refuses a module importing secrets the request did not provide,
+// naming them, rather than letting it fail to link. When strict, secrets
+// missing for placeholders are refused too. Secrets provided for nothing are
+// only warned about.
+func (u *secretUse) check(logger *slog.Logger, secrets Secrets, strict bool) error {
+	if !u.dynamic {
+		for name := range secrets {
+			if !u.used[name] {
+				logger.Warn("Secret provided but not referenced by the module", "name", logging.SecretName(name))
+			}
+		}
+	}
+
+	missing := make(map[string]bool)
+	for name := range u.missingImports {
+		missing[name] = true
+	}
+	if strict {
+		for name := range u.missingPlaceholders {
+			missing[name] = true
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &LimitError{
+		Code:    protocol.ErrorCodeSecretMissing,
+		Message: fmt.Sprintf("module references secrets that were not provided: %s", strings.Join(names, ", ")),
+	}
+}
@@ -0,0 +1,171 @@
+package enclave
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+
+	"hello-wasm-enclave/internal/nsm"
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// selfTestWAT is the module the self-test compiles and runs. It hashes
+// "self-test" with env.sha256 and returns the first word of the digest, so
+// its result shows that the engine, linear memory and the host library work.
+const selfTestWAT = `(module
+  (import "env" "sha256" (func $sha256 (param i32 i32 i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 0) "self-test")
+  (func (export "self_test") (result i32)
+    (call $sha256 (i32.const 0) (i32.const 9) (i32.const 32))
+    (i32.load (i32.const 32))))`
+
+// selfTestModule is selfTestWAT compiled, so that running it does not
+// depend on wat2wasm
+const selfTestModule = "AGFzbQEAAAABCwJgA39/fwBgAAF/Ag4BA2VudgZzaGEyNTYAAAMCAQEFAwEAAQcWAgZtZW1vcnkCAAlzZWxmX3Rlc3QAAQoRAQ8AQQBBCUEgEABBICgCAAsLDwEAQQALCXNlbGYtdGVzdAAQBG5hbWUBCQEABnNoYTI1Ng=="
+
+// skippedCheck is returned by a check that does not apply
+type skippedCheck struct {
+	reason string
+}
+
+func (s *skippedCheck) Error() string {
+	return s.reason
+}
+
+// runSelfTest checks, before the enclave takes requests, that modules compile
+// and run, that the NSM answers and that key generation has entropy. A
+// failure does not stop the enclave: it is logged, and reported in hello and
+// health responses, so the host tells a misbuilt image from the first
+// connection. nsmErr is why the NSM did not open; inEnclave says whether it
+// should have.
+func (s *EnclaveServer) runSelfTest(session *nsm.Session, nsmErr error, inEnclave bool) *protocol.SelfTest {
+	// Only fails on requested features, which this has none of
+	limits, _ := requestLimits(protocol.WASMRequest{}, s.caps)
+	checks := []struct {
+		name string
+		run  func() error
+	}{
+		{protocol.SelfTestWAT, func() error { return checkWAT(limits) }},
+		{protocol.SelfTestExecute, func() error { return s.checkExecute(limits) }},
+		{protocol.SelfTestNSM, func() error { return checkNSM(session, nsmErr, inEnclave) }},
+		{protocol.SelfTestEntropy, s.checkEntropy},
+	}
+
+	result := &protocol.SelfTest{Passed: true}
+	for _, c := range checks {
+		started := time.Now()
+		err := c.run()
+		check := protocol.SelfTestCheck{Name: c.name, Status: protocol.CheckPassed, DurationUS: time.Since(started).Microseconds()}
+		var skipped *skippedCheck
+		switch {
+		case errors.As(err, &skipped):
+			check.Status, check.Error = protocol.CheckSkipped, err.Error()
+			log.Printf("Self-test %s skipped: %v", c.name, err)
+		case err != nil:
+			check.Status, check.Error = protocol.CheckFailed, err.Error()
+			result.Passed = false
+			log.Printf("ERROR: Self-test %s failed: %v", c.name, err)
+		default:
+			log.Printf("Self-test %s passed in %v", c.name, time.Since(started))
+		}
+		result.Checks = append(result.Checks, check)
+	}
+
+	if result.Passed {
+		log.Println("Self-test passed")
+	} else {
+		failed := make([]string, 0, len(result.Checks))
+		for _, check := range result.Failures() {
+			failed = append(failed, check.Name)
+		}
+		log.Printf("WARNING: Self-test failed (%s); the image may be misbuilt", strings.Join(failed, ", "))
+	}
+	return result
+}
+
+// checkWAT turns selfTestWAT into a binary
+func checkWAT(limits ExecutionLimits) error {
+	wasm, err := compileWATToWASM([]byte(selfTestWAT), limits.Features)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(wasm, []byte("\x00asm")) {
+		return fmt.Errorf("wat2wasm produced %d bytes that are not a module", len(wasm))
+	}
+	return nil
+}
+
+// checkExecute runs selfTestModule on the runtime requests use, with the
+// default limits. It runs whatever the module policy, and is neither cached
+// nor measured into the module PCR, so it leaves no trace requests would see.
+func (s *EnclaveServer) checkExecute(limits ExecutionLimits) error {
+	probe := *s.executor
+	probe.policy, probe.modules, probe.measurements = modulePolicy{}, nil, nil
+	runtime, err := newRuntime(s.runtimeName, &probe)
+	if err != nil {
+		return err
+	}
+	calls := []protocol.Call{{FunctionName: "self_test"}}
+	results, _, err := runtime.ExecuteWASM(context.Background(), slog.Default(), selfTestModule, "", calls, nil, limits)
+	if err == nil && len(results) == 1 {
+		err = results[0].Err
+	}
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte("self-test"))
+	if want := int32(binary.LittleEndian.Uint32(digest[:])); results[0].I32 != want {
+		return fmt.Errorf("module returned %d, expected %d", results[0].I32, want)
+	}
+	return nil
+}
+
+// checkNSM has the NSM describe PCR0 and sign an attestation document.
+// Outside a Nitro enclave there is no NSM to check.
+func checkNSM(session *nsm.Session, nsmErr error, inEnclave bool) error {
+	if session == nil {
+		if inEnclave {
+			return nsmErr
+		}
+		return &skippedCheck{reason: "not running in a Nitro enclave"}
+	}
+	pcr, err := session.DescribePCR(0)
+	if err != nil {
+		return fmt.Errorf("failed to describe PCR0: %v", err)
+	}
+	if len(pcr.Data) != sha512.Size384 {
+		return fmt.Errorf("PCR0 holds %d bytes, not a SHA-384", len(pcr.Data))
+	}
+	if _, err := session.Attestation(nil, []byte("self-test"), nil); err != nil {
+		return fmt.Errorf("failed to get an attestation document: %v", err)
+	}
+	return nil
+}
+
+// checkEntropy draws twice from the source of enclave keys, which must give
+// distinct bytes that are not all zero
+func (s *EnclaveServer) checkEntropy() error {
+	first, second := make([]byte, 32), make([]byte, 32)
+	for _, buf := range [][]byte{first, second} {
+		if _, err := io.ReadFull(s.entropy, buf); err != nil {
+			return fmt.Errorf("failed to read entropy: %v", err)
+		}
+	}
+	if bytes.Equal(first, make([]byte, len(first))) {
+		return fmt.Errorf("entropy source returned only zeros")
+	}
+	if bytes.Equal(first, second) {
+		return fmt.Errorf("entropy source returned the same bytes twice")
+	}
+	return nil
+}
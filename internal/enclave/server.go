@@ -0,0 +1,1551 @@
+package enclave
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/config"
+	"hello-wasm-enclave/internal/drain"
+	"hello-wasm-enclave/internal/gctune"
+	"hello-wasm-enclave/internal/logging"
+	"hello-wasm-enclave/internal/nsm"
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/transport"
+	"hello-wasm-enclave/internal/wire"
+)
+
+type WASMExecutor struct {
+	engines *engines
+	// Whether the engine meters fuel, making max_fuel available to requests
+	meterFuel bool
+	// Which modules may run
+	policy modulePolicy
+	// Bounds concurrent executions
+	workers *workerPool
+	// What modules may fetch; nil offers no env.http_get
+	egress *egressPolicy
+	// Modules of -modules-dir, compiled at startup
+	preloaded *preloadedModules
+	// Seed of the module keys of the crypto host functions; nil offers none
+	keySeed []byte
+	// Source of secure_random; crypto/rand when nil
+	random io.Reader
+	// PCR every module is extended into before it first runs; nil for none
+	measurements *moduleMeasurements
+	// Whether secrets missing for data placeholders refuse the request, as
+	// those missing for imports always do
+	strictSecrets bool
+	// Modules compiled for recent requests; nil caches nothing
+	modules *moduleCache
+}
+
+func NewWASMExecutor(meterFuel bool, policy modulePolicy, workers *workerPool, egress *egressPolicy) *WASMExecutor {
+	return &WASMExecutor{
+		engines:   newEngines(meterFuel),
+		meterFuel: meterFuel,
+		policy:    policy,
+		workers:   workers,
+		egress:    egress,
+	}
+}
+
+// ExecuteWASM instantiates a module once, makes the calls against it in
+// order and reports the resources they used, which are meaningful even when
+// the execution fails. The error is set when the module could not be run at
+// all or a limit stopped it; the results say how each call went. The
+// execution is interrupted when ctx ends, streams what the module emits to
+// the emitter ctx carries, and beats the heartbeat it carries, from the
+// wait for a worker on.
+func (w *WASMExecutor) ExecuteWASM(ctx context.Context, logger *slog.Logger, wasmCode, signature string, calls []protocol.Call, secrets Secrets, limits ExecutionLimits) ([]CallResult, ExecutionStats, error) {
+	var stats ExecutionStats
+	beats := heartbeatFrom(ctx)
+	defer beats.start()()
+	release, err := w.workers.acquire(ctx)
+	if err != nil {
+		return nil, stats, err
+	}
+	defer release()
+
+	store, err := w.newStore(limits)
+	if err != nil {
+		return nil, stats, err
+	}
+	beats.watch(store)
+
+	deadline := newDeadline(ctx, store)
+	emits := emitterFrom(ctx)
+	results, err := w.execute(logger, store, deadline, wasmCode, signature, calls, secrets, emits, limits, &stats)
+	stats.EmitsTruncated = emits.wasTruncated()
+	deadline.stop()
+	err = cancelled(ctx, err)
+
+	if w.meterFuel {
+		stats.FuelConsumed, _ = store.FuelConsumed()
+		if err != nil && errorCode(err) == "" {
+			if fuelErr := w.fuelError(store, limits); fuelErr != nil {
+				err = fuelErr
+			}
+		}
+	}
+	return results, stats, err
+}
+
+// newStore returns a store holding the request's fuel budget, in the engine
+// for the request's features
+func (w *WASMExecutor) newStore(limits ExecutionLimits) (*wasmtime.Store, error) {
+	store := wasmtime.NewStore(w.engines.get(limits.Features))
+	if limits.MaxFuel > 0 && !w.meterFuel {
+		return nil, fmt.Errorf("max_fuel requires an enclave started with -fuel-metering")
+	}
+	if w.meterFuel {
+		fuel := limits.MaxFuel
+		if fuel == 0 {
+			fuel = unmeteredFuel
+		}
+		if err := store.AddFuel(fuel); err != nil {
+			return nil, fmt.Errorf("failed to add fuel: %v", err)
+		}
+	}
+	return store, nil
+}
+
+// fuelError returns a fuel limit error when the request's budget is used up.
+// wasmtime reports running out of fuel as an ordinary trap.
+func (w *WASMExecutor) fuelError(store *wasmtime.Store, limits ExecutionLimits) error {
+	if !w.meterFuel || limits.MaxFuel == 0 {
+		return nil
+	}
+	consumed, _ := store.FuelConsumed()
+	if consumed < limits.MaxFuel {
+		return nil
+	}
+	return &LimitError{Code: protocol.ErrorCodeFuelExhausted, Message: fmt.Sprintf("fuel exhausted after %d units", consumed)}
+}
+
+func (w *WASMExecutor) execute(logger *slog.Logger, store *wasmtime.Store, deadline *executionDeadline, wasmCode, signature string, calls []protocol.Call, secrets Secrets, emits *emitter, limits ExecutionLimits, stats *ExecutionStats) ([]CallResult, error) {
+	fetches := w.egress.newLog()
+	defer func() {
+		stats.Fetches = fetches.takeNew()
+	}()
+	instance, capture, err := w.instantiate(logger, store, deadline, wasmCode, signature, secrets, fetches, emits, limits, stats)
+	if capture != nil {
+		defer func() {
+			stats.Stdout, stats.Stderr, stats.OutputTruncated = capture.collect(limits.MaxOutputBytes)
+		}()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	callStart := time.Now()
+	defer func() {
+		stats.CallTime = time.Since(callStart)
+		stats.ExecuteTime += stats.CallTime
+		stats.MemoryPages = memoryPages(store, instance)
+	}()
+	return w.runCalls(logger, store, instance, &refHandles{}, calls, limits)
+}
+
+// instantiate checks that a module may run, compiles it, injecting secrets,
+// unless it was preloaded, and instantiates it in store. The output capture of a WASI module is returned even when
+// instantiation fails, since the start function may have printed something.
+func (w *WASMExecutor) instantiate(logger *slog.Logger, store *wasmtime.Store, deadline *executionDeadline, wasmCode, signature string, secrets Secrets, fetches *fetchLog, emits *emitter, limits ExecutionLimits, stats *ExecutionStats) (*wasmtime.Instance, *outputCapture, error) {
+	preloaded := w.preloaded.lookup(wasmCode)
+	if preloaded == nil {
+		if err := w.policy.check(wasmCode, signature); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	compileStart := time.Now()
+	stats.CompileStart = compileStart
+
+	// Keys are only for the crypto host functions, never for the module
+	secrets, keys := splitKeys(secrets)
+	logger.Info("Parsing WASM code", "length", len(wasmCode), "secrets", len(secrets), "keys", len(keys))
+	for key, value := range secrets {
+		logger.Info("Secret received", "name", logging.SecretName(key), "value", logging.SecretValue(value.Bytes()))
+	}
+
+	use := newSecretUse()
+	module := preloaded.compiled(w.meterFuel, limits, secrets)
+	stats.ModuleSource = protocol.ModuleSourceCompiled
+	cacheKey, cacheable := w.modules.key(wasmCode, w.meterFuel, limits)
+	if module != nil {
+		logger.Info("Using preloaded module", "module", preloaded.name)
+		stats.ModuleSource = protocol.ModuleSourcePreloaded
+	} else if module = w.modules.lookup(cacheKey, cacheable); module != nil {
+		logger.Info("Using cached module")
+		stats.ModuleSource = protocol.ModuleSourceCached
+	} else {
+		wasmBytes, err := decodeModule(logger, wasmCode, secrets, limits, use)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isPrecompiled(wasmBytes) {
+			module, err = w.deserialize(logger, store.Engine, wasmBytes, limits)
+			stats.ModuleSource = protocol.ModuleSourcePrecompiled
+		} else {
+			module, err = compileModule(store.Engine, wasmBytes, limits)
+		}
+		// A template's binary holds its secrets; the module no longer needs it
+		clear(wasmBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		if cacheable {
+			w.modules.put(cacheKey, module, moduleHash(wasmCode), limits.Tenant)
+		}
+	}
+	stats.CompileTime = time.Since(compileStart)
+
+	logger.Info("WASM module created", "compile_time", stats.CompileTime)
+
+	if err := w.measurements.measure(logger, moduleHash(wasmCode)); err != nil {
+		return nil, nil, err
+	}
+
+	use.scanModule(module, secrets)
+	if err := use.check(logger, secrets, w.strictSecrets); err != nil {
+		return nil, nil, err
+	}
+
+	// Global secret imports in WAT were already replaced with constants; what
+	// remains is resolved by the linker: env.get_secret, the global imports
+	// of binary modules, and the secret_ptr/secret_len imports for secrets
+	// placed in memory
+	memSecrets, err := planMemorySecrets(logger, module, secrets)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to place secrets in memory: %v", err)
+	}
+	imports := moduleHostImports(module)
+	linker, err := newSecretLinker(logger, store.Engine, store, module, imports, secrets, memSecrets)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fetches != nil {
+		if err := fetches.define(logger, linker, imports); err != nil {
+			return nil, nil, err
+		}
+	}
+	ring := &keyring{keys: keys, seed: w.keySeed, module: moduleHash(wasmCode)}
+	if err := ring.define(logger, linker, imports); err != nil {
+		return nil, nil, err
+	}
+	if err := defineHostLibrary(linker, imports, w.random); err != nil {
+		return nil, nil, err
+	}
+	if err := emits.define(logger, linker, imports); err != nil {
+		return nil, nil, err
+	}
+
+	// WASI modules may print diagnostics, which are returned to the client
+	var capture *outputCapture
+	if importsWASI(module) {
+		if err := linker.DefineWasi(); err != nil {
+			return nil, nil, fmt.Errorf("failed to define WASI imports: %v", err)
+		}
+		capture, err = captureOutput(store)
+		if err != nil {
+			return nil, nil, err
+		}
+		logger.Info("Providing WASI with captured stdout and stderr")
+	}
+
+	// The deadline covers the start function as well as the calls
+	deadline.start(limits.Timeout)
+
+	// Execution time starts with instantiation; the calls add to it
+	executeStart := time.Now()
+	stats.InstantiateStart = executeStart
+	defer func() {
+		stats.InstantiateTime = time.Since(executeStart)
+		stats.ExecuteTime = stats.InstantiateTime
+	}()
+
+	instance, err := linker.Instantiate(store, module)
+	if err != nil {
+		if isInterrupt(err) {
+			return nil, capture, &LimitError{Code: protocol.ErrorCodeTimeout, Message: fmt.Sprintf("execution timed out after %v during instantiation", limits.Timeout)}
+		}
+		return nil, capture, fmt.Errorf("failed to create WASM instance: %v", err)
+	}
+
+	logger.Info("WASM instance created")
+
+	if memSecrets != nil {
+		if err := memSecrets.write(store, instance); err != nil {
+			return nil, capture, fmt.Errorf("failed to write secrets to memory: %v", err)
+		}
+		logger.Info("Secrets written to linear memory")
+	}
+
+	// List all exports for debugging
+	exports := module.Exports()
+	exportNames := make([]string, 0, len(exports))
+	for _, export := range exports {
+		exportNames = append(exportNames, export.Name())
+	}
+	logger.Info("Module exports", "exports", exportNames)
+	return instance, capture, nil
+}
+
+// runCalls makes calls in order on an instance. One failing does not stop
+// the rest, but running out of time, fuel or memory does. Funcrefs the calls
+// return are named in refs, for later calls on the instance.
+func (w *WASMExecutor) runCalls(logger *slog.Logger, store *wasmtime.Store, instance *wasmtime.Instance, refs *refHandles, calls []protocol.Call, limits ExecutionLimits) ([]CallResult, error) {
+	results := make([]CallResult, 0, len(calls))
+	for _, call := range calls {
+		started := time.Now()
+		result, err := w.callFunction(logger, store, instance, refs, call, limits)
+		result.Started, result.Duration = started, time.Since(started)
+		if err != nil {
+			if fuelErr := w.fuelError(store, limits); fuelErr != nil {
+				err = fuelErr
+			}
+			result.Err = err
+		}
+		results = append(results, result)
+		if errorCode(err) != "" {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// callFunction makes one call on an instance
+func (w *WASMExecutor) callFunction(logger *slog.Logger, store *wasmtime.Store, instance *wasmtime.Instance, refs *refHandles, call protocol.Call, limits ExecutionLimits) (CallResult, error) {
+	functionName := call.Target()
+	args := call.Values()
+
+	// Get the requested function
+	wasmFunc, err := lookupFunction(store, instance, call)
+	if err != nil {
+		return CallResult{}, err
+	}
+	// A null ref result looks like no result at all
+	switch spec := call.Result(); spec.Type {
+	case protocol.ValueExternref, protocol.ValueFuncref:
+		if len(wasmFunc.Type(store).Results()) == 0 {
+			return CallResult{}, fmt.Errorf("function returns nothing, not the requested %s", spec.Type)
+		}
+	}
+
+	callArgs, err := marshalArgs(logger, store, instance, refs, args)
+	if err != nil {
+		if errorCode(err) != "" {
+			return CallResult{}, err
+		}
+		return CallResult{}, fmt.Errorf("failed to pass arguments: %v", err)
+	}
+
+	logger.Info("Calling function", "function", functionName, "args", len(args))
+
+	// Call the function
+	result, err := wasmFunc.Call(store, callArgs...)
+	if err != nil {
+		if isInterrupt(err) {
+			return CallResult{}, &LimitError{Code: protocol.ErrorCodeTimeout, Message: fmt.Sprintf("execution timed out after %v", limits.Timeout)}
+		}
+		if status, exited := wasiExitStatus(err); exited {
+			if status == 0 {
+				logger.Info("WASM module exited successfully")
+				return CallResult{}, nil
+			}
+			return CallResult{I32: status}, fmt.Errorf("WASM module exited with status %d", status)
+		}
+		// A module that cannot grow its memory usually traps soon after
+		if memory := instance.GetExport(store, secretMemoryExport); memory != nil && memory.Memory() != nil &&
+			memory.Memory().Size(store) >= uint64(limits.MaxMemoryPages) {
+			return CallResult{}, resourceLimitError("memory reached %d pages: %v", limits.MaxMemoryPages, err)
+		}
+		return CallResult{}, fmt.Errorf("WASM function call failed: %v", err)
+	}
+
+	callResult, err := readResult(store, instance, refs, result, call.Result())
+	if err != nil {
+		return CallResult{}, err
+	}
+	logger.Info("WASM function returned", "result", callResult.I32, "typed", callResult.Value != nil)
+	return callResult, nil
+}
+
+// injectSecretsIntoWAT replaces imported globals named after secrets with
+// global definitions of their values, and {{NAME}} placeholders in data
+// segments with the bytes of secret NAME, so string secrets reach linear
+// memory exactly. The module is parsed rather than searched, so imports may
+// span lines or be written inline, and comments or strings that look like
+// imports are left alone.
+//
+// A placeholder changes the length of its segment, so modules should find
+// the end of the secret by what follows it, such as "{{API_KEY}}\00".
+func injectSecretsIntoWAT(logger *slog.Logger, watCode string, secrets Secrets, use *secretUse) ([]byte, error) {
+	nodes, err := parseWAT(watCode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WAT: %v", err)
+	}
+	fields := watModuleFields(nodes)
+	imports := watGlobalImports(fields)
+	logger.Info("Processing template imports", "count", len(imports))
+
+	var edits []watEdit
+	var definitions [][]byte
+	for _, imp := range imports {
+		// Memory secrets are satisfied at instantiation, not by rewriting
+		if imp.module == secretPtrNamespace || imp.module == secretLenNamespace {
+			continue
+		}
+
+		secret, exists := secrets[imp.name]
+		use.importSecret(imp.name, exists)
+		if !exists {
+			logger.Warn("Secret not provided, keeping import", "name", logging.SecretName(imp.name), "type", imp.valType)
+			continue
+		}
+		wasmValue, err := convertSecretToWASMValue(secret.Bytes(), imp.valType)
+		if err != nil {
+			wipeWATEdits(edits)
+			return nil, fmt.Errorf("failed to convert secret %s: %v", imp.name, err)
+		}
+		edits = append(edits, watEdit{start: imp.field.start, end: imp.field.end})
+		definitions = append(definitions, imp.definition(watCode, wasmValue))
+		clear(wasmValue)
+		logger.Info("Replaced import with secret constant", "name", logging.SecretName(imp.name), "type", imp.valType)
+	}
+
+	for _, str := range watDataStrings(fields) {
+		literal := watCode[str.start:str.end]
+		for _, match := range watPlaceholder.FindAllStringSubmatchIndex(literal, -1) {
+			name := literal[match[2]:match[3]]
+			secret, exists := secrets[name]
+			use.placeSecret(name, exists)
+			if !exists {
+				logger.Warn("Secret not provided, keeping placeholder", "name", logging.SecretName(name))
+				continue
+			}
+			logger.Info("Substituted secret into data segment", "name", logging.SecretName(name), "bytes", secret.Len())
+			edits = append(edits, watEdit{start: str.start + match[0], end: str.start + match[1], text: escapeWATBytes(secret.Bytes())})
+		}
+	}
+
+	if len(definitions) > 0 {
+		// Every import must come before the module's definitions, so the
+		// constants go after the last import, kept or replaced
+		lastImport := 0
+		for _, field := range fields {
+			if isWATImport(field) {
+				lastImport = field.end
+			}
+		}
+		edits = append(edits, watEdit{start: lastImport, end: lastImport, text: joinWATDefinitions(definitions)})
+	}
+	return applyWATEdits(watCode, edits), nil
+}
+
+// convertSecretToWASMValue converts a secret to the text of a WASM constant of
+// wasmType, which is as secret as the secret itself
+func convertSecretToWASMValue(secret []byte, wasmType string) ([]byte, error) {
+	switch wasmType {
+	case "i32":
+		// Try to parse as integer
+		if intVal, err := strconv.ParseInt(string(secret), 10, 32); err == nil {
+			return strconv.AppendInt(nil, intVal, 10), nil
+		}
+		// For string secrets, use a hash or checksum as i32. This is lossy;
+		// modules that need the exact bytes should import the secret through
+		// the secret_ptr/secret_len namespaces instead.
+		hash := simpleStringHash(secret)
+		return strconv.AppendInt(nil, int64(hash), 10), nil
+
+	case "i64":
+		if intVal, err := strconv.ParseInt(string(secret), 10, 64); err == nil {
+			return strconv.AppendInt(nil, intVal, 10), nil
+		}
+		hash := simpleStringHash(secret)
+		return strconv.AppendInt(nil, int64(hash), 10), nil
+
+	case "f32", "f64":
+		if floatVal, err := strconv.ParseFloat(string(secret), 64); err == nil {
+			return strconv.AppendFloat(nil, floatVal, 'g', -1, 64), nil
+		}
+		return nil, fmt.Errorf("cannot convert string secret to float type %s", wasmType)
+
+	default:
+		return nil, fmt.Errorf("unsupported WASM type: %s", wasmType)
+	}
+}
+
+// Simple hash function for string secrets (convert to i32)
+func simpleStringHash(s []byte) int32 {
+	var hash int32 = 0
+	for _, c := range string(s) {
+		hash = hash*31 + int32(c)
+	}
+	if hash < 0 {
+		hash = -hash
+	}
+	return hash
+}
+
+// decodeModule turns wasm_code into a binary: WAT text is compiled, with
+// secrets injected into templates and their references recorded in use, and
+// anything else is base64. A nil use compiles WAT without secrets untouched.
+// The binary of a template holds its secrets, so the caller wipes it once
+// compiled.
+func decodeModule(logger *slog.Logger, wasmCode string, secrets Secrets, limits ExecutionLimits, use *secretUse) ([]byte, error) {
+	// Check if input is WAT text or binary WASM
+	var wasmBytes []byte
+	var err error
+	if isWATText(wasmCode) {
+		logger.Info("Detected WAT text format")
+
+		// Process template variables if this is WAT with secrets
+		var processedWAT []byte
+		if len(secrets) > 0 || use != nil {
+			logger.Info("Injecting secrets into WAT template")
+			processedWAT, err = injectSecretsIntoWAT(logger, wasmCode, secrets, use)
+			if err != nil {
+				return nil, fmt.Errorf("failed to inject secrets: %v", err)
+			}
+			defer clear(processedWAT)
+			logger.Info("Secrets injected", "original_length", len(wasmCode), "processed_length", len(processedWAT))
+		} else {
+			processedWAT = []byte(wasmCode)
+		}
+
+		// Compile WAT to WASM binary using wat2wasm
+		wasmBytes, err = compileWATToWASM(processedWAT, limits.Features)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile WAT to WASM: %v", err)
+		}
+		logger.Info("Compiled WAT to WASM binary", "bytes", len(wasmBytes))
+	} else {
+		logger.Info("Decoding base64 WASM binary")
+		// Assume it's base64 encoded binary WASM
+		wasmBytes, err = base64DecodeWASM(wasmCode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode WASM bytecode: %v", err)
+		}
+		logger.Info("Decoded WASM binary", "bytes", len(wasmBytes))
+		if isComponent(wasmBytes) {
+			return nil, errComponentsUnsupported
+		}
+	}
+	return wasmBytes, nil
+}
+
+// compileModule clamps a module's limits and compiles it for engine, wiping
+// the clamped copy afterwards
+func compileModule(engine *wasmtime.Engine, wasmBytes []byte, limits ExecutionLimits) (*wasmtime.Module, error) {
+	wasmBytes, err := applyResourceLimits(wasmBytes, limits)
+	if err != nil {
+		if errorCode(err) != "" {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to apply resource limits: %v", err)
+	}
+	defer clear(wasmBytes)
+
+	module, err := wasmtime.NewModule(engine, wasmBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WASM module (enabled features: %v): %v", limits.Features, err)
+	}
+	return module, nil
+}
+
+// Helper function to compile WAT text to WASM binary using wat2wasm, accepting
+// the syntax of the given features. Templates carry their secrets by then, so
+// the text goes in through a pipe and the binary comes back through another:
+// neither is ever written to disk.
+func compileWATToWASM(watCode []byte, features featureSet) ([]byte, error) {
+	// A binary is smaller than its text, so the buffer should not need to
+	// grow and leave copies of it behind
+	var wasm, stderr bytes.Buffer
+	wasm.Grow(len(watCode))
+	args := append(features.watFlags(), "-", "-o", "/dev/stdout")
+	cmd := exec.Command("wat2wasm", args...)
+	cmd.Stdin = bytes.NewReader(watCode)
+	cmd.Stdout = &wasm
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		clear(wasm.Bytes())
+		return nil, fmt.Errorf("wat2wasm compilation failed: %v, output: %s", err, stderr.String())
+	}
+	return wasm.Bytes(), nil
+}
+
+// Helper function to detect if input is WAT text format
+func isWATText(input string) bool {
+	return len(input) > 0 && input[0] == '(' &&
+		(strings.Contains(input, "module") || strings.Contains(input, "func"))
+}
+
+// Helper function to decode base64 WASM bytecode
+func base64DecodeWASM(encoded string) ([]byte, error) {
+	// Remove whitespace
+	cleaned := strings.ReplaceAll(encoded, " ", "")
+	cleaned = strings.ReplaceAll(cleaned, "\n", "")
+	cleaned = strings.ReplaceAll(cleaned, "\t", "")
+
+	// Try base64 decoding
+	decoded, err := base64.StdEncoding.DecodeString(cleaned)
+	if err != nil {
+		// Try hex decoding as fallback
+		if len(cleaned)%2 == 0 {
+			hexDecoded := make([]byte, len(cleaned)/2)
+			for i := 0; i < len(cleaned); i += 2 {
+				b, hexErr := strconv.ParseUint(cleaned[i:i+2], 16, 8)
+				if hexErr != nil {
+					return nil, err // Return original base64 error
+				}
+				hexDecoded[i/2] = byte(b)
+			}
+			return hexDecoded, nil
+		}
+		return nil, err
+	}
+
+	return decoded, nil
+}
+
+// Why requests still running on a connection are cancelled, and one whose
+// stream the host reset
+var (
+	errConnectionClosed = fmt.Errorf("the host closed the connection")
+	errStreamReset      = fmt.Errorf("the host reset the request's stream")
+)
+
+// EnclaveServer answers requests arriving from the host over vsock
+type EnclaveServer struct {
+	executor *WASMExecutor
+	// Runs executions and jobs: the executor, or another engine
+	runtime     Runtime
+	runtimeName string
+	// How the startup checks went; nil when -self-test is off
+	selfTest   *protocol.SelfTest
+	attester   *Attester
+	secretsKey *EnclaveKey
+	// Replaced by rotate_signing_key requests
+	signer atomic.Pointer[ReceiptSigner]
+	// Randomness of keys generated after startup
+	entropy  io.Reader
+	kms      *KMSProvider
+	caps     ResourceCaps
+	health   *healthStats
+	sessions *sessionTable
+	jobs     *jobTable
+	uploads  *uploadTable
+	audit    *auditLog
+	// Where secrets may be released; nil releases them to any module
+	secretPolicy secretPolicy
+	// Certificate of the end-to-end TLS listener; nil when it is disabled
+	tlsCert    *x509.Certificate
+	drainer    *drain.Drainer
+	sizeLimits protocol.SizeLimits
+	// Whether requests may bring their own modules rather than name
+	// preloaded ones
+	moduleUpload bool
+}
+
+// Run configures the enclave server from args, and from environment
+// variables prefixed WASM_ENCLAVE_, then serves the host until SIGTERM and a
+// drain, reaching it over the transport -transport picks
+func Run(args []string) {
+	server, drainTimeout := start(args, nil, false)
+	if err := server.drainer.Run(drainTimeout); err != nil {
+		log.Fatalf("FATAL: Shutdown incomplete: %v", err)
+	}
+	log.Println("Shutdown complete")
+}
+
+// Simulate starts the enclave server inside the host's process, listening on
+// parent, and returns once it listens. The process's logging and GC settings
+// stay the host's, the startup delay is skipped, and the server runs until
+// the process exits rather than draining on SIGTERM.
+func Simulate(args []string, parent *transport.Transport) {
+	start(args, parent, true)
+}
+
+// start configures the enclave server from args and has it listen on parent,
+// or on the transport -transport picks when that is nil. A server with a
+// process of its own also sets up logging and GC, and waits a moment at
+// startup; a simulated one leaves all three to the host.
+func start(args []string, parent *transport.Transport, simulated bool) (*EnclaveServer, time.Duration) {
+	flags := flag.NewFlagSet("enclave-server", flag.ExitOnError)
+	port := flags.Uint("port", protocol.EnclavePort, "vsock port to listen on for the host")
+	tlsPort := flags.Uint("tls-port", protocol.EnclaveTLSPort, "vsock port of the end-to-end TLS listener, which the host relays without seeing plaintext (0 disables)")
+	tlsCertFile := flags.String("tls-cert", "", "PEM certificate for the TLS listener (default: self-signed with a key generated at startup)")
+	tlsKeyFile := flags.String("tls-key", "", "PEM private key of -tls-cert")
+	kmsProxyPort := flags.Uint("kms-proxy-port", 8000, "parent vsock port where vsock-proxy forwards to KMS")
+	transportKind := flags.String("transport", transport.Auto, "how the host and the parent's proxies are reached: vsock, tcp to run outside Nitro for development, or auto for vsock where available")
+	tcpHost := flags.String("tcp-host", transport.DefaultTCPHost, "address to listen on, and where the parent's proxies are, with -transport tcp")
+	defaultTimeout := flags.Duration("default-timeout", defaultExecutionTimeout, "execution time limit for requests that do not set timeout_ms")
+	maxTimeout := flags.Duration("max-timeout", maxExecutionTimeout, "largest timeout_ms a request may ask for")
+	maxMemoryPages := flags.Uint("max-memory-pages", 1024, "cap on each execution's linear memory in 64 KiB pages")
+	maxTableElements := flags.Uint("max-table-elements", 10000, "cap on each execution's table size in elements")
+	maxOutputBytes := flags.Int("max-output-bytes", defaultMaxOutputBytes, "bytes of stdout and of stderr kept from each WASI execution")
+	sessionTTL := flags.Duration("session-ttl", defaultSessionTTL, "idle time after which a session is destroyed (0 keeps sessions until destroyed)")
+	allowlistPath := flags.String("module-allowlist", "", "file of SHA-256 digests (sha256sum format) of the only modules the enclave may run")
+	signersPath := flags.String("trusted-signers", "", "PEM file of Ed25519 public keys whose module_signature lets a module run")
+	fetchAllowlist := flags.String("fetch-allowlist", "", "file of https URL prefixes and the parent vsock ports reaching them, which modules may GET through env.http_get")
+	fetchMaxBytes := flags.Int("fetch-max-bytes", defaultFetchMaxBytes, "largest response body env.http_get returns")
+	fetchTimeout := flags.Duration("fetch-timeout", defaultFetchTimeout, "time one env.http_get may take")
+	workers := flags.Int("workers", runtime.NumCPU(), "executions that compile and run at once")
+	queueLength := flags.Int("queue-length", defaultQueueLength, "executions that may wait for a worker before requests are refused as overloaded")
+	moduleCacheSize := flags.Int("module-cache-size", 0, "compiled binary modules to keep, so that one sent again is not compiled again (0 disables)")
+	gc := gctune.Register(flags)
+	maxSessions := flags.Int("max-sessions", defaultMaxSessions, "sessions alive at once for each tenant (0 disables sessions)")
+	maxJobs := flags.Int("max-jobs", defaultMaxJobs, "jobs running or holding results at once for each tenant (0 disables jobs)")
+	jobTTL := flags.Duration("job-ttl", defaultJobTTL, "time the result of a finished job is kept for polling (0 keeps results until the enclave exits)")
+	maxUploads := flags.Int("max-uploads", defaultMaxUploads, "modules sent in chunks, in progress or committed, held at once, each of up to -max-upload-bytes (0 disables uploads)")
+	uploadTTL := flags.Duration("upload-ttl", defaultUploadTTL, "idle time after which a module sent in chunks is forgotten, committed or not (0 keeps uploads until the enclave exits)")
+	maxJobTimeout := flags.Duration("max-job-timeout", defaultMaxJobTimeout, "largest timeout_ms a submitted job may ask for")
+	maxTables := flags.Int("max-tables", 1, "cap on the number of tables a module may declare")
+	logFormat := flags.String("log-format", "json", "log output format: json or text")
+	stuckTimeout := flags.Duration("stuck-execution-timeout", defaultStuckExecutionTimeout, "time an execution may hold a worker before it is deemed stuck and the workers are restarted, so it cannot starve the rest (0 disables)")
+	logPort := flags.Uint("log-port", protocol.EnclaveLogPort, "vsock port where the host follows the enclave's log records, as JSON lines, besides the console (0 disables)")
+	unsafeLogging := flags.Bool("debug-unsafe-logging", false, "log secret names and masked secret values (debugging only; never in production)")
+	strictSecrets := flags.Bool("strict-secrets", false, "refuse requests leaving {{NAME}} data placeholders without their secret; a secret missing for an import is always refused")
+	fuelMetering := flags.Bool("fuel-metering", false, "meter fuel so requests can set max_fuel and responses report fuel_consumed")
+	selfTest := flags.Bool("self-test", true, "check at startup that modules compile and run, that the NSM answers and that keys get entropy, and report the outcome in hello and health responses")
+	runtimeName := flags.String("runtime", runtimeWasmtime, "engine running executions and jobs: wasmtime, or wazero, which is pure Go but offers no fuel, http_get or key functions")
+	drainTimeout := flags.Duration("drain-timeout", drain.DefaultTimeout, "time given to executions in flight to finish on SIGTERM before exiting")
+	maxRequestBytes := flags.Int("max-request-bytes", protocol.DefaultMaxRequestBytes, "largest encoded request accepted from the host")
+	maxWASMBytes := flags.Int("max-wasm-bytes", protocol.DefaultMaxWASMBytes, "largest wasm_code accepted, as WAT text or base64")
+	maxUploadBytes := flags.Int("max-upload-bytes", protocol.DefaultMaxUploadBytes, "largest wasm_code accepted in the chunks of an upload")
+	maxArgs := flags.Int("max-args", protocol.DefaultMaxArgs, "most arguments a call may pass")
+	maxSecrets := flags.Int("max-secrets", protocol.DefaultMaxSecrets, "most secrets a request may carry, counting sealed and KMS secrets")
+	wasmFeatures := flags.String("wasm-features", defaultWasmFeatures, "comma-separated WebAssembly features every execution runs with: simd, bulk_memory, reference_types, multi_value, multi_memory, threads")
+	optionalFeatures := flags.String("optional-wasm-features", defaultOptionalWasmFeatures, "comma-separated WebAssembly features requests may enable in addition to -wasm-features")
+	modulesDir := flags.String("modules-dir", "", "directory of .wasm and .wat modules to compile at startup and run by module_name, e.g. baked into the image")
+	moduleUpload := flags.Bool("module-upload", true, "run modules sent in wasm_code; disable to run only -modules-dir modules")
+	secretPolicyPath := flags.String("secret-policy", "", "file of conditions (module=HASH|NAME, function=NAME) a module must meet for each secret to be injected into it")
+	auditLogSize := flags.Int("audit-log-size", defaultAuditLogSize, "executions kept in the signed audit log, oldest dropped first (0 disables it)")
+	modulePCR := flags.Int("module-pcr", defaultModulePCR, "PCR (16-31) to extend with the hash of every module before it first runs, so attestation documents cover all modules run (0 disables it)")
+	if err := config.Parse(flags, "WASM_ENCLAVE", args); err != nil {
+		log.Fatalf("FATAL: Invalid configuration: %v", err)
+	}
+	// Records go to the console, and to the host over the log channel
+	var logs *logChannel
+	if !simulated {
+		var forward io.Writer
+		if *logPort != 0 {
+			logs = newLogChannel()
+			forward = logs
+		}
+		if err := logging.SetupForwarding(*logFormat, forward); err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		if err := gc.Apply(); err != nil {
+			log.Fatalf("FATAL: Invalid configuration: %v", err)
+		}
+	}
+	if *unsafeLogging {
+		logging.SetUnsafeSecretLogging(true)
+		slog.Warn("Unsafe secret logging enabled: secret names and masked values will be logged")
+	}
+	if *defaultTimeout <= 0 || *defaultTimeout > *maxTimeout {
+		log.Fatalf("FATAL: -default-timeout must be positive and at most -max-timeout (%v)", *maxTimeout)
+	}
+	if *maxJobTimeout < *defaultTimeout {
+		log.Fatalf("FATAL: -max-job-timeout must be at least -default-timeout (%v)", *defaultTimeout)
+	}
+	if *stuckTimeout != 0 && (*stuckTimeout <= *maxJobTimeout || *stuckTimeout <= *maxTimeout) {
+		log.Fatalf("FATAL: -stuck-execution-timeout must exceed -max-job-timeout and -max-timeout, or executions would be deemed stuck within their time")
+	}
+	if *maxMemoryPages > maxWasmPages {
+		log.Fatalf("FATAL: -max-memory-pages must be at most %d, the pages of a 32-bit memory", maxWasmPages)
+	}
+	if !*moduleUpload && *modulesDir == "" {
+		log.Fatalf("FATAL: -module-upload=false leaves nothing to run without -modules-dir")
+	}
+	defaultFeatureSet, err := parseFeatures(strings.Split(*wasmFeatures, ","))
+	if err != nil {
+		log.Fatalf("FATAL: Invalid -wasm-features: %v", err)
+	}
+	optionalFeatureSet, err := parseFeatures(strings.Split(*optionalFeatures, ","))
+	if err != nil {
+		log.Fatalf("FATAL: Invalid -optional-wasm-features: %v", err)
+	}
+	if parent == nil {
+		if parent, err = transport.New(*transportKind, *tcpHost); err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+	}
+	if parent.Kind() == transport.TCP {
+		log.Printf("Warning: using TCP on %s instead of vsock; this is for development outside Nitro", *tcpHost)
+	}
+
+	log.Println("Starting WASM executor enclave...")
+
+	if !simulated {
+		// Add startup delay
+		time.Sleep(2 * time.Second)
+	}
+
+	// Initialize WASM executor
+	var policy modulePolicy
+	if *allowlistPath != "" {
+		var err error
+		policy.allowlist, err = loadAllowlist(*allowlistPath)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		log.Printf("Module allowlist loaded: %d modules may run", len(policy.allowlist))
+	}
+	if *signersPath != "" {
+		var err error
+		policy.signers, err = loadTrustedSigners(*signersPath)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		log.Printf("Trusted signers loaded: modules signed by %d keys may run", len(policy.signers))
+	}
+
+	var egress *egressPolicy
+	if *fetchAllowlist != "" {
+		var err error
+		egress, err = loadEgressPolicy(*fetchAllowlist, *fetchMaxBytes, *fetchTimeout, parent)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		log.Printf("Fetch allowlist loaded: modules may fetch under %d URL prefixes", len(egress.rules))
+	}
+
+	wasmExecutor := NewWASMExecutor(*fuelMetering, policy, newWorkerPool(*workers, *queueLength), egress)
+	wasmExecutor.strictSecrets = *strictSecrets
+	wasmExecutor.modules = newModuleCache(*moduleCacheSize)
+	log.Printf("Running up to %d executions at once, %d more queued", *workers, *queueLength)
+	if *stuckTimeout != 0 {
+		go wasmExecutor.workers.watch(*stuckTimeout)
+	}
+	if wasmExecutor.modules != nil {
+		log.Printf("Caching up to %d compiled modules", *moduleCacheSize)
+	}
+	if *fuelMetering {
+		log.Println("Fuel metering enabled")
+	}
+	executions, err := newRuntime(*runtimeName, wasmExecutor)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	log.Printf("Running executions on %s", *runtimeName)
+	if *strictSecrets {
+		log.Println("Strict secrets enabled: placeholders for secrets not provided are refused")
+	}
+	log.Printf("WebAssembly features: %v; requests may add: %v", defaultFeatureSet, optionalFeatureSet&^defaultFeatureSet)
+
+	log.Println("WASM executor initialized successfully")
+
+	// Enclave keys, module keys and secure_random all mix the NSM's
+	// hardware randomness into the kernel's
+	session, nsmErr := nsm.Open()
+	if nsmErr != nil {
+		log.Printf("Warning: attestation unavailable: %v", nsmErr)
+	} else {
+		log.Println("NSM device opened, attestation available; mixing its entropy into key generation")
+	}
+	attester := NewAttester(session)
+	entropy := newEntropy(session)
+	wasmExecutor.random = entropy
+
+	if *modulePCR != 0 {
+		if *modulePCR < 16 || *modulePCR > 31 {
+			log.Fatalf("FATAL: -module-pcr must be between 16 and 31, got %d", *modulePCR)
+		}
+		if session == nil {
+			log.Printf("Warning: modules are not measured into PCR%d without the NSM", *modulePCR)
+		} else if wasmExecutor.measurements, err = newModuleMeasurements(session, uint16(*modulePCR)); err != nil {
+			log.Fatalf("FATAL: %v", err)
+		} else {
+			log.Printf("Extending PCR%d with every module run", *modulePCR)
+		}
+	}
+
+	secretsKey, err := NewEnclaveKey(entropy)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	log.Println("Generated ephemeral key for encrypted secrets")
+
+	signer, err := NewReceiptSigner(entropy)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	log.Println("Generated ephemeral key for signing receipts")
+
+	wasmExecutor.keySeed, err = newKeySeed(entropy)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	log.Println("Generated ephemeral seed for module keys")
+
+	server := &EnclaveServer{
+		executor:     wasmExecutor,
+		runtime:      executions,
+		runtimeName:  *runtimeName,
+		attester:     attester,
+		secretsKey:   secretsKey,
+		entropy:      entropy,
+		kms:          NewKMSProvider(attester, secretsKey, parent, uint32(*kmsProxyPort)),
+		health:       newHealthStats(),
+		sessions:     newSessionTable(*sessionTTL, *maxSessions),
+		jobs:         newJobTable(*jobTTL, *maxJobs, *maxJobTimeout),
+		uploads:      newUploadTable(*uploadTTL, *maxUploads),
+		audit:        newAuditLog(*auditLogSize),
+		drainer:      drain.New(),
+		moduleUpload: *moduleUpload,
+		sizeLimits: protocol.SizeLimits{
+			MaxRequestBytes: *maxRequestBytes,
+			MaxWASMBytes:    *maxWASMBytes,
+			MaxUploadBytes:  *maxUploadBytes,
+			MaxArgs:         *maxArgs,
+			MaxSecrets:      *maxSecrets,
+		},
+		caps: ResourceCaps{
+			DefaultTimeout:   *defaultTimeout,
+			MaxTimeout:       *maxTimeout,
+			MaxMemoryPages:   uint32(*maxMemoryPages),
+			MaxTableElements: uint32(*maxTableElements),
+			MaxTables:        *maxTables,
+			MaxOutputBytes:   *maxOutputBytes,
+			DefaultFeatures:  defaultFeatureSet,
+			OptionalFeatures: optionalFeatureSet &^ defaultFeatureSet,
+		},
+	}
+	server.signer.Store(signer)
+
+	if *modulesDir != "" {
+		// Only fails on requested features, which this has none of
+		defaults, _ := requestLimits(protocol.WASMRequest{}, server.caps)
+		wasmExecutor.preloaded, err = loadPreloadedModules(*modulesDir, wasmExecutor.engines.get(defaults.Features), *fuelMetering, defaults)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		log.Printf("Preloaded %d modules from %s: %v", len(wasmExecutor.preloaded.byName), *modulesDir, wasmExecutor.preloaded.names())
+	}
+	if !*moduleUpload {
+		log.Println("Module upload disabled: only preloaded modules run")
+	}
+	if *secretPolicyPath != "" {
+		// Conditions may name preloaded modules
+		server.secretPolicy, err = loadSecretPolicy(*secretPolicyPath, wasmExecutor.preloaded)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		log.Printf("Secret policy loaded: release conditions for %d secrets", len(server.secretPolicy))
+	}
+
+	if *selfTest {
+		server.selfTest = server.runSelfTest(session, nsmErr, parent.Kind() == transport.VSock)
+	}
+
+	if *tlsPort != 0 {
+		tlsConfig, leaf, err := enclaveTLSConfig(*tlsCertFile, *tlsKeyFile, entropy)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		server.tlsCert = leaf
+		tlsListener, err := parent.Listen(uint32(*tlsPort))
+		if err != nil {
+			log.Fatalf("FATAL: Failed to listen on %s: %v", parent.Describe(uint32(*tlsPort)), err)
+		}
+		log.Printf("SUCCESS: Enclave listening for end-to-end TLS on %s", parent.Describe(uint32(*tlsPort)))
+		go server.serve(tls.NewListener(tlsListener, tlsConfig))
+	}
+
+	if logs != nil {
+		logListener, err := parent.Listen(uint32(*logPort))
+		if err != nil {
+			log.Fatalf("FATAL: Failed to listen on %s: %v", parent.Describe(uint32(*logPort)), err)
+		}
+		log.Printf("SUCCESS: Enclave serving its log records on %s", parent.Describe(uint32(*logPort)))
+		go logs.serve(logListener)
+	}
+
+	log.Printf("Setting up %s listener...", parent.Kind())
+
+	listener, err := parent.Listen(uint32(*port))
+	if err != nil {
+		log.Fatalf("FATAL: Failed to listen on %s: %v", parent.Describe(uint32(*port)), err)
+	}
+
+	log.Printf("SUCCESS: Enclave listening on %s", parent.Describe(uint32(*port)))
+	log.Println("Ready to execute arbitrary WASM code!")
+
+	go server.serve(listener)
+	return server, *drainTimeout
+}
+
+// serve handles every connection accepted on listener until it is closed
+// for draining
+func (s *EnclaveServer) serve(listener net.Listener) {
+	s.drainer.Track(listener)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.drainer.Draining() {
+				return
+			}
+			log.Printf("ERROR: Failed to accept connection: %v", err)
+			continue
+		}
+
+		log.Println("SUCCESS: Connection received from parent!")
+		go s.handleConnection(conn)
+	}
+}
+
+func (s *EnclaveServer) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	// A panic outside any request loses only this connection
+	defer func() {
+		if value := recover(); value != nil {
+			s.health.panics.Add(1)
+			err := newPanicError(value)
+			slog.Error("Recovered from a panic handling a connection", "error", err, "stack", string(err.Stack))
+		}
+	}()
+
+	log.Println("Handling connection...")
+
+	encoder, decoder, framed, err := wire.AcceptStreams(conn, s.sizeLimits.MaxRequestBytes)
+	if err != nil {
+		log.Printf("Failed to set up connection: %v", err)
+		return
+	}
+	if framed {
+		log.Println("Using length-prefixed framing")
+	}
+
+	// Requests on a connection run concurrently, so responses may be written
+	// out of order; the encoder is shared and must be serialized
+	var encodeMu sync.Mutex
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+
+	// The host closes a connection to give up on its requests, so whatever
+	// still runs for it is cancelled once reading stops
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(errConnectionClosed)
+
+	// Clients on the end-to-end TLS listener are not the host, and do not
+	// get to make its operator requests
+	_, endToEnd := conn.(*tls.Conn)
+
+	if mux := wire.NewMux(encoder, decoder); mux != nil {
+		log.Println("Carrying requests as streams")
+		s.serveStreams(ctx, mux, endToEnd, &inFlight)
+		return
+	}
+
+	answer := func(response protocol.WASMResponse) error {
+		encodeMu.Lock()
+		defer encodeMu.Unlock()
+		return encoder.Encode(response)
+	}
+	for {
+		var wasmReq protocol.WASMRequest
+		if err := decoder.Decode(&wasmReq); err != nil {
+			// A message that does not decode leaves the stream intact; report
+			// it, under its request ID if that much decoded, and go on
+			var payloadErr *wire.PayloadError
+			var tooLarge *wire.TooLargeError
+			code := protocol.ErrorCodeInvalidRequest
+			if errors.As(err, &tooLarge) {
+				code = protocol.ErrorCodeRequestTooLarge
+			}
+			if errors.As(err, &payloadErr) {
+				slog.Warn("Rejecting malformed request", "request_id", wasmReq.RequestID, "error", err)
+				answer(protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: code})
+				continue
+			}
+			// An oversized message cannot be skipped on the JSON stream
+			if tooLarge != nil {
+				slog.Warn("Closing connection after an oversized request", "error", err)
+				answer(protocol.WASMResponse{Error: err.Error(), ErrorCode: code})
+				return
+			}
+			log.Printf("Failed to decode request or connection closed: %v", err)
+			return
+		}
+		s.dispatch(ctx, wasmReq, endToEnd, answer, answer, &inFlight)
+	}
+}
+
+// serveStreams handles the requests of a connection carrying streams, one
+// on each, until the connection fails. Resetting a stream cancels its
+// request.
+func (s *EnclaveServer) serveStreams(ctx context.Context, mux *wire.Mux, endToEnd bool, inFlight *sync.WaitGroup) {
+	for {
+		stream, err := mux.Accept()
+		if err != nil {
+			log.Printf("Connection carrying streams closed: %v", err)
+			return
+		}
+		go s.serveStream(ctx, stream, endToEnd, inFlight)
+	}
+}
+
+func (s *EnclaveServer) serveStream(ctx context.Context, stream *wire.Stream, endToEnd bool, inFlight *sync.WaitGroup) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		select {
+		case <-stream.Done():
+			cancel(errStreamReset)
+		case <-ctx.Done():
+		}
+	}()
+	answer := func(response protocol.WASMResponse) error {
+		defer cancel(nil)
+		select {
+		case <-stream.Done():
+			// Given up on by the host, which no longer reads it
+			return nil
+		default:
+		}
+		err := stream.EncodeLast(response)
+		stream.Close()
+		return err
+	}
+	// Progress responses go ahead of the answer, leaving the stream open
+	progress := func(response protocol.WASMResponse) error {
+		return stream.Encode(response)
+	}
+
+	var wasmReq protocol.WASMRequest
+	if err := stream.Decode(&wasmReq); err != nil {
+		// Streams skip oversized messages, so every one can be answered
+		var payloadErr *wire.PayloadError
+		var tooLarge *wire.TooLargeError
+		if !errors.As(err, &payloadErr) {
+			slog.Warn("Stream ended without a request", "stream", stream.ID(), "error", err)
+			cancel(nil)
+			stream.Close()
+			return
+		}
+		code := protocol.ErrorCodeInvalidRequest
+		if errors.As(err, &tooLarge) {
+			code = protocol.ErrorCodeRequestTooLarge
+		}
+		slog.Warn("Rejecting malformed request", "request_id", wasmReq.RequestID, "error", err)
+		answer(protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: code})
+		return
+	}
+	s.dispatch(ctx, wasmReq, endToEnd, answer, progress, inFlight)
+}
+
+// dispatch handles one request of a connection, calling answer with its
+// response, and progress with the emits and heartbeats it asked for before
+// it;
+// requests that run are started on inFlight rather than waited for
+func (s *EnclaveServer) dispatch(ctx context.Context, wasmReq protocol.WASMRequest, endToEnd bool, answer, progress func(protocol.WASMResponse) error, inFlight *sync.WaitGroup) {
+	// Pings and health checks arrive periodically from the host; keep them
+	// out of the log
+	quiet := wasmReq.Type == protocol.RequestTypePing || wasmReq.Type == protocol.RequestTypeHealth
+	logger := logging.ForRequest(wasmReq.CorrelationID, wasmReq.RequestID)
+	if wasmReq.ClientID != "" {
+		logger = logger.With("client_id", wasmReq.ClientID)
+	}
+	if !quiet {
+		logger.Info("Received WASM execution request",
+			"function", wasmReq.FunctionName, "args", wasmReq.Args,
+			"code_length", len(wasmReq.WASMCode), "secrets", len(wasmReq.Secrets))
+	}
+
+	if endToEnd && protocol.IsAdminRequest(wasmReq.Type) {
+		logger.Warn("Refusing operator request from a TLS client", "type", wasmReq.Type)
+		answer(protocol.WASMResponse{
+			RequestID:     wasmReq.RequestID,
+			CorrelationID: wasmReq.CorrelationID,
+			Error:         fmt.Sprintf("%s requests are only accepted from the host", wasmReq.Type),
+			ErrorCode:     protocol.ErrorCodePolicy,
+		})
+		return
+	}
+
+	// While draining, new requests are refused; those admitted run to
+	// completion and are answered before the enclave exits
+	if !s.drainer.Start() {
+		logger.Warn("Refusing request while shutting down")
+		answer(protocol.WASMResponse{
+			RequestID:     wasmReq.RequestID,
+			CorrelationID: wasmReq.CorrelationID,
+			Error:         "enclave is shutting down",
+			ErrorCode:     protocol.ErrorCodeShuttingDown,
+		})
+		return
+	}
+	if wasmReq.StreamEmits {
+		ctx = withEmitter(ctx, wasmReq, progress)
+	}
+	if wasmReq.ProgressIntervalMS > 0 {
+		ctx = withHeartbeat(ctx, time.Duration(wasmReq.ProgressIntervalMS)*time.Millisecond, func(elapsed time.Duration, fuel uint64) {
+			progress(protocol.WASMResponse{
+				RequestID:     wasmReq.RequestID,
+				CorrelationID: wasmReq.CorrelationID,
+				Progress:      true,
+				ElapsedMS:     elapsed.Milliseconds(),
+				FuelConsumed:  fuel,
+			})
+		})
+	}
+	inFlight.Add(1)
+	go func() {
+		defer inFlight.Done()
+		defer s.drainer.Done()
+
+		response := s.executeRequest(ctx, logger, wasmReq)
+		if err := answer(response); err != nil {
+			logger.Error("Failed to encode response", "error", err)
+			return
+		}
+
+		if !quiet {
+			logger.Info("Response sent")
+		}
+	}()
+}
+
+// executeRequest runs a single request and builds the response tagged with its ID
+func (s *EnclaveServer) executeRequest(ctx context.Context, logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	ctx, span, spans := startTrace(ctx, wasmReq)
+	response := s.recoverResponse(logger, wasmReq, func() protocol.WASMResponse {
+		return s.handleRequest(ctx, logger, wasmReq)
+	})
+	response.CorrelationID = wasmReq.CorrelationID
+	span.SetError(response.Error)
+	span.End()
+	response.Spans = spans.Spans()
+	return response
+}
+
+func (s *EnclaveServer) handleRequest(ctx context.Context, logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	err := wasmReq.Validate()
+	if err == nil {
+		err = wasmReq.CheckSize(s.sizeLimits)
+	}
+	if err != nil {
+		logger.Warn("Rejecting request", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: protocol.ValidationCode(err)}
+	}
+
+	switch wasmReq.Type {
+	case protocol.RequestTypeHello:
+		return s.helloResponse(logger, wasmReq)
+	case protocol.RequestTypePing:
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID}
+	case protocol.RequestTypePublicKey:
+		return s.publicKeyResponse(logger, wasmReq)
+	case protocol.RequestTypeSigningKey:
+		return s.signingKeyResponse(logger, wasmReq)
+	case protocol.RequestTypeTLSCertificate:
+		return s.tlsCertificateResponse(logger, wasmReq)
+	case protocol.RequestTypeHealth:
+		return s.healthResponse(wasmReq)
+	case protocol.RequestTypeAuditLog:
+		return s.auditLogResponse(logger, wasmReq)
+	case protocol.RequestTypeModuleMeasurements:
+		return s.moduleMeasurementsResponse(logger, wasmReq)
+	case protocol.RequestTypeJobStatus:
+		return s.jobStatus(wasmReq)
+	case protocol.RequestTypeJobResult:
+		return s.jobResult(wasmReq)
+	case protocol.RequestTypeCancelJob:
+		return s.cancelJob(ctx, logger, wasmReq)
+	case protocol.RequestTypeUploadBegin:
+		return s.beginUpload(logger, wasmReq)
+	case protocol.RequestTypeUploadChunk:
+		return s.addUploadChunk(logger, wasmReq)
+	case protocol.RequestTypeUploadCommit:
+		return s.commitUpload(logger, wasmReq)
+	}
+	if protocol.IsAdminRequest(wasmReq.Type) {
+		return s.adminResponse(logger, wasmReq)
+	}
+
+	if wasmReq.Format == protocol.FormatComponent {
+		logger.Warn("Rejecting request", "error", errComponentsUnsupported)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: errComponentsUnsupported.Error(), ErrorCode: protocol.ErrorCodeInvalidRequest}
+	}
+	if err := s.resolveModule(&wasmReq); err != nil {
+		logger.Warn("Rejecting request", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: errorCode(err)}
+	}
+
+	switch wasmReq.Type {
+	case protocol.RequestTypeCreateSession:
+		return s.createSession(ctx, logger, wasmReq)
+	case protocol.RequestTypeCallSession:
+		return s.callSession(ctx, logger, wasmReq)
+	case protocol.RequestTypeDestroySession:
+		return s.destroySession(logger, wasmReq)
+	case protocol.RequestTypeReadMemory, protocol.RequestTypeWriteMemory:
+		return s.accessMemory(logger, wasmReq)
+	case protocol.RequestTypeGetGlobal, protocol.RequestTypeSetGlobal:
+		return s.accessGlobal(logger, wasmReq)
+	case protocol.RequestTypePrecompile:
+		return s.precompileResponse(ctx, logger, wasmReq)
+	case protocol.RequestTypeDescribeModule:
+		return s.describeResponse(ctx, logger, wasmReq)
+	case protocol.RequestTypeSubmitJob:
+		return s.submitJob(ctx, logger, wasmReq)
+	}
+
+	limits, secrets, module, rejection := s.prepareExecution(ctx, logger, wasmReq, s.caps)
+	if rejection != nil {
+		return *rejection
+	}
+	defer secrets.Zero()
+	return s.execute(ctx, logger, wasmReq, module, secrets, limits)
+}
+
+// prepareExecution works out the limits and secrets of an execution, and
+// the hash of its module, or the response refusing it. The caller wipes the
+// secrets once the execution is over.
+func (s *EnclaveServer) prepareExecution(ctx context.Context, logger *slog.Logger, wasmReq protocol.WASMRequest, caps ResourceCaps) (ExecutionLimits, Secrets, string, *protocol.WASMResponse) {
+	reject := func(err error, code string) (ExecutionLimits, Secrets, string, *protocol.WASMResponse) {
+		logger.Warn("Rejecting request", "error", err)
+		return ExecutionLimits{}, nil, "", &protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: code}
+	}
+
+	limits, err := requestLimits(wasmReq, caps)
+	if err != nil {
+		return reject(err, "")
+	}
+
+	module := moduleHash(wasmReq.WASMCode)
+	secrets, err := s.requestSecrets(ctx, logger, wasmReq, module)
+	if err != nil {
+		return reject(err, secretsErrorCode(err))
+	}
+	err = checkBindings(wasmReq.SecretList, module)
+	if err == nil {
+		err = s.secretPolicy.check(wasmReq.Tenant, module, wasmReq.FunctionCalls(), secretNames(secrets))
+	}
+	if err != nil {
+		secrets.Zero()
+		return reject(err, errorCode(err))
+	}
+	return limits, secrets, module, nil
+}
+
+// execute runs the calls of a request against a fresh instance of its
+// module, with secret injection
+func (s *EnclaveServer) execute(ctx context.Context, logger *slog.Logger, wasmReq protocol.WASMRequest, module string, secrets Secrets, limits ExecutionLimits) protocol.WASMResponse {
+	done := s.health.track()
+	// An execution that panics counts as failed
+	defer done(true)
+	results, stats, err := s.runtime.ExecuteWASM(ctx, logger, wasmReq.WASMCode, wasmReq.ModuleSignature, wasmReq.FunctionCalls(), secrets, limits)
+	traceExecution(ctx, wasmReq.FunctionCalls(), stats, results)
+	return s.executionResponse(logger, wasmReq, module, results, stats, err, done)
+}
+
+// executionResponse reports how the calls of a request went. A single call
+// reports its outcome at the top level; a batch fails only when it could not
+// run to the end.
+func (s *EnclaveServer) executionResponse(logger *slog.Logger, wasmReq protocol.WASMRequest, module string, results []CallResult, stats ExecutionStats, err error, done func(failed bool)) protocol.WASMResponse {
+	var result CallResult
+	batch := len(wasmReq.Calls) > 0
+	if !batch && len(results) == 1 {
+		result = results[0]
+		if err == nil {
+			err = result.Err
+		}
+	}
+	done(err != nil)
+	s.audit.record(auditEntry(wasmReq, module, err))
+
+	response := protocol.WASMResponse{
+		RequestID:       wasmReq.RequestID,
+		Result:          result.I32,
+		ResultValue:     result.Value,
+		Error:           "",
+		FuelConsumed:    stats.FuelConsumed,
+		CompileUS:       stats.CompileTime.Microseconds(),
+		ExecuteUS:       stats.ExecuteTime.Microseconds(),
+		Stdout:          stats.Stdout,
+		Stderr:          stats.Stderr,
+		OutputTruncated: stats.OutputTruncated,
+		EmitsTruncated:  stats.EmitsTruncated,
+		Fetches:         stats.Fetches,
+		Metadata:        stats.metadata(),
+	}
+	if batch {
+		response.Results = callResponses(results)
+	}
+	if err != nil {
+		response.Error = fmt.Sprintf("WASM execution failed: %v", err)
+		response.ErrorCode = errorCode(err)
+		logger.Warn("WASM execution failed", "error", err, "error_code", response.ErrorCode)
+	} else if batch {
+		logger.Info("WASM batch succeeded", "calls", len(results),
+			"compile_time", stats.CompileTime, "execute_time", stats.ExecuteTime)
+	} else {
+		logger.Info("WASM execution succeeded", "function", wasmReq.FunctionName, "result", result.I32,
+			"compile_time", stats.CompileTime, "execute_time", stats.ExecuteTime)
+	}
+
+	receipt, signErr := s.signer.Load().Sign(module, wasmReq.FunctionCalls(), results, response.Error)
+	if signErr != nil {
+		logger.Error("Failed to sign receipt", "error", signErr)
+	}
+	response.Receipt = receipt
+
+	s.attest(logger, wasmReq, &response)
+	return response
+}
+
+// attest attaches an attestation document to response if the request asked
+// for one
+func (s *EnclaveServer) attest(logger *slog.Logger, wasmReq protocol.WASMRequest, response *protocol.WASMResponse) {
+	if !wasmReq.Attest {
+		return
+	}
+	// Clients need the identity the host verified to recompute the request
+	// digest
+	response.ClientID = wasmReq.ClientID
+	attestation, err := s.attester.Attest(wasmReq, *response)
+	if err != nil {
+		logger.Error("Attestation failed", "error", err)
+		if response.Error != "" {
+			response.Error += "; "
+		}
+		response.Error += fmt.Sprintf("attestation failed: %v", err)
+	} else {
+		response.Attestation = attestation
+		logger.Info("Attached attestation document")
+	}
+}
+
+// callResponses reports the outcome of each call in a batch
+func callResponses(results []CallResult) []protocol.CallResponse {
+	responses := make([]protocol.CallResponse, len(results))
+	for i, result := range results {
+		responses[i] = protocol.CallResponse{Result: result.I32, ResultValue: result.Value}
+		if result.Err != nil {
+			responses[i].Error = result.Err.Error()
+			responses[i].ErrorCode = errorCode(result.Err)
+		}
+	}
+	return responses
+}
+
+func (s *EnclaveServer) signingKeyResponse(logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	signingKey := s.signer.Load().PublicKeyDER()
+	response := protocol.WASMResponse{
+		RequestID:  wasmReq.RequestID,
+		SigningKey: base64.StdEncoding.EncodeToString(signingKey),
+	}
+
+	attestation, err := s.attester.AttestPublicKey(signingKey, wasmReq.Nonce)
+	if err != nil {
+		logger.Warn("Could not attest signing key", "error", err)
+		response.Error = fmt.Sprintf("attestation failed: %v", err)
+		return response
+	}
+	response.Attestation = attestation
+	return response
+}
+
+// requestSecrets merges plaintext secrets with those sealed to the enclave
+// key and those decrypted through KMS. When several sources name the same
+// secret, KMS wins over sealed, and sealed wins over plaintext. The caller
+// wipes them once done. Secrets sealed to a module other than module are
+// refused with a policy error.
+func (s *EnclaveServer) requestSecrets(ctx context.Context, logger *slog.Logger, wasmReq protocol.WASMRequest, module string) (Secrets, error) {
+	secrets := make(Secrets, len(wasmReq.Secrets))
+	for name, value := range wasmReq.Secrets {
+		secrets.set(name, NewSecretBuffer([]byte(value)))
+	}
+	for _, secret := range wasmReq.SecretList {
+		if secret.Value != "" {
+			secrets.set(secret.Name, NewSecretBuffer([]byte(secret.Value)))
+		}
+	}
+
+	if wasmReq.EncryptedSecrets != "" {
+		decrypted, err := s.secretsKey.DecryptSecrets(wasmReq.EncryptedSecrets, module)
+		if err != nil {
+			secrets.Zero()
+			if errorCode(err) != "" {
+				return nil, err
+			}
+			return nil, fmt.Errorf("failed to open encrypted secrets: %v", err)
+		}
+		logger.Info("Decrypted sealed secrets", "count", len(decrypted))
+		for name, value := range decrypted {
+			secrets.set(name, value)
+		}
+	}
+
+	if len(wasmReq.KMSSecrets) > 0 {
+		decrypted, err := s.kms.DecryptSecrets(ctx, wasmReq.KMSSecrets, wasmReq.KMSEncryptionContexts, module, wasmReq.AWSCredentials)
+		if err != nil {
+			secrets.Zero()
+			return nil, err
+		}
+		logger.Info("Decrypted KMS secrets", "count", len(decrypted))
+		for name, value := range decrypted {
+			secrets.set(name, value)
+		}
+	}
+
+	// Sealed secrets could not be counted before they were opened
+	if err := s.sizeLimits.CheckSecrets(len(secrets)); err != nil {
+		secrets.Zero()
+		return nil, err
+	}
+	for _, secret := range wasmReq.SecretList {
+		if _, ok := secrets[secret.Name]; !ok {
+			secrets.Zero()
+			return nil, fmt.Errorf("secret %s in secret_list has no value", secret.Name)
+		}
+	}
+	return secrets, nil
+}
+
+// secretsErrorCode returns the error code of a failure of requestSecrets
+func secretsErrorCode(err error) string {
+	if code := errorCode(err); code != "" {
+		return code
+	}
+	return protocol.ValidationCode(err)
+}
+
+// publicKeyResponse returns the enclave public key, attested when possible
+func (s *EnclaveServer) publicKeyResponse(logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	publicKey := s.secretsKey.PublicKeyDER()
+	response := protocol.WASMResponse{
+		RequestID: wasmReq.RequestID,
+		PublicKey: base64.StdEncoding.EncodeToString(publicKey),
+	}
+
+	attestation, err := s.attester.AttestPublicKey(publicKey, wasmReq.Nonce)
+	if err != nil {
+		logger.Warn("Could not attest public key", "error", err)
+		response.Error = fmt.Sprintf("attestation failed: %v", err)
+		return response
+	}
+	response.Attestation = attestation
+	return response
+}
@@ -0,0 +1,451 @@
+package enclave
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+const (
+	// Defaults for -session-ttl and -max-sessions
+	defaultSessionTTL  = 5 * time.Minute
+	defaultMaxSessions = 16
+)
+
+// session is an instance kept alive between requests. Its limits are fixed
+// when it is created: every call gets the session's timeout, and fuel is a
+// budget for the whole session. Secrets injected into the module stay in its
+// memory until the session ends, and the session keeps the secrets for
+// get_secret until then, wiping them when it closes.
+type session struct {
+	mu       sync.Mutex
+	store    *wasmtime.Store
+	instance *wasmtime.Instance
+	capture  *outputCapture
+	fetches  *fetchLog
+	limits   ExecutionLimits
+	// Funcrefs the session's calls returned
+	refs *refHandles
+	// Only requests of the tenant that created the session reach it
+	tenant string
+	// Identifies the module in receipts for calls to the session
+	moduleHash string
+	// Names of the secrets injected, whose release policy calls must meet
+	secretNames []string
+	secrets     Secrets
+	createdAt   time.Time
+	lastUsed    time.Time
+	closed      bool
+}
+
+// close releases the session; the caller holds s.mu
+func (s *session) close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.capture != nil {
+		s.capture.discard()
+	}
+	s.secrets.Zero()
+	s.store, s.instance, s.capture, s.refs = nil, nil, nil, nil
+}
+
+// NewSession instantiates a module for a session, which takes over secrets
+// when it is created
+func (w *WASMExecutor) NewSession(ctx context.Context, logger *slog.Logger, wasmCode, signature string, secrets Secrets, limits ExecutionLimits) (*session, ExecutionStats, error) {
+	var stats ExecutionStats
+	release, err := w.workers.acquire(ctx)
+	if err != nil {
+		return nil, stats, err
+	}
+	defer release()
+	// The secrets are not the session's until it is created
+	defer func() {
+		if value := recover(); value != nil {
+			secrets.Zero()
+			panic(value)
+		}
+	}()
+
+	store, err := w.newStore(limits)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	fetches := w.egress.newLog()
+	deadline := newDeadline(ctx, store)
+	instance, capture, err := w.instantiate(logger, store, deadline, wasmCode, signature, secrets, fetches, nil, limits, &stats)
+	deadline.stop()
+	err = cancelled(ctx, err)
+	if capture != nil {
+		stats.Stdout, stats.Stderr, stats.OutputTruncated = capture.collectNew(limits.MaxOutputBytes)
+	}
+	stats.Fetches = fetches.takeNew()
+	if w.meterFuel {
+		stats.FuelConsumed, _ = store.FuelConsumed()
+	}
+	if instance != nil {
+		stats.MemoryPages = memoryPages(store, instance)
+	}
+	if err != nil {
+		if capture != nil {
+			capture.discard()
+		}
+		if fuelErr := w.fuelError(store, limits); fuelErr != nil && errorCode(err) == "" {
+			err = fuelErr
+		}
+		return nil, stats, err
+	}
+
+	return &session{
+		store:      store,
+		instance:   instance,
+		capture:    capture,
+		fetches:    fetches,
+		limits:     limits,
+		refs:       &refHandles{},
+		moduleHash: moduleHash(wasmCode),
+		secrets:    secrets,
+		createdAt:  time.Now(),
+		lastUsed:   time.Now(),
+	}, stats, nil
+}
+
+// CallSession makes calls against a session's instance. Calls to one session
+// run one at a time, and are interrupted when ctx ends.
+func (w *WASMExecutor) CallSession(ctx context.Context, logger *slog.Logger, sess *session, calls []protocol.Call) ([]CallResult, ExecutionStats, error) {
+	var stats ExecutionStats
+	release, err := w.workers.acquire(ctx)
+	if err != nil {
+		return nil, stats, err
+	}
+	defer release()
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.closed {
+		return nil, stats, fmt.Errorf("session has ended")
+	}
+	defer func() {
+		sess.lastUsed = time.Now()
+	}()
+
+	var fuelBefore uint64
+	if w.meterFuel {
+		fuelBefore, _ = sess.store.FuelConsumed()
+	}
+
+	deadline := newDeadline(ctx, sess.store)
+	deadline.start(sess.limits.Timeout)
+	start := time.Now()
+	results, err := w.runCalls(logger, sess.store, sess.instance, sess.refs, calls, sess.limits)
+	deadline.stop()
+	err = cancelled(ctx, err)
+	stats.ExecuteTime = time.Since(start)
+	stats.CallTime = stats.ExecuteTime
+	stats.MemoryPages = memoryPages(sess.store, sess.instance)
+	stats.ModuleSource = protocol.ModuleSourceSession
+
+	if sess.capture != nil {
+		stats.Stdout, stats.Stderr, stats.OutputTruncated = sess.capture.collectNew(sess.limits.MaxOutputBytes)
+	}
+	stats.Fetches = sess.fetches.takeNew()
+	if w.meterFuel {
+		consumed, _ := sess.store.FuelConsumed()
+		stats.FuelConsumed = consumed - fuelBefore
+	}
+	return results, stats, err
+}
+
+// sessionTable holds the live sessions and destroys idle ones. Each tenant
+// may have max sessions, and sees only its own.
+type sessionTable struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	ttl      time.Duration
+	max      int
+}
+
+func newSessionTable(ttl time.Duration, max int) *sessionTable {
+	t := &sessionTable{
+		sessions: make(map[string]*session),
+		ttl:      ttl,
+		max:      max,
+	}
+	if ttl > 0 {
+		go t.expire()
+	}
+	return t
+}
+
+// add registers a session and returns its ID
+func (t *sessionTable) add(sess *session) (string, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %v", err)
+	}
+	id := hex.EncodeToString(raw[:])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkCapacity(sess.tenant); err != nil {
+		return "", err
+	}
+	t.sessions[id] = sess
+	return id, nil
+}
+
+// checkCapacity fails when no session can be added for tenant; the caller
+// holds t.mu
+func (t *sessionTable) checkCapacity(tenant string) error {
+	if t.max == 0 {
+		return fmt.Errorf("sessions are disabled in this enclave")
+	}
+	alive := 0
+	for _, sess := range t.sessions {
+		if sess.tenant == tenant {
+			alive++
+		}
+	}
+	if alive >= t.max {
+		return resourceLimitError("%d sessions are already alive", alive)
+	}
+	return nil
+}
+
+// hasCapacity reports whether a session could be added for tenant right
+// now, so that requests are turned away before their module is compiled
+func (t *sessionTable) hasCapacity(tenant string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.checkCapacity(tenant)
+}
+
+// get returns a session of tenant
+func (t *sessionTable) get(tenant, id string) (*session, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sess, ok := t.sessions[id]
+	if !ok || sess.tenant != tenant {
+		return nil, false
+	}
+	return sess, true
+}
+
+// remove ends a session of tenant, waiting for a call in progress to finish
+func (t *sessionTable) remove(tenant, id string) bool {
+	t.mu.Lock()
+	sess, ok := t.sessions[id]
+	ok = ok && sess.tenant == tenant
+	if ok {
+		delete(t.sessions, id)
+	}
+	t.mu.Unlock()
+
+	if ok {
+		sess.mu.Lock()
+		sess.close()
+		sess.mu.Unlock()
+	}
+	return ok
+}
+
+// sessionHandle is what operators know a session by: its ID lets whoever
+// holds it call the session, a hash of it does not
+func sessionHandle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:16])
+}
+
+// list describes the sessions of every tenant. Sessions in the middle of a
+// call report the time it started.
+func (t *sessionTable) list() []protocol.SessionInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sessions := make([]protocol.SessionInfo, 0, len(t.sessions))
+	for id, sess := range t.sessions {
+		info := protocol.SessionInfo{
+			Handle:     sessionHandle(id),
+			Tenant:     sess.tenant,
+			ModuleHash: sess.moduleHash,
+			Secrets:    len(sess.secretNames),
+			CreatedAt:  sess.createdAt.UnixMilli(),
+		}
+		if sess.mu.TryLock() {
+			info.LastUsed = sess.lastUsed.UnixMilli()
+			sess.mu.Unlock()
+		} else {
+			info.LastUsed = time.Now().UnixMilli()
+		}
+		sessions = append(sessions, info)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt < sessions[j].CreatedAt })
+	return sessions
+}
+
+// kill ends the session of handle, whichever tenant it belongs to
+func (t *sessionTable) kill(handle string) bool {
+	t.mu.Lock()
+	for id, sess := range t.sessions {
+		if sessionHandle(id) == handle {
+			t.mu.Unlock()
+			return t.remove(sess.tenant, id)
+		}
+	}
+	t.mu.Unlock()
+	return false
+}
+
+func (t *sessionTable) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.sessions)
+}
+
+// expire destroys sessions that have been idle for longer than the TTL.
+// Sessions in the middle of a call are left alone.
+func (t *sessionTable) expire() {
+	interval := t.ttl / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.mu.Lock()
+		for id, sess := range t.sessions {
+			if !sess.mu.TryLock() {
+				continue
+			}
+			if time.Since(sess.lastUsed) > t.ttl {
+				sess.close()
+				delete(t.sessions, id)
+				slog.Info("Session expired", "session_id", id)
+			}
+			sess.mu.Unlock()
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (s *EnclaveServer) createSession(ctx context.Context, logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	if err := s.sessions.hasCapacity(wasmReq.Tenant); err != nil {
+		logger.Warn("Rejecting session", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: errorCode(err)}
+	}
+
+	limits, err := requestLimits(wasmReq, s.caps)
+	if err != nil {
+		logger.Warn("Rejecting session", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: protocol.ValidationCode(err)}
+	}
+
+	module := moduleHash(wasmReq.WASMCode)
+	secrets, err := s.requestSecrets(ctx, logger, wasmReq, module)
+	if err != nil {
+		logger.Warn("Rejecting session", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: secretsErrorCode(err)}
+	}
+	// The calls come later, and are checked then
+	names := secretNames(secrets)
+	err = checkBindings(wasmReq.SecretList, module)
+	if err == nil {
+		err = s.secretPolicy.check(wasmReq.Tenant, module, nil, names)
+	}
+	if err != nil {
+		secrets.Zero()
+		logger.Warn("Rejecting session", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: errorCode(err)}
+	}
+
+	done := s.health.track()
+	defer done(true)
+	sess, stats, err := s.executor.NewSession(ctx, logger, wasmReq.WASMCode, wasmReq.ModuleSignature, secrets, limits)
+	traceExecution(ctx, nil, stats, nil)
+	var id string
+	if err == nil {
+		sess.tenant = wasmReq.Tenant
+		sess.secretNames = names
+		if id, err = s.sessions.add(sess); err != nil {
+			sess.close()
+		}
+	} else {
+		secrets.Zero()
+	}
+	done(err != nil)
+
+	response := protocol.WASMResponse{
+		RequestID:       wasmReq.RequestID,
+		SessionID:       id,
+		FuelConsumed:    stats.FuelConsumed,
+		CompileUS:       stats.CompileTime.Microseconds(),
+		ExecuteUS:       stats.ExecuteTime.Microseconds(),
+		Stdout:          stats.Stdout,
+		Stderr:          stats.Stderr,
+		OutputTruncated: stats.OutputTruncated,
+		Fetches:         stats.Fetches,
+		Metadata:        stats.metadata(),
+	}
+	if err != nil {
+		response.Error = fmt.Sprintf("failed to create session: %v", err)
+		response.ErrorCode = errorCode(err)
+		logger.Warn("Failed to create session", "error", err, "error_code", response.ErrorCode)
+	} else {
+		logger.Info("Session created", "session_id", id, "compile_time", stats.CompileTime)
+	}
+
+	s.attest(logger, wasmReq, &response)
+	return response
+}
+
+// callSession runs calls against a session. Once a limit stops a call the
+// instance may be half way through an update, so the session ends.
+func (s *EnclaveServer) callSession(ctx context.Context, logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	logger = logger.With("session_id", wasmReq.SessionID)
+	sess, ok := s.sessions.get(wasmReq.Tenant, wasmReq.SessionID)
+	if !ok {
+		logger.Warn("Rejecting call to unknown session")
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: fmt.Sprintf("unknown session %s", wasmReq.SessionID)}
+	}
+	if err := s.secretPolicy.check(sess.tenant, sess.moduleHash, wasmReq.FunctionCalls(), sess.secretNames); err != nil {
+		logger.Warn("Rejecting call", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: errorCode(err)}
+	}
+
+	done := s.health.track()
+	defer done(true)
+	// A session whose call panicked is in no state to be called again
+	defer func() {
+		if value := recover(); value != nil {
+			s.sessions.remove(wasmReq.Tenant, wasmReq.SessionID)
+			panic(value)
+		}
+	}()
+	results, stats, err := s.executor.CallSession(ctx, logger, sess, wasmReq.FunctionCalls())
+	traceExecution(ctx, wasmReq.FunctionCalls(), stats, results)
+	if errorCode(err) != "" {
+		s.sessions.remove(wasmReq.Tenant, wasmReq.SessionID)
+		logger.Info("Session ended by a limit")
+	}
+	return s.executionResponse(logger, wasmReq, sess.moduleHash, results, stats, err, done)
+}
+
+func (s *EnclaveServer) destroySession(logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	if !s.sessions.remove(wasmReq.Tenant, wasmReq.SessionID) {
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: fmt.Sprintf("unknown session %s", wasmReq.SessionID)}
+	}
+	logger.Info("Session destroyed", "session_id", wasmReq.SessionID)
+	return protocol.WASMResponse{RequestID: wasmReq.RequestID}
+}
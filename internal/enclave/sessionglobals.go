@@ -0,0 +1,124 @@
+package enclave
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// kindTypes names the value types of globals and module signatures
+var kindTypes = map[wasmtime.ValKind]string{
+	wasmtime.KindI32:       protocol.ValueI32,
+	wasmtime.KindI64:       protocol.ValueI64,
+	wasmtime.KindF32:       protocol.ValueF32,
+	wasmtime.KindF64:       protocol.ValueF64,
+	wasmtime.KindExternref: protocol.ValueExternref,
+	wasmtime.KindFuncref:   protocol.ValueFuncref,
+}
+
+// global returns the exported global name of a session's instance and the
+// type of its value; the caller holds s.mu
+func (s *session) global(name string) (*wasmtime.Global, string, error) {
+	if s.closed {
+		return nil, "", fmt.Errorf("session has ended")
+	}
+	export := s.instance.GetExport(s.store, name)
+	if export == nil || export.Global() == nil {
+		return nil, "", fmt.Errorf("the module exports no global %q", name)
+	}
+	global := export.Global()
+	valueType, ok := kindTypes[global.Type(s.store).Content().Kind()]
+	if !ok {
+		return nil, "", fmt.Errorf("global %q has a type this enclave cannot read", name)
+	}
+	return global, valueType, nil
+}
+
+// getGlobal returns the value of an exported global of a session
+func (s *session) getGlobal(name string) (protocol.Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	global, valueType, err := s.global(name)
+	if err != nil {
+		return protocol.Value{}, err
+	}
+	s.lastUsed = time.Now()
+	value := global.Get(s.store).Get()
+	if ref, ok, err := s.refs.value(value, valueType); ok || err != nil {
+		return ref, err
+	}
+	return protocol.EncodeValue(value)
+}
+
+// setGlobal sets an exported mutable global of a session, and returns its
+// new value
+func (s *session) setGlobal(name string, value protocol.Value) (protocol.Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	global, valueType, err := s.global(name)
+	if err != nil {
+		return protocol.Value{}, err
+	}
+	if !global.Type(s.store).Mutable() {
+		return protocol.Value{}, fmt.Errorf("global %q is immutable", name)
+	}
+	if value.Type != valueType {
+		return protocol.Value{}, fmt.Errorf("global %q holds %s, not %s", name, valueType, value.Type)
+	}
+
+	// Validate checked the value
+	decoded, _ := value.Decode()
+	var val wasmtime.Val
+	switch x := decoded.(type) {
+	case int32:
+		val = wasmtime.ValI32(x)
+	case int64:
+		val = wasmtime.ValI64(x)
+	case float32:
+		val = wasmtime.ValF32(x)
+	case float64:
+		val = wasmtime.ValF64(x)
+	case protocol.Ref:
+		if val, err = s.refs.reference(s.store, s.instance, x); err != nil {
+			return protocol.Value{}, err
+		}
+	}
+	if err := global.Set(s.store, val); err != nil {
+		return protocol.Value{}, fmt.Errorf("failed to set global %q: %v", name, err)
+	}
+	s.lastUsed = time.Now()
+	return value, nil
+}
+
+// accessGlobal answers get_global and set_global requests, which like
+// memory accesses run between calls. Globals of sessions holding secrets
+// cannot be read, since secrets may have been injected as globals.
+func (s *EnclaveServer) accessGlobal(logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	logger = logger.With("session_id", wasmReq.SessionID, "global", wasmReq.Global)
+	sess, ok := s.sessions.get(wasmReq.Tenant, wasmReq.SessionID)
+	if !ok {
+		logger.Warn("Rejecting global access to unknown session")
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: fmt.Sprintf("unknown session %s", wasmReq.SessionID)}
+	}
+
+	var value protocol.Value
+	var err error
+	switch {
+	case wasmReq.Type == protocol.RequestTypeSetGlobal:
+		value, err = sess.setGlobal(wasmReq.Global, *wasmReq.GlobalValue)
+	case len(sess.secretNames) > 0:
+		err = policyError("the globals of a session holding secrets cannot be read")
+	default:
+		value, err = sess.getGlobal(wasmReq.Global)
+	}
+	if err != nil {
+		logger.Warn("Rejecting global access", "type", wasmReq.Type, "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: errorCode(err)}
+	}
+	logger.Debug("Session global accessed", "type", wasmReq.Type)
+	return protocol.WASMResponse{RequestID: wasmReq.RequestID, ResultValue: &value}
+}
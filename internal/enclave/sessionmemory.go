@@ -0,0 +1,102 @@
+package enclave
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// memory returns the exported memory name of a session's instance and its
+// bytes, checking that [offset, offset+length) lies inside them; the caller
+// holds s.mu
+func (s *session) memory(name string, offset uint64, length int) ([]byte, error) {
+	if s.closed {
+		return nil, fmt.Errorf("session has ended")
+	}
+	if name == "" {
+		name = secretMemoryExport
+	}
+	export := s.instance.GetExport(s.store, name)
+	var memory *wasmtime.Memory
+	if export != nil {
+		memory = export.Memory()
+	}
+	if memory == nil {
+		return nil, fmt.Errorf("the module exports no memory %q", name)
+	}
+	data := memory.UnsafeData(s.store)
+	if offset > uint64(len(data)) || uint64(length) > uint64(len(data))-offset {
+		return nil, fmt.Errorf("%d bytes at offset %d are outside memory %q of %d bytes", length, offset, name, len(data))
+	}
+	return data, nil
+}
+
+// readMemory copies length bytes at offset out of an exported memory of a
+// session, and returns them with the size of the memory
+func (s *session) readMemory(name string, offset uint64, length uint32) ([]byte, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := s.memory(name, offset, int(length))
+	if err != nil {
+		return nil, 0, err
+	}
+	s.lastUsed = time.Now()
+	out := make([]byte, length)
+	copy(out, data[offset:])
+	return out, uint64(len(data)), nil
+}
+
+// writeMemory copies bytes into an exported memory of a session at offset,
+// and returns the size of the memory
+func (s *session) writeMemory(name string, offset uint64, bytes []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := s.memory(name, offset, len(bytes))
+	if err != nil {
+		return 0, err
+	}
+	s.lastUsed = time.Now()
+	copy(data[offset:], bytes)
+	return uint64(len(data)), nil
+}
+
+// accessMemory answers read_memory and write_memory requests. Memory
+// accesses wait for a call in progress and run between calls, so a module
+// sees its memory change only while it is not running. Sessions holding
+// secrets cannot be read, since the module may have copied them anywhere in
+// its memory.
+func (s *EnclaveServer) accessMemory(logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	logger = logger.With("session_id", wasmReq.SessionID)
+	sess, ok := s.sessions.get(wasmReq.Tenant, wasmReq.SessionID)
+	if !ok {
+		logger.Warn("Rejecting memory access to unknown session")
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: fmt.Sprintf("unknown session %s", wasmReq.SessionID)}
+	}
+
+	response := protocol.WASMResponse{RequestID: wasmReq.RequestID}
+	var err error
+	if wasmReq.Type == protocol.RequestTypeReadMemory {
+		if len(sess.secretNames) > 0 {
+			err = policyError("the memory of a session holding secrets cannot be read")
+		} else {
+			var data []byte
+			data, response.MemorySize, err = sess.readMemory(wasmReq.Memory, wasmReq.Offset, wasmReq.Length)
+			response.Data = base64.StdEncoding.EncodeToString(data)
+		}
+	} else {
+		// Validate checked the encoding
+		data, _ := base64.StdEncoding.DecodeString(wasmReq.Data)
+		response.MemorySize, err = sess.writeMemory(wasmReq.Memory, wasmReq.Offset, data)
+	}
+	if err != nil {
+		logger.Warn("Rejecting memory access", "type", wasmReq.Type, "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: errorCode(err)}
+	}
+	logger.Debug("Session memory accessed", "type", wasmReq.Type, "offset", wasmReq.Offset, "memory_size", response.MemorySize)
+	return response
+}
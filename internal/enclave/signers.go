@@ -0,0 +1,104 @@
+package enclave
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// trustedSigners are the publishers whose modules the enclave runs. A module
+// is signed by a detached Ed25519 signature over wasm_code exactly as sent,
+// e.g. from
+//
+//	openssl pkeyutl -sign -inkey publisher.pem -rawin -in module.wat -out module.sig
+//
+// so that module publishers and enclave operators can be different parties.
+type trustedSigners []ed25519.PublicKey
+
+// loadTrustedSigners reads PEM PUBLIC KEY blocks holding Ed25519 keys
+func loadTrustedSigners(path string) (trustedSigners, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trusted signers: %v", err)
+	}
+
+	var signers trustedSigners
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("trusted signer %d: %v", len(signers)+1, err)
+		}
+		key, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("trusted signer %d is %T, not Ed25519", len(signers)+1, parsed)
+		}
+		signers = append(signers, key)
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no public keys in %s", path)
+	}
+	return signers, nil
+}
+
+// verify reports whether one of the signers signed wasmCode
+func (t trustedSigners) verify(wasmCode, signature string) bool {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	for _, key := range t {
+		if ed25519.Verify(key, []byte(wasmCode), sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// modulePolicy decides which modules may run. With neither an allowlist nor
+// trusted signers every module may; otherwise a module must be on the
+// allowlist or carry a signature from a trusted signer.
+type modulePolicy struct {
+	allowlist moduleAllowlist
+	signers   trustedSigners
+}
+
+// enforced reports whether only some modules may run
+func (p modulePolicy) enforced() bool {
+	return p.allowlist != nil || p.signers != nil
+}
+
+func (p modulePolicy) check(wasmCode, signature string) error {
+	if !p.enforced() {
+		return nil
+	}
+	if p.allowlist[moduleHash(wasmCode)] {
+		return nil
+	}
+	if p.signers != nil {
+		if signature == "" {
+			return policyError("module %s has no module_signature", moduleHash(wasmCode))
+		}
+		if p.signers.verify(wasmCode, signature) {
+			return nil
+		}
+		return policyError("module_signature of %s is not from a trusted signer", moduleHash(wasmCode))
+	}
+	return policyError("module %s is not on the allowlist", moduleHash(wasmCode))
+}
+
+func policyError(format string, args ...interface{}) error {
+	return &LimitError{Code: protocol.ErrorCodePolicy, Message: fmt.Sprintf(format, args...)}
+}
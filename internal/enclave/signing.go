@@ -0,0 +1,78 @@
+package enclave
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// ReceiptSigner signs execution receipts with an Ed25519 key generated at
+// startup. Like EnclaveKey it never leaves enclave memory; its public half
+// is published in an attestation document.
+type ReceiptSigner struct {
+	private   ed25519.PrivateKey
+	publicDER []byte
+}
+
+func NewReceiptSigner(random io.Reader) (*ReceiptSigner, error) {
+	public, private, err := ed25519.GenerateKey(random)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %v", err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signing key: %v", err)
+	}
+
+	return &ReceiptSigner{private: private, publicDER: publicDER}, nil
+}
+
+// PublicKeyDER returns the PKIX DER encoding of the public key
+func (s *ReceiptSigner) PublicKeyDER() []byte {
+	return s.publicDER
+}
+
+// Sign returns a signed receipt for calls made against a module. Results
+// line up with the start of calls; calls after the last result never ran.
+func (s *ReceiptSigner) Sign(moduleHash string, calls []protocol.Call, results []CallResult, failure string) (*protocol.Receipt, error) {
+	receipt := &protocol.Receipt{
+		ModuleHash: moduleHash,
+		Calls:      make([]protocol.ReceiptCall, len(results)),
+		Error:      failure,
+		Timestamp:  time.Now().UnixMilli(),
+	}
+	for i, result := range results {
+		call := protocol.ReceiptCall{FunctionName: calls[i].Target(), Args: calls[i].Values()}
+		switch {
+		case result.Err != nil:
+			call.Error = result.Err.Error()
+		case result.Value != nil:
+			call.Result = result.Value
+		default:
+			value, _ := protocol.EncodeValue(result.I32)
+			call.Result = &value
+		}
+		receipt.Calls[i] = call
+	}
+
+	digest, err := receipt.Digest()
+	if err != nil {
+		return nil, err
+	}
+	receipt.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.private, digest[:]))
+	return receipt, nil
+}
+
+// moduleHash identifies a module in receipts
+func moduleHash(wasmCode string) string {
+	sum := sha256.Sum256([]byte(wasmCode))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,98 @@
+package enclave
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Validity of the self-signed certificate; it is regenerated on every start
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// enclaveTLSConfig returns the configuration of the end-to-end TLS listener
+// and its leaf certificate. Without certFile it uses a self-signed
+// certificate whose key is generated here and never leaves the enclave;
+// clients trust it by checking it against the attested tls_certificate
+// response.
+func enclaveTLSConfig(certFile, keyFile string, random io.Reader) (*tls.Config, *x509.Certificate, error) {
+	var cert tls.Certificate
+	var err error
+	if certFile != "" {
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+		}
+	} else if cert, err = selfSignedCertificate(random); err != nil {
+		return nil, nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse TLS certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+	}
+	return config, leaf, nil
+}
+
+func selfSignedCertificate(random io.Reader) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), random)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate TLS key: %v", err)
+	}
+	serial, err := rand.Int(random, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate certificate serial: %v", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "hello-wasm-enclave"},
+		DNSNames:     []string{"hello-wasm-enclave"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(random, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create TLS certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// tlsCertificateResponse returns the certificate of the TLS listener with an
+// attestation document over its public key, so a client can tell that the
+// TLS session it holds ends inside this enclave
+func (s *EnclaveServer) tlsCertificateResponse(logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	if s.tlsCert == nil {
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: "end-to-end TLS is not enabled"}
+	}
+	response := protocol.WASMResponse{
+		RequestID:      wasmReq.RequestID,
+		TLSCertificate: base64.StdEncoding.EncodeToString(s.tlsCert.Raw),
+	}
+
+	attestation, err := s.attester.AttestPublicKey(s.tlsCert.RawSubjectPublicKeyInfo, wasmReq.Nonce)
+	if err != nil {
+		logger.Warn("Could not attest TLS key", "error", err)
+		response.Error = fmt.Sprintf("attestation failed: %v", err)
+		return response
+	}
+	response.Attestation = attestation
+	return response
+}
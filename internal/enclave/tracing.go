@@ -0,0 +1,47 @@
+package enclave
+
+import (
+	"context"
+	"strconv"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/tracing"
+)
+
+// Service the enclave's spans are recorded as
+const traceService = "hello-wasm-enclave"
+
+// startTrace begins the enclave's span of a request the host traces. The
+// enclave exports nothing itself: the spans come back in the response, for
+// the host to export with its own.
+func startTrace(ctx context.Context, wasmReq protocol.WASMRequest) (context.Context, *tracing.Span, *tracing.Collector) {
+	if wasmReq.TraceParent == "" {
+		return ctx, nil, nil
+	}
+	parent, err := tracing.Parse(wasmReq.TraceParent)
+	if err != nil || !parent.Sampled {
+		return ctx, nil, nil
+	}
+	spans := &tracing.Collector{}
+	ctx, span := tracing.Start(tracing.WithParent(ctx, parent, traceService, spans), "enclave")
+	if wasmReq.Type != protocol.RequestTypeExecute {
+		span.SetAttributes("request_type", wasmReq.Type)
+	}
+	return ctx, span, spans
+}
+
+// traceExecution records the phases of an execution, as its stats and
+// results timed them, under the span in ctx
+func traceExecution(ctx context.Context, calls []protocol.Call, stats ExecutionStats, results []CallResult) {
+	if !stats.CompileStart.IsZero() {
+		tracing.Record(ctx, "compile", stats.CompileStart, stats.CompileTime, "module_source", stats.ModuleSource)
+	}
+	if !stats.InstantiateStart.IsZero() {
+		tracing.Record(ctx, "instantiate", stats.InstantiateStart, stats.InstantiateTime)
+	}
+	for i, result := range results {
+		if i < len(calls) {
+			tracing.Record(ctx, "call", result.Started, result.Duration, "function", calls[i].Target(), "call", strconv.Itoa(i))
+		}
+	}
+}
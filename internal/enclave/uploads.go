@@ -0,0 +1,264 @@
+package enclave
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+const (
+	// Defaults for -max-uploads and -upload-ttl
+	defaultMaxUploads = 4
+	defaultUploadTTL  = 10 * time.Minute
+)
+
+// upload is wasm_code sent in chunks, too large for one request. Once
+// committed it is the module requests run by its upload ID.
+type upload struct {
+	// Only requests of the tenant that began the upload reach it
+	tenant string
+	size   uint64
+	digest string
+
+	mu   sync.Mutex
+	code strings.Builder
+	hash hash.Hash
+	// The whole of code, once its digest was checked
+	committed string
+	done      bool
+	used      time.Time
+}
+
+// add appends the chunk at offset. A chunk that repeats what the upload
+// already holds is taken as a retry and leaves it as it is.
+func (u *upload) add(id string, offset uint64, data string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.used = time.Now()
+	if u.done {
+		return fmt.Errorf("upload %s is already committed", id)
+	}
+	received := uint64(u.code.Len())
+	end := offset + uint64(len(data))
+	if offset < received && end <= received && u.code.String()[offset:end] == data {
+		return nil
+	}
+	if offset != received {
+		return fmt.Errorf("upload %s holds %d bytes; its next chunk goes at offset %d, not %d", id, received, received, offset)
+	}
+	if end > u.size {
+		return fmt.Errorf("chunk ends at byte %d of upload %s, which began as %d bytes", end, id, u.size)
+	}
+	u.code.WriteString(data)
+	u.hash.Write([]byte(data))
+	return nil
+}
+
+// commit checks that the upload is whole and has the digest it began with
+func (u *upload) commit(id string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.used = time.Now()
+	if u.done {
+		return nil
+	}
+	if received := uint64(u.code.Len()); received != u.size {
+		return fmt.Errorf("upload %s holds %d of its %d bytes", id, received, u.size)
+	}
+	if digest := hex.EncodeToString(u.hash.Sum(nil)); digest != u.digest {
+		return fmt.Errorf("upload %s has SHA-256 %s, not the module_hash %s it began with", id, digest, u.digest)
+	}
+	// The builder's buffer becomes the string without a copy
+	u.committed = u.code.String()
+	u.code.Reset()
+	u.hash = nil
+	u.done = true
+	return nil
+}
+
+func (u *upload) received() uint64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.done {
+		return u.size
+	}
+	return uint64(u.code.Len())
+}
+
+// uploadTable holds uploads in progress and committed ones, up to max of
+// them at once so that their buffers stay bounded, and forgets those no
+// request used for the TTL. Committed uploads make way for new ones.
+type uploadTable struct {
+	mu      sync.Mutex
+	uploads map[string]*upload
+	ttl     time.Duration
+	max     int
+}
+
+func newUploadTable(ttl time.Duration, max int) *uploadTable {
+	t := &uploadTable{
+		uploads: make(map[string]*upload),
+		ttl:     ttl,
+		max:     max,
+	}
+	if ttl > 0 {
+		go t.expire()
+	}
+	return t
+}
+
+// begin starts an upload of size bytes with digest for tenant, reserving
+// its buffer, and returns its ID
+func (t *uploadTable) begin(tenant string, size uint64, digest string) (string, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", fmt.Errorf("failed to generate upload ID: %v", err)
+	}
+	id := hex.EncodeToString(raw[:])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.max == 0 {
+		return "", fmt.Errorf("uploads are disabled in this enclave")
+	}
+	if len(t.uploads) >= t.max && !t.evict() {
+		return "", resourceLimitError("%d uploads are already in progress", len(t.uploads))
+	}
+	u := &upload{tenant: tenant, size: size, digest: strings.ToLower(digest), hash: sha256.New(), used: time.Now()}
+	u.code.Grow(int(size))
+	t.uploads[id] = u
+	return id, nil
+}
+
+// evict makes room by forgetting the committed upload used least recently,
+// and reports whether there was one; the caller holds t.mu. Uploads in
+// progress are left for their clients to finish.
+func (t *uploadTable) evict() bool {
+	var oldest string
+	var oldestUsed time.Time
+	for id, u := range t.uploads {
+		u.mu.Lock()
+		done, used := u.done, u.used
+		u.mu.Unlock()
+		if done && (oldest == "" || used.Before(oldestUsed)) {
+			oldest, oldestUsed = id, used
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	delete(t.uploads, oldest)
+	slog.Info("Upload evicted to make room", "upload_id", oldest)
+	return true
+}
+
+// get returns an upload of tenant
+func (t *uploadTable) get(tenant, id string) (*upload, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u, ok := t.uploads[id]
+	if !ok || u.tenant != tenant {
+		return nil, false
+	}
+	return u, true
+}
+
+func (t *uploadTable) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.uploads, id)
+}
+
+// code returns the wasm_code of a committed upload of tenant
+func (t *uploadTable) code(tenant, id string) (string, error) {
+	u, ok := t.get(tenant, id)
+	if !ok {
+		return "", fmt.Errorf("unknown upload %s", id)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.done {
+		return "", fmt.Errorf("upload %s is not committed", id)
+	}
+	u.used = time.Now()
+	return u.committed, nil
+}
+
+// expire forgets uploads, finished or not, that no request used for the TTL
+func (t *uploadTable) expire() {
+	interval := t.ttl / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.mu.Lock()
+		for id, u := range t.uploads {
+			u.mu.Lock()
+			expired := time.Since(u.used) > t.ttl
+			u.mu.Unlock()
+			if expired {
+				delete(t.uploads, id)
+				slog.Info("Upload expired", "upload_id", id)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// beginUpload starts an upload of a module sent in chunks
+func (s *EnclaveServer) beginUpload(logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	var id string
+	err := policyError("module upload is disabled; run a preloaded module by module_name")
+	if s.moduleUpload {
+		id, err = s.uploads.begin(wasmReq.Tenant, wasmReq.UploadSize, wasmReq.ModuleHash)
+	}
+	if err != nil {
+		logger.Warn("Rejecting upload", "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), ErrorCode: errorCode(err)}
+	}
+	logger.Info("Upload started", "upload_id", id, "bytes", wasmReq.UploadSize, "module_hash", wasmReq.ModuleHash)
+	return protocol.WASMResponse{RequestID: wasmReq.RequestID, UploadID: id}
+}
+
+// addUploadChunk appends a chunk to an upload. Failed chunks are answered
+// with what the upload holds, for the client to carry on from there.
+func (s *EnclaveServer) addUploadChunk(logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	u, ok := s.uploads.get(wasmReq.Tenant, wasmReq.UploadID)
+	if !ok {
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: fmt.Sprintf("unknown upload %s", wasmReq.UploadID)}
+	}
+	if err := u.add(wasmReq.UploadID, wasmReq.Offset, wasmReq.Data); err != nil {
+		logger.Warn("Rejecting upload chunk", "upload_id", wasmReq.UploadID, "offset", wasmReq.Offset, "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), Received: u.received()}
+	}
+	return protocol.WASMResponse{RequestID: wasmReq.RequestID, Received: u.received()}
+}
+
+// commitUpload checks an upload and makes it runnable. An upload whose
+// digest does not match is dropped, since no chunk can repair it.
+func (s *EnclaveServer) commitUpload(logger *slog.Logger, wasmReq protocol.WASMRequest) protocol.WASMResponse {
+	u, ok := s.uploads.get(wasmReq.Tenant, wasmReq.UploadID)
+	if !ok {
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: fmt.Sprintf("unknown upload %s", wasmReq.UploadID)}
+	}
+	if err := u.commit(wasmReq.UploadID); err != nil {
+		if u.received() == u.size {
+			s.uploads.remove(wasmReq.UploadID)
+		}
+		logger.Warn("Rejecting upload commit", "upload_id", wasmReq.UploadID, "error", err)
+		return protocol.WASMResponse{RequestID: wasmReq.RequestID, Error: err.Error(), Received: u.received()}
+	}
+	logger.Info("Upload committed", "upload_id", wasmReq.UploadID, "bytes", u.size, "module_hash", u.digest)
+	return protocol.WASMResponse{RequestID: wasmReq.RequestID, UploadID: wasmReq.UploadID, Received: u.size}
+}
@@ -0,0 +1,128 @@
+package enclave
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/bytecodealliance/wasmtime-go"
+)
+
+const (
+	// Modules importing from this namespace get a WASI environment
+	wasiNamespace = "wasi_snapshot_preview1"
+	// Default for -max-output-bytes
+	defaultMaxOutputBytes = 64 << 10
+)
+
+// proc_exit unwinds the call with a trap carrying this message
+var wasiExitPattern = regexp.MustCompile(`^Exited with i32 exit status (-?\d+)`)
+
+// wasiExitStatus reports whether err is a WASI proc_exit, and its status
+func wasiExitStatus(err error) (int32, bool) {
+	var trap *wasmtime.Trap
+	if !errors.As(err, &trap) {
+		return 0, false
+	}
+	match := wasiExitPattern.FindStringSubmatch(trap.Message())
+	if match == nil {
+		return 0, false
+	}
+	status, err := strconv.ParseInt(match[1], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int32(status), true
+}
+
+// importsWASI reports whether a module needs WASI
+func importsWASI(module *wasmtime.Module) bool {
+	for _, imp := range module.Imports() {
+		if imp.Module() == wasiNamespace {
+			return true
+		}
+	}
+	return false
+}
+
+// outputCapture collects what a WASI module writes to stdout and stderr.
+// wasmtime-go can only redirect them to files, so each execution writes to
+// files in a private temporary directory that collect reads and removes.
+// The module gets no arguments, environment, stdin or filesystem access.
+type outputCapture struct {
+	dir string
+	// How much of each file has been returned already
+	stdoutRead, stderrRead int64
+}
+
+// captureOutput gives store a WASI environment with captured stdout/stderr
+func captureOutput(store *wasmtime.Store) (*outputCapture, error) {
+	dir, err := os.MkdirTemp("", "wasi-output-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %v", err)
+	}
+	c := &outputCapture{dir: dir}
+
+	config := wasmtime.NewWasiConfig()
+	if err := config.SetStdoutFile(c.path("stdout")); err != nil {
+		c.discard()
+		return nil, fmt.Errorf("failed to capture stdout: %v", err)
+	}
+	if err := config.SetStderrFile(c.path("stderr")); err != nil {
+		c.discard()
+		return nil, fmt.Errorf("failed to capture stderr: %v", err)
+	}
+	store.SetWasi(config)
+	return c, nil
+}
+
+func (c *outputCapture) path(name string) string {
+	return filepath.Join(c.dir, name)
+}
+
+// collect returns up to maxBytes of each stream, reporting whether either had
+// to be cut short, and removes the files
+func (c *outputCapture) collect(maxBytes int) (stdout, stderr string, truncated bool) {
+	defer c.discard()
+	return c.collectNew(maxBytes)
+}
+
+// collectNew is like collect but keeps capturing, and returns only what was
+// written since the previous call. Sessions use it after every call.
+func (c *outputCapture) collectNew(maxBytes int) (stdout, stderr string, truncated bool) {
+	stdout, cutOut := readBounded(c.path("stdout"), &c.stdoutRead, maxBytes)
+	stderr, cutErr := readBounded(c.path("stderr"), &c.stderrRead, maxBytes)
+	return stdout, stderr, cutOut || cutErr
+}
+
+func (c *outputCapture) discard() {
+	os.RemoveAll(c.dir)
+}
+
+// readBounded reads up to maxBytes from offset on, and moves offset past
+// everything written so far, including what did not fit
+func readBounded(path string, offset *int64, maxBytes int) (string, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.Size() <= *offset {
+		return "", false
+	}
+	start := *offset
+	*offset = info.Size()
+
+	// One byte past the limit tells whether there was more
+	data, _ := io.ReadAll(io.NewSectionReader(f, start, int64(maxBytes)+1))
+	if len(data) > maxBytes {
+		return string(data[:maxBytes]), true
+	}
+	return string(data), false
+}
@@ -0,0 +1,257 @@
+package enclave
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// wasmtime-go has no store limiter, so memory and table limits are enforced
+// by rewriting the module before it is compiled: every defined memory and
+// table gets a maximum no larger than the request allows. memory.grow and
+// table.grow past it fail by returning -1, as they would under a limiter.
+
+const (
+	wasmSectionImport = 2
+	wasmSectionTable  = 4
+	wasmSectionMemory = 5
+
+	wasmImportTable  = 1
+	wasmImportMemory = 2
+
+	limitsHasMax = 0x01
+	limitsIs64   = 0x04
+)
+
+// applyResourceLimits returns wasm with memory and table maximums clamped to
+// limits, or a resource limit error if the module cannot fit within them
+func applyResourceLimits(wasm []byte, limits ExecutionLimits) ([]byte, error) {
+	if len(wasm) < 8 || !bytes.Equal(wasm[:4], []byte("\x00asm")) {
+		return nil, fmt.Errorf("not a WASM binary")
+	}
+
+	out := append([]byte{}, wasm[:8]...)
+	r := &wasmReader{data: wasm, pos: 8}
+	tables, memories := 0, 0
+
+	for r.pos < len(r.data) {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		content, err := r.bytes(int(size))
+		if err != nil {
+			return nil, err
+		}
+
+		switch id {
+		case wasmSectionImport:
+			t, m, err := countImportedResources(content)
+			if err != nil {
+				return nil, err
+			}
+			tables += t
+			memories += m
+		case wasmSectionTable:
+			var count int
+			content, count, err = clampSection(content, true, limits.MaxTableElements)
+			if err != nil {
+				return nil, err
+			}
+			tables += count
+		case wasmSectionMemory:
+			var count int
+			content, count, err = clampSection(content, false, limits.MaxMemoryPages)
+			if err != nil {
+				return nil, err
+			}
+			memories += count
+		}
+
+		out = append(out, id)
+		out = appendU32(out, uint32(len(content)))
+		out = append(out, content...)
+	}
+
+	if tables > limits.MaxTables {
+		return nil, resourceLimitError("module declares %d tables, limit is %d", tables, limits.MaxTables)
+	}
+	if memories > limits.Features.maxMemories() {
+		return nil, resourceLimitError("module declares %d memories, limit is %d", memories, limits.Features.maxMemories())
+	}
+	return out, nil
+}
+
+// clampSection rewrites the limits of each entry in a table or memory section
+func clampSection(content []byte, isTable bool, max uint32) ([]byte, int, error) {
+	kind := "memory"
+	if isTable {
+		kind = "table"
+	}
+
+	r := &wasmReader{data: content}
+	count, err := r.u32()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	out := appendU32(nil, count)
+	for i := uint32(0); i < count; i++ {
+		if isTable {
+			refType, err := r.byte()
+			if err != nil {
+				return nil, 0, err
+			}
+			out = append(out, refType)
+		}
+
+		flags, err := r.byte()
+		if err != nil {
+			return nil, 0, err
+		}
+		if flags&limitsIs64 != 0 {
+			return nil, 0, fmt.Errorf("64-bit %s limits are not supported", kind)
+		}
+		min, err := r.u32()
+		if err != nil {
+			return nil, 0, err
+		}
+		declaredMax := max
+		if flags&limitsHasMax != 0 {
+			if declaredMax, err = r.u32(); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		if min > max {
+			return nil, 0, resourceLimitError("%s %d starts at %d, limit is %d", kind, i, min, max)
+		}
+		if declaredMax > max {
+			declaredMax = max
+		}
+
+		out = append(out, flags|limitsHasMax)
+		out = appendU32(out, min)
+		out = appendU32(out, declaredMax)
+	}
+	if r.pos != len(r.data) {
+		return nil, 0, fmt.Errorf("malformed %s section", kind)
+	}
+	return out, int(count), nil
+}
+
+// countImportedResources counts the tables and memories a module imports
+func countImportedResources(content []byte) (int, int, error) {
+	r := &wasmReader{data: content}
+	count, err := r.u32()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tables, memories := 0, 0
+	for i := uint32(0); i < count; i++ {
+		for j := 0; j < 2; j++ { // module and field names
+			n, err := r.u32()
+			if err != nil {
+				return 0, 0, err
+			}
+			if _, err := r.bytes(int(n)); err != nil {
+				return 0, 0, err
+			}
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return 0, 0, err
+		}
+		switch kind {
+		case 0: // func: type index
+			_, err = r.u32()
+		case wasmImportTable:
+			tables++
+			if _, err = r.byte(); err == nil {
+				err = r.skipLimits()
+			}
+		case wasmImportMemory:
+			memories++
+			err = r.skipLimits()
+		case 3: // global: value type and mutability
+			_, err = r.bytes(2)
+		default:
+			err = fmt.Errorf("unknown import kind %d", kind)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return tables, memories, nil
+}
+
+type wasmReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *wasmReader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, fmt.Errorf("unexpected end of WASM binary")
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *wasmReader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, fmt.Errorf("unexpected end of WASM binary")
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// u32 reads an unsigned LEB128 value
+func (r *wasmReader) u32() (uint32, error) {
+	var value uint32
+	for shift := uint(0); shift < 35; shift += 7 {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint32(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("LEB128 value too long")
+}
+
+func (r *wasmReader) skipLimits() error {
+	flags, err := r.byte()
+	if err != nil {
+		return err
+	}
+	if flags&limitsIs64 != 0 {
+		return fmt.Errorf("64-bit limits are not supported")
+	}
+	if _, err := r.u32(); err != nil {
+		return err
+	}
+	if flags&limitsHasMax != 0 {
+		_, err = r.u32()
+	}
+	return err
+}
+
+func appendU32(out []byte, value uint32) []byte {
+	for {
+		b := byte(value & 0x7f)
+		value >>= 7
+		if value == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
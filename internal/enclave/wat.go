@@ -0,0 +1,420 @@
+package enclave
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// watNode is an atom, a string or a list of WAT source, with the bytes of
+// the source it spans, so that rewrites can leave everything else, comments
+// and layout included, exactly as written
+type watNode struct {
+	start, end int
+	// An atom, or a string with its escapes decoded
+	text     string
+	isString bool
+	// Children of a list
+	list   []*watNode
+	isList bool
+}
+
+// head is the keyword a list starts with, if any
+func (n *watNode) head() string {
+	if !n.isList || len(n.list) == 0 || n.list[0].isList || n.list[0].isString {
+		return ""
+	}
+	return n.list[0].text
+}
+
+// parseWAT splits WAT source into its top-level s-expressions. It knows
+// nothing of WebAssembly, only of lists, atoms, strings and comments.
+func parseWAT(src string) ([]*watNode, error) {
+	root := &watNode{isList: true}
+	stack := []*watNode{root}
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], ";;"):
+			if end := strings.IndexByte(src[i:], '\n'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(src)
+			}
+		case strings.HasPrefix(src[i:], "(;"):
+			end, err := skipBlockComment(src, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case c == '(':
+			list := &watNode{start: i, isList: true}
+			parent := stack[len(stack)-1]
+			parent.list = append(parent.list, list)
+			stack = append(stack, list)
+			i++
+		case c == ')':
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("line %d: unexpected )", watLine(src, i))
+			}
+			i++
+			stack[len(stack)-1].end = i
+			stack = stack[:len(stack)-1]
+		case c == '"':
+			node, err := parseWATString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			stack[len(stack)-1].list = append(stack[len(stack)-1].list, node)
+			i = node.end
+		case c == ';':
+			return nil, fmt.Errorf("line %d: unexpected ;", watLine(src, i))
+		default:
+			end := i
+			for end < len(src) && !strings.ContainsRune(" \t\n\r()\";", rune(src[end])) {
+				end++
+			}
+			stack[len(stack)-1].list = append(stack[len(stack)-1].list, &watNode{start: i, end: end, text: src[i:end]})
+			i = end
+		}
+	}
+	if len(stack) > 1 {
+		return nil, fmt.Errorf("line %d: ( is never closed", watLine(src, stack[len(stack)-1].start))
+	}
+	return root.list, nil
+}
+
+// skipBlockComment returns where the (; ;) comment at start ends. Block
+// comments nest.
+func skipBlockComment(src string, start int) (int, error) {
+	depth := 0
+	for i := start; i+1 < len(src); {
+		switch src[i : i+2] {
+		case "(;":
+			depth++
+			i += 2
+		case ";)":
+			depth--
+			i += 2
+			if depth == 0 {
+				return i, nil
+			}
+		default:
+			i++
+		}
+	}
+	return 0, fmt.Errorf("line %d: block comment is never closed", watLine(src, start))
+}
+
+// parseWATString reads the string at start, decoding \t, \n, \r, \", \',
+// \\, \hh and \u{...} escapes
+func parseWATString(src string, start int) (*watNode, error) {
+	var text strings.Builder
+	for i := start + 1; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '"':
+			return &watNode{start: start, end: i + 1, text: text.String(), isString: true}, nil
+		case c == '\n':
+			return nil, fmt.Errorf("line %d: string is never closed", watLine(src, start))
+		case c != '\\':
+			text.WriteByte(c)
+			i++
+		case i+1 >= len(src):
+			return nil, fmt.Errorf("line %d: string is never closed", watLine(src, start))
+		default:
+			escape := src[i+1]
+			i += 2
+			switch escape {
+			case 't':
+				text.WriteByte('\t')
+			case 'n':
+				text.WriteByte('\n')
+			case 'r':
+				text.WriteByte('\r')
+			case '"', '\'', '\\':
+				text.WriteByte(escape)
+			case 'u':
+				end := strings.IndexByte(src[i:], '}')
+				if !strings.HasPrefix(src[i:], "{") || end < 0 {
+					return nil, fmt.Errorf("line %d: invalid \\u escape", watLine(src, start))
+				}
+				code, err := strconv.ParseUint(src[i+1:i+end], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(code)) {
+					return nil, fmt.Errorf("line %d: invalid \\u escape", watLine(src, start))
+				}
+				text.WriteRune(rune(code))
+				i += end + 1
+			default:
+				if i >= len(src) {
+					return nil, fmt.Errorf("line %d: string is never closed", watLine(src, start))
+				}
+				b, err := strconv.ParseUint(src[i-1:i+1], 16, 8)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid escape \\%c", watLine(src, start), escape)
+				}
+				text.WriteByte(byte(b))
+				i++
+			}
+		}
+	}
+	return nil, fmt.Errorf("line %d: string is never closed", watLine(src, start))
+}
+
+func watLine(src string, offset int) int {
+	return strings.Count(src[:offset], "\n") + 1
+}
+
+// watModuleFields returns the fields of the module in parsed source: the
+// lists inside (module ...), or the top-level lists of a module written
+// without it. Modules given as (module binary ...) or (module quote ...)
+// have no fields to see.
+func watModuleFields(nodes []*watNode) []*watNode {
+	if len(nodes) == 1 && nodes[0].head() == "module" {
+		var fields []*watNode
+		for _, child := range nodes[0].list[1:] {
+			if !child.isList && (child.text == "binary" || child.text == "quote") {
+				return nil
+			}
+			if child.isList {
+				fields = append(fields, child)
+			}
+		}
+		return fields
+	}
+	return nodes
+}
+
+// watGlobalImport is a global a module imports, written either way:
+//
+//	(import "env" "NAME" (global $id TYPE))
+//	(global $id (export "e")* (import "env" "NAME") TYPE)
+//
+// where TYPE is a number type, or (mut TYPE)
+type watGlobalImport struct {
+	field        *watNode
+	module, name string
+	// $id, if the global has one
+	id string
+	// Inline exports, kept on the definition replacing the import
+	exports []*watNode
+	// The global type as written, and its number type
+	globalType *watNode
+	valType    string
+}
+
+// isWATImport reports whether a module field imports something, since
+// imports must all come before the definitions of a module
+func isWATImport(field *watNode) bool {
+	switch field.head() {
+	case "import":
+		return true
+	case "func", "table", "memory", "global", "tag":
+		for _, child := range field.list[1:] {
+			if child.head() == "import" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// watGlobalImports finds the imported globals of number type among fields
+func watGlobalImports(fields []*watNode) []watGlobalImport {
+	var imports []watGlobalImport
+	for _, field := range fields {
+		var imp watGlobalImport
+		var ok bool
+		switch field.head() {
+		case "import":
+			imp, ok = explicitGlobalImport(field)
+		case "global":
+			imp, ok = inlineGlobalImport(field)
+		}
+		if ok {
+			imports = append(imports, imp)
+		}
+	}
+	return imports
+}
+
+func explicitGlobalImport(field *watNode) (watGlobalImport, bool) {
+	if len(field.list) != 4 || !field.list[1].isString || !field.list[2].isString || field.list[3].head() != "global" {
+		return watGlobalImport{}, false
+	}
+	imp := watGlobalImport{field: field, module: field.list[1].text, name: field.list[2].text}
+	rest := field.list[3].list[1:]
+	if len(rest) > 0 && strings.HasPrefix(rest[0].text, "$") && !rest[0].isString && !rest[0].isList {
+		imp.id, rest = rest[0].text, rest[1:]
+	}
+	if len(rest) != 1 {
+		return watGlobalImport{}, false
+	}
+	return imp.withType(rest[0])
+}
+
+func inlineGlobalImport(field *watNode) (watGlobalImport, bool) {
+	imp := watGlobalImport{field: field}
+	rest := field.list[1:]
+	if len(rest) > 0 && strings.HasPrefix(rest[0].text, "$") && !rest[0].isString && !rest[0].isList {
+		imp.id, rest = rest[0].text, rest[1:]
+	}
+	for len(rest) > 0 && rest[0].head() == "export" {
+		imp.exports, rest = append(imp.exports, rest[0]), rest[1:]
+	}
+	if len(rest) != 2 || rest[0].head() != "import" {
+		return watGlobalImport{}, false
+	}
+	names := rest[0].list[1:]
+	if len(names) != 2 || !names[0].isString || !names[1].isString {
+		return watGlobalImport{}, false
+	}
+	imp.module, imp.name = names[0].text, names[1].text
+	return imp.withType(rest[1])
+}
+
+func (imp watGlobalImport) withType(globalType *watNode) (watGlobalImport, bool) {
+	valType := globalType
+	if globalType.head() == "mut" {
+		if len(globalType.list) != 2 {
+			return watGlobalImport{}, false
+		}
+		valType = globalType.list[1]
+	}
+	switch {
+	case valType.isList || valType.isString:
+		return watGlobalImport{}, false
+	case valType.text == "i32", valType.text == "i64", valType.text == "f32", valType.text == "f64":
+		imp.globalType, imp.valType = globalType, valType.text
+		return imp, true
+	}
+	return watGlobalImport{}, false
+}
+
+// definition is the global replacing the import, set to the constant value.
+// It is built where it ends up, as it holds the value.
+func (imp watGlobalImport) definition(src string, value []byte) []byte {
+	var head strings.Builder
+	head.WriteString("(global")
+	if imp.id != "" {
+		head.WriteString(" " + imp.id)
+	}
+	for _, export := range imp.exports {
+		head.WriteString(" " + src[export.start:export.end])
+	}
+	fmt.Fprintf(&head, " %s (%s.const ", src[imp.globalType.start:imp.globalType.end], imp.valType)
+
+	def := make([]byte, 0, head.Len()+len(value)+2)
+	def = append(def, head.String()...)
+	def = append(def, value...)
+	return append(def, "))"...)
+}
+
+// joinWATDefinitions puts definitions together, each after a space, wiping
+// them
+func joinWATDefinitions(definitions [][]byte) []byte {
+	size := 0
+	for _, def := range definitions {
+		size += 1 + len(def)
+	}
+	joined := make([]byte, 0, size)
+	for _, def := range definitions {
+		joined = append(joined, ' ')
+		joined = append(joined, def...)
+		clear(def)
+	}
+	return joined
+}
+
+// watPlaceholder is a {{NAME}} inside a data segment string, naming the
+// secret whose bytes replace it
+var watPlaceholder = regexp.MustCompile(`\{\{([^{}\s"\\]+)\}\}`)
+
+// watDataStrings returns the strings of the module's data segments, both
+// (data ...) fields and the inline (memory (data ...)) form
+func watDataStrings(fields []*watNode) []*watNode {
+	var strs []*watNode
+	for _, field := range fields {
+		segments := []*watNode{field}
+		if field.head() == "memory" {
+			segments = field.list[1:]
+		}
+		for _, segment := range segments {
+			if segment.head() != "data" {
+				continue
+			}
+			for _, child := range segment.list[1:] {
+				if child.isString {
+					strs = append(strs, child)
+				}
+			}
+		}
+	}
+	return strs
+}
+
+// escapeWATBytes writes b for the inside of a WAT string. Only printable
+// ASCII other than quotes and backslashes is left as is, so any bytes, even
+// invalid UTF-8, come out of the string unchanged.
+func escapeWATBytes(b []byte) []byte {
+	size := 0
+	for _, c := range b {
+		if watPrintable(c) {
+			size++
+		} else {
+			size += 3
+		}
+	}
+	const hex = "0123456789abcdef"
+	out := make([]byte, 0, size)
+	for _, c := range b {
+		if watPrintable(c) {
+			out = append(out, c)
+		} else {
+			out = append(out, '\\', hex[c>>4], hex[c&0xf])
+		}
+	}
+	return out
+}
+
+func watPrintable(c byte) bool {
+	return c >= 0x20 && c < 0x7f && c != '"' && c != '\\'
+}
+
+// watEdit replaces src[start:end] with text, which may hold secrets
+type watEdit struct {
+	start, end int
+	text       []byte
+}
+
+// applyWATEdits returns src with edits made, wiping their texts. The result
+// is allocated once at its final size, so no partial copies of it are left.
+func applyWATEdits(src string, edits []watEdit) []byte {
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	size := len(src)
+	for _, edit := range edits {
+		size += len(edit.text) - (edit.end - edit.start)
+	}
+	out := make([]byte, 0, size)
+	pos := 0
+	for _, edit := range edits {
+		out = append(out, src[pos:edit.start]...)
+		out = append(out, edit.text...)
+		pos = edit.end
+	}
+	out = append(out, src[pos:]...)
+	wipeWATEdits(edits)
+	return out
+}
+
+// wipeWATEdits wipes the texts of edits
+func wipeWATEdits(edits []watEdit) {
+	for _, edit := range edits {
+		clear(edit.text)
+	}
+}
@@ -0,0 +1,465 @@
+package enclave
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+	"golang.org/x/crypto/sha3"
+
+	"hello-wasm-enclave/internal/logging"
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// wazeroRuntime runs executions on wazero, which is pure Go, so executions
+// and jobs it runs never reach wasmtime's native code. The enclave binary
+// still links wasmtime through CGo, as sessions, describe_module,
+// precompile and preloaded modules use it whatever -runtime says. It serves
+// the same module policy, secrets, limits and host library as wasmtime,
+// except that it does not:
+//
+//   - meter fuel
+//   - offer env.http_get or the key functions
+//   - link secret globals into binary modules, or place secrets in memory
+//   - call through tables, or pass string, bytes or ref arguments and results
+//   - run precompiled modules
+//
+// WAT is still turned into a binary by wat2wasm, secrets and all.
+type wazeroRuntime struct {
+	executor *WASMExecutor
+}
+
+// wazeroHostFunctions are the env imports wazero executions may use
+var wazeroHostFunctions = map[string]bool{
+	"get_secret":    true,
+	"sha256":        true,
+	"keccak256":     true,
+	"secure_random": true,
+	"emit":          true,
+}
+
+// ExecuteWASM runs an execution as WASMExecutor.ExecuteWASM does. Each
+// execution gets a wazero runtime of its own, closed when it ends.
+func (z *wazeroRuntime) ExecuteWASM(ctx context.Context, logger *slog.Logger, wasmCode, signature string, calls []protocol.Call, secrets Secrets, limits ExecutionLimits) ([]CallResult, ExecutionStats, error) {
+	var stats ExecutionStats
+	defer heartbeatFrom(ctx).start()()
+	release, err := z.executor.workers.acquire(ctx)
+	if err != nil {
+		return nil, stats, err
+	}
+	defer release()
+
+	if limits.MaxFuel > 0 {
+		return nil, stats, fmt.Errorf("max_fuel requires an enclave started with -fuel-metering")
+	}
+	features, err := limits.Features.wazeroFeatures()
+	if err != nil {
+		return nil, stats, err
+	}
+
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCoreFeatures(features).
+		WithMemoryLimitPages(limits.MaxMemoryPages).
+		WithCloseOnContextDone(true))
+	defer runtime.Close(context.Background())
+
+	emits := emitterFrom(ctx)
+	results, err := z.execute(ctx, logger, runtime, wasmCode, signature, calls, secrets, emits, limits, &stats)
+	stats.EmitsTruncated = emits.wasTruncated()
+	return results, stats, cancelled(ctx, err)
+}
+
+func (z *wazeroRuntime) execute(ctx context.Context, logger *slog.Logger, runtime wazero.Runtime, wasmCode, signature string, calls []protocol.Call, secrets Secrets, emits *emitter, limits ExecutionLimits, stats *ExecutionStats) ([]CallResult, error) {
+	w := z.executor
+	if w.preloaded.lookup(wasmCode) == nil {
+		if err := w.policy.check(wasmCode, signature); err != nil {
+			return nil, err
+		}
+	}
+
+	compileStart := time.Now()
+	stats.CompileStart = compileStart
+	stats.ModuleSource = protocol.ModuleSourceCompiled
+
+	// Keys are only for the crypto host functions, which wazero does not offer
+	secrets, keys := splitKeys(secrets)
+	logger.Info("Parsing WASM code", "length", len(wasmCode), "secrets", len(secrets), "keys", len(keys), "runtime", runtimeWazero)
+
+	use := newSecretUse()
+	wasmBytes, err := decodeModule(logger, wasmCode, secrets, limits, use)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(wasmBytes)
+	if isPrecompiled(wasmBytes) {
+		return nil, fmt.Errorf("the wazero runtime does not run precompiled modules")
+	}
+	limited, err := applyResourceLimits(wasmBytes, limits)
+	if err != nil {
+		if errorCode(err) != "" {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to apply resource limits: %v", err)
+	}
+	defer clear(limited)
+
+	module, err := runtime.CompileModule(ctx, limited)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WASM module (enabled features: %v): %v", limits.Features, err)
+	}
+	stats.CompileTime = time.Since(compileStart)
+	logger.Info("WASM module created", "compile_time", stats.CompileTime)
+
+	if err := w.measurements.measure(logger, moduleHash(wasmCode)); err != nil {
+		return nil, err
+	}
+
+	wasi := false
+	for _, imp := range module.ImportedFunctions() {
+		namespace, name, _ := imp.Import()
+		switch {
+		case namespace == wasiNamespace:
+			wasi = true
+		case namespace == hostFunctionNamespace && !wazeroHostFunctions[name]:
+			return nil, fmt.Errorf("the wazero runtime does not offer %s.%s", namespace, name)
+		case namespace == hostFunctionNamespace && name == "get_secret":
+			use.dynamic = true
+		}
+	}
+	if err := use.check(logger, secrets, w.strictSecrets); err != nil {
+		return nil, err
+	}
+
+	if err := defineWazeroHost(ctx, logger, runtime, secrets, w.random, emits); err != nil {
+		return nil, err
+	}
+	config := wazero.NewModuleConfig().
+		WithStartFunctions().
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(w.random)
+
+	// WASI modules may print diagnostics, which are returned to the client
+	if wasi {
+		if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+			return nil, fmt.Errorf("failed to define WASI imports: %v", err)
+		}
+		stdout := &boundedOutput{max: limits.MaxOutputBytes}
+		stderr := &boundedOutput{max: limits.MaxOutputBytes}
+		config = config.WithStdout(stdout).WithStderr(stderr)
+		defer func() {
+			stats.Stdout, stats.Stderr = stdout.String(), stderr.String()
+			stats.OutputTruncated = stdout.truncated || stderr.truncated
+		}()
+		logger.Info("Providing WASI with captured stdout and stderr")
+	}
+
+	// The timeout covers the start function as well as the calls
+	ctx, cancel := context.WithTimeout(ctx, limits.Timeout)
+	defer cancel()
+
+	// Execution time starts with instantiation; the calls add to it
+	executeStart := time.Now()
+	stats.InstantiateStart = executeStart
+	instance, err := runtime.InstantiateModule(ctx, module, config)
+	stats.InstantiateTime = time.Since(executeStart)
+	stats.ExecuteTime = stats.InstantiateTime
+	if err != nil {
+		if interrupted(ctx, err) {
+			return nil, &LimitError{Code: protocol.ErrorCodeTimeout, Message: fmt.Sprintf("execution timed out after %v during instantiation", limits.Timeout)}
+		}
+		return nil, fmt.Errorf("failed to create WASM instance: %v", err)
+	}
+	logger.Info("WASM instance created")
+
+	callStart := time.Now()
+	defer func() {
+		stats.CallTime = time.Since(callStart)
+		stats.ExecuteTime += stats.CallTime
+		stats.MemoryPages = wazeroMemoryPages(instance)
+	}()
+	results := make([]CallResult, 0, len(calls))
+	for _, call := range calls {
+		started := time.Now()
+		result, err := z.callFunction(ctx, logger, instance, call, limits)
+		result.Started, result.Duration = started, time.Since(started)
+		result.Err = err
+		results = append(results, result)
+		if errorCode(err) != "" {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// callFunction makes one call on an instance
+func (z *wazeroRuntime) callFunction(ctx context.Context, logger *slog.Logger, instance api.Module, call protocol.Call, limits ExecutionLimits) (CallResult, error) {
+	if call.Table != "" {
+		return CallResult{}, fmt.Errorf("the wazero runtime does not call through tables")
+	}
+	spec := call.Result()
+	switch spec.Type {
+	case protocol.ValueString, protocol.ValueBytes, protocol.ValueExternref, protocol.ValueFuncref:
+		return CallResult{}, fmt.Errorf("the wazero runtime does not return %s results", spec.Type)
+	}
+	fn := instance.ExportedFunction(call.FunctionName)
+	if fn == nil {
+		return CallResult{}, fmt.Errorf("function '%s' not found in WASM module", call.FunctionName)
+	}
+	definition := fn.Definition()
+
+	args := call.Values()
+	params := definition.ParamTypes()
+	if len(args) != len(params) {
+		return CallResult{}, fmt.Errorf("failed to pass arguments: function takes %d arguments, got %d", len(params), len(args))
+	}
+	callArgs := make([]uint64, len(args))
+	for i, arg := range args {
+		encoded, err := encodeWazeroArg(arg, params[i])
+		if err != nil {
+			return CallResult{}, fmt.Errorf("failed to pass arguments: argument %d: %v", i, err)
+		}
+		callArgs[i] = encoded
+	}
+
+	logger.Info("Calling function", "function", call.Target(), "args", len(args))
+
+	values, err := fn.Call(ctx, callArgs...)
+	if err != nil {
+		if interrupted(ctx, err) {
+			return CallResult{}, &LimitError{Code: protocol.ErrorCodeTimeout, Message: fmt.Sprintf("execution timed out after %v", limits.Timeout)}
+		}
+		var exit *sys.ExitError
+		if errors.As(err, &exit) {
+			status := int32(exit.ExitCode())
+			if status == 0 {
+				logger.Info("WASM module exited successfully")
+				return CallResult{}, nil
+			}
+			return CallResult{I32: status}, fmt.Errorf("WASM module exited with status %d", status)
+		}
+		// A module that cannot grow its memory usually traps soon after
+		if memory := instance.ExportedMemory(secretMemoryExport); memory != nil &&
+			memory.Size()/wazeroPageSize >= limits.MaxMemoryPages {
+			return CallResult{}, resourceLimitError("memory reached %d pages: %v", limits.MaxMemoryPages, err)
+		}
+		return CallResult{}, fmt.Errorf("WASM function call failed: %v", err)
+	}
+
+	var result interface{}
+	switch types := definition.ResultTypes(); len(types) {
+	case 0:
+		// Functions without results, such as a WASI _start, report 0
+		if spec.Type == "" {
+			return CallResult{}, nil
+		}
+	case 1:
+		result = decodeWazeroResult(values[0], types[0])
+	default:
+		return CallResult{}, fmt.Errorf("the wazero runtime does not return several values")
+	}
+	callResult, err := numericResult(result, spec)
+	if err != nil {
+		return CallResult{}, err
+	}
+	logger.Info("WASM function returned", "result", callResult.I32, "typed", callResult.Value != nil)
+	return callResult, nil
+}
+
+// Bytes in a page of linear memory
+const wazeroPageSize = 64 << 10
+
+// wazeroFeatures returns the wazero features for the set. wazero enables
+// bulk memory and reference types together, and has neither multi-memory
+// nor threads.
+func (s featureSet) wazeroFeatures() (api.CoreFeatures, error) {
+	if s.has(featureMultiMemory) || s.has(featureThreads) {
+		return 0, fmt.Errorf("the wazero runtime does not support %s or %s", protocol.FeatureMultiMemory, protocol.FeatureThreads)
+	}
+	features := api.CoreFeaturesV1 | api.CoreFeatureSignExtensionOps | api.CoreFeatureNonTrappingFloatToIntConversion
+	if s.has(featureSIMD) {
+		features |= api.CoreFeatureSIMD
+	}
+	if s.has(featureBulkMemory) || s.has(featureReferenceTypes) {
+		features |= api.CoreFeatureBulkMemoryOperations | api.CoreFeatureReferenceTypes
+	}
+	if s.has(featureMultiValue) {
+		features |= api.CoreFeatureMultiValue
+	}
+	return features, nil
+}
+
+// interrupted reports whether a call failed because ctx ended; cancelled
+// tells a timeout from a cancelled request afterwards
+func interrupted(ctx context.Context, err error) bool {
+	return ctx.Err() != nil && (errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled))
+}
+
+// encodeWazeroArg converts a numeric argument for a parameter of type want
+func encodeWazeroArg(arg protocol.Value, want api.ValueType) (uint64, error) {
+	value, err := arg.Decode()
+	if err != nil {
+		return 0, err
+	}
+	var encoded uint64
+	var got api.ValueType
+	switch v := value.(type) {
+	case int32:
+		encoded, got = api.EncodeI32(v), api.ValueTypeI32
+	case int64:
+		encoded, got = api.EncodeI64(v), api.ValueTypeI64
+	case float32:
+		encoded, got = api.EncodeF32(v), api.ValueTypeF32
+	case float64:
+		encoded, got = api.EncodeF64(v), api.ValueTypeF64
+	default:
+		return 0, fmt.Errorf("the wazero runtime does not pass %s arguments", arg.Type)
+	}
+	if got != want {
+		return 0, fmt.Errorf("function takes %s, not %s", api.ValueTypeName(want), api.ValueTypeName(got))
+	}
+	return encoded, nil
+}
+
+// decodeWazeroResult converts a numeric result to the Go value wasmtime
+// would have returned
+func decodeWazeroResult(value uint64, t api.ValueType) interface{} {
+	switch t {
+	case api.ValueTypeI32:
+		return api.DecodeI32(value)
+	case api.ValueTypeI64:
+		return int64(value)
+	case api.ValueTypeF32:
+		return api.DecodeF32(value)
+	case api.ValueTypeF64:
+		return api.DecodeF64(value)
+	}
+	return nil
+}
+
+// wazeroMemoryPages returns the size of an instance's exported memories
+func wazeroMemoryPages(instance api.Module) uint64 {
+	var pages uint64
+	for name := range instance.ExportedMemoryDefinitions() {
+		if memory := instance.ExportedMemory(name); memory != nil {
+			pages += uint64(memory.Size() / wazeroPageSize)
+		}
+	}
+	return pages
+}
+
+// defineWazeroHost instantiates the env host functions of wazeroHostFunctions
+// in runtime, as newSecretLinker, defineHostLibrary and the emitter define
+// them for wasmtime. Host functions trap by panicking.
+func defineWazeroHost(ctx context.Context, logger *slog.Logger, runtime wazero.Runtime, secrets Secrets, random io.Reader, emits *emitter) error {
+	if random == nil {
+		random = rand.Reader
+	}
+	digest := func(name string, sum func([]byte) []byte) func(context.Context, api.Module, uint32, uint32, uint32) {
+		return func(_ context.Context, m api.Module, inPtr, inLen, outPtr uint32) {
+			data := wazeroMemory(m)
+			in, ok := memoryRange(data, int32(inPtr), int32(inLen))
+			if !ok {
+				panic(name + ": input out of bounds")
+			}
+			out, ok := memoryRange(data, int32(outPtr), 32)
+			if !ok {
+				panic(name + ": output buffer out of bounds")
+			}
+			copy(out, sum(in))
+		}
+	}
+
+	builder := runtime.NewHostModuleBuilder(hostFunctionNamespace)
+	builder.NewFunctionBuilder().WithFunc(func(_ context.Context, m api.Module, namePtr, nameLen, outPtr, outLen uint32) int32 {
+		data := wazeroMemory(m)
+		name, ok := memoryRange(data, int32(namePtr), int32(nameLen))
+		if !ok {
+			panic("get_secret: name out of bounds")
+		}
+		out, ok := memoryRange(data, int32(outPtr), int32(outLen))
+		if !ok {
+			panic("get_secret: output buffer out of bounds")
+		}
+		secret, exists := secrets[string(name)]
+		if !exists {
+			logger.Warn("Module requested unknown secret", "name", logging.SecretName(name))
+			return secretNotFound
+		}
+		copy(out, secret.Bytes())
+		return int32(secret.Len())
+	}).Export("get_secret")
+	builder.NewFunctionBuilder().WithFunc(digest("sha256", func(in []byte) []byte {
+		sum := sha256.Sum256(in)
+		return sum[:]
+	})).Export("sha256")
+	builder.NewFunctionBuilder().WithFunc(digest("keccak256", func(in []byte) []byte {
+		h := sha3.NewLegacyKeccak256()
+		h.Write(in)
+		return h.Sum(nil)
+	})).Export("keccak256")
+	builder.NewFunctionBuilder().WithFunc(func(_ context.Context, m api.Module, ptr, length uint32) {
+		if length > maxRandomBytes {
+			panic(fmt.Sprintf("secure_random: at most %d bytes per call", maxRandomBytes))
+		}
+		buf, ok := memoryRange(wazeroMemory(m), int32(ptr), int32(length))
+		if !ok {
+			panic("secure_random: buffer out of bounds")
+		}
+		if _, err := io.ReadFull(random, buf); err != nil {
+			panic(fmt.Sprintf("secure_random: %v", err))
+		}
+	}).Export("secure_random")
+	builder.NewFunctionBuilder().WithFunc(func(_ context.Context, m api.Module, ptr, length uint32) {
+		if length > maxEmitBytes {
+			panic(fmt.Sprintf("emit: at most %d bytes per call", maxEmitBytes))
+		}
+		piece, ok := memoryRange(wazeroMemory(m), int32(ptr), int32(length))
+		if !ok {
+			panic("emit: buffer out of bounds")
+		}
+		emits.emit(logger, piece)
+	}).Export("emit")
+
+	if _, err := builder.Instantiate(ctx); err != nil {
+		return fmt.Errorf("failed to define host functions: %v", err)
+	}
+	return nil
+}
+
+// wazeroMemory returns the exported "memory" of the calling module
+func wazeroMemory(m api.Module) []byte {
+	memory := m.ExportedMemory(secretMemoryExport)
+	if memory == nil {
+		panic(fmt.Sprintf("host function requires an exported %q memory", secretMemoryExport))
+	}
+	data, _ := memory.Read(0, memory.Size())
+	return data
+}
+
+// boundedOutput keeps the first max bytes written to it
+type boundedOutput struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (o *boundedOutput) Write(p []byte) (int, error) {
+	if room := o.max - o.Len(); len(p) > room {
+		o.truncated = true
+		o.Buffer.Write(p[:max(room, 0)])
+	} else {
+		o.Buffer.Write(p)
+	}
+	return len(p), nil
+}
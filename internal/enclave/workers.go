@@ -0,0 +1,169 @@
+package enclave
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+const (
+	// Default for -queue-length; -workers defaults to the number of vCPUs
+	defaultQueueLength = 64
+	// Default for -stuck-execution-timeout, well past the longest a job may
+	// run and compile
+	defaultStuckExecutionTimeout = defaultMaxJobTimeout + 5*time.Minute
+)
+
+// workerPool bounds how many executions compile and run at once. Enclaves
+// have few vCPUs, and compilation is CPU-heavy, so requests beyond the
+// number of workers wait in a queue of bounded length; once that is full
+// they are turned away rather than thrashing the ones already running.
+//
+// An execution wedged in a host function or in wasmtime never gives its
+// worker back, so a watchdog restarts the pool, with new workers, once one
+// has held its worker for too long.
+type workerPool struct {
+	mu    sync.Mutex
+	slots *workerSlots
+	// Executions holding a worker of slots
+	busy     map[*busyWorker]bool
+	restarts atomic.Uint64
+}
+
+// busyWorker is an execution holding a worker
+type busyWorker struct {
+	started time.Time
+}
+
+// workerSlots is one generation of the pool's tokens
+type workerSlots struct {
+	// A token per running execution
+	running chan struct{}
+	// A token per running or queued execution
+	admitted chan struct{}
+	// Closed when a restart replaces these slots
+	replaced chan struct{}
+}
+
+func newWorkerPool(workers, queueLength int) *workerPool {
+	return &workerPool{
+		slots: newWorkerSlots(workers, queueLength),
+		busy:  make(map[*busyWorker]bool),
+	}
+}
+
+func newWorkerSlots(workers, queueLength int) *workerSlots {
+	return &workerSlots{
+		running:  make(chan struct{}, workers),
+		admitted: make(chan struct{}, workers+queueLength),
+		replaced: make(chan struct{}),
+	}
+}
+
+// acquire waits for a free worker and returns the function that frees it
+// again. It fails at once when the queue is full, and when ctx ends while
+// waiting.
+func (p *workerPool) acquire(ctx context.Context) (func(), error) {
+	for {
+		p.mu.Lock()
+		slots := p.slots
+		p.mu.Unlock()
+
+		select {
+		case slots.admitted <- struct{}{}:
+		default:
+			return nil, &LimitError{
+				Code:    protocol.ErrorCodeOverloaded,
+				Message: fmt.Sprintf("enclave is overloaded: %d executions running and %d queued", cap(slots.running), cap(slots.admitted)-cap(slots.running)),
+			}
+		}
+		select {
+		case slots.running <- struct{}{}:
+		case <-slots.replaced:
+			// Wait for a worker of the new slots instead
+			<-slots.admitted
+			continue
+		case <-ctx.Done():
+			<-slots.admitted
+			return nil, cancelledError(ctx)
+		}
+
+		execution := &busyWorker{started: time.Now()}
+		p.mu.Lock()
+		if slots == p.slots {
+			p.busy[execution] = true
+		}
+		p.mu.Unlock()
+		return func() {
+			p.mu.Lock()
+			delete(p.busy, execution)
+			p.mu.Unlock()
+			<-slots.running
+			<-slots.admitted
+		}, nil
+	}
+}
+
+// size reports how many executions may run at once
+func (p *workerPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return cap(p.slots.running)
+}
+
+// queued reports how many executions are waiting for a worker
+func (p *workerPool) queued() int {
+	p.mu.Lock()
+	slots := p.slots
+	p.mu.Unlock()
+	// The two lengths are read separately, so may briefly disagree
+	if n := len(slots.admitted) - len(slots.running); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// watch restarts the pool whenever an execution has held its worker for
+// longer than stuckAfter. The stuck executions are left to themselves, and
+// give their workers back to slots no longer used if they ever finish;
+// everything else, the listeners included, carries on.
+func (p *workerPool) watch(stuckAfter time.Duration) {
+	interval := stuckAfter / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if stuck := p.restartIfStuck(stuckAfter); stuck > 0 {
+			slog.Error("Restarted the workers: executions held theirs for too long", "stuck", stuck, "after", stuckAfter, "restarts", p.restarts.Load(), "goroutines", goroutineDump())
+		}
+	}
+}
+
+// restartIfStuck replaces the slots when executions have held workers for
+// longer than stuckAfter, and returns how many had
+func (p *workerPool) restartIfStuck(stuckAfter time.Duration) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stuck := 0
+	for execution := range p.busy {
+		if time.Since(execution.started) > stuckAfter {
+			stuck++
+		}
+	}
+	if stuck == 0 {
+		return 0
+	}
+	old := p.slots
+	p.slots = newWorkerSlots(cap(old.running), cap(old.admitted)-cap(old.running))
+	p.busy = make(map[*busyWorker]bool)
+	close(old.replaced)
+	p.restarts.Add(1)
+	return stuck
+}
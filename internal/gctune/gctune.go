@@ -0,0 +1,46 @@
+// Package gctune tunes the garbage collector of the host and the enclave
+// from flags. Under load most of the heap is short-lived request and
+// response buffers, so collecting less often, up to a memory limit that
+// keeps the enclave inside the memory it was given, trades memory for
+// shorter and rarer pauses.
+package gctune
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+// Settings are the collector flags registered on a flag set
+type Settings struct {
+	gcPercent     *int
+	memoryLimitMB *int
+}
+
+// Register adds -gc-percent and -memory-limit-mb to fs
+func Register(fs *flag.FlagSet) *Settings {
+	return &Settings{
+		gcPercent:     fs.Int("gc-percent", 0, "heap growth that triggers a collection, as GOGC; higher collects less often (0 keeps GOGC, negative disables collection short of -memory-limit-mb)"),
+		memoryLimitMB: fs.Int("memory-limit-mb", 0, "soft limit in MiB on the Go heap, as GOMEMLIMIT, past which collections run regardless of -gc-percent (0 keeps GOMEMLIMIT)"),
+	}
+}
+
+// Apply sets the collector up as configured and logs what it changed
+func (s *Settings) Apply() error {
+	if *s.memoryLimitMB < 0 {
+		return fmt.Errorf("-memory-limit-mb must not be negative")
+	}
+	if *s.gcPercent < 0 && *s.memoryLimitMB == 0 {
+		return fmt.Errorf("-gc-percent below zero needs -memory-limit-mb, or the heap grows without bound")
+	}
+	if *s.memoryLimitMB > 0 {
+		debug.SetMemoryLimit(int64(*s.memoryLimitMB) << 20)
+		log.Printf("Go heap soft limit set to %d MiB", *s.memoryLimitMB)
+	}
+	if *s.gcPercent != 0 {
+		debug.SetGCPercent(*s.gcPercent)
+		log.Printf("Garbage collection target set to %d%%", *s.gcPercent)
+	}
+	return nil
+}
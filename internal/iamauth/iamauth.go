@@ -0,0 +1,113 @@
+// Package iamauth lets clients authenticate to the host with AWS IAM
+// credentials the host never sees. The client signs an STS
+// GetCallerIdentity request and sends it, unsent, as its API token; the host
+// replays it to STS and learns the caller's ARN from the answer. A signed
+// audience header ties the request to one host, so a host cannot replay a
+// token to another service that trusts the same scheme.
+package iamauth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"hello-wasm-enclave/internal/sigv4"
+)
+
+const (
+	// TokenPrefix marks an API token as a signed STS request
+	TokenPrefix = "aws-iam:"
+	// AudienceHeader names the host a token is meant for
+	AudienceHeader = "X-Wasm-Audience"
+
+	callerIdentityQuery = "Action=GetCallerIdentity&Version=2011-06-15"
+)
+
+// SignedRequest is a GetCallerIdentity request signed by the client
+type SignedRequest struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// NewToken signs a GetCallerIdentity request for audience with creds
+func NewToken(creds sigv4.Credentials, audience string, now time.Time) (string, error) {
+	endpoint := fmt.Sprintf("https://sts.%s.amazonaws.com/?%s", creds.Region, callerIdentityQuery)
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(AudienceHeader, audience)
+	sigv4.SignRequest(req, nil, creds, "sts", now)
+
+	signed := SignedRequest{URL: endpoint, Headers: map[string]string{}}
+	for name := range req.Header {
+		signed.Headers[name] = req.Header.Get(name)
+	}
+	encoded, err := json.Marshal(signed)
+	if err != nil {
+		return "", err
+	}
+	return TokenPrefix + base64.StdEncoding.EncodeToString(encoded), nil
+}
+
+// ParseToken decodes a token and checks that it is a GetCallerIdentity
+// request to STS, signed over the audience header with the given value
+func ParseToken(token, audience string) (*SignedRequest, error) {
+	encoded := strings.TrimPrefix(token, TokenPrefix)
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IAM token encoding: %v", err)
+	}
+	var signed SignedRequest
+	if err := json.Unmarshal(raw, &signed); err != nil {
+		return nil, fmt.Errorf("invalid IAM token: %v", err)
+	}
+
+	target, err := url.Parse(signed.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IAM token URL: %v", err)
+	}
+	if target.Scheme != "https" || !isSTSHost(target.Host) || (target.Path != "" && target.Path != "/") || target.RawQuery != callerIdentityQuery {
+		return nil, fmt.Errorf("IAM token is not a GetCallerIdentity request to STS")
+	}
+
+	headers := http.Header{}
+	for name, value := range signed.Headers {
+		headers.Set(name, value)
+	}
+	if headers.Get(AudienceHeader) != audience {
+		return nil, fmt.Errorf("IAM token is meant for audience %q", headers.Get(AudienceHeader))
+	}
+	if !signsHeader(headers.Get("Authorization"), strings.ToLower(AudienceHeader)) {
+		return nil, fmt.Errorf("IAM token does not sign the %s header", AudienceHeader)
+	}
+	return &signed, nil
+}
+
+func isSTSHost(host string) bool {
+	if host == "sts.amazonaws.com" {
+		return true
+	}
+	region := strings.TrimSuffix(strings.TrimPrefix(host, "sts."), ".amazonaws.com")
+	return region != host && region != "" && !strings.ContainsAny(region, "./:@")
+}
+
+// signsHeader reports whether a SigV4 Authorization header covers name
+func signsHeader(authorization, name string) bool {
+	for _, part := range strings.Split(authorization, ",") {
+		part = strings.TrimSpace(part)
+		if !strings.HasPrefix(part, "SignedHeaders=") {
+			continue
+		}
+		for _, signed := range strings.Split(strings.TrimPrefix(part, "SignedHeaders="), ";") {
+			if signed == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+// Package logging sets up the structured logger shared by the host and the
+// enclave. Every line logged while handling a request carries the request's
+// correlation ID, which the host assigns (or takes from the client) and sends
+// along to the enclave, so one request can be followed across the vsock
+// boundary.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// CorrelationKey is the log attribute holding a request's correlation ID
+const CorrelationKey = "correlation_id"
+
+// Setup installs the default slog logger. Output of the standard log
+// package is routed through it as well. Format is "json" or "text".
+func Setup(format string) error {
+	return SetupForwarding(format, nil)
+}
+
+// SetupForwarding is Setup with every record also written to forward, one
+// JSON line per Write, whatever the format; a nil forward writes nothing
+func SetupForwarding(format string, forward io.Writer) error {
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, nil)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, nil)
+	default:
+		return fmt.Errorf("unknown log format %q (use json or text)", format)
+	}
+	if forward != nil {
+		handler = teeHandler{handler, slog.NewJSONHandler(forward, &slog.HandlerOptions{Level: slog.LevelDebug})}
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// teeHandler hands each record to several handlers
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var first error
+	for _, h := range t {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	tee := make(teeHandler, len(t))
+	for i, h := range t {
+		tee[i] = h.WithAttrs(attrs)
+	}
+	return tee
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	tee := make(teeHandler, len(t))
+	for i, h := range t {
+		tee[i] = h.WithGroup(name)
+	}
+	return tee
+}
+
+// NewCorrelationID returns a random ID for a request that arrived without one
+func NewCorrelationID() string {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(fmt.Sprintf("failed to generate correlation ID: %v", err))
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// ForRequest returns a logger that tags every line with the request's IDs
+func ForRequest(correlationID, requestID string) *slog.Logger {
+	return slog.Default().With(CorrelationKey, correlationID, "request_id", requestID)
+}
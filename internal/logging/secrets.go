@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+const redacted = "[REDACTED]"
+
+// unsafeSecrets is set by -debug-unsafe-logging
+var unsafeSecrets atomic.Bool
+
+// SetUnsafeSecretLogging allows secret names and masked secret values into
+// the log. It is meant for debugging templates and must stay off in
+// production, where the log leaves the enclave.
+func SetUnsafeSecretLogging(enabled bool) {
+	unsafeSecrets.Store(enabled)
+}
+
+// SecretName wraps the name of a secret for logging. It is redacted unless
+// unsafe secret logging is enabled.
+type SecretName string
+
+func (n SecretName) LogValue() slog.Value {
+	if !unsafeSecrets.Load() {
+		return slog.StringValue(redacted)
+	}
+	return slog.StringValue(string(n))
+}
+
+// SecretValue wraps secret material for logging. It is redacted unless
+// unsafe secret logging is enabled, and even then only a masked form that
+// keeps the first and last four characters of long values is logged.
+type SecretValue []byte
+
+func (v SecretValue) LogValue() slog.Value {
+	if !unsafeSecrets.Load() {
+		return slog.StringValue(redacted)
+	}
+	if len(v) <= 8 {
+		return slog.StringValue("***")
+	}
+	return slog.StringValue(string(v[:4]) + "***" + string(v[len(v)-4:]))
+}
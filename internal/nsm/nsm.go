@@ -0,0 +1,177 @@
+// Package nsm talks to the Nitro Security Module, the enclave's device for
+// attestation documents, hardware randomness and platform configuration
+// registers (PCRs). It only works inside a Nitro enclave.
+package nsm
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"unsafe"
+
+	"github.com/fxamacker/cbor/v2"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Path of the Nitro Security Module device inside the enclave
+	devicePath = "/dev/nsm"
+
+	// _IOWR(0x0A, 0, struct nsm_message) where nsm_message is two iovecs
+	ioctlMessage = 0xC0200A00
+
+	// Buffer sizes used by the NSM driver
+	maxRequestSize  = 0x1000
+	maxResponseSize = 0x3000
+)
+
+// message mirrors the kernel's struct nsm_message
+type message struct {
+	request  unix.Iovec
+	response unix.Iovec
+}
+
+// attestationRequest is the body of an Attestation request; nil fields
+// are encoded as CBOR null, which the NSM treats as absent
+type attestationRequest struct {
+	UserData  []byte `cbor:"user_data"`
+	Nonce     []byte `cbor:"nonce"`
+	PublicKey []byte `cbor:"public_key"`
+}
+
+// response holds the response variants we understand
+type response struct {
+	Attestation *struct {
+		Document []byte `cbor:"document"`
+	} `cbor:"Attestation"`
+	GetRandom *struct {
+		Random []byte `cbor:"random"`
+	} `cbor:"GetRandom"`
+	ExtendPCR *struct {
+		Data []byte `cbor:"data"`
+	} `cbor:"ExtendPCR"`
+	DescribePCR *PCR   `cbor:"DescribePCR"`
+	Error       string `cbor:"Error"`
+}
+
+// pcrRequest is the body of ExtendPCR and DescribePCR requests
+type pcrRequest struct {
+	Index uint16 `cbor:"index"`
+	Data  []byte `cbor:"data,omitempty"`
+}
+
+// PCR is the state of a platform configuration register
+type PCR struct {
+	// Locked PCRs accept no more extends
+	Lock bool   `cbor:"lock"`
+	Data []byte `cbor:"data"`
+}
+
+// Session is an open handle on the Nitro Security Module device
+type Session struct {
+	mu     sync.Mutex
+	device *os.File
+}
+
+// Open opens the NSM device; this only succeeds inside a Nitro enclave
+func Open() (*Session, error) {
+	device, err := os.OpenFile(devicePath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open NSM device: %v", err)
+	}
+	return &Session{device: device}, nil
+}
+
+// send CBOR-encodes the request, passes it to the NSM and decodes the reply
+func (s *Session) send(request interface{}) (response, error) {
+	encoded, err := cbor.Marshal(request)
+	if err != nil {
+		return response{}, fmt.Errorf("failed to encode NSM request: %v", err)
+	}
+	if len(encoded) > maxRequestSize {
+		return response{}, fmt.Errorf("NSM request too large: %d bytes", len(encoded))
+	}
+
+	responseBuf := make([]byte, maxResponseSize)
+	msg := message{}
+	msg.request.Base = &encoded[0]
+	msg.request.SetLen(len(encoded))
+	msg.response.Base = &responseBuf[0]
+	msg.response.SetLen(len(responseBuf))
+
+	s.mu.Lock()
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, s.device.Fd(), ioctlMessage, uintptr(unsafe.Pointer(&msg)))
+	s.mu.Unlock()
+	if errno != 0 {
+		return response{}, fmt.Errorf("NSM ioctl failed: %v", errno)
+	}
+
+	var decoded response
+	if err := cbor.Unmarshal(responseBuf[:msg.response.Len], &decoded); err != nil {
+		return response{}, fmt.Errorf("failed to decode NSM response: %v", err)
+	}
+	if decoded.Error != "" {
+		return response{}, fmt.Errorf("NSM returned error: %s", decoded.Error)
+	}
+	return decoded, nil
+}
+
+// Attestation asks the NSM for a signed attestation document (COSE_Sign1,
+// CBOR encoded) that embeds the given user data, nonce and public key
+func (s *Session) Attestation(userData, nonce, publicKey []byte) ([]byte, error) {
+	response, err := s.send(map[string]attestationRequest{
+		"Attestation": {
+			UserData:  userData,
+			Nonce:     nonce,
+			PublicKey: publicKey,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Attestation == nil || len(response.Attestation.Document) == 0 {
+		return nil, fmt.Errorf("NSM response did not contain an attestation document")
+	}
+	return response.Attestation.Document, nil
+}
+
+// Read fills p with random bytes from the NSM's hardware source, making an
+// Session an io.Reader. It may return fewer bytes than asked.
+func (s *Session) Read(p []byte) (int, error) {
+	// GetRandom takes no arguments, so the request is just its name
+	response, err := s.send("GetRandom")
+	if err != nil {
+		return 0, err
+	}
+	if response.GetRandom == nil || len(response.GetRandom.Random) == 0 {
+		return 0, fmt.Errorf("NSM response did not contain random bytes")
+	}
+	return copy(p, response.GetRandom.Random), nil
+}
+
+// ExtendPCR extends a PCR with data and returns its new value. The image
+// measurements are locked at boot; PCR16 and up start zeroed and record
+// whatever the enclave extends into them, and attestation documents carry
+// them like the others.
+func (s *Session) ExtendPCR(index uint16, data []byte) ([]byte, error) {
+	response, err := s.send(map[string]pcrRequest{"ExtendPCR": {Index: index, Data: data}})
+	if err != nil {
+		return nil, err
+	}
+	if response.ExtendPCR == nil {
+		return nil, fmt.Errorf("NSM response did not contain the extended PCR")
+	}
+	return response.ExtendPCR.Data, nil
+}
+
+// DescribePCR returns the value of a PCR and whether it is locked
+func (s *Session) DescribePCR(index uint16) (*PCR, error) {
+	response, err := s.send(map[string]pcrRequest{"DescribePCR": {Index: index}})
+	if err != nil {
+		return nil, err
+	}
+	if response.DescribePCR == nil {
+		return nil, fmt.Errorf("NSM response did not describe the PCR")
+	}
+	return response.DescribePCR, nil
+}
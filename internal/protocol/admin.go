@@ -0,0 +1,41 @@
+package protocol
+
+// AdminRequestTypes are the operator requests. The host makes them for its
+// admin API and refuses them from clients.
+var AdminRequestTypes = []string{
+	RequestTypeListModules,
+	RequestTypeEvictModules,
+	RequestTypeListSessions,
+	RequestTypeKillSession,
+	RequestTypeRotateSigningKey,
+}
+
+// IsAdminRequest reports whether a request type is an operator request
+func IsAdminRequest(requestType string) bool {
+	for _, t := range AdminRequestTypes {
+		if t == requestType {
+			return true
+		}
+	}
+	return false
+}
+
+// CachedModule is a compiled module an enclave keeps for a tenant
+type CachedModule struct {
+	ModuleHash string `json:"module_hash"`      // Hex SHA-256 of wasm_code
+	Tenant     string `json:"tenant,omitempty"` // Tenant it was compiled for
+	Hits       uint64 `json:"hits"`             // Requests that found it compiled
+	LastUsed   int64  `json:"last_used"`        // Unix milliseconds of the last request to run it
+}
+
+// SessionInfo describes a session alive in an enclave. Session IDs let
+// whoever knows them call the session, so only their handle, a hash of the
+// ID, is reported.
+type SessionInfo struct {
+	Handle     string `json:"handle"`
+	Tenant     string `json:"tenant,omitempty"`
+	ModuleHash string `json:"module_hash"`
+	Secrets    int    `json:"secrets"`    // Secrets injected into the session
+	CreatedAt  int64  `json:"created_at"` // Unix milliseconds
+	LastUsed   int64  `json:"last_used"`  // Unix milliseconds of the last call
+}
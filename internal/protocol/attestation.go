@@ -0,0 +1,188 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// NitroRootFingerprint is the hex SHA-256 fingerprint AWS publishes for the
+// AWS Nitro Enclaves root certificate, which starts the CA bundle of every
+// attestation document
+const NitroRootFingerprint = "641a0321a3e244efe456463195d606317ed7cdcc3c1756e09893f3c68f79bb5b"
+
+// AttestationDocument is the payload of an NSM attestation document. Its PCRs
+// are SHA-384 measurements of the enclave image and of what the enclave
+// extended since, e.g. PCR0 of the image file and PCR8 of its signing
+// certificate.
+type AttestationDocument struct {
+	ModuleID    string          `cbor:"module_id"`
+	Digest      string          `cbor:"digest"`
+	Timestamp   uint64          `cbor:"timestamp"` // Unix milliseconds
+	PCRs        map[uint][]byte `cbor:"pcrs"`
+	Certificate []byte          `cbor:"certificate"` // DER certificate of the key that signed the document
+	CABundle    [][]byte        `cbor:"cabundle"`    // DER chain from the root to Certificate's issuer
+	PublicKey   []byte          `cbor:"public_key"`
+	UserData    []byte          `cbor:"user_data"`
+	Nonce       []byte          `cbor:"nonce"`
+}
+
+// coseSign1 is the COSE_Sign1 structure an attestation document comes in
+type coseSign1 struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   []byte
+	Unprotected cbor.RawMessage
+	Payload     []byte
+	Signature   []byte
+}
+
+// VerifyAttestation decodes a base64 attestation document and checks that
+// the Nitro hypervisor made it: its ES384 signature verifies with its
+// certificate, which chains through the CA bundle to root at the time of
+// signing. With a nil root the bundle must start with the AWS Nitro root.
+func VerifyAttestation(encoded string, root *x509.Certificate) (*AttestationDocument, *x509.Certificate, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("attestation document is not valid base64")
+	}
+	// The COSE_Sign1 tag is optional
+	raw = bytes.TrimPrefix(raw, []byte{0xd2})
+	var sign1 coseSign1
+	if err := cbor.Unmarshal(raw, &sign1); err != nil {
+		return nil, nil, fmt.Errorf("attestation document is not COSE_Sign1: %v", err)
+	}
+	var document AttestationDocument
+	if err := cbor.Unmarshal(sign1.Payload, &document); err != nil {
+		return nil, nil, fmt.Errorf("malformed attestation document: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(document.Certificate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed attestation certificate: %v", err)
+	}
+	if err := verifyCOSESignature(leaf, sign1); err != nil {
+		return nil, nil, err
+	}
+
+	if len(document.CABundle) == 0 {
+		return nil, nil, fmt.Errorf("attestation document has no CA bundle")
+	}
+	bundleRoot, err := x509.ParseCertificate(document.CABundle[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed attestation CA bundle: %v", err)
+	}
+	if root == nil {
+		if fingerprint := sha256.Sum256(bundleRoot.Raw); hex.EncodeToString(fingerprint[:]) != NitroRootFingerprint {
+			return nil, nil, fmt.Errorf("attestation CA bundle starts with %s, not the AWS Nitro root", bundleRoot.Subject)
+		}
+		root = bundleRoot
+	}
+	options := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   time.UnixMilli(int64(document.Timestamp)),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	options.Roots.AddCert(root)
+	for _, der := range document.CABundle[1:] {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, nil, fmt.Errorf("malformed attestation CA bundle: %v", err)
+		}
+		options.Intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(options); err != nil {
+		return nil, nil, fmt.Errorf("attestation certificate does not chain to %s: %v", root.Subject, err)
+	}
+	return &document, leaf, nil
+}
+
+// verifyCOSESignature checks the ES384 signature of a COSE_Sign1 structure,
+// made over its Sig_structure with no external data
+func verifyCOSESignature(cert *x509.Certificate, sign1 coseSign1) error {
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || publicKey.Curve != elliptic.P384() {
+		return fmt.Errorf("attestation certificate key is not ECDSA P-384")
+	}
+	signed, err := cbor.Marshal([]interface{}{"Signature1", sign1.Protected, []byte{}, sign1.Payload})
+	if err != nil {
+		return fmt.Errorf("failed to encode attestation Sig_structure: %v", err)
+	}
+	if len(sign1.Signature) != 96 {
+		return fmt.Errorf("attestation signature is %d bytes, not 96", len(sign1.Signature))
+	}
+	digest := sha512.Sum384(signed)
+	r := new(big.Int).SetBytes(sign1.Signature[:48])
+	s := new(big.Int).SetBytes(sign1.Signature[48:])
+	if !ecdsa.Verify(publicKey, digest[:], r, s) {
+		return fmt.Errorf("attestation signature does not match")
+	}
+	return nil
+}
+
+// AttestationRequestDigest hashes the parts of a request that determine its
+// result, as the first half of the user_data of an execution's attestation
+// document. Secrets are deliberately left out; the client identity is the
+// one the host verified, which the enclave echoes in the response's
+// ClientID so that clients can recompute the digest.
+func AttestationRequestDigest(req WASMRequest) ([32]byte, error) {
+	encoded, err := json.Marshal(struct {
+		Type         string      `json:"type,omitempty"`
+		ClientID     string      `json:"client_id,omitempty"`
+		SessionID    string      `json:"session_id,omitempty"`
+		WASMCode     string      `json:"wasm_code"`
+		FunctionName string      `json:"function_name"`
+		Args         []int32     `json:"args"`
+		TypedArgs    []Value     `json:"typed_args,omitempty"`
+		ResultType   string      `json:"result_type,omitempty"`
+		ResultSpec   *ResultSpec `json:"result_spec,omitempty"`
+		Calls        []Call      `json:"calls,omitempty"`
+	}{req.Type, req.ClientID, req.SessionID, req.WASMCode, req.FunctionName, req.Args, req.TypedArgs, req.ResultType, req.ResultSpec, req.Calls})
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("failed to encode request digest: %v", err)
+	}
+	return sha256.Sum256(encoded), nil
+}
+
+// AttestationResultDigest hashes the outcome reported in a response, as the
+// second half of the user_data of an execution's attestation document
+func AttestationResultDigest(response WASMResponse) ([32]byte, error) {
+	encoded, err := json.Marshal(struct {
+		Result      int32          `json:"result"`
+		ResultValue *Value         `json:"result_value,omitempty"`
+		Error       string         `json:"error,omitempty"`
+		Results     []CallResponse `json:"results,omitempty"`
+		SessionID   string         `json:"session_id,omitempty"`
+		Fetches     []Fetch        `json:"fetches,omitempty"`
+	}{response.Result, response.ResultValue, response.Error, response.Results, response.SessionID, response.Fetches})
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("failed to encode result digest: %v", err)
+	}
+	return sha256.Sum256(encoded), nil
+}
+
+// ReplicaIDSeparator separates the replica a host with several enclaves
+// prefixes to the session and job IDs it hands out from the enclave's own ID
+const ReplicaIDSeparator = ":"
+
+// EnclaveID returns a session or job ID as its enclave made and attested it,
+// without the prefix of a host with several replicas. Enclave IDs are hex,
+// so they never contain the separator.
+func EnclaveID(id string) string {
+	if _, own, ok := strings.Cut(id, ReplicaIDSeparator); ok {
+		return own
+	}
+	return id
+}
@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// auditDomain keeps audit log signatures from being valid for anything else
+const auditDomain = "hello-wasm-nitro audit log v1\x00"
+
+// Outcomes of an audited execution
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditEntry records one execution inside the enclave. Arguments are only
+// hashed, so the log can be handed to auditors without the inputs; whoever
+// holds them can check them against ArgsHash.
+type AuditEntry struct {
+	Sequence   uint64   `json:"sequence"`             // Counts executions since the enclave started
+	Timestamp  int64    `json:"timestamp"`            // Unix milliseconds on the enclave clock
+	ModuleHash string   `json:"module_hash"`          // As in receipts
+	Functions  []string `json:"functions"`            // The functions called, in order
+	ArgsHash   string   `json:"args_hash"`            // Hex SHA-256 of the JSON array of each call's typed arguments
+	ClientID   string   `json:"client_id,omitempty"`  // Identity the host verified
+	Tenant     string   `json:"tenant,omitempty"`     // Tenant the host put the client in
+	SessionID  string   `json:"session_id,omitempty"` // For calls against a session
+	Outcome    string   `json:"outcome"`
+	ErrorCode  string   `json:"error_code,omitempty"` // Why a failure happened, when it was a limit or policy
+}
+
+// AuditLog is the answer to an audit_log request: the executions the enclave
+// still holds, oldest first, signed with the receipt signing key. Entries
+// before FirstSequence were dropped to bound the enclave's memory.
+type AuditLog struct {
+	Entries       []AuditEntry `json:"entries"`
+	FirstSequence uint64       `json:"first_sequence"` // Sequence of the oldest entry held, or of the next when none are
+	Timestamp     int64        `json:"timestamp"`      // When the log was exported
+	Signature     string       `json:"signature"`      // Base64 Ed25519 signature of Digest
+}
+
+// ArgsHash hashes the arguments of calls for an AuditEntry
+func ArgsHash(calls []Call) string {
+	args := make([][]Value, len(calls))
+	for i, call := range calls {
+		args[i] = call.Values()
+	}
+	// Values always encode
+	encoded, _ := json.Marshal(args)
+	digest := sha256.Sum256(encoded)
+	return hex.EncodeToString(digest[:])
+}
+
+// Digest is what the enclave signs: SHA-256 over a domain prefix and the
+// JSON encoding of the log with an empty signature
+func (l AuditLog) Digest() ([32]byte, error) {
+	l.Signature = ""
+	encoded, err := json.Marshal(l)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("failed to encode audit log: %v", err)
+	}
+	return sha256.Sum256(append([]byte(auditDomain), encoded...)), nil
+}
+
+// Verify checks the log's signature against a PKIX DER Ed25519 public key
+func (l AuditLog) Verify(publicKeyDER []byte) error {
+	digest, err := l.Digest()
+	if err != nil {
+		return err
+	}
+	return verifySignature("audit log", publicKeyDER, l.Signature, digest)
+}
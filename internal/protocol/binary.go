@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// Framed connections carry the module in a request's wasm_code, and the one
+// in a response's precompiled, as raw bytes beside the JSON (see
+// internal/wire). Only base64 that encodes back to the very same string is
+// detached, since module hashes and receipts are taken over wasm_code as the
+// client sent it; WAT text and other spellings stay in the JSON.
+
+// DetachBinary returns r without wasm_code and the module bytes it encoded
+func (r WASMRequest) DetachBinary() (interface{}, []byte, bool) {
+	module, ok := canonicalBase64(r.WASMCode)
+	if !ok {
+		return r, nil, false
+	}
+	r.WASMCode = ""
+	return r, module, true
+}
+
+// AttachBinary puts back the wasm_code DetachBinary took out
+func (r *WASMRequest) AttachBinary(module []byte) {
+	r.WASMCode = base64.StdEncoding.EncodeToString(module)
+}
+
+// DetachBinary returns r without precompiled and the module bytes it encoded
+func (r WASMResponse) DetachBinary() (interface{}, []byte, bool) {
+	module, ok := canonicalBase64(r.Precompiled)
+	if !ok {
+		return r, nil, false
+	}
+	r.Precompiled = ""
+	return r, module, true
+}
+
+// AttachBinary puts back the precompiled module DetachBinary took out
+func (r *WASMResponse) AttachBinary(module []byte) {
+	r.Precompiled = base64.StdEncoding.EncodeToString(module)
+}
+
+// canonicalBase64 decodes s if it is padded standard base64 that re-encodes
+// to s itself. Strict decoding rejects stray bits in the padding, but still
+// skips line breaks, so those are ruled out first.
+func canonicalBase64(s string) ([]byte, bool) {
+	if s == "" || strings.ContainsAny(s, "\r\n") {
+		return nil, false
+	}
+	decoded, err := base64.StdEncoding.Strict().DecodeString(s)
+	if err != nil {
+		return nil, false
+	}
+	return decoded, true
+}
@@ -0,0 +1,87 @@
+package protocol
+
+import "fmt"
+
+// MaxCalls is the most function calls one request may batch
+const MaxCalls = 1024
+
+// Call is one function call in a batch. All calls of a request run in order
+// against a single instance of the module, so they share its memory and
+// globals as well as the request's time and fuel budget. A call names an
+// exported function, or else the element of an exported table of functions
+// to call, as call_indirect would.
+type Call struct {
+	FunctionName string      `json:"function_name"`
+	Table        string      `json:"table,omitempty"`
+	TableIndex   uint32      `json:"table_index,omitempty"`
+	Args         []int32     `json:"args,omitempty"`
+	TypedArgs    []Value     `json:"typed_args,omitempty"`
+	ResultType   string      `json:"result_type,omitempty"`
+	ResultSpec   *ResultSpec `json:"result_spec,omitempty"`
+}
+
+// CallResponse is the outcome of one call in a batch. A call that fails does
+// not stop the calls after it, unless it ran into a request limit.
+type CallResponse struct {
+	Result      int32  `json:"result"`
+	ResultValue *Value `json:"result_value,omitempty"`
+	Error       string `json:"error,omitempty"`
+	ErrorCode   string `json:"error_code,omitempty"`
+}
+
+// Target names what the call calls, as receipts, audit entries and
+// policies on function names see it: the function name, or TABLE[INDEX]
+func (c Call) Target() string {
+	if c.Table != "" {
+		return fmt.Sprintf("%s[%d]", c.Table, c.TableIndex)
+	}
+	return c.FunctionName
+}
+
+// Values returns the call's arguments as typed values
+func (c Call) Values() []Value {
+	if len(c.TypedArgs) > 0 {
+		return c.TypedArgs
+	}
+	return I32Values(c.Args)
+}
+
+// Result returns how the call's result should be read
+func (c Call) Result() ResultSpec {
+	if c.ResultSpec != nil {
+		return *c.ResultSpec
+	}
+	return ResultSpec{Type: c.ResultType}
+}
+
+func (c Call) validate() error {
+	if c.FunctionName == "" && c.Table == "" {
+		return fmt.Errorf("function_name is required")
+	}
+	if c.FunctionName != "" && c.Table != "" {
+		return fmt.Errorf("set only one of function_name and table")
+	}
+	if c.Table == "" && c.TableIndex != 0 {
+		return fmt.Errorf("table_index needs a table")
+	}
+	if len(c.Args) > 0 && len(c.TypedArgs) > 0 {
+		return fmt.Errorf("set only one of args and typed_args")
+	}
+	for i, arg := range c.TypedArgs {
+		if _, err := arg.Decode(); err != nil {
+			return fmt.Errorf("typed_args[%d]: %v", i, err)
+		}
+	}
+	if !validResultType(c.ResultType) {
+		return fmt.Errorf("unknown result_type %q", c.ResultType)
+	}
+	if c.ResultSpec != nil {
+		if c.ResultType != "" {
+			return fmt.Errorf("set only one of result_type and result_spec")
+		}
+		if err := c.ResultSpec.validate(); err != nil {
+			return fmt.Errorf("result_spec: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package protocol
+
+// Kinds of ModuleItem
+const (
+	ItemFunc   = "func"
+	ItemGlobal = "global"
+	ItemMemory = "memory"
+	ItemTable  = "table"
+)
+
+// ModuleDescription is the answer to a describe_module request: what a
+// module imports and exports, with their types. Memory and table limits are
+// those the module runs with, after the enclave's caps were applied.
+type ModuleDescription struct {
+	ModuleHash string       `json:"module_hash"` // Hex SHA-256 of wasm_code
+	Imports    []ModuleItem `json:"imports"`
+	Exports    []ModuleItem `json:"exports"`
+}
+
+// ModuleItem is one import or export of a module. Value types are the
+// ValueTypes names for numbers and references, and v128 for vectors.
+type ModuleItem struct {
+	Module   string   `json:"module,omitempty"` // Module an import comes from
+	Name     string   `json:"name"`
+	Kind     string   `json:"kind"`              // One of the Item kinds
+	Params   []string `json:"params,omitempty"`  // Parameter types of a function
+	Results  []string `json:"results,omitempty"` // Result types of a function
+	Type     string   `json:"type,omitempty"`    // Value type of a global, or element type of a table
+	Mutable  bool     `json:"mutable,omitempty"` // The global may be set
+	Min      uint64   `json:"min,omitempty"`     // Initial pages of a memory, or elements of a table
+	Max      *uint64  `json:"max,omitempty"`     // Most pages or elements it may grow to, when bounded
+	Memory64 bool     `json:"memory64,omitempty"`
+}
@@ -0,0 +1,15 @@
+package protocol
+
+// WebAssembly proposals a request may ask the enclave to enable in
+// WASMRequest.Features. The enclave reports which it enables by default and
+// which requests may add in its HealthStatus.
+const (
+	FeatureSIMD           = "simd"
+	FeatureBulkMemory     = "bulk_memory"
+	FeatureReferenceTypes = "reference_types"
+	FeatureMultiValue     = "multi_value"
+	FeatureMultiMemory    = "multi_memory"
+	FeatureThreads        = "threads"
+	FeatureMemory64       = "memory64"
+	FeatureTailCall       = "tail_call"
+)
@@ -0,0 +1,10 @@
+package protocol
+
+// Fetch records an HTTPS GET a module made through env.http_get. Fetches
+// are part of the result an attestation document covers, so a verifier
+// learns which external data a result was computed from.
+type Fetch struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"` // Hex SHA-256 of the body
+	Bytes  int    `json:"bytes"`
+}
@@ -0,0 +1,124 @@
+package protocol
+
+// ProtocolVersion is the version of these messages. A peer that does not
+// answer hello requests predates the handshake and counts as version 0,
+// which executes single calls with i32 arguments and nothing else for sure.
+//
+// From version 2, requests declare the version they are written in, and a
+// request of version 2 or later is decoded strictly: a field the peer does
+// not know is refused rather than dropped, so that a typo, or a field newer
+// than the peer, fails with an error naming it instead of meaning nothing.
+// Clients write requests in the version they negotiated, so older peers
+// decode them as they always did. Responses are decoded leniently, so peers
+// may add fields to them.
+const ProtocolVersion = 2
+
+// StrictVersion is the first version whose requests are decoded strictly
+const StrictVersion = 2
+
+// Operations a peer reports in Capabilities, beyond executing single calls
+const (
+	OperationBatch    = "batch"    // Several calls against one instance
+	OperationSessions = "sessions" // create_session, call_session and destroy_session
+	OperationMemory   = "memory"   // read_memory and write_memory of a session
+	OperationGlobals  = "globals"  // get_global and set_global of a session
+	OperationJobs     = "jobs"     // submit_job, job_status, job_result and cancel_job
+	OperationRegister = "register" // Modules registered over gRPC or HTTP and run by module_id
+	OperationOCI      = "oci"      // Modules named by module_ref, pulled from OCI registries by the host
+	// Precompiled modules are accepted as wasm_code; any peer answers
+	// precompile requests, but enclaves only run the results under a
+	// module policy
+	OperationPrecompiled = "precompiled"
+	// upload_begin, upload_chunk and upload_commit, and modules run by
+	// upload_id
+	OperationUploads = "uploads"
+	// stream_emits is understood: what modules pass to env.emit arrives in
+	// progress responses ahead of the result
+	OperationEmits = "emits"
+	// progress_interval_ms is understood: executions report their elapsed
+	// time and fuel in progress responses while they run
+	OperationProgress = "progress"
+	// secret_list is understood; a peer without it drops the list, and
+	// with it the bindings
+	OperationBoundSecrets = "bound_secrets"
+)
+
+// RequestTypes are the request types of this protocol version, besides
+// execution
+var RequestTypes = []string{
+	RequestTypeHello,
+	RequestTypePing,
+	RequestTypeHealth,
+	RequestTypePublicKey,
+	RequestTypeSigningKey,
+	RequestTypeTLSCertificate,
+	RequestTypeCreateSession,
+	RequestTypeCallSession,
+	RequestTypeDestroySession,
+	RequestTypeReadMemory,
+	RequestTypeWriteMemory,
+	RequestTypeGetGlobal,
+	RequestTypeSetGlobal,
+	RequestTypeSubmitJob,
+	RequestTypeJobStatus,
+	RequestTypeJobResult,
+	RequestTypeCancelJob,
+	RequestTypePrecompile,
+	RequestTypeDescribeModule,
+	RequestTypeUploadBegin,
+	RequestTypeUploadChunk,
+	RequestTypeUploadCommit,
+	RequestTypeAuditLog,
+	RequestTypeModuleMeasurements,
+}
+
+// ValueTypes are the types of typed arguments and results of this protocol
+// version
+var ValueTypes = []string{ValueI32, ValueI64, ValueF32, ValueF64, ValueString, ValueBytes, ValueExternref, ValueFuncref}
+
+// Capabilities is the answer to a hello request: what the peer speaks, so a
+// client can avoid what it does not support instead of failing on it. A host
+// answers for itself and nests the enclave's answer in Enclave.
+type Capabilities struct {
+	ProtocolVersion      int           `json:"protocol_version"`
+	RequestTypes         []string      `json:"request_types"`                    // Request types answered besides execution
+	ValueTypes           []string      `json:"value_types"`                      // Types of typed_args and results
+	Operations           []string      `json:"operations,omitempty"`             // Available operations beyond single calls
+	MaxCalls             int           `json:"max_calls,omitempty"`              // Largest batch
+	WasmtimeVersion      string        `json:"wasmtime_version,omitempty"`       // Reported by enclaves
+	WasmFeatures         []string      `json:"wasm_features,omitempty"`          // WebAssembly features every execution runs with
+	OptionalWasmFeatures []string      `json:"optional_wasm_features,omitempty"` // Features requests may add
+	EngineHash           string        `json:"engine_hash,omitempty"`            // Engine hash of precompiled modules run with the default features and limits
+	PreloadedModules     []string      `json:"preloaded_modules,omitempty"`      // Modules requests may run by module_name
+	ModuleUploadDisabled bool          `json:"module_upload_disabled,omitempty"` // Only preloaded modules run
+	Runtime              string        `json:"runtime,omitempty"`                // Engine running executions, reported by enclaves
+	SelfTest             *SelfTest     `json:"self_test,omitempty"`              // How the enclave's startup checks went; nil when it ran none
+	Enclave              *Capabilities `json:"enclave,omitempty"`                // What the enclave behind a host speaks; nil when it predates the handshake
+}
+
+// Supports reports whether the peer offers an operation
+func (c *Capabilities) Supports(operation string) bool {
+	for _, op := range c.Operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeStrictly reports whether the request declares a version whose
+// requests refuse unknown fields
+func (r *WASMRequest) DecodeStrictly() bool {
+	return r.ProtocolVersion >= StrictVersion
+}
+
+// NegotiatedVersion is the protocol version both sides speak
+func (c *Capabilities) NegotiatedVersion() int {
+	if c == nil {
+		return 0
+	}
+	if c.ProtocolVersion < ProtocolVersion {
+		return c.ProtocolVersion
+	}
+	return ProtocolVersion
+}
@@ -0,0 +1,47 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+)
+
+// ModuleMeasurements lists the modules an enclave extended into a PCR
+// since it started, in the order each first ran. Attestation documents
+// carry that PCR, so a verifier who checks one against ReplayPCR(Modules)
+// knows the enclave ran these modules and no others.
+type ModuleMeasurements struct {
+	PCR     int      `json:"pcr"`
+	Modules []string `json:"modules"` // Hex SHA-256 of each module's wasm_code, as in receipts
+	Value   string   `json:"value"`   // Hex PCR value after the last extend
+}
+
+// ReplayPCR computes the value of a PCR that starts zeroed and is
+// extended with each module digest in turn. The NSM's PCRs are SHA-384,
+// and extending sets a PCR to SHA-384(PCR || data).
+func ReplayPCR(modules []string) ([]byte, error) {
+	value := make([]byte, sha512.Size384)
+	for _, module := range modules {
+		digest, err := hex.DecodeString(module)
+		if err != nil || len(digest) != 32 {
+			return nil, fmt.Errorf("module %q is not a SHA-256 digest", module)
+		}
+		sum := sha512.Sum384(append(value, digest...))
+		value = sum[:]
+	}
+	return value, nil
+}
+
+// Verify checks that Value is what the listed modules extend the PCR to
+func (m *ModuleMeasurements) Verify() error {
+	replayed, err := ReplayPCR(m.Modules)
+	if err != nil {
+		return err
+	}
+	value, err := hex.DecodeString(m.Value)
+	if err != nil || !bytes.Equal(value, replayed) {
+		return fmt.Errorf("PCR%d value does not match the %d modules listed", m.PCR, len(m.Modules))
+	}
+	return nil
+}
@@ -0,0 +1,611 @@
+// Package protocol defines the messages exchanged between the client, the
+// host and the enclave, so all three binaries agree on one definition.
+// Messages travel as JSON, either as a newline-delimited stream or inside
+// frames (see internal/wire), where modules may travel as raw bytes beside
+// it (see binary.go).
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"hello-wasm-enclave/internal/sigv4"
+)
+
+const (
+	// EnclavePort is the vsock port the enclave listens on
+	EnclavePort = 8080
+	// HostPort is the TCP port of the host's JSON listener
+	HostPort = 8081
+	// EnclaveTLSPort is the vsock port of the enclave's end-to-end TLS
+	// listener, which speaks this protocol inside a TLS session
+	EnclaveTLSPort = 8443
+	// EnclaveLogPort is the vsock port where the enclave serves its log
+	// records to the host
+	EnclaveLogPort = 8090
+
+	// RequestTypeExecute (the empty type) runs a function
+	RequestTypeExecute = ""
+	// RequestTypePing asks the enclave to answer immediately without executing anything
+	RequestTypePing = "ping"
+	// RequestTypePublicKey asks the enclave for its attested secrets encryption key
+	RequestTypePublicKey = "public_key"
+	// RequestTypeSigningKey asks the enclave for its attested receipt signing key
+	RequestTypeSigningKey = "signing_key"
+	// RequestTypeTLSCertificate asks the enclave for the attested certificate
+	// of its TLS listener
+	RequestTypeTLSCertificate = "tls_certificate"
+	// RequestTypeHealth asks the enclave to report its HealthStatus
+	RequestTypeHealth = "health"
+	// RequestTypeHello opens a connection by exchanging protocol versions;
+	// the answer carries the peer's Capabilities
+	RequestTypeHello = "hello"
+	// RequestTypeCreateSession instantiates a module and keeps it alive,
+	// answering with a SessionID
+	RequestTypeCreateSession = "create_session"
+	// RequestTypeCallSession runs calls against a session's instance
+	RequestTypeCallSession = "call_session"
+	// RequestTypeDestroySession ends a session
+	RequestTypeDestroySession = "destroy_session"
+	// RequestTypeReadMemory copies a range of an exported memory of a
+	// session's instance into Data
+	RequestTypeReadMemory = "read_memory"
+	// RequestTypeWriteMemory copies Data into an exported memory of a
+	// session's instance
+	RequestTypeWriteMemory = "write_memory"
+	// RequestTypeGetGlobal reads an exported global of a session's instance
+	// into ResultValue
+	RequestTypeGetGlobal = "get_global"
+	// RequestTypeSetGlobal sets an exported mutable global of a session's
+	// instance to GlobalValue
+	RequestTypeSetGlobal = "set_global"
+	// RequestTypeSubmitJob starts an execution in the background, answering
+	// at once with a JobID to poll instead of the result
+	RequestTypeSubmitJob = "submit_job"
+	// RequestTypeJobStatus reports how far a job has got
+	RequestTypeJobStatus = "job_status"
+	// RequestTypeJobResult answers with the response of a finished job, as
+	// an execute request would have
+	RequestTypeJobResult = "job_result"
+	// RequestTypeCancelJob stops a job that is still running
+	RequestTypeCancelJob = "cancel_job"
+	// RequestTypePrecompile compiles a module without running it, answering
+	// with the Precompiled module for later requests to send as wasm_code
+	RequestTypePrecompile = "precompile"
+	// RequestTypeDescribeModule compiles a module without running it,
+	// answering with its ModuleDescription
+	RequestTypeDescribeModule = "describe_module"
+	// RequestTypeUploadBegin starts sending a module too large for one
+	// request in chunks: UploadSize bytes of wasm_code whose hex SHA-256 is
+	// ModuleHash. It answers with the UploadID the chunks go to.
+	RequestTypeUploadBegin = "upload_begin"
+	// RequestTypeUploadChunk adds Data, the next piece of wasm_code, at
+	// Offset of an upload
+	RequestTypeUploadChunk = "upload_chunk"
+	// RequestTypeUploadCommit checks that an upload arrived whole and with
+	// its digest, after which requests run the module by upload_id
+	RequestTypeUploadCommit = "upload_commit"
+	// RequestTypeAuditLog asks the enclave for its signed AuditLog of
+	// executions
+	RequestTypeAuditLog = "audit_log"
+	// RequestTypeModuleMeasurements asks the enclave for the
+	// ModuleMeasurements of the modules it ran
+	RequestTypeModuleMeasurements = "module_measurements"
+
+	// Operator requests, which the host only makes for its admin API; see
+	// AdminRequestTypes
+	//
+	// RequestTypeListModules asks the enclave for the CachedModules it
+	// holds compiled
+	RequestTypeListModules = "list_modules"
+	// RequestTypeEvictModules drops the compiled modules of ModuleHash, or
+	// all of them, from the enclave's cache
+	RequestTypeEvictModules = "evict_modules"
+	// RequestTypeListSessions asks the enclave for the Sessions alive in it
+	RequestTypeListSessions = "list_sessions"
+	// RequestTypeKillSession ends the session of SessionHandle, whichever
+	// tenant it belongs to
+	RequestTypeKillSession = "kill_session"
+	// RequestTypeRotateSigningKey replaces the receipt signing key with a
+	// new one, answered as a signing_key request would
+	RequestTypeRotateSigningKey = "rotate_signing_key"
+
+	// Formats of WASMRequest.WASMCode
+	FormatModule    = "module"
+	FormatComponent = "component"
+
+	// Error codes reported in WASMResponse.ErrorCode
+	ErrorCodeTimeout           = "timeout"
+	ErrorCodeFuelExhausted     = "fuel_exhausted"
+	ErrorCodeResourceLimit     = "resource_limit_exceeded"
+	ErrorCodePolicy            = "policy_violation"
+	ErrorCodeOverloaded        = "overloaded"
+	ErrorCodeRateLimited       = "rate_limited"
+	ErrorCodeUnauthenticated   = "unauthenticated"
+	ErrorCodeForbidden         = "forbidden"
+	ErrorCodeShuttingDown      = "shutting_down"
+	ErrorCodeInvalidRequest    = "invalid_request"
+	ErrorCodeRequestTooLarge   = "request_too_large"
+	ErrorCodeCancelled         = "cancelled"
+	ErrorCodeSecretMissing     = "secret_missing"
+	ErrorCodeJobRunning        = "job_running"
+	ErrorCodeModuleUnavailable = "module_unavailable"
+	ErrorCodeInternal          = "internal_error"
+	// The host fails requests with ErrorCodeEnclaveUnavailable without
+	// trying while too many in a row failed to reach the enclave
+	ErrorCodeEnclaveUnavailable = "enclave_unavailable"
+
+	// MaxMemoryAccess bounds the bytes one read_memory or write_memory
+	// request moves; larger buffers are moved in chunks
+	MaxMemoryAccess = 1 << 20
+
+	// MaxUploadChunk bounds the bytes of wasm_code one upload_chunk
+	// request adds
+	MaxUploadChunk = 1 << 20
+
+	// MaxIdempotencyKey bounds the length of an idempotency_key
+	MaxIdempotencyKey = 256
+
+	// MinProgressIntervalMS bounds how often an execution reports progress
+	MinProgressIntervalMS = 100
+
+	// States of a job in JobStatus.State
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// WASMRequest represents a request to execute WASM code. Clients fill in the
+// execution and secret fields; SecretRefs are replaced by the host with
+// KMSSecrets and KMSEncryptionContexts, and AWSCredentials are attached by
+// the host, before the request reaches the enclave.
+type WASMRequest struct {
+	Type                  string                       `json:"type,omitempty"`                    // Request kind; empty means execute
+	ProtocolVersion       int                          `json:"protocol_version,omitempty"`        // Version the request is written in; in hello requests, the highest the client speaks
+	RequestID             string                       `json:"request_id,omitempty"`              // Correlates the response with this request
+	SessionID             string                       `json:"session_id,omitempty"`              // Session to call, destroy, or read or write the memory of
+	Memory                string                       `json:"memory,omitempty"`                  // Exported memory a read_memory or write_memory request accesses; "memory" if empty
+	Offset                uint64                       `json:"offset,omitempty"`                  // Byte offset into the memory, or into the wasm_code of an upload
+	Length                uint32                       `json:"length,omitempty"`                  // Bytes read_memory copies out
+	Data                  string                       `json:"data,omitempty"`                    // Base64 bytes write_memory copies in, or the piece of wasm_code an upload_chunk request adds
+	Global                string                       `json:"global,omitempty"`                  // Exported global a get_global or set_global request accesses
+	GlobalValue           *Value                       `json:"global_value,omitempty"`            // Value set_global sets the global to
+	JobID                 string                       `json:"job_id,omitempty"`                  // Job to report on or cancel
+	Callback              string                       `json:"callback,omitempty"`                // HTTPS URL or SQS queue ARN the host delivers the result of a submit_job request to; removed by the host
+	CorrelationID         string                       `json:"correlation_id,omitempty"`          // Tags log lines for this request; the host assigns one if unset
+	TraceParent           string                       `json:"traceparent,omitempty"`             // W3C trace context of the caller's span, for the host to trace the request under
+	ClientID              string                       `json:"client_id,omitempty"`               // Verified identity of the client, set by the host from its TLS certificate or API token
+	Tenant                string                       `json:"tenant,omitempty"`                  // Tenant of the client, set by the host from its API token; sessions, jobs, cached modules and secret policies are kept apart by tenant
+	Enclave               string                       `json:"enclave,omitempty"`                 // Enclave of a multi-enclave host to run on; routed by module if empty, and removed by the host
+	AuthToken             string                       `json:"auth_token,omitempty"`              // API token; checked and removed by the host
+	WASMCode              string                       `json:"wasm_code"`                         // WAT text with template variables
+	ModuleName            string                       `json:"module_name,omitempty"`             // Module preloaded into the enclave, instead of wasm_code
+	ModuleRef             string                       `json:"module_ref,omitempty"`              // OCI artifact reference, such as ghcr.io/org/module:tag, of a module the host pulls into wasm_code, instead of sending it
+	ModuleHash            string                       `json:"module_hash,omitempty"`             // Module whose compiled copies an evict_modules request drops, all if empty; or the digest an upload must have
+	UploadID              string                       `json:"upload_id,omitempty"`               // Upload a chunk or commit goes to, or a committed one to run instead of wasm_code
+	UploadSize            uint64                       `json:"upload_size,omitempty"`             // Bytes of wasm_code an upload_begin request announces
+	SessionHandle         string                       `json:"session_handle,omitempty"`          // Session a kill_session request ends, as list_sessions reports it
+	Format                string                       `json:"format,omitempty"`                  // module (the default) or component
+	Deterministic         bool                         `json:"deterministic,omitempty"`           // The result depends only on the request, so the host may answer it from its cache
+	StreamEmits           bool                         `json:"stream_emits,omitempty"`            // Send what the module passes to env.emit as progress responses while it runs
+	ProgressIntervalMS    int64                        `json:"progress_interval_ms,omitempty"`    // Send a progress response with the elapsed time and fuel this often while the execution runs
+	IdempotencyKey        string                       `json:"idempotency_key,omitempty"`         // Client-chosen key of an execute or submit_job request; the host answers a retry with the same key with the original response instead of running it again
+	ModuleSignature       string                       `json:"module_signature,omitempty"`        // Base64 Ed25519 signature of wasm_code by its publisher
+	FunctionName          string                       `json:"function_name"`                     // Function to call in the WASM module
+	Args                  []int32                      `json:"args"`                              // Arguments to pass to the function
+	TypedArgs             []Value                      `json:"typed_args,omitempty"`              // Typed arguments, instead of args
+	ResultType            string                       `json:"result_type,omitempty"`             // Expected result type; string and bytes are returned as a (pointer, length) pair
+	ResultSpec            *ResultSpec                  `json:"result_spec,omitempty"`             // How to read the result, instead of result_type
+	Calls                 []Call                       `json:"calls,omitempty"`                   // Several calls against one instance, instead of function_name and its arguments
+	TimeoutMS             int64                        `json:"timeout_ms,omitempty"`              // Execution time limit; the enclave default applies when zero
+	MaxFuel               uint64                       `json:"max_fuel,omitempty"`                // Instruction budget; requires fuel metering in the enclave
+	MaxMemoryPages        uint32                       `json:"max_memory_pages,omitempty"`        // Linear memory limit in 64 KiB pages, below the enclave cap
+	MaxTableElements      uint32                       `json:"max_table_elements,omitempty"`      // Table size limit, below the enclave cap
+	Features              []string                     `json:"features,omitempty"`                // WebAssembly features to enable beyond the enclave defaults
+	Secrets               map[string]string            `json:"secrets"`                           // Secret values to inject into template
+	SecretList            []Secret                     `json:"secret_list,omitempty"`             // Secrets bound to a module, alongside the maps
+	EncryptedSecrets      string                       `json:"encrypted_secrets,omitempty"`       // Secrets sealed to the enclave public key (base64)
+	KMSSecrets            map[string]string            `json:"kms_secrets,omitempty"`             // Base64 KMS ciphertexts decrypted inside the enclave
+	SecretRefs            map[string]string            `json:"secret_refs,omitempty"`             // Secrets Manager / SSM ARNs the host resolves into KMS secrets
+	KMSEncryptionContexts map[string]map[string]string `json:"kms_encryption_contexts,omitempty"` // Optional KMS encryption context per KMS secret
+	AWSCredentials        *sigv4.Credentials           `json:"aws_credentials,omitempty"`         // Attached by the host for KMS calls
+	Attest                bool                         `json:"attest,omitempty"`                  // Return an NSM attestation document binding request and result
+	Nonce                 string                       `json:"nonce,omitempty"`                   // Base64 nonce to embed in the attestation document
+}
+
+// WASMResponse represents the response from WASM execution
+type WASMResponse struct {
+	RequestID       string             `json:"request_id,omitempty"`
+	CorrelationID   string             `json:"correlation_id,omitempty"`
+	SessionID       string             `json:"session_id,omitempty"` // The session a create_session request started
+	JobID           string             `json:"job_id,omitempty"`     // The job a submit_job request started, or was asked about
+	UploadID        string             `json:"upload_id,omitempty"`  // The upload an upload_begin request started
+	Progress        bool               `json:"progress,omitempty"`   // Not the answer yet: more responses to the request follow
+	Emit            string             `json:"emit,omitempty"`       // Base64 bytes the module passed to env.emit, in a progress response
+	ElapsedMS       int64              `json:"elapsed_ms,omitempty"` // How long the execution has run, in a progress response without emit
+	Enclave         string             `json:"enclave,omitempty"`    // Replica of a multi-enclave host that answered; requests naming it reach the same enclave and keys
+	Result          int32              `json:"result"`
+	ResultValue     *Value             `json:"result_value,omitempty"` // The result when it is not a plain i32
+	Error           string             `json:"error,omitempty"`
+	ErrorCode       string             `json:"error_code,omitempty"`       // Machine-readable reason when a limit stopped execution
+	RetryAfterMS    int64              `json:"retry_after_ms,omitempty"`   // With error_code overloaded: when to try again
+	FuelConsumed    uint64             `json:"fuel_consumed,omitempty"`    // Fuel used when the enclave meters fuel
+	CompileUS       int64              `json:"compile_us,omitempty"`       // Microseconds spent compiling the module
+	ExecuteUS       int64              `json:"execute_us,omitempty"`       // Microseconds spent instantiating and running it
+	Stdout          string             `json:"stdout,omitempty"`           // What a WASI module wrote to stdout
+	Stderr          string             `json:"stderr,omitempty"`           // What a WASI module wrote to stderr
+	OutputTruncated bool               `json:"output_truncated,omitempty"` // Stdout or stderr exceeded the enclave's limit
+	EmitsTruncated  bool               `json:"emits_truncated,omitempty"`  // The module emitted more than the enclave streams; the rest was dropped
+	Results         []CallResponse     `json:"results,omitempty"`          // One per call of a batch request
+	Fetches         []Fetch            `json:"fetches,omitempty"`          // HTTPS responses the module fetched
+	Attestation     string             `json:"attestation,omitempty"`      // Base64 CBOR attestation document, if requested
+	ClientID        string             `json:"client_id,omitempty"`        // With an attestation: the client identity the request was attested with
+	PublicKey       string             `json:"public_key,omitempty"`       // Base64 DER enclave public key for encrypting secrets
+	SigningKey      string             `json:"signing_key,omitempty"`      // Base64 DER Ed25519 key that signs receipts
+	TLSCertificate  string             `json:"tls_certificate,omitempty"`  // Base64 DER certificate of the enclave TLS listener
+	Receipt         *Receipt           `json:"receipt,omitempty"`          // Signed record of what was computed
+	Health          *HealthStatus      `json:"health,omitempty"`           // Answer to a health request
+	Hello           *Capabilities      `json:"hello,omitempty"`            // Answer to a hello request
+	Job             *JobStatus         `json:"job,omitempty"`              // Answer to a job_status or cancel_job request
+	Data            string             `json:"data,omitempty"`             // Base64 bytes a read_memory request copied out
+	MemorySize      uint64             `json:"memory_size,omitempty"`      // Bytes in the memory a read_memory or write_memory request accessed
+	Received        uint64             `json:"received,omitempty"`         // Bytes of wasm_code an upload holds after a chunk
+	Precompiled     string             `json:"precompiled,omitempty"`      // Base64 module a precompile request produced
+	Module          *ModuleDescription `json:"module,omitempty"`           // Answer to a describe_module request
+	Cached          bool               `json:"cached,omitempty"`           // Answered by the host from its cache of deterministic results
+	Replayed        bool               `json:"replayed,omitempty"`         // The response to an earlier request with the same idempotency_key
+	Metadata        *Metadata          `json:"metadata,omitempty"`         // How the execution went, phase by phase
+	EngineHash      string             `json:"engine_hash,omitempty"`      // Hex hash of the engine and limits it needs
+	AuditLog        *AuditLog          `json:"audit_log,omitempty"`        // Answer to an audit_log request
+	// Answer to a module_measurements request
+	ModuleMeasurements *ModuleMeasurements `json:"module_measurements,omitempty"`
+	// Answer to a list_modules request
+	CachedModules []CachedModule `json:"cached_modules,omitempty"`
+	// Answer to a list_sessions request
+	Sessions []SessionInfo `json:"sessions,omitempty"`
+	// Compiled modules or sessions an evict_modules or kill_session request
+	// dropped
+	Evicted int `json:"evicted,omitempty"`
+	// The enclave's spans of a traced request; the host exports them and
+	// does not pass them on
+	Spans []Span `json:"spans,omitempty"`
+	// Trace the host recorded the request in, as hex
+	TraceID string `json:"trace_id,omitempty"`
+}
+
+// HealthStatus describes a running enclave
+type HealthStatus struct {
+	UptimeSeconds        int64    `json:"uptime_seconds"`
+	WasmtimeVersion      string   `json:"wasmtime_version"`
+	FuelMetering         bool     `json:"fuel_metering"`
+	InFlight             int64    `json:"in_flight"`                        // Executions running or queued right now
+	Workers              int      `json:"workers"`                          // Executions that may run at once
+	Queued               int      `json:"queued"`                           // Executions waiting for a worker
+	Executions           uint64   `json:"executions"`                       // Executions finished since startup
+	Failures             uint64   `json:"failures"`                         // Finished executions that returned an error
+	Sessions             int      `json:"sessions"`                         // Sessions currently alive
+	Jobs                 int      `json:"jobs"`                             // Jobs running or kept for their results
+	PanicsRecovered      uint64   `json:"panics_recovered,omitempty"`       // Requests answered with internal_error after a panic
+	WorkerRestarts       uint64   `json:"worker_restarts,omitempty"`        // Times stuck executions had the workers restarted
+	AllowedModules       int      `json:"allowed_modules,omitempty"`        // Size of the module allowlist
+	TrustedSigners       int      `json:"trusted_signers,omitempty"`        // Keys whose module signatures are accepted
+	WasmFeatures         []string `json:"wasm_features"`                    // WebAssembly features every execution runs with
+	OptionalWasmFeatures []string `json:"optional_wasm_features,omitempty"` // Features requests may add
+	// How the startup checks went; nil when the enclave ran none
+	SelfTest *SelfTest `json:"self_test,omitempty"`
+}
+
+// JobStatus describes a job submitted with submit_job
+type JobStatus struct {
+	State     string `json:"state"`                // One of the Job states
+	ElapsedMS int64  `json:"elapsed_ms"`           // Time since the job was submitted, or that it ran for once finished
+	Error     string `json:"error,omitempty"`      // Why a failed job failed
+	ErrorCode string `json:"error_code,omitempty"` // Machine-readable reason a job failed
+	// Fuel the execution has used so far, when the enclave meters fuel
+	FuelConsumed uint64 `json:"fuel_consumed,omitempty"`
+}
+
+// FunctionCalls returns the calls a request makes: its batch, or the single
+// call described by its top-level fields
+func (r *WASMRequest) FunctionCalls() []Call {
+	if len(r.Calls) > 0 {
+		return r.Calls
+	}
+	return []Call{{
+		FunctionName: r.FunctionName,
+		Args:         r.Args,
+		TypedArgs:    r.TypedArgs,
+		ResultType:   r.ResultType,
+		ResultSpec:   r.ResultSpec,
+	}}
+}
+
+// Validate checks that a request is well formed, returning a
+// ValidationError if not. It does not check anything that depends on the
+// enclave's configuration, such as limit ceilings.
+func (r *WASMRequest) Validate() error {
+	if err := r.validate(); err != nil {
+		return &ValidationError{Code: ErrorCodeInvalidRequest, Message: err.Error()}
+	}
+	return nil
+}
+
+func (r *WASMRequest) validate() error {
+	if err := r.validateSecretList(); err != nil {
+		return err
+	}
+	switch r.Type {
+	case RequestTypeExecute, RequestTypeSubmitJob:
+		if err := r.validateModule(); err != nil {
+			return err
+		}
+		if err := r.validateCalls(); err != nil {
+			return err
+		}
+	case RequestTypePrecompile, RequestTypeDescribeModule:
+		if err := r.validateModule(); err != nil {
+			return err
+		}
+		if r.FunctionName != "" || len(r.Calls) > 0 {
+			return fmt.Errorf("%s does not call functions", r.Type)
+		}
+	case RequestTypeCreateSession:
+		if err := r.validateModule(); err != nil {
+			return err
+		}
+		if r.FunctionName != "" || len(r.Calls) > 0 {
+			return fmt.Errorf("create_session does not call functions; use call_session")
+		}
+	case RequestTypeCallSession:
+		if r.SessionID == "" {
+			return fmt.Errorf("session_id is required")
+		}
+		if r.WASMCode != "" {
+			return fmt.Errorf("call_session runs the session's module; wasm_code must be empty")
+		}
+		if err := r.validateCalls(); err != nil {
+			return err
+		}
+	case RequestTypeDestroySession:
+		if r.SessionID == "" {
+			return fmt.Errorf("session_id is required")
+		}
+	case RequestTypeReadMemory, RequestTypeWriteMemory:
+		if err := r.validateMemoryAccess(); err != nil {
+			return err
+		}
+	case RequestTypeGetGlobal, RequestTypeSetGlobal:
+		if err := r.validateGlobalAccess(); err != nil {
+			return err
+		}
+	case RequestTypeJobStatus, RequestTypeJobResult, RequestTypeCancelJob:
+		if r.JobID == "" {
+			return fmt.Errorf("job_id is required")
+		}
+	case RequestTypeUploadBegin, RequestTypeUploadChunk, RequestTypeUploadCommit:
+		if err := r.validateUpload(); err != nil {
+			return err
+		}
+	case RequestTypeKillSession:
+		if r.SessionHandle == "" {
+			return fmt.Errorf("session_handle is required")
+		}
+	case RequestTypeHello, RequestTypePing, RequestTypePublicKey, RequestTypeSigningKey, RequestTypeTLSCertificate, RequestTypeHealth, RequestTypeAuditLog, RequestTypeModuleMeasurements:
+	case RequestTypeListModules, RequestTypeEvictModules, RequestTypeListSessions, RequestTypeRotateSigningKey:
+	default:
+		return fmt.Errorf("unknown request type: %s", r.Type)
+	}
+
+	if r.Callback != "" {
+		if r.Type != RequestTypeSubmitJob {
+			return fmt.Errorf("callback only applies to submit_job")
+		}
+		if !strings.HasPrefix(r.Callback, "https://") && !strings.HasPrefix(r.Callback, "arn:aws:sqs:") {
+			return fmt.Errorf("callback must be an https URL or an SQS queue ARN")
+		}
+	}
+	if r.StreamEmits && r.Type != RequestTypeExecute {
+		return fmt.Errorf("stream_emits only applies to executions; jobs are polled for their result")
+	}
+	if r.ProgressIntervalMS != 0 {
+		if r.Type != RequestTypeExecute {
+			return fmt.Errorf("progress_interval_ms only applies to executions; job_status reports the progress of jobs")
+		}
+		if r.ProgressIntervalMS < MinProgressIntervalMS {
+			return fmt.Errorf("progress_interval_ms must be at least %d", MinProgressIntervalMS)
+		}
+	}
+	if r.IdempotencyKey != "" {
+		if r.Type != RequestTypeExecute && r.Type != RequestTypeSubmitJob {
+			return fmt.Errorf("idempotency_key only applies to executions and submit_job")
+		}
+		if len(r.IdempotencyKey) > MaxIdempotencyKey {
+			return fmt.Errorf("idempotency_key is longer than %d bytes", MaxIdempotencyKey)
+		}
+	}
+	if r.Nonce != "" {
+		if _, err := base64.StdEncoding.DecodeString(r.Nonce); err != nil {
+			return fmt.Errorf("nonce is not valid base64")
+		}
+	}
+	return nil
+}
+
+// validateMemoryAccess checks a read_memory or write_memory request
+func (r *WASMRequest) validateMemoryAccess() error {
+	if r.SessionID == "" {
+		return fmt.Errorf("session_id is required")
+	}
+	if r.WASMCode != "" || r.ModuleName != "" || r.ModuleRef != "" || r.FunctionName != "" || len(r.Calls) > 0 {
+		return fmt.Errorf("%s accesses the session's memory; it sends no module and calls nothing", r.Type)
+	}
+	if r.Type == RequestTypeReadMemory {
+		if r.Data != "" {
+			return fmt.Errorf("read_memory takes a length, not data")
+		}
+		if r.Length == 0 || r.Length > MaxMemoryAccess {
+			return fmt.Errorf("length must be between 1 and %d", MaxMemoryAccess)
+		}
+		return nil
+	}
+	if r.Length != 0 {
+		return fmt.Errorf("write_memory takes data, not a length")
+	}
+	data, err := base64.StdEncoding.DecodeString(r.Data)
+	if err != nil {
+		return fmt.Errorf("data is not valid base64")
+	}
+	if len(data) == 0 || len(data) > MaxMemoryAccess {
+		return fmt.Errorf("data must hold between 1 and %d bytes", MaxMemoryAccess)
+	}
+	return nil
+}
+
+// validateUpload checks an upload_begin, upload_chunk or upload_commit
+// request
+func (r *WASMRequest) validateUpload() error {
+	if r.WASMCode != "" || r.ModuleName != "" || r.ModuleRef != "" || r.FunctionName != "" || len(r.Calls) > 0 {
+		return fmt.Errorf("%s sends wasm_code in chunks and calls nothing", r.Type)
+	}
+	if r.Type == RequestTypeUploadBegin {
+		if r.UploadID != "" {
+			return fmt.Errorf("upload_begin starts an upload; upload_id must be empty")
+		}
+		if digest, err := hex.DecodeString(r.ModuleHash); err != nil || len(digest) != sha256.Size {
+			return fmt.Errorf("module_hash must be the hex SHA-256 of the wasm_code uploaded")
+		}
+		if r.UploadSize == 0 {
+			return fmt.Errorf("upload_size is required")
+		}
+		return nil
+	}
+	if r.UploadID == "" {
+		return fmt.Errorf("upload_id is required")
+	}
+	if r.Type == RequestTypeUploadCommit {
+		if r.Data != "" {
+			return fmt.Errorf("upload_commit takes no data")
+		}
+		return nil
+	}
+	if len(r.Data) == 0 || len(r.Data) > MaxUploadChunk {
+		return fmt.Errorf("data must hold between 1 and %d bytes", MaxUploadChunk)
+	}
+	return nil
+}
+
+// validateGlobalAccess checks a get_global or set_global request
+func (r *WASMRequest) validateGlobalAccess() error {
+	if r.SessionID == "" {
+		return fmt.Errorf("session_id is required")
+	}
+	if r.Global == "" {
+		return fmt.Errorf("global is required")
+	}
+	if r.WASMCode != "" || r.ModuleName != "" || r.ModuleRef != "" || r.FunctionName != "" || len(r.Calls) > 0 {
+		return fmt.Errorf("%s accesses the session's globals; it sends no module and calls nothing", r.Type)
+	}
+	if r.Type == RequestTypeGetGlobal {
+		if r.GlobalValue != nil {
+			return fmt.Errorf("get_global takes no global_value")
+		}
+		return nil
+	}
+	if r.GlobalValue == nil {
+		return fmt.Errorf("global_value is required")
+	}
+	value, err := r.GlobalValue.Decode()
+	if err != nil {
+		return fmt.Errorf("global_value: %v", err)
+	}
+	if _, buffer := value.([]byte); buffer {
+		return fmt.Errorf("global_value must be a number or a reference")
+	}
+	return nil
+}
+
+// validateModule checks the fields that describe a module to instantiate
+func (r *WASMRequest) validateModule() error {
+	sources := 0
+	for _, source := range []string{r.WASMCode, r.ModuleName, r.ModuleRef, r.UploadID} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources == 0 {
+		return fmt.Errorf("wasm_code, module_name, module_ref or upload_id is required")
+	}
+	if sources > 1 {
+		return fmt.Errorf("set only one of wasm_code, module_name, module_ref and upload_id")
+	}
+	switch r.Format {
+	case "", FormatModule, FormatComponent:
+	default:
+		return fmt.Errorf("unknown format %q", r.Format)
+	}
+	if r.TimeoutMS < 0 {
+		return fmt.Errorf("timeout_ms must not be negative")
+	}
+	if r.ModuleSignature != "" {
+		if _, err := base64.StdEncoding.DecodeString(r.ModuleSignature); err != nil {
+			return fmt.Errorf("module_signature is not valid base64")
+		}
+	}
+	if r.EncryptedSecrets != "" {
+		if _, err := base64.StdEncoding.DecodeString(r.EncryptedSecrets); err != nil {
+			return fmt.Errorf("encrypted_secrets is not valid base64")
+		}
+	}
+	for name, ciphertext := range r.KMSSecrets {
+		if _, err := base64.StdEncoding.DecodeString(ciphertext); err != nil {
+			return fmt.Errorf("kms_secrets[%s] is not valid base64", name)
+		}
+	}
+	return nil
+}
+
+// validateCalls checks the single call or the batch a request makes
+func (r *WASMRequest) validateCalls() error {
+	if len(r.Calls) == 0 {
+		return r.FunctionCalls()[0].validate()
+	}
+	if r.FunctionName != "" || len(r.Args) > 0 || len(r.TypedArgs) > 0 || r.ResultType != "" || r.ResultSpec != nil {
+		return fmt.Errorf("calls replaces function_name, args, typed_args, result_type and result_spec")
+	}
+	if len(r.Calls) > MaxCalls {
+		return fmt.Errorf("at most %d calls are allowed, got %d", MaxCalls, len(r.Calls))
+	}
+	for i, call := range r.Calls {
+		if err := call.validate(); err != nil {
+			return fmt.Errorf("calls[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Sources of the module of an execution, in Metadata.ModuleSource
+const (
+	ModuleSourceCompiled    = "compiled"    // Compiled from wasm_code for this request
+	ModuleSourcePrecompiled = "precompiled" // Deserialized from a precompiled module
+	ModuleSourcePreloaded   = "preloaded"   // Compiled when the enclave started
+	ModuleSourceCached      = "cached"      // Compiled for an earlier request with the same module
+	ModuleSourceSession     = "session"     // The session's instance, made when it was created
+)
+
+// Metadata profiles an execution so clients can see where its time went. A
+// response the host answered from its cache carries the metadata of the
+// execution that produced it.
+type Metadata struct {
+	CompileUS     int64  `json:"compile_us"`              // Obtaining the module
+	InstantiateUS int64  `json:"instantiate_us"`          // Instantiating it, including its start function
+	CallUS        int64  `json:"call_us"`                 // Running the calls
+	FuelConsumed  uint64 `json:"fuel_consumed,omitempty"` // When the enclave meters fuel
+	MemoryPages   uint64 `json:"memory_pages"`            // Size of the exported memories afterwards, their peak since memories only grow
+	ModuleSource  string `json:"module_source"`           // Where the module came from
+	// Whether compilation was skipped because a compiled module was at hand
+	ModuleCacheHit bool `json:"module_cache_hit"`
+}
@@ -0,0 +1,74 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// receiptDomain keeps receipt signatures from being valid for anything else
+const receiptDomain = "hello-wasm-nitro receipt v1\x00"
+
+// Receipt is a signed statement from the enclave that a module computed a
+// result. It is signed with an Ed25519 key generated inside the enclave at
+// boot, whose public half the enclave attests on a signing_key request, so a
+// receipt can be checked later by anyone holding that key.
+type Receipt struct {
+	ModuleHash string        `json:"module_hash"` // Hex SHA-256 of wasm_code as sent, before secrets are injected
+	Calls      []ReceiptCall `json:"calls"`
+	Error      string        `json:"error,omitempty"` // Why the calls could not all run
+	Timestamp  int64         `json:"timestamp"`       // Unix milliseconds on the enclave clock
+	Signature  string        `json:"signature"`       // Base64 Ed25519 signature of Digest
+}
+
+// ReceiptCall is one call covered by a receipt
+type ReceiptCall struct {
+	FunctionName string  `json:"function_name"` // Call.Target of the call
+	Args         []Value `json:"args"`
+	Result       *Value  `json:"result,omitempty"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// Digest is what the enclave signs: SHA-256 over a domain prefix and the
+// JSON encoding of the receipt with an empty signature
+func (r Receipt) Digest() ([32]byte, error) {
+	r.Signature = ""
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("failed to encode receipt: %v", err)
+	}
+	return sha256.Sum256(append([]byte(receiptDomain), encoded...)), nil
+}
+
+// Verify checks the receipt's signature against a PKIX DER Ed25519 public key
+func (r Receipt) Verify(publicKeyDER []byte) error {
+	digest, err := r.Digest()
+	if err != nil {
+		return err
+	}
+	return verifySignature("receipt", publicKeyDER, r.Signature, digest)
+}
+
+// verifySignature checks a base64 Ed25519 signature of digest against a PKIX
+// DER public key
+func verifySignature(what string, publicKeyDER []byte, encoded string, digest [32]byte) error {
+	parsed, err := x509.ParsePKIXPublicKey(publicKeyDER)
+	if err != nil {
+		return fmt.Errorf("invalid signing key: %v", err)
+	}
+	publicKey, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("signing key is %T, not Ed25519", parsed)
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%s signature is not valid base64", what)
+	}
+	if !ed25519.Verify(publicKey, digest[:], signature) {
+		return fmt.Errorf("%s signature does not match", what)
+	}
+	return nil
+}
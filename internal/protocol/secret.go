@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// KeySecretPrefix marks a secret as a key for the enclave's crypto host
+// functions: a secret named key:NAME is never injected into the module, which
+// can only use it as key NAME
+const KeySecretPrefix = "key:"
+
+// KMSModuleContextKey is the KMS encryption context key that seals a KMS
+// secret to a module: encrypted under a context mapping it to the hex
+// SHA-256 of a module, the secret only decrypts with that context, and the
+// enclave only passes it to KMS for that module
+const KMSModuleContextKey = "module_sha256"
+
+// Secret is one entry of WASMRequest.SecretList: a secret bound to the module
+// it is meant for, so the enclave refuses to inject it into any other even
+// when several modules are in flight. The value comes in Value, or from the
+// Secrets Manager / SSM ARN in Ref, which the host resolves into a KMS
+// secret of the same name. An entry with neither binds a secret whose value
+// travels in encrypted_secrets or kms_secrets. The host can drop entries
+// of the list, so against the host the binding must be sealed with the
+// value: as the secret's module_sha256 in the encrypted_secrets envelope, or
+// under KMSModuleContextKey in the KMS encryption context.
+type Secret struct {
+	Name         string `json:"name"`
+	Value        string `json:"value,omitempty"`
+	Ref          string `json:"ref,omitempty"`
+	ModuleSHA256 string `json:"module_sha256,omitempty"` // Hex SHA-256 of the only wasm_code to inject it into
+}
+
+// validateSecretList checks the entries of SecretList
+func (r *WASMRequest) validateSecretList() error {
+	seen := make(map[string]bool, len(r.SecretList))
+	for i, secret := range r.SecretList {
+		switch {
+		case secret.Name == "":
+			return fmt.Errorf("secret_list entry %d has no name", i)
+		case seen[secret.Name]:
+			return fmt.Errorf("secret %s is listed twice in secret_list", secret.Name)
+		case secret.Value != "" && secret.Ref != "":
+			return fmt.Errorf("secret %s has both a value and a ref", secret.Name)
+		}
+		seen[secret.Name] = true
+		if secret.Value != "" || secret.Ref != "" {
+			// The value must not also come from elsewhere
+			if _, ok := r.Secrets[secret.Name]; ok {
+				return fmt.Errorf("secret %s is in both secrets and secret_list", secret.Name)
+			}
+			if _, ok := r.SecretRefs[secret.Name]; ok {
+				return fmt.Errorf("secret %s is in both secret_refs and secret_list", secret.Name)
+			}
+			if _, ok := r.KMSSecrets[secret.Name]; ok {
+				return fmt.Errorf("secret %s is in both kms_secrets and secret_list", secret.Name)
+			}
+		}
+		if secret.ModuleSHA256 != "" {
+			if decoded, err := hex.DecodeString(secret.ModuleSHA256); err != nil || len(decoded) != 32 {
+				return fmt.Errorf("module_sha256 of secret %s is not a SHA-256 digest", secret.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// listedSecrets counts the entries of SecretList that carry a value or ref
+func (r *WASMRequest) listedSecrets() int {
+	count := 0
+	for _, secret := range r.SecretList {
+		if secret.Value != "" || secret.Ref != "" {
+			count++
+		}
+	}
+	return count
+}
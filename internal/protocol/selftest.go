@@ -0,0 +1,46 @@
+package protocol
+
+// Checks an enclave runs on itself at startup
+const (
+	SelfTestWAT     = "wat"     // wat2wasm turns a built-in module into a binary
+	SelfTestExecute = "execute" // The runtime compiles and runs the built-in module
+	SelfTestNSM     = "nsm"     // The NSM describes PCR0 and signs an attestation document
+	SelfTestEntropy = "entropy" // Key generation draws distinct, non-zero bytes
+)
+
+// Outcomes of a self-test check
+const (
+	CheckPassed = "passed"
+	CheckFailed = "failed"
+	// The check does not apply, such as the NSM outside a Nitro enclave
+	CheckSkipped = "skipped"
+)
+
+// SelfTest is what an enclave found checking itself at startup, before it
+// took any request, so a misbuilt image shows on the host's first connection
+type SelfTest struct {
+	Passed bool            `json:"passed"` // No check failed
+	Checks []SelfTestCheck `json:"checks"`
+}
+
+// SelfTestCheck is the outcome of one check
+type SelfTestCheck struct {
+	Name       string `json:"name"`            // One of the SelfTest checks
+	Status     string `json:"status"`          // CheckPassed, CheckFailed or CheckSkipped
+	Error      string `json:"error,omitempty"` // Why the check failed or was skipped
+	DurationUS int64  `json:"duration_us"`
+}
+
+// Failures returns the checks that failed
+func (t *SelfTest) Failures() []SelfTestCheck {
+	if t == nil {
+		return nil
+	}
+	var failed []SelfTestCheck
+	for _, check := range t.Checks {
+		if check.Status == CheckFailed {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
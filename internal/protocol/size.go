@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Defaults for the size limits of host and enclave
+const (
+	DefaultMaxRequestBytes = 16 << 20
+	DefaultMaxWASMBytes    = 8 << 20
+	DefaultMaxUploadBytes  = 64 << 20
+	DefaultMaxArgs         = 64
+	DefaultMaxSecrets      = 64
+)
+
+// SizeLimits bound what a request may carry, so that a client cannot run the
+// host or the enclave out of memory. Zero leaves a size unlimited.
+type SizeLimits struct {
+	// The encoded request, enforced while it is read
+	MaxRequestBytes int
+	// wasm_code as sent: WAT text or base64
+	MaxWASMBytes int
+	// wasm_code sent in the chunks of an upload
+	MaxUploadBytes int
+	// Arguments of each call
+	MaxArgs int
+	// Plaintext, KMS and referenced secrets together
+	MaxSecrets int
+}
+
+// ValidationError is a request refused before it ran, with Code
+// ErrorCodeInvalidRequest or ErrorCodeRequestTooLarge
+type ValidationError struct {
+	Code    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func tooLarge(format string, args ...interface{}) error {
+	return &ValidationError{Code: ErrorCodeRequestTooLarge, Message: fmt.Sprintf(format, args...)}
+}
+
+// ValidationCode returns the error_code of a validation error, if err is one
+func ValidationCode(err error) string {
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		return invalid.Code
+	}
+	return ""
+}
+
+// CheckWASM checks the size of a module's code
+func (l SizeLimits) CheckWASM(code string) error {
+	if l.MaxWASMBytes > 0 && len(code) > l.MaxWASMBytes {
+		return tooLarge("wasm_code of %d bytes exceeds the limit of %d", len(code), l.MaxWASMBytes)
+	}
+	return nil
+}
+
+// CheckSecrets checks a number of secrets
+func (l SizeLimits) CheckSecrets(count int) error {
+	if l.MaxSecrets > 0 && count > l.MaxSecrets {
+		return tooLarge("%d secrets exceed the limit of %d", count, l.MaxSecrets)
+	}
+	return nil
+}
+
+// CheckSize checks a request against limits. Secrets sealed in
+// encrypted_secrets can only be counted once the enclave opens them.
+func (r *WASMRequest) CheckSize(limits SizeLimits) error {
+	if err := limits.CheckWASM(r.WASMCode); err != nil {
+		return err
+	}
+	if limits.MaxUploadBytes > 0 && r.UploadSize > uint64(limits.MaxUploadBytes) {
+		return tooLarge("upload of %d bytes exceeds the limit of %d", r.UploadSize, limits.MaxUploadBytes)
+	}
+	if limits.MaxArgs > 0 {
+		for i, call := range r.FunctionCalls() {
+			if args := len(call.Args) + len(call.TypedArgs); args > limits.MaxArgs {
+				return tooLarge("call %d passes %d arguments, the limit is %d", i, args, limits.MaxArgs)
+			}
+		}
+	}
+	return limits.CheckSecrets(len(r.Secrets) + len(r.KMSSecrets) + len(r.SecretRefs) + r.listedSecrets())
+}
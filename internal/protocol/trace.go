@@ -0,0 +1,15 @@
+package protocol
+
+// Span is a timed phase of a traced request. Enclaves return theirs in the
+// response, for the host to export with its own.
+type Span struct {
+	TraceID       string            `json:"trace_id"` // Hex, as in the request's traceparent
+	SpanID        string            `json:"span_id"`
+	ParentSpanID  string            `json:"parent_span_id,omitempty"`
+	Name          string            `json:"name"`
+	Service       string            `json:"service"` // Process that recorded the span
+	StartUnixNano int64             `json:"start_unix_nano"`
+	EndUnixNano   int64             `json:"end_unix_nano"`
+	Attributes    map[string]string `json:"attributes,omitempty"`
+	Error         string            `json:"error,omitempty"`
+}
@@ -0,0 +1,164 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+)
+
+// Value types for typed arguments and results
+const (
+	ValueI32    = "i32"
+	ValueI64    = "i64"
+	ValueF32    = "f32"
+	ValueF64    = "f64"
+	ValueString = "string"
+	ValueBytes  = "bytes"
+	// References, whose values are handles; the empty handle is null
+	ValueExternref = "externref"
+	ValueFuncref   = "funcref"
+)
+
+// Value is a typed argument or result. Numbers are written in decimal so
+// that i64 values survive JSON, and bytes are base64. A string or bytes
+// argument is copied into the module's memory and passed as a (pointer,
+// length) pair of i32s.
+//
+// An externref value is an opaque handle the enclave wraps into a reference
+// for the module, and unwraps again when the module returns it. A funcref
+// argument names an exported function, or is a handle a funcref result of an
+// earlier call on the same instance (a batch or session) came back as.
+type Value struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Ref is a decoded externref or funcref value
+type Ref struct {
+	Type   string
+	Handle string
+}
+
+// Decode returns v as int32, int64, float32, float64, []byte for string
+// and bytes values, or Ref for references
+func (v Value) Decode() (interface{}, error) {
+	switch v.Type {
+	case ValueExternref, ValueFuncref:
+		return Ref{Type: v.Type, Handle: v.Value}, nil
+	case ValueI32:
+		n, err := strconv.ParseInt(v.Value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid i32 %q", v.Value)
+		}
+		return int32(n), nil
+	case ValueI64:
+		n, err := strconv.ParseInt(v.Value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid i64 %q", v.Value)
+		}
+		return n, nil
+	case ValueF32:
+		f, err := strconv.ParseFloat(v.Value, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid f32 %q", v.Value)
+		}
+		return float32(f), nil
+	case ValueF64:
+		f, err := strconv.ParseFloat(v.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid f64 %q", v.Value)
+		}
+		return f, nil
+	case ValueString:
+		return []byte(v.Value), nil
+	case ValueBytes:
+		data, err := base64.StdEncoding.DecodeString(v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("bytes value is not valid base64")
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unknown value type %q", v.Type)
+	}
+}
+
+// EncodeValue is the inverse of Decode for the numeric types wasmtime
+// returns. Byte slices become bytes values.
+func EncodeValue(x interface{}) (Value, error) {
+	switch x := x.(type) {
+	case int32:
+		return Value{Type: ValueI32, Value: strconv.FormatInt(int64(x), 10)}, nil
+	case int64:
+		return Value{Type: ValueI64, Value: strconv.FormatInt(x, 10)}, nil
+	case float32:
+		return Value{Type: ValueF32, Value: strconv.FormatFloat(float64(x), 'g', -1, 32)}, nil
+	case float64:
+		return Value{Type: ValueF64, Value: strconv.FormatFloat(x, 'g', -1, 64)}, nil
+	case []byte:
+		return Value{Type: ValueBytes, Value: base64.StdEncoding.EncodeToString(x)}, nil
+	case Ref:
+		return Value{Type: x.Type, Value: x.Handle}, nil
+	default:
+		return Value{}, fmt.Errorf("unsupported value of type %T", x)
+	}
+}
+
+// Layouts of a string or bytes result in linear memory
+const (
+	// The function returns (pointer, length) as two i32s
+	LayoutPtrLen = "ptr_len"
+	// The function returns a pointer to a (pointer, length) pair of
+	// little-endian u32s
+	LayoutPtrLenIndirect = "ptr_len_indirect"
+	// The function returns a pointer to a little-endian u32 length followed
+	// by the data
+	LayoutLengthPrefixed = "length_prefixed"
+	// The function returns a pointer to NUL-terminated data
+	LayoutNulTerminated = "nul_terminated"
+)
+
+// ResultSpec says how to interpret a function's return value. Numeric types
+// need no layout; string and bytes results are read from linear memory
+// according to Layout, which defaults to ptr_len.
+type ResultSpec struct {
+	Type   string `json:"type"`
+	Layout string `json:"layout,omitempty"`
+}
+
+// Buffer reports whether the result is read from linear memory
+func (s ResultSpec) Buffer() bool {
+	return s.Type == ValueString || s.Type == ValueBytes
+}
+
+func (s ResultSpec) validate() error {
+	if !validResultType(s.Type) {
+		return fmt.Errorf("unknown result type %q", s.Type)
+	}
+	switch s.Layout {
+	case "":
+	case LayoutPtrLen, LayoutPtrLenIndirect, LayoutLengthPrefixed, LayoutNulTerminated:
+		if !s.Buffer() {
+			return fmt.Errorf("layout %s applies only to string and bytes results", s.Layout)
+		}
+	default:
+		return fmt.Errorf("unknown result layout %q", s.Layout)
+	}
+	return nil
+}
+
+// I32Values converts plain args into typed ones
+func I32Values(args []int32) []Value {
+	values := make([]Value, len(args))
+	for i, arg := range args {
+		values[i] = Value{Type: ValueI32, Value: strconv.FormatInt(int64(arg), 10)}
+	}
+	return values
+}
+
+func validResultType(t string) bool {
+	switch t {
+	case "", ValueI32, ValueI64, ValueF32, ValueF64, ValueString, ValueBytes, ValueExternref, ValueFuncref:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,240 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Bounds on the spans an Exporter holds and sends at once, and how often it
+// sends them
+const (
+	maxQueuedSpans = 8192
+	maxBatchSpans  = 512
+	exportInterval = 5 * time.Second
+)
+
+// Exporter is a Recorder that sends spans to an OTLP/HTTP collector, in
+// batches, encoded as JSON. Spans beyond what it holds while the collector
+// is slow or down are dropped.
+type Exporter struct {
+	endpoint string
+	client   *http.Client
+
+	mu      sync.Mutex
+	queue   []protocol.Span
+	dropped int
+	// Signalled when a batch is full
+	full chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewExporter returns an exporter to the collector at endpoint, such as
+// http://localhost:4318, and starts sending to it
+func NewExporter(endpoint string) (*Exporter, error) {
+	target, err := url.Parse(endpoint)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: use an http or https URL", endpoint)
+	}
+	if !strings.HasSuffix(target.Path, "/v1/traces") {
+		target.Path = strings.TrimSuffix(target.Path, "/") + "/v1/traces"
+	}
+	e := &Exporter{
+		endpoint: target.String(),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		full:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	e.wg.Add(1)
+	go e.run()
+	return e, nil
+}
+
+func (e *Exporter) Record(span protocol.Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queue) >= maxQueuedSpans {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, span)
+	if len(e.queue) == maxBatchSpans {
+		select {
+		case e.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Shutdown sends the spans still queued, giving up when ctx ends
+func (e *Exporter) Shutdown(ctx context.Context) {
+	close(e.done)
+	stopped := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+	}
+}
+
+func (e *Exporter) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.full:
+		case <-e.done:
+			for e.flush() {
+			}
+			return
+		}
+		for e.flush() {
+		}
+	}
+}
+
+// flush sends a batch of queued spans, and reports whether more are queued
+func (e *Exporter) flush() bool {
+	e.mu.Lock()
+	n := len(e.queue)
+	if n > maxBatchSpans {
+		n = maxBatchSpans
+	}
+	batch := e.queue[:n:n]
+	e.queue = e.queue[n:]
+	dropped := e.dropped
+	e.dropped = 0
+	more := len(e.queue) > 0
+	e.mu.Unlock()
+
+	if dropped > 0 {
+		slog.Warn("Dropped spans the OTLP collector could not take in time", "spans", dropped)
+	}
+	if len(batch) == 0 {
+		return false
+	}
+	if err := e.send(batch); err != nil {
+		slog.Warn("Failed to export spans", "spans", len(batch), "endpoint", e.endpoint, "error", err)
+		return false
+	}
+	return more
+}
+
+func (e *Exporter) send(spans []protocol.Span) error {
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// The OTLP/JSON encoding of an ExportTraceServiceRequest, as far as used
+type (
+	otlpExport struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// OTLP span kind and status codes
+const (
+	otlpKindInternal = 1
+	otlpStatusError  = 2
+)
+
+// otlpRequest groups spans by the service that recorded them
+func otlpRequest(spans []protocol.Span) otlpExport {
+	var export otlpExport
+	byService := make(map[string]int)
+	for _, span := range spans {
+		i, ok := byService[span.Service]
+		if !ok {
+			i = len(export.ResourceSpans)
+			byService[span.Service] = i
+			export.ResourceSpans = append(export.ResourceSpans, otlpResourceSpans{
+				Resource:   otlpResource{Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: span.Service}}}},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "hello-wasm-enclave"}}},
+			})
+		}
+		encoded := otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			Name:              span.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.StartUnixNano, 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndUnixNano, 10),
+		}
+		for key, value := range span.Attributes {
+			encoded.Attributes = append(encoded.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+		}
+		if span.Error != "" {
+			encoded.Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+		scope := &export.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, encoded)
+	}
+	return export
+}
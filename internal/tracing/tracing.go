@@ -0,0 +1,233 @@
+// Package tracing records the spans of requests traced with W3C trace
+// context. A request carries the traceparent of its caller's span; each
+// process starts its own spans under it and hands them to a Recorder, which
+// on the host exports them over OTLP and in the enclave gathers them for its
+// response. Untraced requests get nil spans, which record nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// SpanContext identifies a span within its trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// Parse decodes a traceparent header value, version 00
+func Parse(traceparent string) (SpanContext, error) {
+	var c SpanContext
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return c, fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err == nil {
+		_, err = hex.Decode(c.TraceID[:], []byte(parts[1]))
+	}
+	if err == nil {
+		_, err = hex.Decode(c.SpanID[:], []byte(parts[2]))
+	}
+	if err != nil || c.TraceID == [16]byte{} || c.SpanID == [8]byte{} {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	c.Sampled = flags[0]&1 == 1
+	return c, nil
+}
+
+// NewTrace returns a new, sampled trace without spans yet; the first span
+// started in it is its root
+func NewTrace() SpanContext {
+	c := SpanContext{Sampled: true}
+	randomID(c.TraceID[:])
+	return c
+}
+
+// String returns the traceparent header value of c
+func (c SpanContext) String() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%s", c.TraceID, c.SpanID, flags)
+}
+
+func randomID(id []byte) {
+	if _, err := rand.Read(id); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(fmt.Sprintf("failed to generate trace ID: %v", err))
+	}
+}
+
+// Recorder takes the spans that end
+type Recorder interface {
+	Record(span protocol.Span)
+}
+
+// trace is what a context carries of the span in it
+type trace struct {
+	parent   SpanContext
+	service  string
+	recorder Recorder
+}
+
+type traceKey struct{}
+
+// WithParent returns ctx in the trace of parent, whose spans service
+// records with recorder. Spans started from it are children of parent.
+func WithParent(ctx context.Context, parent SpanContext, service string, recorder Recorder) context.Context {
+	if !parent.Sampled || recorder == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, traceKey{}, trace{parent: parent, service: service, recorder: recorder})
+}
+
+// TraceParent returns the traceparent of the span in ctx, or "" when ctx is
+// not traced
+func TraceParent(ctx context.Context) string {
+	t, ok := ctx.Value(traceKey{}).(trace)
+	if !ok {
+		return ""
+	}
+	return t.parent.String()
+}
+
+// TraceID returns the hex ID of the trace of ctx, or "" when ctx is not
+// traced
+func TraceID(ctx context.Context) string {
+	t, ok := ctx.Value(traceKey{}).(trace)
+	if !ok {
+		return ""
+	}
+	return hex.EncodeToString(t.parent.TraceID[:])
+}
+
+// Span is a phase of a traced request
+type Span struct {
+	recorder Recorder
+	context  SpanContext
+
+	mu     sync.Mutex
+	record protocol.Span
+	ended  bool
+}
+
+// Start begins a span in the trace of ctx, and returns ctx with the span as
+// the parent of those started from it. When ctx is not traced, both come
+// back nil or unchanged.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartAt(ctx, name, time.Now())
+}
+
+// StartAt is Start for a span that began at start
+func StartAt(ctx context.Context, name string, start time.Time) (context.Context, *Span) {
+	t, ok := ctx.Value(traceKey{}).(trace)
+	if !ok {
+		return ctx, nil
+	}
+	s := &Span{
+		recorder: t.recorder,
+		context:  SpanContext{TraceID: t.parent.TraceID, Sampled: true},
+		record: protocol.Span{
+			TraceID:       hex.EncodeToString(t.parent.TraceID[:]),
+			Name:          name,
+			Service:       t.service,
+			StartUnixNano: start.UnixNano(),
+		},
+	}
+	if t.parent.SpanID != [8]byte{} {
+		s.record.ParentSpanID = hex.EncodeToString(t.parent.SpanID[:])
+	}
+	randomID(s.context.SpanID[:])
+	s.record.SpanID = hex.EncodeToString(s.context.SpanID[:])
+	t.parent = s.context
+	return context.WithValue(ctx, traceKey{}, t), s
+}
+
+// Record records a span of the trace of ctx that has already happened
+func Record(ctx context.Context, name string, start time.Time, duration time.Duration, attributes ...string) {
+	_, s := StartAt(ctx, name, start)
+	if s == nil {
+		return
+	}
+	s.SetAttributes(attributes...)
+	s.end(start.Add(duration))
+}
+
+// SetAttributes sets attributes from key, value pairs
+func (s *Span) SetAttributes(keyValues ...string) {
+	if s == nil || len(keyValues) < 2 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.record.Attributes == nil {
+		s.record.Attributes = make(map[string]string, len(keyValues)/2)
+	}
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		s.record.Attributes[keyValues[i]] = keyValues[i+1]
+	}
+}
+
+// SetError marks the span failed, unless message is empty
+func (s *Span) SetError(message string) {
+	if s == nil || message == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.Error = message
+}
+
+// End ends the span and hands it to its recorder; only the first End counts
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end(time.Now())
+}
+
+func (s *Span) end(at time.Time) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.record.EndUnixNano = at.UnixNano()
+	record := s.record
+	s.mu.Unlock()
+	s.recorder.Record(record)
+}
+
+// Collector is a Recorder that keeps the spans it is given
+type Collector struct {
+	mu    sync.Mutex
+	spans []protocol.Span
+}
+
+func (c *Collector) Record(span protocol.Span) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, span)
+}
+
+// Spans returns the spans recorded so far
+func (c *Collector) Spans() []protocol.Span {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]protocol.Span(nil), c.spans...)
+}
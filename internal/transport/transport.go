@@ -0,0 +1,158 @@
+// Package transport carries connections between the host and the enclave.
+// On a Nitro instance that is vsock, where the enclave and its parent are
+// told apart by CID. Elsewhere, on a laptop or in CI, the same binaries can
+// talk over loopback TCP instead: every (CID, port) address becomes the
+// port on one TCP host, and the protocol on top is unchanged. A host
+// simulating its enclave in process uses a third kind of its own.
+package transport
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/vsock"
+)
+
+// Values of the -transport flags
+const (
+	// Auto picks vsock where the machine has it, and TCP elsewhere
+	Auto  = "auto"
+	VSock = "vsock"
+	TCP   = "tcp"
+	// InProcess is the kind of NewInProcess transports, not a flag value
+	InProcess = "in-process"
+)
+
+// DefaultTCPHost is where TCP connections go and listeners bind
+const DefaultTCPHost = "127.0.0.1"
+
+// How long an in-process Dial waits for its port to be listened on, since
+// the enclave server starts alongside the host dialing it
+const inProcessDialTimeout = 30 * time.Second
+
+// Transport dials and listens on vsock addresses, or on their TCP stand-ins
+type Transport struct {
+	kind string
+	// Host of every TCP address; CIDs are ignored over TCP, so enclaves
+	// sharing a machine need ports of their own
+	tcpHost string
+	// Where each port listened on in process really is
+	local *localPorts
+}
+
+// localPorts maps the ports of an in-process transport to loopback
+// addresses the OS picked, so a simulated enclave never collides with a
+// real one listening on the same machine
+type localPorts struct {
+	mu    sync.Mutex
+	addrs map[uint32]string
+	// Closed and replaced whenever a port is added
+	changed chan struct{}
+}
+
+// New returns the transport kind names, resolving Auto
+func New(kind, tcpHost string) (*Transport, error) {
+	switch kind {
+	case Auto:
+		kind = TCP
+		if _, err := vsock.ContextID(); err == nil {
+			kind = VSock
+		}
+	case VSock, TCP:
+	default:
+		return nil, fmt.Errorf("unknown transport %q: use %s, %s or %s", kind, Auto, VSock, TCP)
+	}
+	return &Transport{kind: kind, tcpHost: tcpHost}, nil
+}
+
+// NewInProcess returns a transport for a host and an enclave server sharing
+// one process. Connections still go through loopback sockets, so both
+// sides behave exactly as they would over vsock.
+func NewInProcess() *Transport {
+	return &Transport{
+		kind:  InProcess,
+		local: &localPorts{addrs: make(map[uint32]string), changed: make(chan struct{})},
+	}
+}
+
+// Dial connects to port at cid
+func (t *Transport) Dial(cid, port uint32) (net.Conn, error) {
+	switch t.kind {
+	case TCP:
+		return net.Dial("tcp", t.tcpAddr(port))
+	case InProcess:
+		addr, err := t.local.wait(port, inProcessDialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return net.Dial("tcp", addr)
+	}
+	return vsock.Dial(cid, port, &vsock.Config{})
+}
+
+// Listen accepts connections on port
+func (t *Transport) Listen(port uint32) (net.Listener, error) {
+	switch t.kind {
+	case TCP:
+		return net.Listen("tcp", t.tcpAddr(port))
+	case InProcess:
+		listener, err := net.Listen("tcp", net.JoinHostPort(DefaultTCPHost, "0"))
+		if err != nil {
+			return nil, err
+		}
+		t.local.add(port, listener.Addr().String())
+		return listener, nil
+	}
+	return vsock.Listen(port, &vsock.Config{})
+}
+
+// Describe names port on this transport, for log lines
+func (t *Transport) Describe(port uint32) string {
+	switch t.kind {
+	case TCP:
+		return "TCP " + t.tcpAddr(port)
+	case InProcess:
+		return fmt.Sprintf("in-process port %d", port)
+	}
+	return fmt.Sprintf("vsock port %d", port)
+}
+
+// Kind is VSock, TCP or InProcess
+func (t *Transport) Kind() string {
+	return t.kind
+}
+
+func (t *Transport) tcpAddr(port uint32) string {
+	return net.JoinHostPort(t.tcpHost, strconv.FormatUint(uint64(port), 10))
+}
+
+func (l *localPorts) add(port uint32, addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.addrs[port] = addr
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// wait returns the address of port once something listens on it
+func (l *localPorts) wait(port uint32, timeout time.Duration) (string, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		l.mu.Lock()
+		addr, ok := l.addrs[port]
+		changed := l.changed
+		l.mu.Unlock()
+		if ok {
+			return addr, nil
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return "", fmt.Errorf("nothing listens on in-process port %d", port)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package wire
+
+import "sync"
+
+// Frames up to pooledFrameSize are read into and written from buffers shared
+// by every connection, so that a busy peer does not allocate, and collect,
+// a buffer for each small message. Larger frames, mostly module uploads, get
+// buffers of their own rather than pinning big ones in the pool.
+const pooledFrameSize = 64 << 10
+
+var framePool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, pooledFrameSize)
+		return &buf
+	},
+}
+
+// frameBuffer is a buffer of size bytes, to give back with release once
+// nothing refers to it
+type frameBuffer struct {
+	bytes  []byte
+	pooled *[]byte
+}
+
+func getFrameBuffer(size int) frameBuffer {
+	if size > pooledFrameSize {
+		return frameBuffer{bytes: make([]byte, size)}
+	}
+	pooled := framePool.Get().(*[]byte)
+	return frameBuffer{bytes: (*pooled)[:size], pooled: pooled}
+}
+
+func (b frameBuffer) release() {
+	if b.pooled != nil {
+		framePool.Put(b.pooled)
+	}
+}
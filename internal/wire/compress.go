@@ -0,0 +1,117 @@
+package wire
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression codecs a framed connection may negotiate
+const (
+	CompressionZstd = "zstd"
+	CompressionGzip = "gzip"
+	// CompressionNone turns compression off in ParseCompression
+	CompressionNone = "none"
+)
+
+// DefaultCompression is the -compression flag default: zstd, with gzip for
+// peers that lack it
+const DefaultCompression = CompressionZstd + "," + CompressionGzip
+
+// Payloads shorter than this go uncompressed, since the saving would not pay
+// for the work
+const compressMinSize = 4 << 10
+
+type codec interface {
+	compress(payload []byte) ([]byte, error)
+	// decompress fails with a TooLargeError past limit bytes
+	decompress(payload []byte, limit int) ([]byte, error)
+}
+
+var codecs = map[string]codec{
+	CompressionZstd: zstdCodec{},
+	CompressionGzip: gzipCodec{},
+}
+
+// ParseCompression turns a comma-separated list of codecs, in order of
+// preference, into what Dial offers; "none" offers nothing
+func ParseCompression(list string) ([]string, error) {
+	if list == "" || list == CompressionNone {
+		return nil, nil
+	}
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if codecs[name] == nil {
+			return nil, fmt.Errorf("unknown compression %q: use %s, %s or %s", name, CompressionZstd, CompressionGzip, CompressionNone)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// The zstd encoder and decoder are safe for concurrent EncodeAll and
+// DecodeAll, and costly to create, so every connection shares one of each
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+type zstdCodec struct{}
+
+func (zstdCodec) init() {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(MaxFrameSize))
+	})
+}
+
+func (c zstdCodec) compress(payload []byte) ([]byte, error) {
+	c.init()
+	return zstdEncoder.EncodeAll(payload, nil), nil
+}
+
+func (c zstdCodec) decompress(payload []byte, limit int) ([]byte, error) {
+	c.init()
+	decompressed, err := zstdDecoder.DecodeAll(payload, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || len(decompressed) > limit {
+		return nil, &TooLargeError{Limit: limit}
+	}
+	return decompressed, err
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) compress(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) decompress(payload []byte, limit int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	decompressed, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > limit {
+		return nil, &TooLargeError{Limit: limit}
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,113 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Message encodings a framed connection may negotiate. JSON is what every
+// peer speaks and stays the default, since it can be read off the wire; CBOR
+// is smaller and faster to decode. Messages map to CBOR through their json
+// tags, so both carry the same fields.
+const (
+	EncodingJSON = "json"
+	EncodingCBOR = "cbor"
+)
+
+// strictCBOR refuses map keys a struct has no field for
+var strictCBOR, _ = cbor.DecOptions{ExtraReturnErrors: cbor.ExtraDecErrorUnknownField}.DecMode()
+
+// Strict is a message that may ask to be decoded strictly, so that a field
+// its type lacks is refused instead of dropped. Such messages are decoded
+// strictly first; only when that fails are they decoded leniently and asked,
+// so they can answer from the version they declare.
+type Strict interface {
+	DecodeStrictly() bool
+}
+
+// Unmarshal decodes a JSON message into v, refusing unknown fields when v is
+// a Strict message that asks for it
+func Unmarshal(data []byte, v interface{}) error {
+	return unmarshalMessage(data, v, strictJSON, json.Unmarshal)
+}
+
+func unmarshalCBOR(data []byte, v interface{}) error {
+	return unmarshalMessage(data, v, strictCBOR.Unmarshal, cbor.Unmarshal)
+}
+
+func unmarshalMessage(data []byte, v interface{}, strict, lenient func([]byte, interface{}) error) error {
+	s, ok := v.(Strict)
+	if !ok {
+		return lenient(data, v)
+	}
+	strictErr := strict(data, v)
+	if strictErr == nil {
+		return nil
+	}
+	// Strict decoding may stop at the unknown field, and only reports the
+	// first error; the lenient pass decodes the rest and reports the others
+	if err := lenient(data, v); err != nil {
+		return err
+	}
+	if s.DecodeStrictly() {
+		return strictErr
+	}
+	return nil
+}
+
+func strictJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("data after the message")
+	}
+	return nil
+}
+
+// ParseEncoding turns the -encoding flag into what Dial offers. JSON needs
+// no offer, as it is what peers fall back to.
+func ParseEncoding(name string) ([]string, error) {
+	switch name {
+	case "", EncodingJSON:
+		return nil, nil
+	case EncodingCBOR:
+		return []string{EncodingCBOR}, nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q: use %s or %s", name, EncodingJSON, EncodingCBOR)
+}
+
+// messageFrame returns the frame type of a message in encoding, with or
+// without binary bytes attached, and how to marshal it
+func messageFrame(encoding string, attached bool) (byte, func(interface{}) ([]byte, error)) {
+	switch {
+	case encoding == EncodingCBOR && attached:
+		return FrameCBORBinary, cbor.Marshal
+	case encoding == EncodingCBOR:
+		return FrameCBOR, cbor.Marshal
+	case attached:
+		return FrameBinary, json.Marshal
+	}
+	return FrameJSON, json.Marshal
+}
+
+// unmarshaler returns how to decode the message of a frame type, and
+// whether raw binary bytes follow it
+func unmarshaler(frameType byte) (func([]byte, interface{}) error, bool, error) {
+	switch frameType {
+	case FrameJSON:
+		return Unmarshal, false, nil
+	case FrameBinary:
+		return Unmarshal, true, nil
+	case FrameCBOR:
+		return unmarshalCBOR, false, nil
+	case FrameCBORBinary:
+		return unmarshalCBOR, true, nil
+	}
+	return nil, false, fmt.Errorf("unexpected frame type %d", frameType)
+}
@@ -0,0 +1,786 @@
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Frames of a connection carrying streams. Every one starts with the 4-byte
+// big-endian ID of its stream.
+const (
+	// FrameData carries a piece of a message: the stream ID, a flags byte
+	// of DataEndMessage and DataEndStream, then the piece. The pieces of a
+	// message put together are the type and payload of the frame that
+	// would carry it on its own.
+	FrameData = 8
+	// FrameWindow lets the peer send more on a stream: the stream ID, then
+	// a 4-byte big-endian count of bytes
+	FrameWindow = 9
+	// FrameReset ends a stream abruptly, as when its exchange is given up
+	// on: the stream ID, then the reason
+	FrameReset = 10
+
+	// DataEndMessage marks the last piece of a message; DataEndStream
+	// marks the last frame its sender sends on the stream
+	DataEndMessage = 1
+	DataEndStream  = 2
+)
+
+// Bounds on streams: the bytes of a message in one FrameData, which keeps
+// large messages from holding up the others; the bytes a stream may be
+// sent ahead of reading them; and the streams a peer may have open at once.
+const (
+	maxDataPiece     = 64 << 10
+	streamWindow     = 1 << 20
+	maxStreams       = 1024
+	streamHeaderSize = 5
+)
+
+// Shortest payload of each frame of a stream
+var minStreamFrame = map[byte]int{FrameData: streamHeaderSize, FrameWindow: 8, FrameReset: 4}
+
+// ErrStreamIDsExhausted is the failure to open a stream on a Mux that
+// numbered its last: stream IDs are never reused, so further streams need a
+// connection of their own
+var ErrStreamIDsExhausted = errors.New("stream IDs of the connection are exhausted")
+
+// ResetError is the failure of an exchange whose stream was reset
+type ResetError struct {
+	Reason string
+}
+
+func (e *ResetError) Error() string {
+	return "stream reset: " + e.Reason
+}
+
+// Mux carries many streams over one framed connection, so that exchanges
+// neither wait for one another nor need a connection each. Streams are
+// opened by either side and numbered by it, odd from the dialer and even
+// from the acceptor, in the order their first frames are sent; the dialer
+// never accepts any, so it resets those the acceptor opens. Messages travel
+// in FrameData pieces, interleaved with those of other streams. Each stream's receiver grants its sender
+// streamWindow bytes to start with, and more with FrameWindow as it reads
+// them, so a stream nobody reads stops only its own sender.
+//
+// A goroutine reads the connection, answering pings as it goes, until the
+// connection fails; the Mux and its streams then fail with that error.
+// Stream IDs are 32 bits and never wrap: once a side numbered its last, it
+// opens no more streams, letting those open run to their end, much like an
+// HTTP/2 GOAWAY.
+type Mux struct {
+	writer *FrameWriter
+	reader *FrameReader
+	// Decodes messages as the connection's FrameReader would, within its
+	// limit
+	messages *FrameReader
+
+	// Numbers streams and sends their first frames, keeping the two in order
+	openMu sync.Mutex
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	// Highest ID of a stream the peer opened
+	peerID uint32
+	// Set once nextID is past the last ID; drained is closed when the last
+	// stream is then over
+	exhausted bool
+	drained   chan struct{}
+	accepted  chan *Stream
+	pings     map[string]chan struct{}
+	err       error
+	done      chan struct{}
+}
+
+// NewMux returns the Mux of a connection whose hello negotiated streams,
+// given the codecs Dial or AcceptStreams returned for it, and nil for any
+// other. The codecs must not be used directly afterwards.
+func NewMux(encoder Encoder, decoder Decoder) *Mux {
+	writer, ok := encoder.(*FrameWriter)
+	if !ok || !writer.streams {
+		return nil
+	}
+	reader, ok := decoder.(*FrameReader)
+	if !ok {
+		return nil
+	}
+	m := &Mux{
+		writer:   writer,
+		reader:   reader,
+		messages: &FrameReader{limit: reader.limit, compression: reader.compression},
+		streams:  make(map[uint32]*Stream),
+		nextID:   2,
+		accepted: make(chan *Stream, maxStreams),
+		pings:    make(map[string]chan struct{}),
+		done:     make(chan struct{}),
+		drained:  make(chan struct{}),
+	}
+	if writer.dialer {
+		m.nextID = 1
+	}
+	// Frames are pieces of messages now, so the message limit applies to
+	// the pieces put together
+	reader.limit = streamHeaderSize + maxDataPiece
+	go m.read()
+	return m
+}
+
+// Open starts a stream, which the peer learns of with its first frame
+func (m *Mux) Open() (*Stream, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.exhausted {
+		return nil, ErrStreamIDsExhausted
+	}
+	return newStream(m, 0), nil
+}
+
+// Accept waits for the next stream the peer opens
+func (m *Mux) Accept() (*Stream, error) {
+	select {
+	case s := <-m.accepted:
+		return s, nil
+	case <-m.done:
+		return nil, m.Err()
+	}
+}
+
+// Done is closed once the connection fails
+func (m *Mux) Done() <-chan struct{} {
+	return m.done
+}
+
+// Exhausted reports whether the Mux numbered its last stream, so that
+// streams must be opened on a new connection
+func (m *Mux) Exhausted() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.exhausted
+}
+
+// Drained is closed once the Mux is exhausted and its last stream is over,
+// when the connection is of no further use
+func (m *Mux) Drained() <-chan struct{} {
+	return m.drained
+}
+
+// Err returns why the connection failed, or nil while it works
+func (m *Mux) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Ping sends ping seq and waits up to timeout for its pong, failing with
+// os.ErrDeadlineExceeded when none arrives. Unlike FrameReader.Ping, it may
+// be used while streams are busy.
+func (m *Mux) Ping(seq uint64, timeout time.Duration) error {
+	payload := strconv.FormatUint(seq, 10)
+	pong := make(chan struct{})
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		return m.err
+	}
+	m.pings[payload] = pong
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.pings, payload)
+		m.mu.Unlock()
+	}()
+
+	if err := m.write(FramePing, []byte(payload)); err != nil {
+		return err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-pong:
+		return nil
+	case <-m.done:
+		return m.Err()
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// write sends a frame, failing the connection when that fails
+func (m *Mux) write(frameType byte, payload []byte) error {
+	if err := m.writer.writeFrame(frameType, payload); err != nil {
+		m.fail(err)
+		return err
+	}
+	return nil
+}
+
+// writeData sends a piece of a message of stream id
+func (m *Mux) writeData(id uint32, flags byte, piece []byte) error {
+	buf := getFrameBuffer(streamHeaderSize + len(piece))
+	defer buf.release()
+	binary.BigEndian.PutUint32(buf.bytes, id)
+	buf.bytes[4] = flags
+	copy(buf.bytes[streamHeaderSize:], piece)
+	return m.write(FrameData, buf.bytes)
+}
+
+// writeControl sends a window or reset frame for stream id
+func (m *Mux) writeControl(frameType byte, id uint32, data []byte) error {
+	payload := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(payload, id)
+	copy(payload[4:], data)
+	return m.write(frameType, payload)
+}
+
+// fail ends the connection and every stream on it with err
+func (m *Mux) fail(err error) {
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		return
+	}
+	m.err = err
+	streams := m.streams
+	m.streams = make(map[uint32]*Stream)
+	close(m.done)
+	m.mu.Unlock()
+	for _, s := range streams {
+		s.abort(err)
+	}
+}
+
+func (m *Mux) remove(id uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.streams, id)
+	m.drainedIfIdle()
+}
+
+// drainedIfIdle closes drained when the Mux is exhausted and no stream is
+// open; the caller holds mu
+func (m *Mux) drainedIfIdle() {
+	if !m.exhausted || len(m.streams) > 0 {
+		return
+	}
+	select {
+	case <-m.drained:
+	default:
+		close(m.drained)
+	}
+}
+
+// read hands out the frames arriving on the connection until it fails
+func (m *Mux) read() {
+	for {
+		frameType, buf, err := m.reader.readFrame()
+		if err == nil {
+			err = m.handle(frameType, buf.bytes)
+			buf.release()
+		}
+		if err != nil {
+			m.fail(err)
+			return
+		}
+	}
+}
+
+func (m *Mux) handle(frameType byte, payload []byte) error {
+	switch frameType {
+	case FramePing:
+		return m.write(FramePong, payload)
+	case FramePong:
+		m.mu.Lock()
+		if pong, ok := m.pings[string(payload)]; ok {
+			close(pong)
+			delete(m.pings, string(payload))
+		}
+		m.mu.Unlock()
+		return nil
+	case FrameData, FrameWindow, FrameReset:
+	default:
+		return fmt.Errorf("unexpected frame type %d on a connection carrying streams", frameType)
+	}
+
+	if len(payload) < minStreamFrame[frameType] {
+		return fmt.Errorf("truncated frame of type %d", frameType)
+	}
+	id := binary.BigEndian.Uint32(payload)
+	s := m.stream(id, frameType == FrameData)
+	if s == nil {
+		// Frames the peer sent before it learned the stream was over
+		return nil
+	}
+	switch frameType {
+	case FrameData:
+		s.received(payload[4], payload[streamHeaderSize:])
+	case FrameWindow:
+		s.granted(int(binary.BigEndian.Uint32(payload[4:])))
+	case FrameReset:
+		m.remove(id)
+		s.abort(&ResetError{Reason: string(payload[4:])})
+	}
+	return nil
+}
+
+// stream returns the open stream id, starting it when the peer opens it
+// with a data frame, or nil when there is none
+func (m *Mux) stream(id uint32, open bool) *Stream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.streams[id]; ok {
+		return s
+	}
+	// The peer numbers its streams with the other parity, upwards; lower
+	// IDs are of streams already over
+	if !open || id%2 == m.nextID%2 || id <= m.peerID {
+		return nil
+	}
+	m.peerID = id
+	s := newStream(m, id)
+	switch {
+	case m.writer.dialer:
+		// Nothing calls Accept on the dialing side
+		go s.Reset("the dialer accepts no streams")
+		return nil
+	case len(m.streams) >= maxStreams:
+		go s.Reset("too many streams")
+		return nil
+	}
+	// Streams over before they were accepted still wait in the channel, so
+	// it may be full with fewer open
+	select {
+	case m.accepted <- s:
+	default:
+		go s.Reset("too many streams waiting to be accepted")
+		return nil
+	}
+	m.streams[id] = s
+	return s
+}
+
+// Stream is one exchange on a Mux, read and written one message at a time
+// like a connection of its own. Each side ends what it sends with
+// EncodeLast or CloseWrite, and the stream is over once both have; Close
+// resets a stream that is not.
+type Stream struct {
+	mux *Mux
+	// Keeps the pieces of a message together
+	sendMu sync.Mutex
+	// Zero until a stream this side opened sends its first frame
+	id uint32
+
+	mu sync.Mutex
+	// Pieces received and not yet read, with their flags
+	pieces []streamPiece
+	// Bytes the peer may still send before it is granted more, and bytes
+	// read since it last was
+	receiveWindow  int
+	unacknowledged int
+	// Bytes this side may still send
+	sendWindow  int
+	localEnded  bool
+	remoteEnded bool
+	err         error
+	deadline    time.Time
+	readable    chan struct{}
+	writable    chan struct{}
+	deadlineSet chan struct{}
+	done        chan struct{}
+}
+
+type streamPiece struct {
+	flags byte
+	data  []byte
+}
+
+func newStream(m *Mux, id uint32) *Stream {
+	return &Stream{
+		mux:           m,
+		id:            id,
+		receiveWindow: streamWindow,
+		sendWindow:    streamWindow,
+		readable:      make(chan struct{}, 1),
+		writable:      make(chan struct{}, 1),
+		deadlineSet:   make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// ID is the number of the stream on its connection, or zero while a
+// stream this side opened has sent nothing
+func (s *Stream) ID() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// writeData sends a piece of a message, first numbering a stream this side
+// opened if the piece is its first frame
+func (s *Stream) writeData(flags byte, piece []byte) error {
+	if id := s.ID(); id != 0 {
+		return s.mux.writeData(id, flags, piece)
+	}
+	m := s.mux
+	m.openMu.Lock()
+	defer m.openMu.Unlock()
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		return m.err
+	}
+	if m.exhausted {
+		m.mu.Unlock()
+		return ErrStreamIDsExhausted
+	}
+	id := m.nextID
+	// Numbering on from the last ID of this side's parity would wrap
+	// around to IDs in use, or to the zero of streams not yet numbered
+	if id > math.MaxUint32-2 {
+		m.exhausted = true
+	}
+	m.nextID += 2
+	m.streams[id] = s
+	m.mu.Unlock()
+	s.mu.Lock()
+	s.id = id
+	s.mu.Unlock()
+	return m.writeData(id, flags, piece)
+}
+
+// Done is closed once the stream is reset, by either side, or its
+// connection fails
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Encode sends one message
+func (s *Stream) Encode(v interface{}) error {
+	return s.send(v, 0)
+}
+
+// EncodeLast sends one message and ends what this side sends
+func (s *Stream) EncodeLast(v interface{}) error {
+	return s.send(v, DataEndStream)
+}
+
+// CloseWrite ends what this side sends, without a message
+func (s *Stream) CloseWrite() error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if err := s.sendable(); err != nil {
+		return err
+	}
+	if err := s.writeData(DataEndStream, nil); err != nil {
+		return err
+	}
+	s.endLocal()
+	return nil
+}
+
+func (s *Stream) send(v interface{}, flags byte) error {
+	frameType, payload, err := s.mux.writer.encodeMessage(v)
+	if err != nil {
+		return err
+	}
+	if len(payload) > MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds limit of %d", len(payload), MaxFrameSize)
+	}
+	message := make([]byte, 1+len(payload))
+	message[0] = frameType
+	copy(message[1:], payload)
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if err := s.sendable(); err != nil {
+		return err
+	}
+	for len(message) > 0 {
+		n, err := s.reserve(len(message))
+		if err != nil {
+			return err
+		}
+		pieceFlags := byte(0)
+		if n == len(message) {
+			pieceFlags = DataEndMessage | flags
+		}
+		if err := s.writeData(pieceFlags, message[:n]); err != nil {
+			return err
+		}
+		message = message[n:]
+	}
+	if flags&DataEndStream != 0 {
+		s.endLocal()
+	}
+	return nil
+}
+
+func (s *Stream) sendable() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.localEnded {
+		return errors.New("stream is closed for writing")
+	}
+	return nil
+}
+
+// reserve waits until the peer lets this side send, and takes up to n of
+// the bytes it may
+func (s *Stream) reserve(n int) (int, error) {
+	if n > maxDataPiece {
+		n = maxDataPiece
+	}
+	for {
+		s.mu.Lock()
+		if s.err != nil {
+			s.mu.Unlock()
+			return 0, s.err
+		}
+		if s.sendWindow > 0 {
+			if n > s.sendWindow {
+				n = s.sendWindow
+			}
+			s.sendWindow -= n
+			s.mu.Unlock()
+			return n, nil
+		}
+		s.mu.Unlock()
+		if err := s.wait(s.writable); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Decode reads the next message, failing with io.EOF once the peer ended
+// the stream. A message over the connection's limit is skipped and
+// reported in a PayloadError, leaving the stream usable.
+func (s *Stream) Decode(v interface{}) error {
+	var message []byte
+	tooLarge := false
+	for {
+		s.mu.Lock()
+		if len(s.pieces) == 0 {
+			ended, err := s.remoteEnded, s.err
+			s.mu.Unlock()
+			switch {
+			case err != nil:
+				return err
+			case ended && message == nil && !tooLarge:
+				return io.EOF
+			case ended:
+				return io.ErrUnexpectedEOF
+			}
+			if err := s.wait(s.readable); err != nil {
+				return err
+			}
+			continue
+		}
+		piece := s.pieces[0]
+		s.pieces = s.pieces[1:]
+		s.mu.Unlock()
+		s.consumed(len(piece.data))
+
+		if !tooLarge {
+			message = append(message, piece.data...)
+			if len(message) > 1+int(s.mux.messages.limit) {
+				message, tooLarge = nil, true
+			}
+		}
+		if piece.flags&DataEndMessage != 0 {
+			break
+		}
+	}
+	if tooLarge {
+		return &PayloadError{Err: &TooLargeError{Limit: int(s.mux.messages.limit)}}
+	}
+	if len(message) == 0 {
+		return &PayloadError{Err: errors.New("empty message")}
+	}
+	if err := s.mux.messages.decodeMessage(message[0], message[1:], v); err != nil {
+		return &PayloadError{Err: err}
+	}
+	return nil
+}
+
+// consumed grants the peer the bytes read once they make up a good part of
+// its window, rather than a frame for every piece
+func (s *Stream) consumed(n int) {
+	s.mu.Lock()
+	s.unacknowledged += n
+	id := s.id
+	grant := 0
+	if s.unacknowledged >= streamWindow/4 && !s.remoteEnded && s.err == nil {
+		grant = s.unacknowledged
+		s.unacknowledged = 0
+		s.receiveWindow += grant
+	}
+	s.mu.Unlock()
+	if grant > 0 {
+		var increment [4]byte
+		binary.BigEndian.PutUint32(increment[:], uint32(grant))
+		s.mux.writeControl(FrameWindow, id, increment[:])
+	}
+}
+
+// SetDeadline makes reads and writes waiting past t fail with
+// os.ErrDeadlineExceeded; the zero time lets them wait indefinitely
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deadline = t
+	close(s.deadlineSet)
+	s.deadlineSet = make(chan struct{})
+	return nil
+}
+
+// wait blocks until signal fires, the stream fails, or its deadline passes
+func (s *Stream) wait(signal chan struct{}) error {
+	for {
+		s.mu.Lock()
+		deadline, deadlineSet := s.deadline, s.deadlineSet
+		s.mu.Unlock()
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return os.ErrDeadlineExceeded
+		}
+		var expired <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			expired = timer.C
+		}
+		var err error
+		changed := false
+		select {
+		case <-signal:
+		case <-s.done:
+			s.mu.Lock()
+			err = s.err
+			s.mu.Unlock()
+		case <-s.mux.done:
+			// Streams that sent nothing are not among those fail ends
+			err = s.mux.Err()
+		case <-expired:
+			err = os.ErrDeadlineExceeded
+		case <-deadlineSet:
+			changed = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if !changed {
+			return err
+		}
+	}
+}
+
+// Close ends the stream. One that either side has not ended yet is reset,
+// which tells the peer its exchange was given up on.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	over := s.err != nil || (s.localEnded && s.remoteEnded)
+	s.mu.Unlock()
+	if over {
+		return nil
+	}
+	return s.Reset("closed")
+}
+
+// Reset ends the stream abruptly on both sides
+func (s *Stream) Reset(reason string) error {
+	s.mu.Lock()
+	failed, id := s.err != nil, s.id
+	s.mu.Unlock()
+	if failed {
+		return nil
+	}
+	s.abort(&ResetError{Reason: reason})
+	if id == 0 {
+		// The peer never heard of it
+		return nil
+	}
+	s.mux.remove(id)
+	return s.mux.writeControl(FrameReset, id, []byte(reason))
+}
+
+// received queues a piece the peer sent, resetting the stream when the
+// peer sends more than it was granted
+func (s *Stream) received(flags byte, data []byte) {
+	s.mu.Lock()
+	if s.err != nil || s.remoteEnded {
+		s.mu.Unlock()
+		return
+	}
+	s.receiveWindow -= len(data)
+	if s.receiveWindow < 0 {
+		s.mu.Unlock()
+		go s.Reset("flow control window exceeded")
+		return
+	}
+	if len(data) > 0 || flags != 0 {
+		s.pieces = append(s.pieces, streamPiece{flags: flags, data: append([]byte(nil), data...)})
+	}
+	ended := flags&DataEndStream != 0
+	if ended {
+		s.remoteEnded = true
+	}
+	s.mu.Unlock()
+	notify(s.readable)
+	if ended {
+		s.finishIfOver()
+	}
+}
+
+func (s *Stream) granted(n int) {
+	s.mu.Lock()
+	s.sendWindow += n
+	s.mu.Unlock()
+	notify(s.writable)
+}
+
+func (s *Stream) endLocal() {
+	s.mu.Lock()
+	s.localEnded = true
+	s.mu.Unlock()
+	s.finishIfOver()
+}
+
+// finishIfOver forgets the stream once both sides ended it; what was
+// received stays readable
+func (s *Stream) finishIfOver() {
+	s.mu.Lock()
+	over, id := s.localEnded && s.remoteEnded, s.id
+	s.mu.Unlock()
+	if over {
+		s.mux.remove(id)
+	}
+}
+
+// abort fails whatever waits on the stream with err
+func (s *Stream) abort(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	close(s.done)
+}
+
+func notify(signal chan struct{}) {
+	select {
+	case signal <- struct{}{}:
+	default:
+	}
+}
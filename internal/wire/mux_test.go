@@ -0,0 +1,160 @@
+package wire
+
+import (
+	"errors"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+// muxPair returns the Muxes of both ends of a connection
+func muxPair(t *testing.T) (dialer, acceptor *Mux) {
+	t.Helper()
+	dialConn, acceptConn := net.Pipe()
+	t.Cleanup(func() {
+		dialConn.Close()
+		acceptConn.Close()
+	})
+	accepted := make(chan *Mux, 1)
+	go func() {
+		encoder, decoder, _, err := AcceptStreams(acceptConn, 0)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- NewMux(encoder, decoder)
+	}()
+	encoder, decoder, err := Dial(dialConn, DialOptions{Streams: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer, acceptor = NewMux(encoder, decoder), <-accepted
+	if dialer == nil || acceptor == nil {
+		t.Fatal("streams were not negotiated")
+	}
+	return dialer, acceptor
+}
+
+// TestMuxDialerResetsPeerStreams opens more streams from the acceptor than
+// the dialer could queue, and checks that the dialer resets them all and
+// keeps serving streams of its own
+func TestMuxDialerResetsPeerStreams(t *testing.T) {
+	dialer, acceptor := muxPair(t)
+	deadline := time.Now().Add(10 * time.Second)
+
+	for i := 0; i < maxStreams+1; i++ {
+		stream, err := acceptor.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.SetDeadline(deadline)
+		if err := stream.Encode(map[string]int{"stream": i}); err != nil {
+			t.Fatal(err)
+		}
+		var ignored map[string]int
+		var reset *ResetError
+		if err := stream.Decode(&ignored); !errors.As(err, &reset) {
+			t.Fatalf("stream %d opened by the acceptor: %v, want a reset", i, err)
+		}
+	}
+
+	go func() {
+		stream, err := acceptor.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var request map[string]string
+		if err := stream.Decode(&request); err != nil {
+			t.Error(err)
+			return
+		}
+		stream.EncodeLast(map[string]string{"echo": request["ping"]})
+	}()
+	stream, err := dialer.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.SetDeadline(deadline)
+	if err := stream.EncodeLast(map[string]string{"ping": "pong"}); err != nil {
+		t.Fatal(err)
+	}
+	var response map[string]string
+	if err := stream.Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response["echo"] != "pong" {
+		t.Fatalf("response = %v, want the echo of the request", response)
+	}
+}
+
+// TestMuxStreamIDsExhausted numbers streams up to the last ID, and checks
+// that the dialer then opens no more, lets the open ones finish and reports
+// when they have
+func TestMuxStreamIDsExhausted(t *testing.T) {
+	dialer, acceptor := muxPair(t)
+	deadline := time.Now().Add(10 * time.Second)
+	dialer.mu.Lock()
+	dialer.nextID = math.MaxUint32 - 2
+	dialer.mu.Unlock()
+
+	go func() {
+		for {
+			stream, err := acceptor.Accept()
+			if err != nil {
+				return
+			}
+			var request map[string]string
+			if err := stream.Decode(&request); err != nil {
+				t.Error(err)
+				return
+			}
+			stream.EncodeLast(map[string]string{"echo": request["ping"]})
+		}
+	}()
+	var open []*Stream
+	for _, id := range []uint32{math.MaxUint32 - 2, math.MaxUint32} {
+		stream, err := dialer.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.SetDeadline(deadline)
+		if err := stream.EncodeLast(map[string]string{"ping": "pong"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := stream.ID(); got != id {
+			t.Fatalf("stream ID = %d, want %d", got, id)
+		}
+		open = append(open, stream)
+	}
+
+	if !dialer.Exhausted() {
+		t.Fatal("dialer that numbered its last stream ID is not exhausted")
+	}
+	if _, err := dialer.Open(); !errors.Is(err, ErrStreamIDsExhausted) {
+		t.Fatalf("Open after the last stream ID: %v, want ErrStreamIDsExhausted", err)
+	}
+	select {
+	case <-dialer.Drained():
+		t.Fatal("drained while streams are open")
+	default:
+	}
+
+	for _, stream := range open {
+		var response map[string]string
+		if err := stream.Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response["echo"] != "pong" {
+			t.Fatalf("response = %v, want the echo of the request", response)
+		}
+	}
+	select {
+	case <-dialer.Drained():
+	case <-time.After(10 * time.Second):
+		t.Fatal("not drained once its streams were over")
+	}
+	if err := dialer.Err(); err != nil {
+		t.Fatalf("exhausted connection failed: %v", err)
+	}
+}
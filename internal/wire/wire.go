@@ -0,0 +1,602 @@
+// Package wire implements the transports shared by the client, host and
+// enclave: the original newline-delimited JSON stream, and a length-prefixed
+// frame format that keeps peers in sync even when a payload is malformed.
+//
+// A frame is a fixed header followed by the payload:
+//
+//	magic   2 bytes  "WN"
+//	version 1 byte   FrameVersion
+//	type    1 byte   FrameHello, FrameJSON, FrameBinary, FramePing,
+//	                 FramePong, FrameCBOR or FrameCBORBinary, with
+//	                 FrameCompressed set if compressed; FrameData,
+//	                 FrameWindow or FrameReset on connections with streams
+//	length  4 bytes  big-endian payload length
+//
+// The dialing side opts in by sending a hello frame first. The accepting
+// side tells the two formats apart by the first byte, so framed and legacy
+// JSON peers can share a listener. Once framed, either side may send a ping
+// between messages, which the other's reader answers with a pong, so an idle
+// connection can be checked without a request.
+//
+// The hello also negotiates how messages travel. The dialer offers message
+// encodings and compression codecs, and the acceptor picks one of each: CBOR
+// may replace JSON, and the codec compresses message payloads of a few KiB
+// and up in both directions. Messages with a large base64 field, such as a
+// module in wasm_code, send its bytes raw after the message in a
+// FrameBinary or FrameCBORBinary, where both peers understand it.
+//
+// A dialer may also ask for streams, which an acceptor that serves them
+// grants: the connection then carries many exchanges at once, as described
+// with Mux.
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+const (
+	// FrameVersion is the frame format version spoken by this package
+	FrameVersion = 1
+
+	// FrameHello carries the handshake; FrameJSON carries one JSON message
+	FrameHello = 1
+	FrameJSON  = 2
+	// FramePing asks the peer for a FramePong with the same payload
+	FramePing = 3
+	FramePong = 4
+	// FrameBinary carries a JSON message and the raw bytes of its binary
+	// field: a 4-byte big-endian JSON length, the JSON, then the bytes
+	FrameBinary = 5
+	// FrameCBOR and FrameCBORBinary are FrameJSON and FrameBinary with the
+	// message in CBOR
+	FrameCBOR       = 6
+	FrameCBORBinary = 7
+
+	// FrameCompressed is set in the type of a message frame whose payload
+	// is compressed with the codec negotiated in the hello
+	FrameCompressed = 0x80
+
+	// MaxFrameSize bounds a single frame's payload
+	MaxFrameSize = 64 << 20
+
+	headerSize = 8
+)
+
+var magic = [2]byte{'W', 'N'}
+
+// Encoder writes one message at a time, like json.Encoder
+type Encoder interface {
+	Encode(v interface{}) error
+}
+
+// Decoder reads one message at a time, like json.Decoder
+type Decoder interface {
+	Decode(v interface{}) error
+}
+
+// PayloadError is a message that arrived intact but could not be decoded,
+// such as a corrupt frame payload or a strict message with an unknown field.
+// The stream is still in sync, so the connection can be kept.
+type PayloadError struct {
+	Err error
+}
+
+func (e *PayloadError) Error() string {
+	return fmt.Sprintf("invalid message: %v", e.Err)
+}
+
+func (e *PayloadError) Unwrap() error {
+	return e.Err
+}
+
+// TooLargeError is a message over the size limit of its connection. An
+// oversized frame is skipped and reported inside a PayloadError; on the JSON
+// stream the message cannot be skipped, so the connection is lost.
+type TooLargeError struct {
+	Limit int
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("message exceeds the limit of %d bytes", e.Limit)
+}
+
+// BinaryDetacher is a message with a binary field that may travel as raw
+// bytes after it instead of as base64 inside it
+type BinaryDetacher interface {
+	// DetachBinary returns the message without the field and the bytes it
+	// held; ok is false when the field has nothing to detach
+	DetachBinary() (message interface{}, binary []byte, ok bool)
+}
+
+// BinaryAttacher is the decoding side of a BinaryDetacher
+type BinaryAttacher interface {
+	// AttachBinary puts detached bytes back into the decoded message
+	AttachBinary(binary []byte)
+}
+
+// hello is the handshake payload
+type hello struct {
+	Version int `json:"version"`
+	// Codecs and encodings offered by the dialer in order of preference,
+	// and the ones the acceptor picked in its answer; no encoding is JSON
+	Compression []string `json:"compression,omitempty"`
+	Encodings   []string `json:"encodings,omitempty"`
+	// Whether the sender reads FrameBinary and FrameCBORBinary
+	Binary bool `json:"binary,omitempty"`
+	// Asked for by the dialer, and granted by an acceptor serving streams
+	Streams bool `json:"streams,omitempty"`
+}
+
+// DialOptions is what Dial offers the peer, each in order of preference
+type DialOptions struct {
+	// Compression codecs; none leaves payloads uncompressed
+	Compression []string
+	// Message encodings besides JSON, which is always understood
+	Encodings []string
+	// Whether to carry exchanges as streams, for NewMux
+	Streams bool
+}
+
+// FrameWriter writes messages as JSON frames, or CBOR frames once
+// negotiated. It is safe for concurrent use.
+type FrameWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+	// Negotiated in the hello: the message encoding, the codec compressing
+	// payloads, if any, and whether the peer reads binary frames
+	encoding    string
+	compression string
+	binary      bool
+	// Whether the connection carries streams, and which side opened it
+	streams bool
+	dialer  bool
+}
+
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{w: w}
+}
+
+func (fw *FrameWriter) Encode(v interface{}) error {
+	frameType, payload, err := fw.encodeMessage(v)
+	if err != nil {
+		return err
+	}
+	return fw.writeFrame(frameType, payload)
+}
+
+// encodeMessage returns the type and payload of the frame carrying v
+func (fw *FrameWriter) encodeMessage(v interface{}) (byte, []byte, error) {
+	var attached []byte
+	if detacher, ok := v.(BinaryDetacher); ok && fw.binary {
+		if message, raw, ok := detacher.DetachBinary(); ok {
+			v, attached = message, raw
+		}
+	}
+	frameType, marshal := messageFrame(fw.encoding, attached != nil)
+	payload, err := marshal(v)
+	if err != nil {
+		return 0, nil, err
+	}
+	if attached != nil {
+		payload = appendBinary(payload, attached)
+	}
+
+	if codec := codecs[fw.compression]; codec != nil && len(payload) >= compressMinSize {
+		compressed, err := codec.compress(payload)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to compress frame: %v", err)
+		}
+		// Already compressed data, such as a zipped module, can come out larger
+		if len(compressed) < len(payload) {
+			payload = compressed
+			frameType |= FrameCompressed
+		}
+	}
+	return frameType, payload, nil
+}
+
+// Encoding is the message encoding negotiated for the connection
+func (fw *FrameWriter) Encoding() string {
+	if fw.encoding == "" {
+		return EncodingJSON
+	}
+	return fw.encoding
+}
+
+// Compression is the codec negotiated for the connection, or CompressionNone
+func (fw *FrameWriter) Compression() string {
+	if fw.compression == "" {
+		return CompressionNone
+	}
+	return fw.compression
+}
+
+// appendBinary lays out the payload of a binary frame
+func appendBinary(message, raw []byte) []byte {
+	payload := make([]byte, 4+len(message)+len(raw))
+	binary.BigEndian.PutUint32(payload[:4], uint32(len(message)))
+	copy(payload[4:], message)
+	copy(payload[4+len(message):], raw)
+	return payload
+}
+
+func (fw *FrameWriter) writeFrame(frameType byte, payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds limit of %d", len(payload), MaxFrameSize)
+	}
+
+	buf := getFrameBuffer(headerSize + len(payload))
+	defer buf.release()
+	frame := buf.bytes
+	copy(frame, magic[:])
+	frame[2] = FrameVersion
+	frame[3] = frameType
+	binary.BigEndian.PutUint32(frame[4:headerSize], uint32(len(payload)))
+	copy(frame[headerSize:], payload)
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	_, err := fw.w.Write(frame)
+	return err
+}
+
+// FrameReader reads message frames in either encoding. It never reads past
+// the end of a frame, so the underlying reader may be handed over between
+// frames. Pings are answered through the writer of the same connection, and
+// pongs skipped.
+type FrameReader struct {
+	r io.Reader
+	// Payloads above limit are skipped
+	limit uint32
+	// Answers pings; nil ignores them
+	writer *FrameWriter
+	// Whether a read failed part way through a frame, losing the stream
+	torn bool
+	// Codec of compressed frames, negotiated in the hello
+	compression string
+}
+
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: r, limit: MaxFrameSize}
+}
+
+func (fr *FrameReader) Decode(v interface{}) error {
+	for {
+		frameType, buf, err := fr.readFrame()
+		if err != nil {
+			return err
+		}
+		switch frameType {
+		case FramePing:
+			err := fr.pong(buf.bytes)
+			buf.release()
+			if err != nil {
+				return err
+			}
+			continue
+		case FramePong:
+			// The late answer to a ping that was given up on
+			buf.release()
+			continue
+		}
+		// Decoding copies what it keeps, so the buffer is free afterwards
+		err = fr.decodeMessage(frameType, buf.bytes, v)
+		buf.release()
+		if err != nil {
+			return &PayloadError{Err: err}
+		}
+		return nil
+	}
+}
+
+// decodeMessage decodes the payload of a message frame into v, decompressing
+// it first if needed
+func (fr *FrameReader) decodeMessage(frameType byte, payload []byte, v interface{}) error {
+	if frameType&FrameCompressed != 0 {
+		codec := codecs[fr.compression]
+		if codec == nil {
+			return fmt.Errorf("compressed frame on a connection without compression")
+		}
+		var err error
+		if payload, err = codec.decompress(payload, int(fr.limit)); err != nil {
+			return err
+		}
+		frameType &^= FrameCompressed
+	}
+
+	unmarshal, attached, err := unmarshaler(frameType)
+	if err != nil {
+		return err
+	}
+	if !attached {
+		return unmarshal(payload, v)
+	}
+
+	if len(payload) < 4 || binary.BigEndian.Uint32(payload) > uint32(len(payload)-4) {
+		return fmt.Errorf("truncated binary frame")
+	}
+	end := 4 + int(binary.BigEndian.Uint32(payload))
+	attacher, ok := v.(BinaryAttacher)
+	if !ok {
+		return fmt.Errorf("binary frame for a message without a binary field")
+	}
+	if err := unmarshal(payload[4:end], v); err != nil {
+		return err
+	}
+	attacher.AttachBinary(payload[end:])
+	return nil
+}
+
+// Ping sends ping seq through the connection's writer and reads until its
+// pong arrives, skipping pongs of earlier pings. Any message arriving
+// instead is an error, so Ping is only for connections without a request
+// in flight.
+func (fr *FrameReader) Ping(seq uint64) error {
+	if fr.writer == nil {
+		return fmt.Errorf("connection cannot send pings")
+	}
+	payload := []byte(strconv.FormatUint(seq, 10))
+	if err := fr.writer.writeFrame(FramePing, payload); err != nil {
+		return err
+	}
+	for {
+		frameType, buf, err := fr.readFrame()
+		if err != nil {
+			return err
+		}
+		got := buf.bytes
+		switch {
+		case frameType == FramePong && bytes.Equal(got, payload):
+			buf.release()
+			return nil
+		case frameType == FramePong:
+		case frameType == FramePing:
+			if err := fr.pong(got); err != nil {
+				buf.release()
+				return err
+			}
+		default:
+			buf.release()
+			return fmt.Errorf("unexpected frame type %d while waiting for a pong", frameType)
+		}
+		buf.release()
+	}
+}
+
+// InSync reports whether the next read starts at a frame boundary. A read
+// that timed out before any of a frame arrived leaves the stream usable.
+func (fr *FrameReader) InSync() bool {
+	return !fr.torn
+}
+
+func (fr *FrameReader) pong(payload []byte) error {
+	if fr.writer == nil {
+		return nil
+	}
+	return fr.writer.writeFrame(FramePong, payload)
+}
+
+// readFrame reads the next frame into a buffer the caller releases
+func (fr *FrameReader) readFrame() (byte, frameBuffer, error) {
+	var header [headerSize]byte
+	if n, err := io.ReadFull(fr.r, header[:]); err != nil {
+		fr.torn = fr.torn || n > 0
+		return 0, frameBuffer{}, err
+	}
+	if header[0] != magic[0] || header[1] != magic[1] {
+		return 0, frameBuffer{}, fmt.Errorf("bad frame magic %q", header[:2])
+	}
+	if header[2] != FrameVersion {
+		return 0, frameBuffer{}, fmt.Errorf("unsupported frame version %d", header[2])
+	}
+
+	length := binary.BigEndian.Uint32(header[4:])
+	if length > MaxFrameSize {
+		return 0, frameBuffer{}, fmt.Errorf("frame of %d bytes exceeds limit of %d", length, MaxFrameSize)
+	}
+	if length > fr.limit {
+		// Skipping the payload keeps the stream in sync without holding it
+		if _, err := io.CopyN(io.Discard, fr.r, int64(length)); err != nil {
+			fr.torn = true
+			return 0, frameBuffer{}, err
+		}
+		return header[3], frameBuffer{}, &PayloadError{Err: &TooLargeError{Limit: int(fr.limit)}}
+	}
+	buf := getFrameBuffer(int(length))
+	if _, err := io.ReadFull(fr.r, buf.bytes); err != nil {
+		buf.release()
+		fr.torn = true
+		return 0, frameBuffer{}, err
+	}
+	return header[3], buf, nil
+}
+
+// Dial performs the client side of the handshake on a fresh connection and
+// returns framed codecs for it, using what the peer picked of options
+func Dial(conn io.ReadWriter, options DialOptions) (Encoder, Decoder, error) {
+	writer := NewFrameWriter(conn)
+	reader := NewFrameReader(conn)
+	reader.writer = writer
+
+	payload, _ := json.Marshal(hello{
+		Version:     FrameVersion,
+		Compression: options.Compression,
+		Encodings:   options.Encodings,
+		Binary:      true,
+		Streams:     options.Streams,
+	})
+	if err := writer.writeFrame(FrameHello, payload); err != nil {
+		return nil, nil, fmt.Errorf("failed to send hello: %v", err)
+	}
+
+	frameType, buf, err := reader.readFrame()
+	if err != nil {
+		return nil, nil, fmt.Errorf("peer did not complete framing handshake: %v", err)
+	}
+	var reply hello
+	err = json.Unmarshal(buf.bytes, &reply)
+	buf.release()
+	if frameType != FrameHello || err != nil {
+		return nil, nil, fmt.Errorf("peer sent an invalid hello")
+	}
+	if reply.Version != FrameVersion {
+		return nil, nil, fmt.Errorf("peer speaks frame version %d, want %d", reply.Version, FrameVersion)
+	}
+	if writer.compression, err = picked("compression", options.Compression, reply.Compression); err != nil {
+		return nil, nil, err
+	}
+	if writer.encoding, err = picked("encoding", options.Encodings, reply.Encodings); err != nil {
+		return nil, nil, err
+	}
+	reader.compression = writer.compression
+	writer.binary = reply.Binary
+	writer.streams = options.Streams && reply.Streams
+	writer.dialer = true
+	return writer, reader, nil
+}
+
+// picked checks that the peer answered with at most one of the names offered
+func picked(what string, offered, answer []string) (string, error) {
+	if len(answer) == 0 {
+		return "", nil
+	}
+	if len(answer) == 1 {
+		for _, name := range offered {
+			if name == answer[0] {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("peer picked %s %v, which was not offered", what, answer)
+}
+
+// pick returns the first of the names offered that known allows, or nothing
+func pick(offered []string, known func(string) bool) []string {
+	for _, name := range offered {
+		if known(name) {
+			return []string{name}
+		}
+	}
+	return nil
+}
+
+// Accept returns codecs for an accepted connection, answering the framing
+// handshake if the peer opens with one and otherwise falling back to the
+// JSON stream. framed reports which was chosen. Messages over maxMessage
+// bytes are refused with a TooLargeError; zero allows up to MaxFrameSize.
+func Accept(conn io.ReadWriter, maxMessage int) (encoder Encoder, decoder Decoder, framed bool, err error) {
+	return accept(conn, maxMessage, false)
+}
+
+// AcceptStreams is Accept for a side that serves streams: it grants them
+// to dialers that ask, whose codecs NewMux then turns into a Mux
+func AcceptStreams(conn io.ReadWriter, maxMessage int) (encoder Encoder, decoder Decoder, framed bool, err error) {
+	return accept(conn, maxMessage, true)
+}
+
+func accept(conn io.ReadWriter, maxMessage int, streams bool) (encoder Encoder, decoder Decoder, framed bool, err error) {
+	if maxMessage <= 0 || maxMessage > MaxFrameSize {
+		maxMessage = MaxFrameSize
+	}
+	buffered := bufio.NewReader(conn)
+	first, err := buffered.Peek(1)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if first[0] != magic[0] {
+		return json.NewEncoder(conn), newStreamDecoder(buffered, maxMessage), false, nil
+	}
+
+	writer := NewFrameWriter(conn)
+	reader := NewFrameReader(buffered)
+	reader.limit = uint32(maxMessage)
+	reader.writer = writer
+
+	frameType, buf, err := reader.readFrame()
+	if err != nil {
+		return nil, nil, true, err
+	}
+	var request hello
+	err = json.Unmarshal(buf.bytes, &request)
+	buf.release()
+	if frameType != FrameHello || err != nil {
+		return nil, nil, true, fmt.Errorf("framed connection did not start with a valid hello")
+	}
+
+	// Only one version exists so far; always answer with ours and let the
+	// peer decide whether it can continue. The first codec and encoding
+	// offered that this side knows are picked.
+	reply := hello{
+		Version:     FrameVersion,
+		Compression: pick(request.Compression, func(name string) bool { return codecs[name] != nil }),
+		Encodings:   pick(request.Encodings, func(name string) bool { return name == EncodingCBOR }),
+		Binary:      true,
+		Streams:     streams && request.Streams,
+	}
+	if len(reply.Compression) > 0 {
+		writer.compression = reply.Compression[0]
+		reader.compression = reply.Compression[0]
+	}
+	if len(reply.Encodings) > 0 {
+		writer.encoding = reply.Encodings[0]
+	}
+	writer.binary = request.Binary
+	writer.streams = reply.Streams
+	payload, _ := json.Marshal(reply)
+	if err := writer.writeFrame(FrameHello, payload); err != nil {
+		return nil, nil, true, err
+	}
+	return writer, reader, true, nil
+}
+
+// streamDecoder reads the JSON stream, failing a message once more than
+// limit bytes were read for it. The decoder reads ahead, so a message may
+// get up to one buffer past the limit before it is refused. A message that
+// is valid JSON but does not decode leaves the stream in sync, and is
+// reported in a PayloadError.
+type streamDecoder struct {
+	reader  *boundedReader
+	decoder *json.Decoder
+}
+
+func newStreamDecoder(r io.Reader, limit int) *streamDecoder {
+	reader := &boundedReader{r: r, limit: limit}
+	return &streamDecoder{reader: reader, decoder: json.NewDecoder(reader)}
+}
+
+func (d *streamDecoder) Decode(v interface{}) error {
+	d.reader.remaining = d.reader.limit
+	var raw json.RawMessage
+	if err := d.decoder.Decode(&raw); err != nil {
+		return err
+	}
+	if err := Unmarshal(raw, v); err != nil {
+		return &PayloadError{Err: err}
+	}
+	return nil
+}
+
+type boundedReader struct {
+	r         io.Reader
+	limit     int
+	remaining int
+}
+
+func (b *boundedReader) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, &TooLargeError{Limit: b.limit}
+	}
+	if len(p) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= n
+	return n, err
+}
@@ -1,179 +1,855 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
-
-	"github.com/mdlayher/vsock"
+	"time"
+
+	"hello-wasm-enclave/internal/config"
+	"hello-wasm-enclave/internal/drain"
+	"hello-wasm-enclave/internal/gctune"
+	"hello-wasm-enclave/internal/logging"
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/tracing"
+	"hello-wasm-enclave/internal/transport"
+	"hello-wasm-enclave/internal/wire"
 )
 
-// WASMRequest represents a request to execute WASM code
-type WASMRequest struct {
-	WASMCode     string            `json:"wasm_code"`     // WAT text with template variables
-	FunctionName string            `json:"function_name"` // Function to call in the WASM module
-	Args         []int32           `json:"args"`          // Arguments to pass to the function
-	Secrets      map[string]string `json:"secrets"`       // Secret values to inject into template
-}
-
-// WASMResponse represents the response from WASM execution
-type WASMResponse struct {
-	Result int32  `json:"result"`
-	Error  string `json:"error,omitempty"`
-}
+const (
+	// Default enclave CID; override it with -enclave-cid to match the CID
+	// nitro-cli assigned to the enclave you're running
+	defaultEnclaveCID = 16
+)
 
 const (
-	// Port for our WASM service
-	WASMPort = 8080
-	// Enclave CID (the enclave you're running)
-	EnclaveCID = 16 // This should match the CID you used when running the enclave
+	// Backoff between retries of a failed enclave round trip
+	initialRetryBackoff = 100 * time.Millisecond
+	maxRetryBackoff     = 2 * time.Second
 )
 
 type HostService struct {
-	mu               sync.RWMutex
-	enclaveConn      net.Conn
-	enclaveConnected bool
+	enclaves    *enclaveRouter
+	limiter     *rateLimiter
+	auth        *Authenticator
+	credentials *CredentialProvider
+	secrets     *SecretFetcher
+	maxRetries  int
+	// How often executions report progress, and how long one may go without
+	// before it is given up on as hung; zero asks for no reports
+	progressInterval time.Duration
+	progressTimeout  time.Duration
+	// Bound on a readiness check of the enclave
+	healthTimeout time.Duration
+	// Whether modules can be registered over gRPC or HTTP
+	moduleRegistration bool
+	// Bounds on client requests, checked before they are forwarded
+	sizeLimits protocol.SizeLimits
+	// Responses to deterministic requests; nil caches nothing
+	cache *responseCache
+	// Responses to requests with idempotency keys; nil ignores the keys
+	idempotency *idempotencyKeys
+	// Pulls the modules of module_ref; nil refuses module_ref
+	oci *ociPuller
+	// Delivers job results to clients' callbacks; nil refuses callbacks
+	callbacks *callbacks
+	// Exports the spans of traced requests; nil traces nothing
+	tracer *hostTracer
+	mu     sync.Mutex
+	nextID uint64
 }
 
-func NewHostService() *HostService {
-	return &HostService{}
+func NewHostService(enclaves *enclaveRouter, limiter *rateLimiter, auth *Authenticator, maxRetries int, healthTimeout time.Duration) *HostService {
+	credentials := NewCredentialProvider()
+	return &HostService{
+		enclaves:      enclaves,
+		limiter:       limiter,
+		auth:          auth,
+		credentials:   credentials,
+		secrets:       NewSecretFetcher(credentials),
+		maxRetries:    maxRetries,
+		healthTimeout: healthTimeout,
+	}
 }
 
-func (h *HostService) connectToEnclave() error {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// connectToEnclaves checks that each enclave is reachable by opening a
+// pooled connection, logging those that are not
+func (h *HostService) connectToEnclaves() {
+	for _, replica := range h.enclaves.byID {
+		c, err := replica.pool.Checkout()
+		if err != nil {
+			log.Printf("Warning: Could not connect to enclave %s initially: %v", replica.id, err)
+			log.Println("Will retry when handling client requests")
+			continue
+		}
+		replica.pool.Checkin(c)
+	}
+}
 
-	if h.enclaveConnected {
-		return nil
+// enclaveHealth checks every enclave replica and returns their status by
+// ID, failing when some backend has no replica that answers
+func (h *HostService) enclaveHealth() (map[string]backendHealth, error) {
+	return h.enclaves.checkHealth(h.healthTimeout)
+}
+
+// correlate assigns a correlation ID to a request that arrived without one
+// and returns a logger tagged with it
+func correlate(req *protocol.WASMRequest) *slog.Logger {
+	if req.CorrelationID == "" {
+		req.CorrelationID = logging.NewCorrelationID()
+	}
+	logger := logging.ForRequest(req.CorrelationID, req.RequestID)
+	if req.ClientID != "" {
+		logger = logger.With("client_id", req.ClientID)
+	}
+	return logger
+}
+
+// forwardToEnclave sends a request from the client at addr to the enclave.
+// Clients are rate limited by their certificate or token identity if they
+// have one, else by address, and then held to the quotas of their tenant.
+// Requests refused by authentication, rate
+// limiting or admission control come back as responses carrying an
+// error_code (and retry_after_ms when worth retrying), not as errors, and
+// so do requests whose ctx ended before the enclave answered.
+func (h *HostService) forwardToEnclave(ctx context.Context, addr string, req protocol.WASMRequest) (protocol.WASMResponse, error) {
+	err := req.CheckSize(h.sizeLimits)
+	if err == nil && protocol.IsAdminRequest(req.Type) {
+		err = &protocol.ValidationError{Code: protocol.ErrorCodePolicy, Message: fmt.Sprintf("%s requests are only accepted on the admin port", req.Type)}
+	}
+	var caller *authClient
+	if err == nil {
+		caller, err = h.authenticate(&req)
+	}
+	logger := correlate(&req)
+	client := addr
+	if req.ClientID != "" {
+		client = req.ClientID
+	}
+	var response protocol.WASMResponse
+	if err == nil {
+		err = h.limiter.allow(client)
+	}
+	// Clients are allowed modules by hash, so a module_ref is pulled first
+	if err == nil {
+		err = h.oci.resolve(ctx, logger, &req)
+	}
+	if err == nil {
+		err = caller.authorize(req)
+	}
+	if err == nil {
+		err = h.auth.tenant(req.Tenant).admit(&req)
+	}
+	if err == nil {
+		response, err = h.forwardIdempotent(ctx, logger, req)
 	}
 
-	log.Printf("Connecting to enclave at CID %d, port %d", EnclaveCID, WASMPort)
+	var invalid *protocol.ValidationError
+	var refused *authError
+	var overload *overloadError
+	var limited *rateLimitError
+	var unavailable *unavailableError
+	switch {
+	case errors.As(err, &invalid):
+		logger.Warn("Rejecting request", "error", err)
+		response = protocol.WASMResponse{RequestID: req.RequestID, Error: err.Error(), ErrorCode: invalid.Code}
+		err = nil
+	case errors.As(err, &refused):
+		logger.Warn("Refusing request", "error", err)
+		response = protocol.WASMResponse{RequestID: req.RequestID, Error: err.Error(), ErrorCode: refused.code}
+		err = nil
+	case errors.As(err, &overload):
+		logger.Warn("Shedding request", "reason", err)
+		response = protocol.WASMResponse{RequestID: req.RequestID, Error: err.Error(), ErrorCode: protocol.ErrorCodeOverloaded}
+		err = nil
+	case errors.As(err, &limited):
+		logger.Warn("Rate limiting "+limited.scope, limited.scope, limited.client)
+		response = protocol.WASMResponse{
+			RequestID:    req.RequestID,
+			Error:        err.Error(),
+			ErrorCode:    protocol.ErrorCodeRateLimited,
+			RetryAfterMS: (limited.retryAfter + time.Millisecond - 1).Milliseconds(),
+		}
+		err = nil
+	case errors.As(err, &unavailable):
+		logger.Warn("Failing fast", "reason", err)
+		response = protocol.WASMResponse{
+			RequestID:    req.RequestID,
+			Error:        err.Error(),
+			ErrorCode:    protocol.ErrorCodeEnclaveUnavailable,
+			RetryAfterMS: unavailable.retryAfter.Milliseconds(),
+		}
+		err = nil
+	case err != nil && ctx.Err() != nil:
+		logger.Info("Request cancelled", "reason", ctx.Err())
+		response = protocol.WASMResponse{RequestID: req.RequestID, Error: fmt.Sprintf("request cancelled: %v", ctx.Err()), ErrorCode: protocol.ErrorCodeCancelled}
+		err = nil
+	}
+	if err == nil && req.Type == protocol.RequestTypeHello && response.ErrorCode == "" {
+		response = h.hello(logger, req, response)
+	}
+	if response.ErrorCode == protocol.ErrorCodeOverloaded && response.RetryAfterMS == 0 {
+		response.RetryAfterMS = h.enclaves.retryAfter.Milliseconds()
+	}
+	observeResponse(response, err)
+	response.CorrelationID = req.CorrelationID
+	response.TraceID = tracing.TraceID(ctx)
+	return response, err
+}
 
-	conn, err := vsock.Dial(EnclaveCID, WASMPort, &vsock.Config{})
+// authenticate checks the API token of a request, which goes no further
+// than the host, and returns its client for authorizing the request
+func (h *HostService) authenticate(req *protocol.WASMRequest) (*authClient, error) {
+	token := req.AuthToken
+	req.AuthToken = ""
+	client, identity, err := h.auth.authenticate(token)
 	if err != nil {
-		return fmt.Errorf("failed to connect to enclave: %v", err)
+		return nil, err
 	}
+	if req.ClientID == "" {
+		req.ClientID = identity
+	}
+	// The tenant comes from the token alone; clients cannot pick another
+	req.Tenant = ""
+	if client != nil {
+		req.Tenant = client.Tenant
+	}
+	return client, nil
+}
 
-	h.enclaveConn = conn
-	h.enclaveConnected = true
-	log.Println("Successfully connected to enclave")
-	return nil
+// forward sends req to an enclave, retrying on transport failures until ctx
+// ends. In a traced request, the enclave traces its phases under the span
+// of the forward phase.
+func (h *HostService) forward(ctx context.Context, logger *slog.Logger, req protocol.WASMRequest) (protocol.WASMResponse, error) {
+	ctx, span := tracing.Start(ctx, "forward")
+	defer span.End()
+	req.TraceParent = tracing.TraceParent(ctx)
+	response, err := h.forwardWithRetries(ctx, logger, req)
+	h.tracer.export(response.Spans)
+	response.Spans = nil
+	if err != nil {
+		span.SetError(err.Error())
+	} else if response.Enclave != "" {
+		span.SetAttributes("replica", response.Enclave)
+	}
+	return response, err
 }
 
-func (h *HostService) forwardToEnclave(req WASMRequest) (WASMResponse, error) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+func (h *HostService) forwardWithRetries(ctx context.Context, logger *slog.Logger, req protocol.WASMRequest) (protocol.WASMResponse, error) {
+	enclaveID := h.nextRequestID()
 
-	if !h.enclaveConnected || h.enclaveConn == nil {
-		return WASMResponse{}, fmt.Errorf("not connected to enclave")
-	}
+	// Client IDs are only unique per client, so the enclave sees ours instead
+	clientID := req.RequestID
+	req.RequestID = enclaveID
 
-	encoder := json.NewEncoder(h.enclaveConn)
-	decoder := json.NewDecoder(h.enclaveConn)
+	backend, pinned, err := h.enclaves.route(&req)
+	if err != nil {
+		return protocol.WASMResponse{}, err
+	}
 
-	log.Printf("Forwarding to enclave: function=%s, args=%v, code_length=%d",
-		req.FunctionName, req.Args, len(req.WASMCode))
+	// Secret references become KMS ciphertexts that only the enclave can open
+	if err := h.secrets.Resolve(&req); err != nil {
+		return protocol.WASMResponse{}, err
+	}
 
-	// Send request to enclave
-	if err := encoder.Encode(req); err != nil {
-		return WASMResponse{}, fmt.Errorf("failed to send request to enclave: %v", err)
+	// The enclave calls KMS itself but has no credentials of its own
+	if len(req.KMSSecrets) > 0 && req.AWSCredentials == nil {
+		creds, err := h.credentials.Credentials()
+		if err != nil {
+			return protocol.WASMResponse{}, fmt.Errorf("cannot forward KMS secrets: %v", err)
+		}
+		req.AWSCredentials = creds
 	}
 
-	log.Println("Request sent to enclave, waiting for response...")
+	// Progress goes to the client under its own request ID, and only what
+	// it asked for, since the host asks for reports of its own. A request
+	// whose client cannot be sent any does not ask the enclave for it, and
+	// one that already streamed emits is not run again.
+	var streamed bool
+	progress := progressFrom(ctx)
+	if progress == nil {
+		req.StreamEmits = false
+		req.ProgressIntervalMS = 0
+	}
+	clientReports := req.ProgressIntervalMS > 0
+	if interval := h.progressInterval.Milliseconds(); interval > 0 && req.Type == protocol.RequestTypeExecute && (!clientReports || interval < req.ProgressIntervalMS) {
+		req.ProgressIntervalMS = interval
+	}
+	var relay func(protocol.WASMResponse)
+	if req.StreamEmits || clientReports {
+		relay = func(response protocol.WASMResponse) {
+			if response.Emit == "" && !clientReports {
+				return
+			}
+			streamed = streamed || response.Emit != ""
+			response.RequestID = clientID
+			progress(response)
+		}
+	}
+	ctx = withProgress(ctx, relay)
+
+	logger = logger.With("enclave", backend.name, "enclave_request_id", enclaveID)
+	logger.Info("Forwarding to enclave", "function", req.FunctionName, "args", req.Args, "code_length", len(req.WASMCode))
+
+	// Transport failures (enclave restarting, stale connections) are retried
+	// with exponential backoff, on another replica when there is one; errors
+	// reported by the enclave itself, and shed requests, are not. Requests
+	// for a replica's session or keys can only be retried on that replica.
+	var response protocol.WASMResponse
+	var overload *overloadError
+	var replica *enclaveReplica
+	tried := map[*enclaveReplica]bool{}
+	backoff := initialRetryBackoff
+	for attempt := 0; ; attempt++ {
+		replica = pinned
+		if replica == nil {
+			replica = backend.pick(tried)
+		}
+		if replica == nil {
+			return protocol.WASMResponse{}, backend.unavailable()
+		}
+		if open := replica.breaker.openError(replica.id); open != nil {
+			return protocol.WASMResponse{}, open
+		}
+		tried[replica] = true
+		response, err = h.tryForward(ctx, replica, req)
+		if err == nil {
+			replica.markUp()
+			break
+		}
+		if ctx.Err() != nil {
+			// Given up on by the client, not failed by the enclave
+			return protocol.WASMResponse{}, ctx.Err()
+		}
+		if errors.As(err, &overload) {
+			return protocol.WASMResponse{}, err
+		}
+		replica.markDown(err)
+		if streamed {
+			return protocol.WASMResponse{}, fmt.Errorf("enclave failed after streaming progress: %v", err)
+		}
+		if attempt >= h.maxRetries {
+			return protocol.WASMResponse{}, fmt.Errorf("giving up after %d attempts: %v", attempt+1, err)
+		}
 
-	// Receive response from enclave
-	var response WASMResponse
-	if err := decoder.Decode(&response); err != nil {
-		return WASMResponse{}, fmt.Errorf("failed to decode WASM response from enclave: %v", err)
+		if pinned == nil && len(tried) < len(backend.replicas) {
+			// Another replica is worth trying right away
+			enclaveFailovers.WithLabelValues(replica.id).Inc()
+			logger.Warn("Enclave request failed, trying another replica",
+				"replica", replica.id, "attempt", attempt+1, "attempts", h.maxRetries+1, "error", err)
+			continue
+		}
+		logger.Warn("Enclave request failed, retrying",
+			"replica", replica.id, "attempt", attempt+1, "attempts", h.maxRetries+1, "error", err, "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return protocol.WASMResponse{}, ctx.Err()
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+	response.RequestID = clientID
+	response.SessionID = h.enclaves.sessionID(replica, response.SessionID)
+	response.JobID = h.enclaves.sessionID(replica, response.JobID)
+	response.UploadID = h.enclaves.sessionID(replica, response.UploadID)
+	if h.enclaves.multiple() {
+		response.Enclave = replica.id
 	}
 
-	log.Printf("Received response from enclave: result=%d, error=%s", response.Result, response.Error)
+	logger.Info("Received response from enclave", "replica", replica.id, "result", response.Result, "error", response.Error)
 
 	return response, nil
 }
 
+// nextRequestID returns a request ID no other request to an enclave has
+func (h *HostService) nextRequestID() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	return strconv.FormatUint(h.nextID, 10)
+}
+
+// tryForward performs a single round trip on a pooled connection of replica
+func (h *HostService) tryForward(ctx context.Context, replica *enclaveReplica, req protocol.WASMRequest) (protocol.WASMResponse, error) {
+	// Requests beyond what the pool and its queue hold are shed right away
+	release, err := replica.admission.admit()
+	if err != nil {
+		return protocol.WASMResponse{}, err
+	}
+	defer release()
+	replica.inFlight.Add(1)
+	enclaveInFlight.WithLabelValues(replica.id).Inc()
+	defer func() {
+		replica.inFlight.Add(-1)
+		enclaveInFlight.WithLabelValues(replica.id).Dec()
+	}()
+
+	c, err := replica.admission.checkout(ctx, replica.pool)
+	if err != nil {
+		return protocol.WASMResponse{}, err
+	}
+	defer replica.pool.Checkin(c)
+
+	defer observeRoundTrip(time.Now())
+	if req.ProgressIntervalMS == 0 || h.progressInterval == 0 || h.progressTimeout == 0 {
+		return c.roundTrip(ctx, req)
+	}
+	// An enclave that stops reporting is given up on like a broken connection
+	watched, release := watchProgress(ctx, h.progressTimeout)
+	defer release()
+	response, err := c.roundTrip(watched, req)
+	var stalled *stalledError
+	if err != nil && errors.As(context.Cause(watched), &stalled) {
+		err = stalled
+	}
+	return response, err
+}
+
 func main() {
-	log.Println("Starting enclave host...")
+	enclaveCID := flag.Uint("enclave-cid", defaultEnclaveCID, "vsock CID of the enclave")
+	enclavePort := flag.Uint("enclave-port", protocol.EnclavePort, "vsock port the enclave listens on")
+	enclavesFile := flag.String("enclaves", "", "YAML file of named enclaves and the modules each runs, to route requests among instead of -enclave-cid (empty disables)")
+	poolSize := flag.Int("pool-size", 4, "number of pooled vsock connections to each enclave, or with -multiplex the requests its connection carries at once")
+	queueLength := flag.Int("queue-length", defaultHostQueueLength, "requests that may wait for a free enclave connection before new ones are shed")
+	queueTimeout := flag.Duration("queue-timeout", defaultQueueTimeout, "time a request may wait for a free enclave connection before it is shed")
+	retryAfter := flag.Duration("retry-after", defaultRetryAfter, "retry delay suggested to clients whose request was shed")
+	rateLimit := flag.Float64("rate-limit", 0, "requests per second each client may send (0 disables)")
+	rateBurst := flag.Int("rate-burst", 10, "requests a client may send at once before -rate-limit applies")
+	maxRetries := flag.Int("max-retries", 3, "retries for a request when the enclave connection fails")
+	pingInterval := flag.Duration("ping-interval", 10*time.Second, "interval between enclave liveness checks (0 disables)")
+	pingTimeout := flag.Duration("ping-timeout", 5*time.Second, "time an enclave liveness or readiness check may take")
+	circuitFailures := flag.Int("circuit-failures", 5, "requests in a row that may fail to reach an enclave before its requests fail fast until it answers a probe (0 disables)")
+	circuitProbeInterval := flag.Duration("circuit-probe-interval", 5*time.Second, "interval between probes of an enclave whose requests fail fast")
+	progressInterval := flag.Duration("progress-interval", 0, "how often enclaves report the elapsed time and fuel of executions, so that one that stops reporting for -progress-timeout is given up on as hung (0 disables)")
+	progressTimeout := flag.Duration("progress-timeout", 30*time.Second, "time an execution may go without reporting progress, with -progress-interval, before the host gives up on its enclave connection and retries")
+	pingMisses := flag.Int("ping-misses", 3, "heartbeats in a row an idle -framed connection may leave unanswered before it is redialed; JSON stream connections are redialed after one")
+	framed := flag.Bool("framed", false, "use length-prefixed framing on connections to the enclave")
+	multiplex := flag.Bool("multiplex", false, "carry the requests to each enclave as streams of one -framed connection, up to -pool-size at once, instead of on a connection each")
+	compressionList := flag.String("compression", wire.DefaultCompression, "comma-separated codecs to offer the enclave on -framed connections, in order of preference: zstd, gzip, or none")
+	encoding := flag.String("encoding", wire.EncodingJSON, "message encoding to offer the enclave on -framed connections: json, or cbor for smaller messages, falling back to JSON with enclaves that lack it")
+	logFormat := flag.String("log-format", "json", "log output format: json or text")
+	jsonAddr := flag.String("json-addr", fmt.Sprintf(":%d", protocol.HostPort), "address of the JSON-over-TCP listener (empty disables)")
+	grpcAddr := flag.String("grpc-addr", ":50051", "address of the gRPC listener (empty disables)")
+	httpAddr := flag.String("http-addr", ":8082", "address of the HTTP/JSON REST listener (empty disables)")
+	adminAddr := flag.String("admin-addr", "", "address of the operators' admin API, which must not be reachable by clients (empty disables)")
+	adminTokenSHA256 := flag.String("admin-token-sha256", "", "hex SHA-256 of the bearer token the admin API requires")
+	passthroughAddr := flag.String("tls-passthrough-addr", "", "address where clients reach the enclave's own TLS listener, with the host only relaying bytes (empty disables)")
+	enclaveTLSPort := flag.Uint("enclave-tls-port", protocol.EnclaveTLSPort, "vsock port of the enclave's TLS listener")
+	transportKind := flag.String("transport", transport.Auto, "how to reach enclaves: vsock, tcp for enclaves run locally with -transport tcp, or auto for vsock where available")
+	tcpHost := flag.String("tcp-host", transport.DefaultTCPHost, "host of the enclaves' listeners with -transport tcp; CIDs are ignored, so local enclaves need ports of their own")
+	simulate := flag.Bool("simulate", false, "run the enclave server inside the host instead of reaching enclaves, for integration tests without Nitro; there is no attestation or KMS (needs a host built with -tags simulate)")
+	simulateArgs := flag.String("simulate-args", "", "space-separated enclave-server flags for the -simulate enclave, e.g. \"-modules-dir modules -fuel-metering\"")
+	httpMaxBody := flag.Int64("http-max-body", 16<<20, "maximum HTTP request body size in bytes")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate for TLS on the client-facing listeners (empty serves plaintext)")
+	tlsKey := flag.String("tls-key", "", "PEM private key of -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "PEM bundle of CAs whose client certificates are accepted; requires clients to present one")
+	authFile := flag.String("auth-file", "", "YAML file of API clients and what they may run; requests without a valid token are refused (empty disables)")
+	iamAudience := flag.String("iam-audience", "hello-wasm-host", "audience IAM tokens must be signed for")
+	maxModules := flag.Int("max-modules", 256, "maximum number of modules each tenant may register over gRPC or HTTP")
+	drainTimeout := flag.Duration("drain-timeout", drain.DefaultTimeout, "time given to requests in flight to finish on SIGTERM before exiting")
+	maxRequestBytes := flag.Int("max-request-bytes", protocol.DefaultMaxRequestBytes, "largest encoded request accepted over JSON or gRPC")
+	maxWASMBytes := flag.Int("max-wasm-bytes", protocol.DefaultMaxWASMBytes, "largest wasm_code accepted, as WAT text or base64")
+	maxArgs := flag.Int("max-args", protocol.DefaultMaxArgs, "most arguments a call may pass")
+	maxSecrets := flag.Int("max-secrets", protocol.DefaultMaxSecrets, "most secrets a request may carry")
+	cacheSize := flag.Int("cache-size", 0, "responses to deterministic requests to keep and answer repeats with (0 disables)")
+	cacheTTL := flag.Duration("cache-ttl", defaultCacheTTL, "time a cached response is answered with")
+	idempotencyKeys := flag.Int("idempotency-keys", defaultIdempotencyKeys, "idempotency keys of executions to remember and answer retries of with the original response (0 ignores the keys)")
+	moduleBucket := flag.String("module-bucket", "", "S3 bucket, as BUCKET or BUCKET/PREFIX, that registered modules are stored in and fetched from after a restart (empty keeps them in memory)")
+	moduleStoreEndpoint := flag.String("module-store-endpoint", "", "URL of an S3-compatible service to store modules in instead of AWS S3, addressed path-style")
+	idempotencyTTL := flag.Duration("idempotency-ttl", defaultIdempotencyTTL, "time an idempotency key is remembered")
+	ociRegistries := flag.String("oci-registries", "", "comma-separated registries, such as ghcr.io, that requests may name modules in by module_ref for the host to pull (empty refuses module_ref)")
+	ociCacheTTL := flag.Duration("oci-cache-ttl", defaultOCICacheTTL, "time a module pulled by module_ref is kept before its reference is resolved again")
+	callbackAllowlist := flag.String("callback-allowlist", "", "comma-separated prefixes of the https URLs and SQS queue ARNs that submit_job requests may have their result delivered to (empty refuses callbacks)")
+	callbackRetries := flag.Int("callback-retries", defaultCallbackRetries, "retries of a failed delivery to a callback, with exponential backoff")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "URL of an OTLP/HTTP collector, such as http://localhost:4318, to export the spans of traced requests to (empty traces nothing)")
+	traceSampleRatio := flag.Float64("trace-sample-ratio", 1, "share of the requests arriving without a traceparent to trace, with -otlp-endpoint; those with one are traced as it says")
+	enclaveLogDestination := flag.String("enclave-logs", "", "where to write the log records enclaves send over their log channel: stdout, or cloudwatch:GROUP for a CloudWatch Logs stream per replica (empty does not follow them)")
+	enclaveLogLevel := flag.String("enclave-log-level", "info", "least severe enclave log records written with -enclave-logs: debug, info, warn or error")
+	enclaveLogPort := flag.Uint("enclave-log-port", protocol.EnclaveLogPort, "vsock port of the enclaves' log channel")
+	cloudWatchNamespace := flag.String("cloudwatch-namespace", "", "CloudWatch namespace to publish request counts, latencies, error rates and enclave restarts to with PutMetricData (empty publishes nothing)")
+	cloudWatchInterval := flag.Duration("cloudwatch-interval", time.Minute, "how often metrics are published to -cloudwatch-namespace")
+	callbackPollInterval := flag.Duration("callback-poll-interval", defaultCallbackPollInterval, "how often the host asks the enclave whether a job with a callback has finished")
+	gc := gctune.Register(flag.CommandLine)
+	if err := config.Parse(flag.CommandLine, "WASM_HOST", os.Args[1:]); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := logging.Setup(*logFormat); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := gc.Apply(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-	hostService := NewHostService()
+	tlsConfig, err := loadServerTLS(*tlsCert, *tlsKey, *tlsClientCA)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	var dialOptions wire.DialOptions
+	if dialOptions.Compression, err = wire.ParseCompression(*compressionList); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if dialOptions.Encodings, err = wire.ParseEncoding(*encoding); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if *multiplex && !*framed {
+		log.Fatalf("Invalid configuration: -multiplex needs -framed")
+	}
+	dialOptions.Streams = *multiplex
 
-	// Try to connect to enclave
-	log.Println("Attempting to connect to enclave...")
-	if err := hostService.connectToEnclave(); err != nil {
-		log.Printf("Warning: Could not connect to enclave initially: %v", err)
-		log.Println("Will retry when handling client requests")
+	var auth *Authenticator
+	if *authFile != "" {
+		if auth, err = loadAuthenticator(*authFile, *iamAudience); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		log.Printf("Requiring API tokens for %d clients", len(auth.byToken)+len(auth.byARN))
+	}
+
+	backends := []backendConfig{{Name: defaultBackendName, CIDs: []uint32{uint32(*enclaveCID)}, Port: uint32(*enclavePort), Modules: []string{anyName}}}
+	if *enclavesFile != "" {
+		if backends, err = loadBackends(*enclavesFile); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		for _, backend := range backends {
+			log.Printf("Routing %d modules to enclave %s on CIDs %v port %d", len(backend.Modules), backend.Name, backend.CIDs, backend.Port)
+		}
 	}
 
-	// Listen on TCP for clients (since host process runs on EC2, not in enclave)
-	listener, err := net.Listen("tcp", ":8081")
+	var enclaveTransport *transport.Transport
+	if *simulate {
+		if *enclavesFile != "" {
+			log.Fatalf("Invalid configuration: -simulate runs a single enclave and cannot be combined with -enclaves")
+		}
+		if *enclaveLogDestination != "" {
+			log.Fatalf("Invalid configuration: -enclave-logs has nothing to follow with -simulate, whose enclave logs with the host")
+		}
+		enclaveTransport = transport.NewInProcess()
+		// The simulated enclave listens where the host will dial it, and
+		// logs with the host's logger rather than over a log channel
+		args := append(strings.Fields(*simulateArgs), "-port", strconv.FormatUint(uint64(*enclavePort), 10), "-tls-port", strconv.FormatUint(uint64(*enclaveTLSPort), 10))
+		if err := startSimulatedEnclave(args, enclaveTransport); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		log.Println("Simulating the enclave in process: responses are not attested")
+	} else if enclaveTransport, err = transport.New(*transportKind, *tcpHost); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	log.Println("Starting enclave host...")
+	log.Printf("Reaching enclaves over %s", enclaveTransport.Kind())
+
+	enclaves := newEnclaveRouter(backends, enclaveTransport, *poolSize, *framed, dialOptions, *queueLength, *queueTimeout, *retryAfter)
+	limiter := newRateLimiter("client", *rateLimit, *rateBurst)
+	hostService := NewHostService(enclaves, limiter, auth, *maxRetries, *pingTimeout)
+	hostService.moduleRegistration = *grpcAddr != "" || *httpAddr != ""
+	if *progressInterval > 0 {
+		if *progressInterval < protocol.MinProgressIntervalMS*time.Millisecond {
+			log.Fatalf("Invalid configuration: -progress-interval must be at least %dms", protocol.MinProgressIntervalMS)
+		}
+		if *progressTimeout > 0 && *progressTimeout <= *progressInterval {
+			log.Fatalf("Invalid configuration: -progress-timeout must be longer than -progress-interval")
+		}
+		hostService.progressInterval = *progressInterval
+		hostService.progressTimeout = *progressTimeout
+		log.Printf("Asking enclaves for the progress of executions every %v", *progressInterval)
+		if *progressTimeout > 0 {
+			log.Printf("Giving up on executions that report no progress for %v", *progressTimeout)
+		}
+	}
+	hostService.sizeLimits = protocol.SizeLimits{
+		MaxRequestBytes: *maxRequestBytes,
+		MaxWASMBytes:    *maxWASMBytes,
+		MaxArgs:         *maxArgs,
+		MaxSecrets:      *maxSecrets,
+	}
+	hostService.cache = newResponseCache(*cacheSize, *cacheTTL)
+	if hostService.cache != nil {
+		log.Printf("Caching up to %d deterministic responses for %v", *cacheSize, *cacheTTL)
+	}
+	hostService.idempotency = newIdempotencyKeys(*idempotencyKeys, *idempotencyTTL)
+	if hostService.oci, err = newOCIPuller(*ociRegistries, *ociCacheTTL, *maxWASMBytes); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if hostService.oci != nil {
+		log.Printf("Pulling modules by module_ref from %s", *ociRegistries)
+	}
+	if hostService.tracer, err = newHostTracer(*otlpEndpoint, *traceSampleRatio); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if hostService.tracer != nil {
+		log.Printf("Exporting the spans of traced requests to %s", *otlpEndpoint)
+	}
+	cloudWatch, err := newCloudWatchMetrics(*cloudWatchNamespace, *cloudWatchInterval, hostService.credentials)
 	if err != nil {
-		log.Fatalf("Failed to listen on TCP: %v", err)
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cloudWatch != nil {
+		log.Printf("Publishing metrics to CloudWatch namespace %s every %v", *cloudWatchNamespace, *cloudWatchInterval)
+	}
+	enclaveLogs, err := newEnclaveLogs(*enclaveLogDestination, *enclaveLogLevel, enclaveTransport, uint32(*enclaveLogPort), hostService.credentials)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if enclaveLogs != nil {
+		log.Printf("Writing enclave log records at %s and above to %s", enclaveLogs.level, *enclaveLogDestination)
+		enclaveLogs.follow(enclaves)
+	}
+	if *circuitFailures > 0 {
+		if *circuitProbeInterval <= 0 {
+			log.Fatalf("Invalid configuration: -circuit-probe-interval must be positive")
+		}
+		enclaves.StartCircuitBreakers(*circuitFailures, *circuitProbeInterval, *pingTimeout)
+	}
+	if *pingInterval > 0 {
+		if *pingMisses < 1 {
+			log.Fatalf("Invalid configuration: -ping-misses must be at least 1")
+		}
+		enclaves.StartHealthCheck(*pingInterval, *pingTimeout, *pingMisses)
+	}
+
+	// Try to connect to the enclaves
+	log.Println("Attempting to connect to enclave...")
+	hostService.connectToEnclaves()
+
+	// Modules registered over gRPC or HTTP are usable from both
+	modules := NewModuleRegistry(*maxModules, auth.moduleQuotas())
+	if *moduleBucket != "" {
+		store, err := newS3ModuleStore(*moduleBucket, *moduleStoreEndpoint, hostService.credentials)
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		stored, err := modules.Load(store)
+		if err != nil {
+			log.Fatalf("Failed to list the modules in %s: %v", *moduleBucket, err)
+		}
+		log.Printf("Storing registered modules in %s, which holds %d", *moduleBucket, stored)
+	}
+	drainer := drain.New()
+	if hostService.callbacks, err = newCallbacks(*callbackAllowlist, *callbackRetries, *callbackPollInterval, hostService.credentials, drainer); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if hostService.callbacks != nil {
+		log.Printf("Delivering job results to callbacks under %d prefixes", len(hostService.callbacks.allowed))
+	}
+
+	if *grpcAddr != "" {
+		go func() {
+			if err := serveGRPC(*grpcAddr, hostService, modules, tlsConfig, drainer); err != nil {
+				log.Fatalf("gRPC server failed: %v", err)
+			}
+		}()
+	}
+
+	if *httpAddr != "" {
+		go func() {
+			if err := serveHTTP(*httpAddr, hostService, modules, *httpMaxBody, tlsConfig, drainer); err != nil {
+				log.Fatalf("HTTP server failed: %v", err)
+			}
+		}()
+	}
+
+	if *adminAddr != "" {
+		if *adminTokenSHA256 == "" {
+			log.Fatalf("Invalid configuration: -admin-addr requires -admin-token-sha256")
+		}
+		go func() {
+			if err := serveAdmin(*adminAddr, hostService, *adminTokenSHA256, tlsConfig, drainer); err != nil {
+				log.Fatalf("Admin server failed: %v", err)
+			}
+		}()
+	}
+
+	if *passthroughAddr != "" {
+		// TLS sessions carry requests the host cannot route, so they all
+		// go to the first enclave
+		go func() {
+			if err := servePassthrough(*passthroughAddr, enclaveTransport, enclaves.backends[0].replicas[0].cid, uint32(*enclaveTLSPort), limiter, drainer); err != nil {
+				log.Fatalf("TLS passthrough listener failed: %v", err)
+			}
+		}()
+	}
+
+	if *jsonAddr == "" {
+		log.Printf("JSON listener disabled; ready to forward requests to %d enclaves (pool size %d each)", len(backends), *poolSize)
+	} else {
+		// Listen on TCP for clients (since host process runs on EC2, not in enclave)
+		listener, err := net.Listen("tcp", *jsonAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen on TCP: %v", err)
+		}
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+			log.Printf("JSON listener uses TLS (client certificates required: %v)", tlsConfig.ClientCAs != nil)
+		}
+		drainer.Track(listener)
+
+		log.Printf("Listening for JSON clients on %s", *jsonAddr)
+		log.Printf("Ready to forward requests to %d enclaves (pool size %d each)", len(backends), *poolSize)
+		go serveJSON(listener, hostService, drainer)
 	}
-	defer listener.Close()
 
-	log.Printf("Listening for clients on TCP port 8081")
-	log.Printf("Ready to forward requests to enclave on CID %d", EnclaveCID)
+	if err := drainer.Run(*drainTimeout); err != nil {
+		log.Fatalf("Shutdown incomplete: %v", err)
+	}
+	// The spans of the requests drained go out before the host exits
+	hostService.tracer.shutdown(*drainTimeout)
+	enclaveLogs.shutdown(*drainTimeout)
+	cloudWatch.shutdown(*drainTimeout)
+	log.Println("Shutdown complete")
+}
 
+// serveJSON accepts JSON clients until the listener is closed for draining
+func serveJSON(listener net.Listener, hostService *HostService, drainer *drain.Drainer) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if drainer.Draining() {
+				return
+			}
 			log.Printf("Failed to accept connection: %v", err)
 			continue
 		}
 
 		log.Println("New client connection")
-		go handleClientConnection(conn, hostService)
+		go handleClientConnection(conn, hostService, drainer)
 	}
 }
 
-func handleClientConnection(conn net.Conn, hostService *HostService) {
+func handleClientConnection(conn net.Conn, hostService *HostService, drainer *drain.Drainer) {
 	defer conn.Close()
+	activeClientConnections.Inc()
+	defer activeClientConnections.Dec()
+
+	addr := clientAddr(conn.RemoteAddr().String())
+	var clientID string
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// Handshake now so a rejected certificate ends the connection
+		// before any request is read
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+		err := tlsConn.Handshake()
+		conn.SetDeadline(time.Time{})
+		if err != nil {
+			log.Printf("TLS handshake with client %s failed: %v", addr, err)
+			return
+		}
+		state := tlsConn.ConnectionState()
+		clientID = tlsIdentity(&state)
+	}
+
+	encoder, decoder, framed, err := wire.Accept(conn, hostService.sizeLimits.MaxRequestBytes)
+	if err != nil {
+		log.Printf("Failed to set up client connection: %v", err)
+		return
+	}
+	if framed {
+		log.Println("Client uses length-prefixed framing")
+	}
 
-	decoder := json.NewDecoder(conn)
-	encoder := json.NewEncoder(conn)
+	// Client requests are handled concurrently; responses carry the client's
+	// request_id so they can be matched up regardless of completion order
+	var encodeMu sync.Mutex
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+
+	// Requests still in flight when the client disconnects are cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sendResponse := func(response protocol.WASMResponse) {
+		encodeMu.Lock()
+		defer encodeMu.Unlock()
+		if err := encoder.Encode(response); err != nil {
+			log.Printf("Failed to encode response to client: %v", err)
+		}
+	}
 
 	log.Println("Client connected, handling requests...")
 
 	for {
-		var req WASMRequest
+		var req protocol.WASMRequest
 		if err := decoder.Decode(&req); err != nil {
+			// A message that does not decode, or an oversized frame, leaves
+			// the stream intact; an oversized message on the JSON stream
+			// does not
+			var payloadErr *wire.PayloadError
+			var tooLarge *wire.TooLargeError
+			code := protocol.ErrorCodeInvalidRequest
+			if errors.As(err, &tooLarge) {
+				code = protocol.ErrorCodeRequestTooLarge
+			}
+			if errors.As(err, &payloadErr) {
+				log.Printf("Rejecting malformed client request %s: %v", req.RequestID, err)
+				sendResponse(protocol.WASMResponse{RequestID: req.RequestID, Error: err.Error(), ErrorCode: code})
+				continue
+			}
+			if tooLarge != nil {
+				log.Printf("Closing client connection after an oversized request: %v", err)
+				sendResponse(protocol.WASMResponse{Error: err.Error(), ErrorCode: code})
+				return
+			}
 			log.Printf("Failed to decode request or client disconnected: %v", err)
 			return
 		}
 
-		log.Printf("Received WASM request from client: function=%s, args=%v", req.FunctionName, req.Args)
+		// Identity comes from the connection, never from the client
+		received := time.Now()
+		req.ClientID = clientID
+		logger := correlate(&req)
+		logger.Info("Received WASM request from client", "function", req.FunctionName, "args", req.Args)
 
-		// Try to connect to enclave if not connected
-		if !hostService.enclaveConnected {
-			if err := hostService.connectToEnclave(); err != nil {
-				response := WASMResponse{
-					Result: 0,
-					Error:  fmt.Sprintf("Could not connect to enclave: %v", err),
-				}
-				encoder.Encode(response)
-				continue
-			}
+		if err := req.Validate(); err != nil {
+			logger.Warn("Rejecting invalid client request", "error", err)
+			sendResponse(protocol.WASMResponse{RequestID: req.RequestID, CorrelationID: req.CorrelationID, Error: err.Error(), ErrorCode: protocol.ValidationCode(err)})
+			continue
 		}
-
-		// Forward to enclave
-		wasmResp, err := hostService.forwardToEnclave(req)
-		if err != nil {
-			log.Printf("Failed to forward request to enclave: %v", err)
-			response := WASMResponse{
-				Result: 0,
-				Error:  fmt.Sprintf("Enclave communication error: %v", err),
+		reqCtx, span := hostService.startRequest(ctx, req.TraceParent, received)
+
+		// While draining, requests on open connections are refused; those
+		// admitted are answered before the host exits
+		if !drainer.Start() {
+			logger.Warn("Refusing request while shutting down")
+			response := protocol.WASMResponse{
+				RequestID:     req.RequestID,
+				CorrelationID: req.CorrelationID,
+				Error:         "host is shutting down",
+				ErrorCode:     protocol.ErrorCodeShuttingDown,
 			}
-			encoder.Encode(response)
+			finishRequest(reqCtx, span, response.Error, func() { sendResponse(response) })
 			continue
 		}
-
-		log.Printf("Sending response to client: %s(%v) = %d", req.FunctionName, req.Args, wasmResp.Result)
-
-		if err := encoder.Encode(wasmResp); err != nil {
-			log.Printf("Failed to encode response to client: %v", err)
-			return
-		}
+		// What the module emits reaches the client as it runs, on this
+		// connection alongside other responses
+		reqCtx = withProgress(reqCtx, sendResponse)
+		inFlight.Add(1)
+		go func(req protocol.WASMRequest) {
+			defer inFlight.Done()
+			defer drainer.Done()
+
+			// Forward to enclave; the pool dials on demand
+			wasmResp, err := hostService.forwardToEnclave(reqCtx, addr, req)
+			if err != nil {
+				logger.Error("Failed to forward request to enclave", "error", err)
+				wasmResp = protocol.WASMResponse{
+					RequestID:     req.RequestID,
+					CorrelationID: req.CorrelationID,
+					Result:        0,
+					Error:         fmt.Sprintf("Enclave communication error: %v", err),
+				}
+			} else {
+				logger.Info("Sending response to client", "function", req.FunctionName, "result", wasmResp.Result)
+			}
+			finishRequest(reqCtx, span, wasmResp.Error, func() { sendResponse(wasmResp) })
+		}(req)
 	}
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Host metrics, served on /metrics of the REST listener
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wasm_host_requests_total",
+		Help: "Requests forwarded to the enclave, by outcome: ok, wasm_error, forward_error or an error_code.",
+	}, []string{"outcome"})
+
+	enclaveRoundTrip = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "wasm_host_enclave_round_trip_seconds",
+		Help:    "Time of a single round trip to the enclave, including execution.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+	})
+
+	enclaveCompile = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "wasm_host_enclave_compile_seconds",
+		Help:    "Module compile time reported by the enclave.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+	})
+
+	enclaveExecute = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "wasm_host_enclave_execute_seconds",
+		Help:    "Instantiation and call time reported by the enclave.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 18),
+	})
+
+	activeClientConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "wasm_host_client_connections",
+		Help: "Open client connections on the JSON listener.",
+	})
+
+	enclaveConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wasm_host_enclave_connections",
+		Help: "Open pooled vsock connections, by enclave.",
+	}, []string{"enclave"})
+
+	enclaveReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wasm_host_enclave_reconnects_total",
+		Help: "Connections dialed to replace one that broke or went stale, by enclave.",
+	}, []string{"enclave"})
+
+	enclaveUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wasm_host_enclave_up",
+		Help: "Whether requests are sent to an enclave (1), or it failed and is skipped until it answers (0).",
+	}, []string{"enclave"})
+	enclaveCircuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wasm_host_enclave_circuit_open",
+		Help: "Whether requests for an enclave fail fast (1) after too many in a row failed to reach it, until a probe gets an answer.",
+	}, []string{"enclave"})
+
+	enclaveSelfTestFailures = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wasm_host_enclave_self_test_failures",
+		Help: "Startup checks an enclave reported failing when the host first connected to it; nonzero suggests a misbuilt image.",
+	}, []string{"enclave"})
+
+	enclaveInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wasm_host_enclave_in_flight",
+		Help: "Requests sent or queued for an enclave.",
+	}, []string{"enclave"})
+
+	enclaveRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wasm_host_enclave_restarts_total",
+		Help: "Times an enclave was found to have started again, by the uptime its health checks report.",
+	}, []string{"enclave"})
+
+	enclaveFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wasm_host_enclave_failovers_total",
+		Help: "Requests moved to another replica after failing on an enclave.",
+	}, []string{"enclave"})
+
+	queuedRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "wasm_host_queued_requests",
+		Help: "Requests waiting for a free enclave connection.",
+	})
+
+	queueWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "wasm_host_queue_wait_seconds",
+		Help:    "Time queued requests waited for a free enclave connection.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	})
+
+	shedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wasm_host_shed_requests_total",
+		Help: "Requests shed by admission control, by reason: queue_full or queue_timeout.",
+	}, []string{"reason"})
+
+	passthroughConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "wasm_host_passthrough_connections",
+		Help: "Open end-to-end TLS connections relayed to the enclave.",
+	})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wasm_host_cache_lookups_total",
+		Help: "Deterministic requests looked up in the response cache, by result: hit or miss.",
+	}, []string{"result"})
+
+	responseCacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "wasm_host_cache_entries",
+		Help: "Responses held in the response cache.",
+	})
+
+	idempotentReplays = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wasm_host_idempotent_replays_total",
+		Help: "Retries answered with the original response of their idempotency key.",
+	})
+
+	idempotencyKeyEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "wasm_host_idempotency_keys",
+		Help: "Idempotency keys remembered.",
+	})
+
+	bytesForwarded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wasm_host_forwarded_bytes_total",
+		Help: "Bytes exchanged with the enclave, by direction: to_enclave or from_enclave.",
+	}, []string{"direction"})
+
+	callbackDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wasm_host_callback_deliveries_total",
+		Help: "Job results delivered to client callbacks, by outcome: delivered or failed.",
+	}, []string{"outcome"})
+)
+
+// observeResponse records the outcome and enclave timings of a request
+func observeResponse(response protocol.WASMResponse, err error) {
+	switch {
+	case err != nil:
+		requestsTotal.WithLabelValues("forward_error").Inc()
+		return
+	case response.ErrorCode != "":
+		requestsTotal.WithLabelValues(response.ErrorCode).Inc()
+	case response.Error != "":
+		requestsTotal.WithLabelValues("wasm_error").Inc()
+	default:
+		requestsTotal.WithLabelValues("ok").Inc()
+	}
+	if response.Cached || response.Replayed {
+		// The timings are those of the response's first enclave round trip
+		return
+	}
+	if response.CompileUS > 0 {
+		enclaveCompile.Observe(float64(response.CompileUS) / 1e6)
+	}
+	if response.ExecuteUS > 0 {
+		enclaveExecute.Observe(float64(response.ExecuteUS) / 1e6)
+	}
+}
+
+func observeRoundTrip(start time.Time) {
+	enclaveRoundTrip.Observe(time.Since(start).Seconds())
+}
+
+// countingConn counts the bytes moved over an enclave connection
+type countingConn struct {
+	net.Conn
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	bytesForwarded.WithLabelValues("from_enclave").Add(float64(n))
+	return n, err
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	bytesForwarded.WithLabelValues("to_enclave").Add(float64(n))
+	return n, err
+}
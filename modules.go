@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	errRegistryFull = errors.New("module registry is full")
+	errModuleStore  = errors.New("module store failed")
+)
+
+// ModuleRegistry keeps modules uploaded ahead of time so executions can
+// refer to them by ID instead of resending the code. IDs are the hex SHA-256
+// of the code, so registering the same module twice yields the same ID.
+// Each tenant has modules of its own, up to max unless quotas says
+// otherwise, and cannot run those of another by ID. With a store, modules
+// outlive the host: they are stored as they are registered, and those
+// registered before it started are fetched on first use. Modules may also
+// be run by alias, a name each tenant points at one of its modules.
+type ModuleRegistry struct {
+	mu sync.RWMutex
+	// Registered code by tenant and ID; empty for a stored module not yet
+	// fetched
+	modules map[string]map[string]string
+	max     int
+	quotas  map[string]int
+	// Module IDs by tenant and alias
+	aliases map[string]map[string]string
+	// Persists modules; nil keeps them in memory only
+	store *s3ModuleStore
+	// Held by a registration while it stores its module, so that the quota
+	// is not exceeded without holding up lookups
+	registering sync.Mutex
+	// Held by an alias change while it stores the aliases, so that changes
+	// are stored in the order they are made
+	aliasing sync.Mutex
+}
+
+func NewModuleRegistry(max int, quotas map[string]int) *ModuleRegistry {
+	return &ModuleRegistry{
+		modules: make(map[string]map[string]string),
+		aliases: make(map[string]map[string]string),
+		max:     max,
+		quotas:  quotas,
+	}
+}
+
+// Load makes the registry keep its modules and aliases in store, and learns
+// those already there; it returns how many modules there are
+func (r *ModuleRegistry) Load(store *s3ModuleStore) (int, error) {
+	ids, err := store.list()
+	if err != nil {
+		return 0, err
+	}
+	aliases, err := store.getAliases()
+	if err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.store = store
+	r.aliases = aliases
+	total := 0
+	for tenant, tenantIDs := range ids {
+		modules := r.modules[tenant]
+		if modules == nil {
+			modules = make(map[string]string)
+			r.modules[tenant] = modules
+		}
+		for _, id := range tenantIDs {
+			if _, ok := modules[id]; !ok {
+				modules[id] = ""
+			}
+		}
+		total += len(tenantIDs)
+	}
+	return total, nil
+}
+
+// Register stores code for tenant and returns its module ID
+func (r *ModuleRegistry) Register(tenant, code string) (string, error) {
+	if code == "" {
+		return "", fmt.Errorf("module code is empty")
+	}
+	digest := sha256.Sum256([]byte(code))
+	id := hex.EncodeToString(digest[:])
+
+	r.registering.Lock()
+	defer r.registering.Unlock()
+	r.mu.RLock()
+	modules := r.modules[tenant]
+	_, exists := modules[id]
+	count := len(modules)
+	r.mu.RUnlock()
+	if exists {
+		return id, nil
+	}
+	max := r.max
+	if quota, ok := r.quotas[tenant]; ok {
+		max = quota
+	}
+	if count >= max {
+		return "", fmt.Errorf("%w (%d modules)", errRegistryFull, max)
+	}
+	// Stored before it is registered, so that an ID handed out survives
+	// a restart
+	if r.store != nil {
+		if err := r.store.put(tenant, id, code); err != nil {
+			return "", fmt.Errorf("%w: %v", errModuleStore, err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.modules[tenant] == nil {
+		r.modules[tenant] = make(map[string]string)
+	}
+	r.modules[tenant][id] = code
+	return id, nil
+}
+
+// Get returns the code tenant registered under id, or under the module an
+// alias points at, fetching it from the store if it was registered before
+// the host started
+func (r *ModuleRegistry) Get(tenant, id string) (string, bool, error) {
+	r.mu.RLock()
+	if target, ok := r.aliases[tenant][id]; ok {
+		id = target
+	}
+	code, ok := r.modules[tenant][id]
+	r.mu.RUnlock()
+	if !ok || code != "" {
+		return code, ok, nil
+	}
+
+	code, ok, err := r.store.get(tenant, id)
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %v", errModuleStore, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !ok {
+		// Deleted from the bucket behind the host's back
+		delete(r.modules[tenant], id)
+		return "", false, nil
+	}
+	r.modules[tenant][id] = code
+	return code, true, nil
+}
+
+// Len returns the number of modules registered by every tenant
+func (r *ModuleRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	total := 0
+	for _, modules := range r.modules {
+		total += len(modules)
+	}
+	return total
+}
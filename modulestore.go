@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"hello-wasm-enclave/internal/sigv4"
+	"hello-wasm-enclave/internal/wire"
+)
+
+// Upper bound on an S3 response body other than a module
+const maxStoreResponseSize = 4 << 20
+
+// Object under the prefix that holds the aliases of every tenant; it is no
+// module ID, so listing skips it
+const aliasesObject = "aliases.json"
+
+// s3ModuleStore keeps registered modules in an S3 bucket, so that the
+// registry survives restarts and redeployments of the host. Each module is
+// one object named by its ID, under PREFIX/ for the default tenant and
+// PREFIX/TENANT/ for the others.
+type s3ModuleStore struct {
+	bucket, prefix string
+	// An S3-compatible service addressed path-style; empty for AWS S3
+	endpoint    string
+	credentials *CredentialProvider
+	client      *http.Client
+}
+
+// newS3ModuleStore returns the store of -module-bucket, which is BUCKET or
+// BUCKET/PREFIX
+func newS3ModuleStore(location, endpoint string, credentials *CredentialProvider) (*s3ModuleStore, error) {
+	bucket, prefix, _ := strings.Cut(location, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("module bucket %q has no bucket name", location)
+	}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+	if endpoint != "" {
+		if _, err := url.ParseRequestURI(endpoint); err != nil {
+			return nil, fmt.Errorf("invalid module store endpoint: %v", err)
+		}
+	}
+	return &s3ModuleStore{
+		bucket:      bucket,
+		prefix:      prefix,
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		credentials: credentials,
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// key returns the object name of a module. Tenant names are escaped, so
+// they cannot reach into each other's objects.
+func (s *s3ModuleStore) key(tenant, id string) string {
+	if tenant == "" {
+		return s.prefix + id
+	}
+	return s.prefix + url.PathEscape(tenant) + "/" + id
+}
+
+// put stores the code of a module
+func (s *s3ModuleStore) put(tenant, id, code string) error {
+	_, err := s.do(http.MethodPut, s.key(tenant, id), "", []byte(code), maxStoreResponseSize)
+	return err
+}
+
+// get returns the code of a module, or false when the bucket does not hold
+// it. Code that does not hash to its ID is an error.
+func (s *s3ModuleStore) get(tenant, id string) (string, bool, error) {
+	body, err := s.do(http.MethodGet, s.key(tenant, id), "", nil, wire.MaxFrameSize)
+	if err == errObjectNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if digest := sha256.Sum256(body); hex.EncodeToString(digest[:]) != id {
+		return "", false, fmt.Errorf("stored module %s does not match its ID", id)
+	}
+	return string(body), true, nil
+}
+
+// list returns the IDs of the modules in the bucket by tenant, ignoring
+// objects that are not modules
+func (s *s3ModuleStore) list() (map[string][]string, error) {
+	ids := make(map[string][]string)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		body, err := s.do(http.MethodGet, "", strings.ReplaceAll(query.Encode(), "+", "%20"), nil, maxStoreResponseSize)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("invalid ListObjectsV2 response: %v", err)
+		}
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, s.prefix)
+			tenant, id := "", name
+			if slash := strings.LastIndex(name, "/"); slash >= 0 {
+				escaped, err := url.PathUnescape(name[:slash])
+				if err != nil {
+					continue
+				}
+				tenant, id = escaped, name[slash+1:]
+			}
+			if !isModuleID(id) {
+				continue
+			}
+			ids[tenant] = append(ids[tenant], id)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return ids, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// getAliases returns the aliases in the bucket by tenant
+func (s *s3ModuleStore) getAliases() (map[string]map[string]string, error) {
+	aliases := make(map[string]map[string]string)
+	body, err := s.do(http.MethodGet, s.prefix+aliasesObject, "", nil, maxStoreResponseSize)
+	if err == errObjectNotFound {
+		return aliases, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &aliases); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", aliasesObject, err)
+	}
+	return aliases, nil
+}
+
+// putAliases stores the aliases of every tenant
+func (s *s3ModuleStore) putAliases(aliases map[string]map[string]string) error {
+	body, err := json.Marshal(aliases)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(http.MethodPut, s.prefix+aliasesObject, "", body, maxStoreResponseSize)
+	return err
+}
+
+// errObjectNotFound is an object the bucket does not hold
+var errObjectNotFound = errors.New("object not found")
+
+// do makes a signed S3 request for an object, or for the bucket when key is
+// empty, and returns up to limit bytes of the response body
+func (s *s3ModuleStore) do(method, key, query string, body []byte, limit int) ([]byte, error) {
+	creds, err := s.credentials.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	target := &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", s.bucket, creds.Region), Path: "/" + key, RawQuery: query}
+	if s.endpoint != "" {
+		if target, err = url.Parse(s.endpoint); err != nil {
+			return nil, err
+		}
+		target.Path += "/" + s.bucket + "/" + key
+		target.RawQuery = query
+	}
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	sigv4.SignRequest(req, body, *creds, "s3", time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s request failed: %v", method, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read S3 %s response: %v", method, err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound && key != "":
+		return nil, errObjectNotFound
+	case resp.StatusCode != http.StatusOK:
+		var s3Err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		xml.Unmarshal(respBody, &s3Err)
+		return nil, fmt.Errorf("S3 %s returned %d: %s %s", method, resp.StatusCode, s3Err.Code, s3Err.Message)
+	case len(respBody) > limit:
+		return nil, fmt.Errorf("S3 %s response exceeds %d bytes", method, limit)
+	}
+	return respBody, nil
+}
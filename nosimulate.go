@@ -0,0 +1,13 @@
+//go:build !simulate
+
+package main
+
+import (
+	"fmt"
+
+	"hello-wasm-enclave/internal/transport"
+)
+
+func startSimulatedEnclave([]string, *transport.Transport) error {
+	return fmt.Errorf("-simulate needs a host built with -tags simulate")
+}
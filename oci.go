@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/wire"
+)
+
+// Default for -oci-cache-ttl
+const defaultOCICacheTTL = 5 * time.Minute
+
+const (
+	// Manifests the host asks registries for
+	ociManifestTypes = "application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json"
+	// Upper bound on a manifest or token response
+	maxOCIResponseSize = 1 << 20
+	// Most pulled modules kept at once
+	maxPulledModules = 100
+)
+
+// Media types of the layer holding a module: that of the WASM OCI artifact
+// layout, and the one of the older wasm-to-oci tool
+var wasmLayerTypes = map[string]bool{"application/wasm": true, "application/vnd.wasm.content.layer.v1+wasm": true}
+
+// Syntax of the parts of a reference, as the OCI distribution spec has it
+var (
+	ociRepositoryPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	ociTagPattern        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,127}$`)
+	ociDigestPattern     = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	challengeParam       = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// ociReference is a parsed module_ref, REGISTRY/REPOSITORY[:TAG][@DIGEST]
+type ociReference struct {
+	registry, repository, tag, digest string
+}
+
+// parseOCIReference parses a module_ref. As with container images, a
+// reference without a registry is on Docker Hub, and one with neither tag
+// nor digest is tagged latest.
+func parseOCIReference(ref string) (ociReference, error) {
+	var r ociReference
+	rest := ref
+	if at := strings.Index(rest, "@"); at >= 0 {
+		rest, r.digest = rest[:at], rest[at+1:]
+		if !ociDigestPattern.MatchString(r.digest) {
+			return r, fmt.Errorf("module_ref %q has an invalid digest; only sha256 digests are supported", ref)
+		}
+	}
+	if slash := strings.Index(rest, "/"); slash >= 0 && (strings.ContainsAny(rest[:slash], ".:") || rest[:slash] == "localhost") {
+		r.registry, rest = rest[:slash], rest[slash+1:]
+	} else {
+		r.registry = "docker.io"
+		if !strings.Contains(rest, "/") {
+			rest = "library/" + rest
+		}
+	}
+	if colon := strings.LastIndex(rest, ":"); colon >= 0 {
+		rest, r.tag = rest[:colon], rest[colon+1:]
+		if !ociTagPattern.MatchString(r.tag) {
+			return r, fmt.Errorf("module_ref %q has an invalid tag", ref)
+		}
+	}
+	if !ociRepositoryPattern.MatchString(rest) {
+		return r, fmt.Errorf("module_ref %q has an invalid repository", ref)
+	}
+	r.repository = rest
+	if r.tag == "" && r.digest == "" {
+		r.tag = "latest"
+	}
+	return r, nil
+}
+
+// String returns the reference in full
+func (r ociReference) String() string {
+	s := r.registry + "/" + r.repository
+	if r.tag != "" {
+		s += ":" + r.tag
+	}
+	if r.digest != "" {
+		s += "@" + r.digest
+	}
+	return s
+}
+
+// url returns the registry API URL of a resource of the repository
+func (r ociReference) url(path string) string {
+	host := r.registry
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	return "https://" + host + "/v2/" + r.repository + path
+}
+
+// ociPuller pulls the modules that requests name by module_ref, an OCI
+// artifact reference such as ghcr.io/org/module:tag, so that clients need
+// not ship their bytes. The module is the artifact's wasm layer, which must
+// match its digest, as the manifest of a reference by digest must. Only
+// public artifacts of the allowed registries are pulled, since the host
+// makes the requests. Pulled modules are kept for ttl, so that a tag is not
+// resolved again for every request.
+type ociPuller struct {
+	registries map[string]bool
+	ttl        time.Duration
+	// Largest layer, such that its base64 fits -max-wasm-bytes
+	maxBytes int64
+	client   *http.Client
+
+	mu     sync.Mutex
+	pulled map[string]pulledModule
+}
+
+// pulledModule is the code of a module_ref and when it is to be pulled again
+type pulledModule struct {
+	code    string
+	expires time.Time
+}
+
+// newOCIPuller returns a puller for the comma-separated registries in
+// allowlist, or nil when it is empty, which refuses module_ref
+func newOCIPuller(allowlist string, ttl time.Duration, maxWASMBytes int) (*ociPuller, error) {
+	registries := make(map[string]bool)
+	for _, registry := range strings.Split(allowlist, ",") {
+		registry = strings.TrimSpace(registry)
+		if registry == "" {
+			continue
+		}
+		if strings.ContainsAny(registry, "/@") {
+			return nil, fmt.Errorf("OCI registry %q is not a host name", registry)
+		}
+		registries[registry] = true
+	}
+	if len(registries) == 0 {
+		return nil, nil
+	}
+	maxBytes := int64(wire.MaxFrameSize)
+	if maxWASMBytes > 0 {
+		maxBytes = int64(maxWASMBytes) / 4 * 3
+	}
+	return &ociPuller{
+		registries: registries,
+		ttl:        ttl,
+		maxBytes:   maxBytes,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			// Registries redirect blobs to their storage, but never to
+			// plain HTTP
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if req.URL.Scheme != "https" {
+					return fmt.Errorf("redirected to %s", req.URL.Scheme)
+				}
+				if len(via) >= 10 {
+					return errors.New("stopped after 10 redirects")
+				}
+				return nil
+			},
+		},
+		pulled: make(map[string]pulledModule),
+	}, nil
+}
+
+// resolve replaces the module_ref of a request with the code it names
+func (p *ociPuller) resolve(ctx context.Context, logger *slog.Logger, req *protocol.WASMRequest) error {
+	if req.ModuleRef == "" {
+		return nil
+	}
+	if p == nil {
+		return &protocol.ValidationError{Code: protocol.ErrorCodePolicy, Message: "this host does not pull modules from OCI registries"}
+	}
+	ref, err := parseOCIReference(req.ModuleRef)
+	if err != nil {
+		return &protocol.ValidationError{Code: protocol.ErrorCodeInvalidRequest, Message: err.Error()}
+	}
+	if !p.registries[ref.registry] {
+		return &protocol.ValidationError{Code: protocol.ErrorCodePolicy, Message: fmt.Sprintf("registry %s is not allowed", ref.registry)}
+	}
+
+	key := ref.String()
+	p.mu.Lock()
+	cached, ok := p.pulled[key]
+	p.mu.Unlock()
+	if !ok || time.Now().After(cached.expires) {
+		layer, err := p.pull(ctx, ref)
+		if err != nil {
+			logger.Warn("Failed to pull module", "module_ref", key, "error", err)
+			return &protocol.ValidationError{Code: protocol.ErrorCodeModuleUnavailable, Message: fmt.Sprintf("failed to pull %s: %v", key, err)}
+		}
+		logger.Info("Pulled module", "module_ref", key, "bytes", len(layer))
+		cached = pulledModule{code: base64.StdEncoding.EncodeToString(layer), expires: time.Now().Add(p.ttl)}
+		p.remember(key, cached)
+	}
+	req.WASMCode = cached.code
+	req.ModuleRef = ""
+	return nil
+}
+
+// remember keeps a pulled module, dropping the expired ones, and others
+// while there are too many
+func (p *ociPuller) remember(key string, module pulledModule) {
+	if p.ttl <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for other, pulled := range p.pulled {
+		if len(p.pulled) < maxPulledModules && now.Before(pulled.expires) {
+			continue
+		}
+		delete(p.pulled, other)
+	}
+	p.pulled[key] = module
+}
+
+// pull returns the wasm layer of an artifact
+func (p *ociPuller) pull(ctx context.Context, ref ociReference) ([]byte, error) {
+	var token string
+	reference := ref.tag
+	if ref.digest != "" {
+		reference = ref.digest
+	}
+	body, err := p.fetch(ctx, ref, "/manifests/"+reference, ociManifestTypes, maxOCIResponseSize, &token)
+	if err != nil {
+		return nil, err
+	}
+	if ref.digest != "" && sha256Digest(body) != ref.digest {
+		return nil, fmt.Errorf("manifest does not match digest %s", ref.digest)
+	}
+	var manifest struct {
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+			Size      int64  `json:"size"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+
+	for _, layer := range manifest.Layers {
+		if !wasmLayerTypes[layer.MediaType] {
+			continue
+		}
+		if !ociDigestPattern.MatchString(layer.Digest) {
+			return nil, fmt.Errorf("wasm layer has unsupported digest %q", layer.Digest)
+		}
+		if layer.Size > p.maxBytes {
+			return nil, fmt.Errorf("wasm layer of %d bytes exceeds the limit of %d", layer.Size, p.maxBytes)
+		}
+		blob, err := p.fetch(ctx, ref, "/blobs/"+layer.Digest, "", p.maxBytes, &token)
+		if err != nil {
+			return nil, err
+		}
+		if sha256Digest(blob) != layer.Digest {
+			return nil, fmt.Errorf("wasm layer does not match digest %s", layer.Digest)
+		}
+		return blob, nil
+	}
+	return nil, fmt.Errorf("artifact has no layer of media type application/wasm")
+}
+
+// fetch returns up to limit bytes of a resource of the repository. The
+// first refusal is answered by getting an anonymous token for the
+// registry's bearer challenge, which later fetches reuse.
+func (p *ociPuller) fetch(ctx context.Context, ref ociReference, path, accept string, limit int64, token *string) ([]byte, error) {
+	target := ref.url(path)
+	for challenged := false; ; challenged = true {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if *token != "" {
+			req.Header.Set("Authorization", "Bearer "+*token)
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", target, err)
+		}
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized && !challenged:
+			if *token, err = p.authorize(ctx, resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		case resp.StatusCode != http.StatusOK:
+			return nil, fmt.Errorf("GET %s returned %s", target, resp.Status)
+		case int64(len(body)) > limit:
+			return nil, fmt.Errorf("%s exceeds %d bytes", target, limit)
+		}
+		return body, nil
+	}
+}
+
+// authorize returns an anonymous token for a bearer challenge
+func (p *ociPuller) authorize(ctx context.Context, challenge string) (string, error) {
+	scheme, rest, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("registry requires %q authentication, which the host does not offer", scheme)
+	}
+	params := make(map[string]string)
+	for _, match := range challengeParam.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme != "https" {
+		return "", fmt.Errorf("registry challenge has no https realm: %q", challenge)
+	}
+	query := realm.Query()
+	for _, name := range []string{"service", "scope"} {
+		if params[name] != "" {
+			query.Set(name, params[name])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxOCIResponseSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read token: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request to %s returned %s", realm.Host, resp.Status)
+	}
+	var answer struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &answer); err != nil {
+		return "", fmt.Errorf("invalid token response: %v", err)
+	}
+	if answer.Token == "" {
+		answer.Token = answer.AccessToken
+	}
+	if answer.Token == "" {
+		return "", fmt.Errorf("token response from %s holds no token", realm.Host)
+	}
+	return answer.Token, nil
+}
+
+// sha256Digest returns the OCI digest of data
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+
+	"hello-wasm-enclave/internal/drain"
+	"hello-wasm-enclave/internal/transport"
+)
+
+// servePassthrough relays the raw bytes of clients on addr to the enclave's
+// TLS listener. The TLS session runs between client and enclave, so the
+// host sees neither requests nor results, and can only rate limit clients
+// by address since tokens travel inside the session. While draining, open
+// relays count as requests in flight.
+func servePassthrough(addr string, t *transport.Transport, cid, port uint32, limiter *rateLimiter, drainer *drain.Drainer) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	drainer.Track(listener)
+
+	log.Printf("Relaying end-to-end TLS clients on %s to enclave port %d", addr, port)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if drainer.Draining() {
+				return nil
+			}
+			log.Printf("Failed to accept passthrough connection: %v", err)
+			continue
+		}
+		if !drainer.Start() {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer drainer.Done()
+			relayToEnclave(conn, t, cid, port, limiter)
+		}()
+	}
+}
+
+func relayToEnclave(client net.Conn, t *transport.Transport, cid, port uint32, limiter *rateLimiter) {
+	defer client.Close()
+	addr := clientAddr(client.RemoteAddr().String())
+	if err := limiter.allow(addr); err != nil {
+		log.Printf("Refusing passthrough connection: %v", err)
+		return
+	}
+
+	rawConn, err := t.Dial(cid, port)
+	if err != nil {
+		log.Printf("Failed to connect passthrough client %s to enclave: %v", addr, err)
+		return
+	}
+	enclave := countingConn{rawConn}
+	defer enclave.Close()
+
+	passthroughConnections.Inc()
+	defer passthroughConnections.Dec()
+
+	// Each direction ends when its sender closes; the other is then shut
+	// down so the copy blocked on it returns
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(enclave, client)
+		enclave.Close()
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, enclave)
+		client.Close()
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
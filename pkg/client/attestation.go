@@ -0,0 +1,175 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Expectations are what VerifyAttestation checks an attestation document
+// against, beyond its signature
+type Expectations struct {
+	// Root is the certificate the document must chain to; nil for the
+	// AWS Nitro root
+	Root *x509.Certificate
+	// PCRs the document must carry, such as PCR0 of the enclave image file
+	// and PCR8 of its signing certificate
+	PCRs map[uint][]byte
+	// Nonce the request carried, if any
+	Nonce []byte
+	// Request the document must attest, as sent, for an execution; the
+	// client identity is taken from Result, where the enclave echoes it
+	Request *Request
+	// Result the document must attest, for an execution
+	Result *Response
+	// PublicKey the document must carry, for an enclave key
+	PublicKey []byte
+}
+
+// Check is one expectation VerifyAttestation checked, and why it failed
+type Check struct {
+	Name string
+	Err  error
+}
+
+// AttestationReport is what VerifyAttestation found of a document whose
+// signature verified
+type AttestationReport struct {
+	Document *AttestationDocument
+	Signer   *x509.Certificate
+	Checks   []Check
+}
+
+// AttestationError is a document that failed some checks
+type AttestationError struct {
+	Failed []Check
+}
+
+func (e *AttestationError) Error() string {
+	failed := make([]string, len(e.Failed))
+	for i, check := range e.Failed {
+		failed[i] = fmt.Sprintf("%s %v", check.Name, check.Err)
+	}
+	return "attestation failed: " + strings.Join(failed, "; ")
+}
+
+// Err returns an *AttestationError of the failed checks, or nil
+func (r *AttestationReport) Err() error {
+	var failed []Check
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			failed = append(failed, check)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &AttestationError{Failed: failed}
+}
+
+// VerifyAttestation checks that a base64 attestation document was made by
+// the Nitro hypervisor and checks it against expect. An error means the
+// document itself did not verify; the report says which expectations it
+// meets.
+func VerifyAttestation(document string, expect Expectations) (*AttestationReport, error) {
+	if document == "" {
+		return nil, fmt.Errorf("no attestation document")
+	}
+	doc, signer, err := protocol.VerifyAttestation(document, expect.Root)
+	if err != nil {
+		return nil, err
+	}
+	report := &AttestationReport{Document: doc, Signer: signer}
+	check := func(name string, err error) {
+		report.Checks = append(report.Checks, Check{Name: name, Err: err})
+	}
+
+	indexes := make([]int, 0, len(expect.PCRs))
+	for index := range expect.PCRs {
+		indexes = append(indexes, int(index))
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		want := expect.PCRs[uint(index)]
+		got, ok := doc.PCRs[uint(index)]
+		switch {
+		case !ok:
+			check(fmt.Sprintf("PCR%d", index), fmt.Errorf("is not in the document"))
+		case !bytes.Equal(got, want):
+			check(fmt.Sprintf("PCR%d", index), fmt.Errorf("is %x, expected %x", got, want))
+		default:
+			check(fmt.Sprintf("PCR%d", index), nil)
+		}
+	}
+	if expect.Nonce != nil {
+		var err error
+		if !bytes.Equal(doc.Nonce, expect.Nonce) {
+			err = fmt.Errorf("does not match: the document was made for another request")
+		}
+		check("nonce", err)
+	}
+	if expect.Request != nil {
+		// The enclave attested the request as it reached it: with the
+		// client identity the host verified, and its own session ID
+		req := *expect.Request
+		req.SessionID = protocol.EnclaveID(req.SessionID)
+		req.ClientID = ""
+		if expect.Result != nil {
+			req.ClientID = expect.Result.ClientID
+		}
+		digest, err := protocol.AttestationRequestDigest(req)
+		if err == nil && (len(doc.UserData) != 64 || !bytes.Equal(doc.UserData[:32], digest[:])) {
+			err = fmt.Errorf("does not match: the document attests another request")
+		}
+		check("request", err)
+	}
+	if expect.Result != nil {
+		// The enclave attested its own session ID, which a host with several
+		// replicas then prefixes with the replica's
+		result := *expect.Result
+		result.SessionID = protocol.EnclaveID(result.SessionID)
+		digest, err := protocol.AttestationResultDigest(result)
+		if err == nil && (len(doc.UserData) != 64 || !bytes.Equal(doc.UserData[32:], digest[:])) {
+			err = fmt.Errorf("does not match: the document attests another result")
+		}
+		check("result", err)
+	}
+	if expect.PublicKey != nil {
+		var err error
+		if !bytes.Equal(doc.PublicKey, expect.PublicKey) {
+			err = fmt.Errorf("does not match: the document attests another key")
+		}
+		check("public key", err)
+	}
+	return report, nil
+}
+
+// AttestedRequest returns request as the Request expectation of its
+// result's attestation, or nil if the module it runs is named rather than
+// sent, so that the client does not know the code the enclave hashed
+func AttestedRequest(request Request) *Request {
+	if request.ModuleName != "" || request.ModuleRef != "" || request.UploadID != "" {
+		return nil
+	}
+	return &request
+}
+
+// NewNonce returns a fresh nonce, which proves that an attestation document
+// was made for the request carrying it
+func NewNonce() ([]byte, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate attestation nonce: %v", err)
+	}
+	return nonce, nil
+}
+
+func encodeNonce(nonce []byte) string {
+	return base64.StdEncoding.EncodeToString(nonce)
+}
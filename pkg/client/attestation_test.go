@@ -0,0 +1,190 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// testAttester signs attestation documents as the NSM would, with a leaf
+// certificate under a root of its own
+type testAttester struct {
+	root      *x509.Certificate
+	leaf      *x509.Certificate
+	leafKey   *ecdsa.PrivateKey
+	bundleDER []byte
+}
+
+func newTestAttester(t *testing.T) *testAttester {
+	t.Helper()
+	rootKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := x509.ParseCertificate(rootDER)
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test enclave"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, root, &leafKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(leafDER)
+	return &testAttester{root: root, leaf: leaf, leafKey: leafKey, bundleDER: rootDER}
+}
+
+// attest returns a document binding response, as the enclave attests it
+func (a *testAttester) attest(t *testing.T, req Request, response Response, nonce []byte) string {
+	t.Helper()
+	requestHash, err := protocol.AttestationRequestDigest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resultHash, err := protocol.AttestationResultDigest(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := cbor.Marshal(protocol.AttestationDocument{
+		ModuleID:    "test",
+		Digest:      "SHA384",
+		Timestamp:   uint64(time.Now().UnixMilli()),
+		Certificate: a.leaf.Raw,
+		CABundle:    [][]byte{a.bundleDER},
+		UserData:    append(requestHash[:], resultHash[:]...),
+		Nonce:       nonce,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected, _ := cbor.Marshal(map[int]int{1: -35})
+	signed, err := cbor.Marshal([]interface{}{"Signature1", protected, []byte{}, payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha512.Sum384(signed)
+	r, s, err := ecdsa.Sign(rand.Reader, a.leafKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 96)
+	r.FillBytes(signature[:48])
+	s.FillBytes(signature[48:])
+	document, err := cbor.Marshal([]interface{}{protected, map[int]int{}, payload, signature})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(document)
+}
+
+// TestVerifyAttestationOfReplicaSession checks an attested create_session
+// answered by a host with several replicas, which prefixes the session ID
+// the enclave attested with the replica's
+func TestVerifyAttestationOfReplicaSession(t *testing.T) {
+	attester := newTestAttester(t)
+	nonce, err := NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := Request{Type: protocol.RequestTypeCreateSession, WASMCode: "(module)", Attest: true, Nonce: encodeNonce(nonce)}
+	response := Response{SessionID: "8f3a9c"}
+	response.Attestation = attester.attest(t, request, response, nonce)
+
+	for _, test := range []struct {
+		name      string
+		sessionID string
+		wantErr   bool
+	}{
+		{"single replica", "8f3a9c", false},
+		{"several replicas", "default/16" + protocol.ReplicaIDSeparator + "8f3a9c", false},
+		{"another session", "default/16" + protocol.ReplicaIDSeparator + "77aa01", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			received := response
+			received.SessionID = test.sessionID
+			report, err := VerifyAttestation(received.Attestation, Expectations{Root: attester.root, Nonce: nonce, Result: &received})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := report.Err(); (err != nil) != test.wantErr {
+				t.Fatalf("checks = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// TestVerifyAttestationOfRequest checks the request half of an execution's
+// user_data, which the enclave takes over the request with the client
+// identity the host verified
+func TestVerifyAttestationOfRequest(t *testing.T) {
+	attester := newTestAttester(t)
+	nonce, err := NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := Request{WASMCode: "(module)", FunctionName: "run", Args: []int32{1}, Attest: true, Nonce: encodeNonce(nonce)}
+	response := Response{Result: 7, ClientID: "spiffe://tenant/client"}
+	executed := sent
+	executed.ClientID = response.ClientID
+	response.Attestation = attester.attest(t, executed, response, nonce)
+
+	swapped := executed
+	swapped.WASMCode = "(module (func))"
+	swappedResponse := response
+	swappedResponse.Attestation = attester.attest(t, swapped, response, nonce)
+
+	anonymous := response
+	anonymous.ClientID = ""
+
+	for _, test := range []struct {
+		name     string
+		response Response
+		wantErr  bool
+	}{
+		{"request as sent", response, false},
+		{"module swapped by the host", swappedResponse, true},
+		{"client identity withheld", anonymous, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := VerifyAttestation(test.response.Attestation, Expectations{Root: attester.root, Nonce: nonce, Request: &sent, Result: &test.response})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := report.Err(); (err != nil) != test.wantErr {
+				t.Fatalf("checks = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
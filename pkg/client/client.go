@@ -0,0 +1,363 @@
+// Package client runs WebAssembly in the enclave from other Go programs, as
+// wasm-client does from the command line. A Client holds one connection to
+// the host's JSON listener, optionally framed and over TLS, and sends one
+// request at a time on it; modules are registered over the host's REST API,
+// or uploaded over the connection in chunks when too large for one request.
+//
+//	c, err := client.Dial(ctx, client.Options{Address: "host.example.com:8081", TLS: &tls.Config{}})
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	response, err := c.Execute(ctx, client.Request{
+//		WASMCode:     module,
+//		FunctionName: "add",
+//		TypedArgs:    []client.Value{client.I32(2), client.I32(3)},
+//	})
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/wire"
+)
+
+// Types of the protocol, whose package is internal to this module
+type (
+	Request             = protocol.WASMRequest
+	Response            = protocol.WASMResponse
+	Value               = protocol.Value
+	Call                = protocol.Call
+	ResultSpec          = protocol.ResultSpec
+	Capabilities        = protocol.Capabilities
+	JobStatus           = protocol.JobStatus
+	ModuleDescription   = protocol.ModuleDescription
+	AttestationDocument = protocol.AttestationDocument
+)
+
+// Options configures a Client
+type Options struct {
+	// Address is host:port of the host's JSON listener; empty for
+	// localhost on the default port
+	Address string
+	// TLS connects over TLS when set. An empty config verifies the host's
+	// certificate against the system's CAs.
+	TLS *tls.Config
+	// Token authenticates every request: an API token, or an IAM token
+	Token string
+	// Enclave sends every request to one enclave of a multi-enclave host.
+	// Without it, requests go to the enclave that answered the first one,
+	// so that keys, receipts and sessions all come from one.
+	Enclave string
+	// Framed uses length-prefixed framing instead of the JSON stream,
+	// offering the comma-separated Compression codecs (empty for the
+	// default) and Encoding (empty for JSON)
+	Framed      bool
+	Compression string
+	Encoding    string
+	// HTTPURL is the base URL of the host's REST API, such as
+	// https://host.example.com:8080, for RegisterModule and
+	// ExecuteRegistered. HTTPClient defaults to one using TLS.
+	HTTPURL    string
+	HTTPClient *http.Client
+	// Attestation, when set, makes Execute and sessions request an
+	// attestation document for every result and fail results whose
+	// document does not meet it
+	Attestation *Expectations
+}
+
+// Error is a request the host or enclave refused or could not run
+type Error struct {
+	Code       string // One of the protocol's error codes, or empty
+	Message    string
+	RetryAfter time.Duration // How long to wait before retrying, when overloaded or rate limited
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+	}
+	return e.Message
+}
+
+// responseError returns the error a response reports, or nil
+func responseError(response Response) error {
+	if response.Error == "" {
+		return nil
+	}
+	return &Error{Code: response.ErrorCode, Message: response.Error, RetryAfter: time.Duration(response.RetryAfterMS) * time.Millisecond}
+}
+
+// Client is a connection to a host. Its methods may be called from several
+// goroutines; requests wait for each other.
+type Client struct {
+	options Options
+	conn    net.Conn
+	encoder wire.Encoder
+	decoder wire.Decoder
+
+	mu      sync.Mutex
+	enclave string
+	sent    uint64
+	// version is the protocol version requests are written in, negotiated
+	// by Hello
+	version int
+	// broken is set once the connection can no longer be trusted to be in
+	// step, such as after a request was cancelled mid-response
+	broken error
+}
+
+// Dial connects to the host at options.Address
+func Dial(ctx context.Context, options Options) (*Client, error) {
+	address := options.Address
+	if address == "" {
+		address = net.JoinHostPort("localhost", strconv.Itoa(protocol.HostPort))
+	}
+	var conn net.Conn
+	var err error
+	if options.TLS != nil {
+		config := options.TLS.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		dialer := &tls.Dialer{Config: config}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to host: %v", err)
+	}
+	c, err := New(conn, options)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// New makes a Client of an open connection to the host, such as one whose
+// TLS session ends in the enclave. The Client closes it.
+func New(conn net.Conn, options Options) (*Client, error) {
+	c := &Client{options: options, conn: conn, enclave: options.Enclave}
+	if !options.Framed {
+		c.encoder = json.NewEncoder(conn)
+		c.decoder = json.NewDecoder(conn)
+		return c, nil
+	}
+
+	compression := options.Compression
+	if compression == "" {
+		compression = wire.DefaultCompression
+	}
+	encoding := options.Encoding
+	if encoding == "" {
+		encoding = wire.EncodingJSON
+	}
+	var dialOptions wire.DialOptions
+	var err error
+	if dialOptions.Compression, err = wire.ParseCompression(compression); err != nil {
+		return nil, err
+	}
+	if dialOptions.Encodings, err = wire.ParseEncoding(encoding); err != nil {
+		return nil, err
+	}
+	if c.encoder, c.decoder, err = wire.Dial(conn, dialOptions); err != nil {
+		return nil, fmt.Errorf("failed to negotiate framing with host: %v", err)
+	}
+	return c, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Conn returns the connection to the host
+func (c *Client) Conn() net.Conn {
+	return c.conn
+}
+
+// Framing returns the encoding and compression the host picked for a framed
+// connection, or false for the JSON stream
+func (c *Client) Framing() (encoding, compression string, framed bool) {
+	writer, ok := c.encoder.(*wire.FrameWriter)
+	if !ok {
+		return "", "", false
+	}
+	return writer.Encoding(), writer.Compression(), true
+}
+
+// Enclave returns the enclave requests go to, once known
+func (c *Client) Enclave() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enclave
+}
+
+// Do sends a request as it is and waits for its response, which may report
+// an error of its own. It fills in the token, the enclave and, when empty,
+// the request ID and the protocol version negotiated by Hello. Cancelling
+// ctx abandons the connection. Progress responses ahead of the response
+// are skipped.
+func (c *Client) Do(ctx context.Context, request Request) (Response, error) {
+	return c.do(ctx, request, nil)
+}
+
+// do is Do, handing progress responses to progress
+func (c *Client) do(ctx context.Context, request Request, progress func(Response)) (Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.broken != nil {
+		return Response{}, c.broken
+	}
+
+	c.sent++
+	if request.RequestID == "" {
+		request.RequestID = fmt.Sprintf("client-%d-%d", os.Getpid(), c.sent)
+	}
+	request.AuthToken = c.options.Token
+	if request.ProtocolVersion == 0 {
+		request.ProtocolVersion = c.version
+	}
+	if request.Enclave == "" {
+		request.Enclave = c.enclave
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	fail := func(what string, err error) (Response, error) {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		c.broken = fmt.Errorf("%s: %w", what, err)
+		return Response{}, c.broken
+	}
+	if err := c.encoder.Encode(request); err != nil {
+		return fail("failed to send request", err)
+	}
+	var response Response
+	for {
+		response = Response{}
+		if err := c.decoder.Decode(&response); err != nil {
+			return fail("failed to decode response", err)
+		}
+		if response.RequestID != request.RequestID {
+			c.broken = fmt.Errorf("response %s does not match request %s", response.RequestID, request.RequestID)
+			return Response{}, c.broken
+		}
+		if !response.Progress {
+			break
+		}
+		if progress != nil {
+			progress(response)
+		}
+	}
+	// Hello requests go to the host's first enclave, which need not run
+	// the module
+	if c.enclave == "" && request.Type != protocol.RequestTypeHello {
+		c.enclave = response.Enclave
+	}
+	return response, nil
+}
+
+// Hello exchanges protocol versions with the host and returns what it and
+// the enclave behind it support. It returns nil for a peer that predates
+// the handshake.
+func (c *Client) Hello(ctx context.Context) (*Capabilities, error) {
+	response, err := c.Do(ctx, Request{Type: protocol.RequestTypeHello, ProtocolVersion: protocol.ProtocolVersion})
+	if err != nil {
+		return nil, err
+	}
+	if response.ErrorCode != "" {
+		return nil, responseError(response)
+	}
+	c.mu.Lock()
+	c.version = response.Hello.NegotiatedVersion()
+	c.mu.Unlock()
+	return response.Hello, nil
+}
+
+// Execute runs the calls of a request on a fresh instance of its module.
+// The error is an *Error when the execution failed, or an
+// *AttestationError when Options.Attestation was not met.
+func (c *Client) Execute(ctx context.Context, request Request) (Response, error) {
+	request.Type = ""
+	return c.attested(ctx, request, nil)
+}
+
+// Describe lists the imports and exports of the request's module without
+// running it
+func (c *Client) Describe(ctx context.Context, request Request) (*ModuleDescription, error) {
+	response, err := c.Do(ctx, Request{
+		Type:            protocol.RequestTypeDescribeModule,
+		RequestID:       request.RequestID,
+		WASMCode:        request.WASMCode,
+		ModuleName:      request.ModuleName,
+		ModuleRef:       request.ModuleRef,
+		ModuleSignature: request.ModuleSignature,
+		Features:        request.Features,
+		MaxMemoryPages:  request.MaxMemoryPages,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(response); err != nil {
+		return nil, err
+	}
+	return response.Module, nil
+}
+
+// attested validates and sends a request whose result Options.Attestation
+// applies to, handing its progress responses to progress
+func (c *Client) attested(ctx context.Context, request Request, progress func(Response)) (Response, error) {
+	var nonce []byte
+	if c.options.Attestation != nil {
+		var err error
+		if nonce, err = NewNonce(); err != nil {
+			return Response{}, err
+		}
+		request.Attest = true
+		request.Nonce = encodeNonce(nonce)
+	}
+	if err := request.Validate(); err != nil {
+		return Response{}, err
+	}
+	response, err := c.do(ctx, request, progress)
+	if err != nil {
+		return Response{}, err
+	}
+	// Errors the host answers itself, such as rate limits, carry no document
+	if c.options.Attestation != nil && (response.Attestation != "" || response.Error == "") {
+		expect := *c.options.Attestation
+		expect.Nonce = nonce
+		expect.Request = AttestedRequest(request)
+		expect.Result = &response
+		report, err := VerifyAttestation(response.Attestation, expect)
+		if err == nil {
+			err = report.Err()
+		}
+		if err != nil {
+			return response, err
+		}
+	}
+	return response, responseError(response)
+}
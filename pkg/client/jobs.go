@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// SubmitJob submits the execution of a request as a job the enclave runs
+// in the background, and returns its ID
+func (c *Client) SubmitJob(ctx context.Context, request Request) (string, error) {
+	request.Type = protocol.RequestTypeSubmitJob
+	if err := request.Validate(); err != nil {
+		return "", err
+	}
+	response, err := c.Do(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	if err := responseError(response); err != nil {
+		return "", err
+	}
+	return response.JobID, nil
+}
+
+// Job reports how far a job has got
+func (c *Client) Job(ctx context.Context, jobID string) (*JobStatus, error) {
+	response, err := c.Do(ctx, Request{Type: protocol.RequestTypeJobStatus, JobID: jobID})
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(response); err != nil {
+		return nil, err
+	}
+	return response.Job, nil
+}
+
+// JobResult returns the response of a finished job, as Execute would have
+// returned it
+func (c *Client) JobResult(ctx context.Context, jobID string) (Response, error) {
+	response, err := c.Do(ctx, Request{Type: protocol.RequestTypeJobResult, JobID: jobID})
+	if err != nil {
+		return Response{}, err
+	}
+	return response, responseError(response)
+}
+
+// AwaitJob asks every interval whether a job has finished, then returns its
+// result
+func (c *Client) AwaitJob(ctx context.Context, jobID string, interval time.Duration) (Response, error) {
+	for {
+		status, err := c.Job(ctx, jobID)
+		if err != nil {
+			return Response{}, err
+		}
+		if status.State != protocol.JobRunning {
+			return c.JobResult(ctx, jobID)
+		}
+		select {
+		case <-ctx.Done():
+			return Response{}, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// CancelJob stops a job that is still running
+func (c *Client) CancelJob(ctx context.Context, jobID string) (*JobStatus, error) {
+	response, err := c.Do(ctx, Request{Type: protocol.RequestTypeCancelJob, JobID: jobID})
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(response); err != nil {
+		return nil, err
+	}
+	return response.Job, nil
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RegisterModule registers a module with the host over its REST API, so
+// that ExecuteRegistered can run it without sending its code each time. It
+// returns the module's ID, which only clients of the same tenant can use.
+func (c *Client) RegisterModule(ctx context.Context, wasmCode string) (string, error) {
+	var registered struct {
+		ModuleID string `json:"module_id"`
+	}
+	if err := c.send(ctx, http.MethodPost, "/v1/modules", map[string]string{"wasm_code": wasmCode}, &registered); err != nil {
+		return "", err
+	}
+	return registered.ModuleID, nil
+}
+
+// ExecuteRegistered runs the calls of a request on a registered module,
+// named by its ID or an alias, leaving the request's WASMCode empty. It goes over the REST API, not the
+// Client's connection.
+func (c *Client) ExecuteRegistered(ctx context.Context, moduleID string, request Request) (Response, error) {
+	if request.WASMCode != "" {
+		return Response{}, fmt.Errorf("set only one of wasm_code and the module ID")
+	}
+	body := struct {
+		Request
+		ModuleID string `json:"module_id"`
+	}{request, moduleID}
+	var response Response
+	if err := c.send(ctx, http.MethodPost, "/v1/execute", body, &response); err != nil {
+		return Response{}, err
+	}
+	return response, responseError(response)
+}
+
+// SetAlias points an alias of the client's tenant at a registered module,
+// which takes an admin client, and returns the module it pointed at
+// before. With previous set, the alias only moves if it still points there.
+func (c *Client) SetAlias(ctx context.Context, alias, moduleID, previous string) (string, error) {
+	var moved struct {
+		PreviousModuleID string `json:"previous_module_id"`
+	}
+	body := map[string]string{"module_id": moduleID}
+	if previous != "" {
+		body["previous_module_id"] = previous
+	}
+	if err := c.send(ctx, http.MethodPut, "/v1/aliases/"+url.PathEscape(alias), body, &moved); err != nil {
+		return "", err
+	}
+	return moved.PreviousModuleID, nil
+}
+
+// send sends a JSON body to the REST API and decodes what it answers. Error
+// statuses whose body is a response are returned as that response.
+func (c *Client) send(ctx context.Context, method, path string, body, answer interface{}) error {
+	if c.options.HTTPURL == "" {
+		return fmt.Errorf("the host's REST API needs Options.HTTPURL")
+	}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.options.HTTPURL, "/")+path, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if c.options.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.options.Token)
+	}
+	httpClient := c.options.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: c.options.TLS}}
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to reach the host's REST API: %v", err)
+	}
+	defer response.Body.Close()
+
+	raw := new(bytes.Buffer)
+	if _, err := raw.ReadFrom(response.Body); err != nil {
+		return fmt.Errorf("failed to read the answer of %s: %v", path, err)
+	}
+	if response.StatusCode >= 300 {
+		// Failures come as an error message, or for executions a response
+		var failure Response
+		if json.Unmarshal(raw.Bytes(), &failure) != nil || failure.Error == "" {
+			return &Error{Message: fmt.Sprintf("%s answered %s", path, response.Status)}
+		}
+		if execution, ok := answer.(*Response); ok {
+			*execution = failure
+			return nil
+		}
+		return &Error{Code: failure.ErrorCode, Message: failure.Error}
+	}
+	if err := json.Unmarshal(raw.Bytes(), answer); err != nil {
+		return fmt.Errorf("malformed answer of %s: %v", path, err)
+	}
+	return nil
+}
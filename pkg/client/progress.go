@@ -0,0 +1,33 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+)
+
+// ExecuteProgress runs the calls of a request as Execute does, calling
+// progress with each progress response that arrives ahead of the result:
+// the pieces the module passes to env.emit when StreamEmits is set, and its
+// elapsed time and fuel every ProgressIntervalMS when that is. Progress is
+// not covered by the receipt or attestation of the response.
+func (c *Client) ExecuteProgress(ctx context.Context, request Request, progress func(Response)) (Response, error) {
+	request.Type = ""
+	return c.attested(ctx, request, progress)
+}
+
+// ExecuteStreaming runs the calls of a request as Execute does, calling
+// emit with each piece the module passes to env.emit while it runs, so the
+// progress of a long computation shows before its result. Pieces beyond
+// the enclave's limit are dropped, which the response reports as
+// EmitsTruncated.
+func (c *Client) ExecuteStreaming(ctx context.Context, request Request, emit func([]byte)) (Response, error) {
+	request.StreamEmits = true
+	return c.ExecuteProgress(ctx, request, func(response Response) {
+		if response.Emit == "" {
+			return
+		}
+		if piece, err := base64.StdEncoding.DecodeString(response.Emit); err == nil {
+			emit(piece)
+		}
+	})
+}
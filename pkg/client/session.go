@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Session is an instance of a module the enclave keeps between calls
+type Session struct {
+	client *Client
+	ID     string
+}
+
+// NewSession instantiates the request's module in a session; the request
+// carries the module, its secrets and its limits, and calls nothing
+func (c *Client) NewSession(ctx context.Context, request Request) (*Session, error) {
+	request.Type = protocol.RequestTypeCreateSession
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	response, err := c.Do(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(response); err != nil {
+		return nil, err
+	}
+	return &Session{client: c, ID: response.SessionID}, nil
+}
+
+// Call calls a function of the session's instance. Setting the call's
+// Table calls an element of an exported table instead.
+func (s *Session) Call(ctx context.Context, call Call) (Response, error) {
+	return s.client.attested(ctx, Request{
+		Type:         protocol.RequestTypeCallSession,
+		SessionID:    s.ID,
+		FunctionName: call.FunctionName,
+		Args:         call.Args,
+		TypedArgs:    call.TypedArgs,
+		ResultType:   call.ResultType,
+		ResultSpec:   call.ResultSpec,
+		Calls:        batch(call),
+	}, nil)
+}
+
+// batch returns a table call as the one-call batch it must be sent as
+func batch(call Call) []Call {
+	if call.Table == "" {
+		return nil
+	}
+	return []Call{call}
+}
+
+// ReadMemory copies length bytes at offset out of an exported memory of
+// the session, the one named "memory" when name is empty
+func (s *Session) ReadMemory(ctx context.Context, name string, offset uint64, length uint32) ([]byte, error) {
+	response, err := s.access(ctx, Request{Type: protocol.RequestTypeReadMemory, Memory: name, Offset: offset, Length: length})
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(response.Data)
+}
+
+// WriteMemory copies data into an exported memory of the session at offset
+func (s *Session) WriteMemory(ctx context.Context, name string, offset uint64, data []byte) error {
+	_, err := s.access(ctx, Request{Type: protocol.RequestTypeWriteMemory, Memory: name, Offset: offset, Data: base64.StdEncoding.EncodeToString(data)})
+	return err
+}
+
+// Global returns the value of an exported global of the session
+func (s *Session) Global(ctx context.Context, name string) (Value, error) {
+	response, err := s.access(ctx, Request{Type: protocol.RequestTypeGetGlobal, Global: name})
+	if err != nil {
+		return Value{}, err
+	}
+	return *response.ResultValue, nil
+}
+
+// SetGlobal sets an exported mutable global of the session
+func (s *Session) SetGlobal(ctx context.Context, name string, value Value) error {
+	_, err := s.access(ctx, Request{Type: protocol.RequestTypeSetGlobal, Global: name, GlobalValue: &value})
+	return err
+}
+
+// Close destroys the session
+func (s *Session) Close(ctx context.Context) error {
+	_, err := s.access(ctx, Request{Type: protocol.RequestTypeDestroySession})
+	return err
+}
+
+func (s *Session) access(ctx context.Context, request Request) (Response, error) {
+	request.SessionID = s.ID
+	if err := request.Validate(); err != nil {
+		return Response{}, err
+	}
+	response, err := s.client.Do(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+	return response, responseError(response)
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// UploadModule sends the size bytes of wasm_code in code to the enclave in
+// chunks, so that a module too large for one request is never held whole,
+// and returns the upload ID requests run it by in place of WASMCode. code
+// is read twice: once for its digest, which the enclave checks, and once to
+// send it.
+func (c *Client) UploadModule(ctx context.Context, code io.ReaderAt, size int64) (string, error) {
+	digest := sha256.New()
+	if _, err := io.Copy(digest, io.NewSectionReader(code, 0, size)); err != nil {
+		return "", fmt.Errorf("failed to read module: %v", err)
+	}
+	response, err := c.upload(ctx, Request{Type: protocol.RequestTypeUploadBegin, ModuleHash: hex.EncodeToString(digest.Sum(nil)), UploadSize: uint64(size)})
+	if err != nil {
+		return "", err
+	}
+	id := response.UploadID
+
+	chunk := make([]byte, protocol.MaxUploadChunk)
+	for offset := int64(0); offset < size; {
+		n, err := code.ReadAt(chunk[:min(size-offset, int64(len(chunk)))], offset)
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("failed to read module: %v", err)
+		}
+		piece := chunk[:n]
+		if offset+int64(n) < size {
+			piece = wholeRunes(piece)
+		}
+		if len(piece) == 0 {
+			return "", fmt.Errorf("failed to read module: it changed while it was sent")
+		}
+		if _, err := c.upload(ctx, Request{Type: protocol.RequestTypeUploadChunk, UploadID: id, Offset: uint64(offset), Data: string(piece)}); err != nil {
+			return "", err
+		}
+		offset += int64(len(piece))
+	}
+
+	if _, err := c.upload(ctx, Request{Type: protocol.RequestTypeUploadCommit, UploadID: id}); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (c *Client) upload(ctx context.Context, request Request) (Response, error) {
+	response, err := c.Do(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+	return response, responseError(response)
+}
+
+// wholeRunes cuts a UTF-8 character split at the end of a chunk of WAT text
+// off it, for the next chunk to carry, since chunks travel as strings.
+// Base64 is all ASCII and goes through as it is.
+func wholeRunes(piece []byte) []byte {
+	for i := len(piece) - 1; i >= 0 && i >= len(piece)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(piece[i]) {
+			if !utf8.FullRune(piece[i:]) {
+				return piece[:i]
+			}
+			break
+		}
+	}
+	return piece
+}
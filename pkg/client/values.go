@@ -0,0 +1,34 @@
+package client
+
+import "hello-wasm-enclave/internal/protocol"
+
+// I32, I64, F32 and F64 are numeric arguments of typed calls
+func I32(x int32) Value   { return encode(x) }
+func I64(x int64) Value   { return encode(x) }
+func F32(x float32) Value { return encode(x) }
+func F64(x float64) Value { return encode(x) }
+
+// String is passed to the module as a (pointer, length) pair in its memory
+func String(s string) Value {
+	return Value{Type: protocol.ValueString, Value: s}
+}
+
+// Bytes is passed to the module as a (pointer, length) pair in its memory
+func Bytes(b []byte) Value { return encode(b) }
+
+// Externref is an opaque handle the module can hold and pass back
+func Externref(handle string) Value {
+	return Value{Type: protocol.ValueExternref, Value: handle}
+}
+
+// Funcref names an exported function, or a funcref handle "#N" an earlier
+// call of the same instance returned
+func Funcref(handle string) Value {
+	return Value{Type: protocol.ValueFuncref, Value: handle}
+}
+
+// encode cannot fail for the types above
+func encode(x interface{}) Value {
+	value, _ := protocol.EncodeValue(x)
+	return value
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/transport"
+	"hello-wasm-enclave/internal/wire"
+)
+
+// Time allowed for the framing handshake on a new connection
+const handshakeTimeout = 5 * time.Second
+
+// How much later than before an enclave must say it started for the host to
+// count a restart
+const restartSlack = 5 * time.Second
+
+// enclaveLink is what an enclaveConn exchanges messages over: a connection
+// of its own, or a stream of the pool's multiplexed connection
+type enclaveLink interface {
+	SetDeadline(t time.Time) error
+	Close() error
+}
+
+// enclaveConn is a single connection to the enclave together with its
+// codec state. It is used by exactly one request at a time.
+type enclaveConn struct {
+	conn       enclaveLink
+	encoder    wire.Encoder
+	decoder    wire.Decoder
+	broken     bool
+	generation uint64
+	// Its request was given up on before the response was read
+	abandoned bool
+	// Liveness checks missed in a row
+	missed int
+	// A stream of the multiplexed connection, used for one request only
+	stream bool
+}
+
+// requestStream sends a request as the last message of its stream, which
+// tells the enclave not to wait for more
+type requestStream struct {
+	*wire.Stream
+}
+
+func (s requestStream) Encode(v interface{}) error {
+	return s.EncodeLast(v)
+}
+
+// roundTrip sends one request and waits for its response. Any transport
+// error marks the connection broken so the pool replaces it on checkin.
+// When ctx ends first the connection is abandoned instead: closing it is
+// what tells the enclave to interrupt the execution.
+func (c *enclaveConn) roundTrip(ctx context.Context, req protocol.WASMRequest) (protocol.WASMResponse, error) {
+	// The deadline unblocks whichever of encoding and decoding is waiting
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(time.Now())
+	})
+	response, err := c.exchange(req, progressFrom(ctx))
+	if !stop() {
+		c.abandoned = true
+		if err != nil {
+			return protocol.WASMResponse{}, ctx.Err()
+		}
+	}
+	return response, err
+}
+
+// exchange sends req and reads responses until its answer, handing the
+// progress responses before it to progress
+func (c *enclaveConn) exchange(req protocol.WASMRequest, progress func(protocol.WASMResponse)) (protocol.WASMResponse, error) {
+	if err := c.encoder.Encode(req); err != nil {
+		c.broken = true
+		return protocol.WASMResponse{}, fmt.Errorf("failed to send request to enclave: %v", err)
+	}
+
+	for {
+		var response protocol.WASMResponse
+		if err := c.decoder.Decode(&response); err != nil {
+			c.broken = true
+			return protocol.WASMResponse{}, fmt.Errorf("failed to decode WASM response from enclave: %v", err)
+		}
+
+		if response.RequestID != req.RequestID {
+			c.broken = true
+			return protocol.WASMResponse{}, fmt.Errorf("enclave response %s does not match request %s", response.RequestID, req.RequestID)
+		}
+
+		if !response.Progress {
+			return response, nil
+		}
+		if progress != nil {
+			progress(response)
+		}
+	}
+}
+
+type progressKey struct{}
+
+// withProgress returns ctx whose requests hand their progress responses to
+// progress. Only a client connection can be sent them; requests forwarded
+// without one do not ask the enclave for any.
+func withProgress(ctx context.Context, progress func(protocol.WASMResponse)) context.Context {
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
+// progressFrom returns the progress handler in ctx, or nil
+func progressFrom(ctx context.Context) func(protocol.WASMResponse) {
+	progress, _ := ctx.Value(progressKey{}).(func(protocol.WASMResponse))
+	return progress
+}
+
+// probe sends a control request (ping or health) that must be answered
+// within timeout
+func (c *enclaveConn) probe(req protocol.WASMRequest, timeout time.Duration) (protocol.WASMResponse, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	response, err := c.roundTrip(context.Background(), req)
+	if err != nil {
+		return response, err
+	}
+	if response.Error != "" {
+		return response, fmt.Errorf("enclave rejected %s request: %s", req.Type, response.Error)
+	}
+	return response, nil
+}
+
+// ping checks that the enclave is still answering on this connection, with
+// a heartbeat frame when framed and a ping request on the JSON stream
+func (c *enclaveConn) ping(seq uint64, timeout time.Duration) error {
+	if reader, ok := c.decoder.(*wire.FrameReader); ok {
+		c.conn.SetDeadline(time.Now().Add(timeout))
+		defer c.conn.SetDeadline(time.Time{})
+		return reader.Ping(seq)
+	}
+	_, err := c.probe(protocol.WASMRequest{Type: protocol.RequestTypePing, RequestID: fmt.Sprintf("ping-%d", seq)}, timeout)
+	return err
+}
+
+// canMissPing reports whether the connection is still usable after ping
+// failed with err. Only a heartbeat that timed out leaves it so: a late
+// pong is skipped, while a late ping response would be read as the answer
+// to the next request.
+func (c *enclaveConn) canMissPing(err error) bool {
+	reader, ok := c.decoder.(*wire.FrameReader)
+	return ok && reader.InSync() && errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// EnclavePool hands out exclusive connections to the enclave. The pool
+// holds a fixed number of slots; an empty slot is dialed lazily on checkout,
+// and connections that fail are closed on checkin so the slot is redialed.
+//
+// A failed connection usually means the enclave restarted, in which case
+// every other pooled connection is stale too. Each failure therefore bumps
+// the pool generation, and connections from an older generation are dropped
+// instead of being handed out.
+//
+// When dialOptions asks for streams, the enclave is instead reached over a
+// single multiplexed connection, each request on a stream of its own; the
+// slots then only bound how many requests it carries at once.
+type EnclavePool struct {
+	name      string
+	transport *transport.Transport
+	cid       uint32
+	port      uint32
+	size      int
+	framed    bool
+	// What framed connections offer the enclave
+	dialOptions wire.DialOptions
+	slots       chan *enclaveConn
+
+	mu         sync.Mutex
+	open       int
+	generation uint64
+	pingSeq    uint64
+	// Connections discarded and not yet redialed
+	lost int
+	// When the enclave last said it started
+	started time.Time
+	// Whether the enclave reported its capabilities since the pool was
+	// last reset
+	reported atomic.Bool
+
+	// The multiplexed connection and its codecs' Mux, redialed once it
+	// fails; dialMu lets one request at a time dial it
+	dialMu  sync.Mutex
+	mux     *wire.Mux
+	muxConn net.Conn
+	// Heartbeats the multiplexed connection missed in a row, guarded by mu
+	muxMissed int
+}
+
+// NewEnclavePool creates a pool of size connections to the enclave called
+// name, dialed over t. With framed set, each connection negotiates
+// length-prefixed framing instead of the JSON stream, offering dialOptions.
+func NewEnclavePool(name string, t *transport.Transport, cid, port uint32, size int, framed bool, dialOptions wire.DialOptions) *EnclavePool {
+	if size < 1 {
+		size = 1
+	}
+
+	p := &EnclavePool{
+		name:        name,
+		transport:   t,
+		cid:         cid,
+		port:        port,
+		size:        size,
+		framed:      framed,
+		dialOptions: dialOptions,
+		slots:       make(chan *enclaveConn, size),
+	}
+	for i := 0; i < size; i++ {
+		p.slots <- nil
+	}
+	return p
+}
+
+// Checkout waits for a free slot and returns a live connection, dialing the
+// enclave if the slot was empty.
+func (p *EnclavePool) Checkout() (*enclaveConn, error) {
+	return p.connect(<-p.slots)
+}
+
+// connect turns a slot taken from the pool into a live connection. The
+// first since a reset has the enclave report itself.
+func (p *EnclavePool) connect(c *enclaveConn) (*enclaveConn, error) {
+	c, err := p.live(c)
+	if err == nil && !p.reported.Swap(true) {
+		p.report(c)
+	}
+	return c, err
+}
+
+// report has the enclave report itself over c, or over a stream of its own
+// when c is a stream, which carries one request only
+func (p *EnclavePool) report(c *enclaveConn) {
+	if c.stream {
+		mux, err := p.multiplexed()
+		var stream *wire.Stream
+		if err == nil {
+			stream, err = mux.Open()
+		}
+		if err != nil {
+			log.Printf("Warning: enclave %s did not report its capabilities: %v", p.name, err)
+			return
+		}
+		defer stream.Close()
+		c = &enclaveConn{conn: stream, encoder: requestStream{stream}, decoder: stream, stream: true}
+	}
+	reportEnclave(p.name, c, handshakeTimeout)
+}
+
+func (p *EnclavePool) live(c *enclaveConn) (*enclaveConn, error) {
+	if p.dialOptions.Streams {
+		return p.openStream()
+	}
+	if c != nil {
+		if !p.isStale(c) {
+			return c, nil
+		}
+		log.Println("Discarding enclave connection from before the last reset")
+		p.discard(c)
+	}
+
+	conn, encoder, decoder, err := p.dial()
+	if err != nil {
+		// Give the empty slot back so a later checkout can retry
+		p.slots <- nil
+		return nil, err
+	}
+	open, generation := p.opened()
+	log.Printf("Successfully connected to enclave %s (%d/%d pooled connections)", p.name, open, p.size)
+
+	return &enclaveConn{
+		conn:       conn,
+		encoder:    encoder,
+		decoder:    decoder,
+		generation: generation,
+	}, nil
+}
+
+// dial opens a connection to the enclave and sets up its codecs
+func (p *EnclavePool) dial() (net.Conn, wire.Encoder, wire.Decoder, error) {
+	log.Printf("Connecting to enclave %s at CID %d, port %d over %s", p.name, p.cid, p.port, p.transport.Kind())
+
+	rawConn, err := p.transport.Dial(p.cid, p.port)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to connect to enclave: %v", err)
+	}
+	var conn net.Conn = countingConn{rawConn}
+
+	var encoder wire.Encoder = json.NewEncoder(conn)
+	var decoder wire.Decoder = json.NewDecoder(conn)
+	if p.framed {
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+		encoder, decoder, err = wire.Dial(conn, p.dialOptions)
+		conn.SetDeadline(time.Time{})
+		if err != nil {
+			conn.Close()
+			return nil, nil, nil, fmt.Errorf("failed to negotiate framing with enclave: %v", err)
+		}
+	}
+	return conn, encoder, decoder, nil
+}
+
+// opened counts a connection dialed, and returns how many are open and the
+// pool generation it belongs to
+func (p *EnclavePool) opened() (int, uint64) {
+	p.mu.Lock()
+	p.open++
+	open := p.open
+	generation := p.generation
+	if p.lost > 0 {
+		p.lost--
+		enclaveReconnects.WithLabelValues(p.name).Inc()
+	}
+	p.mu.Unlock()
+	enclaveConnections.WithLabelValues(p.name).Set(float64(open))
+	return open, generation
+}
+
+// openStream opens a stream for one request on the multiplexed connection
+func (p *EnclavePool) openStream() (*enclaveConn, error) {
+	mux, err := p.multiplexed()
+	if err == nil {
+		var stream *wire.Stream
+		if stream, err = mux.Open(); err == nil {
+			return &enclaveConn{conn: stream, encoder: requestStream{stream}, decoder: stream, stream: true}, nil
+		}
+	}
+	p.slots <- nil
+	return nil, err
+}
+
+// multiplexed returns the multiplexed connection, dialing it first when
+// there is none, it failed or it ran out of stream IDs
+func (p *EnclavePool) multiplexed() (*wire.Mux, error) {
+	p.dialMu.Lock()
+	defer p.dialMu.Unlock()
+	if p.mux != nil {
+		failed := p.mux.Err()
+		if failed == nil && !p.mux.Exhausted() {
+			return p.mux, nil
+		}
+		if failed != nil {
+			log.Printf("Discarding failed multiplexed connection to enclave %s: %v", p.name, failed)
+			p.muxConn.Close()
+		} else {
+			log.Printf("Multiplexed connection to enclave %s ran out of stream IDs; dialing another", p.name)
+			retireMultiplexed(p.mux, p.muxConn)
+		}
+		p.mux, p.muxConn = nil, nil
+		p.reported.Store(false)
+		p.mu.Lock()
+		p.open--
+		if failed != nil {
+			p.lost++
+		}
+		p.mu.Unlock()
+		enclaveConnections.WithLabelValues(p.name).Set(0)
+	}
+
+	conn, encoder, decoder, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	mux := wire.NewMux(encoder, decoder)
+	if mux == nil {
+		conn.Close()
+		return nil, fmt.Errorf("enclave %s does not carry requests as streams; run the host without -multiplex", p.name)
+	}
+	p.opened()
+	p.mux, p.muxConn = mux, conn
+	p.mu.Lock()
+	p.muxMissed = 0
+	p.mu.Unlock()
+	log.Printf("Successfully connected to enclave %s, carrying up to %d requests at once as streams", p.name, p.size)
+	return mux, nil
+}
+
+// retireMultiplexed closes conn, whose Mux opens no more streams, once the
+// streams still open on it are over
+func retireMultiplexed(mux *wire.Mux, conn net.Conn) {
+	go func() {
+		select {
+		case <-mux.Drained():
+		case <-mux.Done():
+		}
+		conn.Close()
+	}()
+}
+
+// Checkin returns a connection to the pool. A broken connection is closed
+// and resets the pool so the remaining stale connections get redialed; an
+// abandoned one is only closed, since the enclave is fine. A stream is
+// always closed, which resets it when its request was given up on.
+func (p *EnclavePool) Checkin(c *enclaveConn) {
+	if c.stream {
+		c.conn.Close()
+		p.slots <- nil
+		return
+	}
+	if c.abandoned {
+		p.close(c)
+		p.slots <- nil
+		return
+	}
+	if c.broken {
+		log.Println("Discarding broken enclave connection")
+		p.discard(c)
+		p.Reset()
+		p.slots <- nil
+		return
+	}
+	p.slots <- c
+}
+
+// Reset invalidates every pooled connection dialed so far
+func (p *EnclavePool) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.generation++
+	p.reported.Store(false)
+}
+
+func (p *EnclavePool) isStale(c *enclaveConn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return c.generation != p.generation
+}
+
+func (p *EnclavePool) discard(c *enclaveConn) {
+	p.close(c)
+	p.mu.Lock()
+	p.lost++
+	p.mu.Unlock()
+}
+
+// close closes c and gives up its place among the open connections
+func (p *EnclavePool) close(c *enclaveConn) {
+	c.conn.Close()
+	p.mu.Lock()
+	p.open--
+	open := p.open
+	p.mu.Unlock()
+	enclaveConnections.WithLabelValues(p.name).Set(float64(open))
+}
+
+// CheckHealth asks the enclave for its health status over a pooled
+// connection. Unlike a request it fails instead of queueing when the pool
+// stays busy for the whole timeout, since that is itself a sign of trouble.
+func (p *EnclavePool) CheckHealth(timeout time.Duration) (*protocol.HealthStatus, error) {
+	var slot *enclaveConn
+	select {
+	case slot = <-p.slots:
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no enclave connection became free within %v", timeout)
+	}
+	c, err := p.connect(slot)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Checkin(c)
+
+	p.mu.Lock()
+	p.pingSeq++
+	id := fmt.Sprintf("health-%d", p.pingSeq)
+	p.mu.Unlock()
+
+	response, err := c.probe(protocol.WASMRequest{Type: protocol.RequestTypeHealth, RequestID: id}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if response.Health == nil {
+		return nil, fmt.Errorf("enclave response %s carries no health status", id)
+	}
+	p.observeUptime(response.Health.UptimeSeconds)
+	return response.Health, nil
+}
+
+// observeUptime counts a restart when the enclave says it started later
+// than it last did, allowing for its uptime being in whole seconds
+func (p *EnclavePool) observeUptime(uptimeSeconds int64) {
+	started := time.Now().Add(-time.Duration(uptimeSeconds) * time.Second)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	restarted := !p.started.IsZero() && started.Sub(p.started) > restartSlack
+	if restarted {
+		log.Printf("Enclave %s restarted %v ago", p.name, time.Duration(uptimeSeconds)*time.Second)
+		enclaveRestarts.WithLabelValues(p.name).Inc()
+	}
+	if p.started.IsZero() || restarted {
+		p.started = started
+	}
+}
+
+// pingIdle checks the idle connections, and reports whether one of them
+// turned out dead. A framed connection is dead once it missed maxMisses
+// heartbeats in a row; other failures, and any on the JSON stream, kill a
+// connection at once.
+func (p *EnclavePool) pingIdle(timeout time.Duration, maxMisses int) bool {
+	if p.dialOptions.Streams {
+		return p.pingMultiplexed(timeout, maxMisses)
+	}
+	// Only take slots that are free right now; busy connections are
+	// already being exercised by requests
+	var taken []*enclaveConn
+collect:
+	for i := 0; i < p.size; i++ {
+		select {
+		case c := <-p.slots:
+			taken = append(taken, c)
+		default:
+			break collect
+		}
+	}
+
+	dead := false
+	for _, c := range taken {
+		if c == nil || p.isStale(c) {
+			continue
+		}
+		p.mu.Lock()
+		p.pingSeq++
+		seq := p.pingSeq
+		p.mu.Unlock()
+
+		err := c.ping(seq, timeout)
+		if err == nil {
+			c.missed = 0
+			continue
+		}
+		c.missed++
+		if c.missed < maxMisses && c.canMissPing(err) {
+			log.Printf("Enclave %s missed heartbeat %d of %d: %v", p.name, c.missed, maxMisses, err)
+			continue
+		}
+		log.Printf("Liveness check of enclave %s failed: %v", p.name, err)
+		c.broken = true
+		dead = true
+	}
+
+	for _, c := range taken {
+		if c == nil {
+			p.slots <- nil
+			continue
+		}
+		p.Checkin(c)
+	}
+	return dead
+}
+
+// pingMultiplexed sends the multiplexed connection a heartbeat, which the
+// enclave answers however busy its streams are, and reports whether the
+// connection turned out dead. It is closed once it missed maxMisses in a
+// row, failing its streams, and redialed by the next request.
+func (p *EnclavePool) pingMultiplexed(timeout time.Duration, maxMisses int) bool {
+	p.dialMu.Lock()
+	mux, conn := p.mux, p.muxConn
+	p.dialMu.Unlock()
+	if mux == nil {
+		return false
+	}
+	if err := mux.Err(); err != nil {
+		return true
+	}
+
+	p.mu.Lock()
+	p.pingSeq++
+	seq := p.pingSeq
+	p.mu.Unlock()
+	err := mux.Ping(seq, timeout)
+
+	p.mu.Lock()
+	if err == nil {
+		p.muxMissed = 0
+	} else {
+		p.muxMissed++
+	}
+	missed := p.muxMissed
+	p.mu.Unlock()
+	if err == nil {
+		return false
+	}
+	if missed < maxMisses && errors.Is(err, os.ErrDeadlineExceeded) {
+		log.Printf("Enclave %s missed heartbeat %d of %d: %v", p.name, missed, maxMisses, err)
+		return false
+	}
+	log.Printf("Liveness check of enclave %s failed: %v", p.name, err)
+	conn.Close()
+	return true
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// stalledError is an execution given up on because its enclave stopped
+// reporting progress, which a long execution never does
+type stalledError struct {
+	timeout time.Duration
+}
+
+func (e *stalledError) Error() string {
+	return fmt.Sprintf("enclave reported no progress for %v", e.timeout)
+}
+
+// watchProgress returns ctx that ends with a stalledError once no response
+// of its request, progress or final, arrived for timeout, and the function
+// that releases it. Progress responses are passed on to the handler in ctx.
+func watchProgress(ctx context.Context, timeout time.Duration) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(timeout, func() {
+		cancel(&stalledError{timeout: timeout})
+	})
+	progress := progressFrom(ctx)
+	ctx = withProgress(ctx, func(response protocol.WASMResponse) {
+		timer.Reset(timeout)
+		if progress != nil {
+			progress(response)
+		}
+	})
+	return ctx, func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// How often buckets of clients that went quiet are dropped
+const rateLimitSweepInterval = time.Minute
+
+// rateLimitError is a request refused because its client, or the tenant of
+// its client, exceeded its rate
+type rateLimitError struct {
+	// "client" or "tenant"
+	scope      string
+	client     string
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded for %s %s", e.scope, e.client)
+}
+
+// tokenBucket holds the requests a client may still make right away
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter gives every client a token bucket that refills at rate
+// requests per second up to burst, so one chatty client cannot starve the
+// others of enclave time. Clients are identified by source address.
+type rateLimiter struct {
+	scope string
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// newRateLimiter returns a limiter of the clients or tenants named by
+// scope, or nil when rate is zero, which allows everything
+func newRateLimiter(scope string, rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	l := &rateLimiter{scope: scope, rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+	go l.sweep()
+	return l
+}
+
+// allow takes a token from the client's bucket, or reports how long until
+// the next one
+func (l *rateLimiter) allow(client string) error {
+	if l == nil {
+		return nil
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return &rateLimitError{scope: l.scope, client: client, retryAfter: wait}
+	}
+	b.tokens--
+	return nil
+}
+
+// sweep drops buckets that have refilled completely, since a new bucket
+// would be the same
+func (l *rateLimiter) sweep() {
+	ticker := time.NewTicker(rateLimitSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		l.mu.Lock()
+		for client, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, client)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// clientAddr identifies a client by the host part of its address
+func clientAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
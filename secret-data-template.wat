@@ -0,0 +1,38 @@
+(module
+  ;; The enclave replaces {{DB_PASSWORD}} with the secret's bytes before
+  ;; compiling, so the exact password sits in memory at offset 16. The NUL
+  ;; after it marks where it ends, since its length is not known here.
+  (memory (export "memory") 1)
+  (data (i32.const 16) "{{DB_PASSWORD}}\00")
+
+  ;; Returns the length of the password
+  (func $password_length (result i32)
+    (local $end i32)
+    i32.const 16
+    local.set $end
+    block $done
+      loop $scan
+        local.get $end
+        i32.load8_u
+        i32.eqz
+        br_if $done
+        local.get $end
+        i32.const 1
+        i32.add
+        local.set $end
+        br $scan
+      end
+    end
+    local.get $end
+    i32.const 16
+    i32.sub)
+
+  ;; Returns the byte at the given index of the password
+  (func $password_byte (param i32) (result i32)
+    i32.const 16
+    local.get 0
+    i32.add
+    i32.load8_u)
+
+  (export "password_length" (func $password_length))
+  (export "password_byte" (func $password_byte)))
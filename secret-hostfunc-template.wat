@@ -0,0 +1,29 @@
+(module
+  ;; Secrets are fetched at run time through a host function, so the same
+  ;; approach works for binary modules compiled from any language
+  (import "env" "get_secret" (func $get_secret (param i32 i32 i32 i32) (result i32)))
+
+  (memory (export "memory") 1)
+  (data (i32.const 0) "API_KEY")
+
+  ;; Returns the sum of the API key's bytes plus the argument, or -1 if the
+  ;; key was not provided
+  (func $checksum (param $x i32) (result i32)
+    (local $len i32)
+    (local $i i32)
+    (local $sum i32)
+    ;; Copy up to 256 bytes of API_KEY to offset 1024
+    (local.set $len
+      (call $get_secret (i32.const 0) (i32.const 7) (i32.const 1024) (i32.const 256)))
+    (if (i32.lt_s (local.get $len) (i32.const 0))
+      (then (return (i32.const -1))))
+    (block $done
+      (loop $next
+        (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
+        (local.set $sum
+          (i32.add (local.get $sum) (i32.load8_u (i32.add (i32.const 1024) (local.get $i)))))
+        (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        (br $next)))
+    (i32.add (local.get $sum) (local.get $x)))
+
+  (export "checksum" (func $checksum)))
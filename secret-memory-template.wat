@@ -0,0 +1,27 @@
+(module
+  ;; The enclave writes DB_PASSWORD into linear memory and hands the module
+  ;; its location, so the exact bytes are available instead of a hash
+  (import "secret_ptr" "DB_PASSWORD" (global $pw_ptr i32))
+  (import "secret_len" "DB_PASSWORD" (global $pw_len i32))
+
+  (memory (export "memory") 1)
+
+  (func $password_length (result i32)
+    global.get $pw_len)
+
+  ;; Returns the byte at the given index of the password, or -1 if out of range
+  (func $password_byte (param i32) (result i32)
+    local.get 0
+    global.get $pw_len
+    i32.ge_u
+    if (result i32)
+      i32.const -1
+    else
+      global.get $pw_ptr
+      local.get 0
+      i32.add
+      i32.load8_u
+    end)
+
+  (export "password_length" (func $password_length))
+  (export "password_byte" (func $password_byte)))
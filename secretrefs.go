@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/sigv4"
+)
+
+// Upper bound on a Secrets Manager / SSM response body
+const maxSecretResponseSize = 1 << 20
+
+// SecretFetcher resolves secret references in requests into KMS ciphertexts
+// for the enclave to decrypt, so plaintext secret values never exist on the
+// host. Two kinds of ARN are supported:
+//
+//   - SSM SecureString parameters, read without decryption. The value is the
+//     raw KMS ciphertext and is decrypted with the PARAMETER_ARN encryption
+//     context that SSM uses.
+//   - Secrets Manager secrets whose value is itself a KMS ciphertext (binary,
+//     or base64 in the string field) encrypted under an enclave-gated key.
+//     Secrets Manager only ever returns decrypted values, so secrets that are
+//     not wrapped this way would be exposed to the host and are not supported.
+type SecretFetcher struct {
+	credentials *CredentialProvider
+	client      *http.Client
+}
+
+func NewSecretFetcher(credentials *CredentialProvider) *SecretFetcher {
+	return &SecretFetcher{
+		credentials: credentials,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Resolve moves every secret reference in req into its KMS secrets. Entries
+// of the secret list keep their binding and lose their ref.
+func (f *SecretFetcher) Resolve(req *protocol.WASMRequest) error {
+	refs := make(map[string]string, len(req.SecretRefs))
+	for name, arn := range req.SecretRefs {
+		refs[name] = arn
+	}
+	for _, secret := range req.SecretList {
+		if secret.Ref != "" {
+			refs[secret.Name] = secret.Ref
+		}
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+
+	creds, err := f.credentials.Credentials()
+	if err != nil {
+		return err
+	}
+
+	if req.KMSSecrets == nil {
+		req.KMSSecrets = make(map[string]string)
+	}
+	for name, arn := range refs {
+		ciphertext, context, err := f.fetch(arn, *creds)
+		if err != nil {
+			return fmt.Errorf("failed to fetch secret %s: %v", name, err)
+		}
+		req.KMSSecrets[name] = ciphertext
+		if context != nil {
+			if req.KMSEncryptionContexts == nil {
+				req.KMSEncryptionContexts = make(map[string]map[string]string)
+			}
+			req.KMSEncryptionContexts[name] = context
+		}
+	}
+
+	log.Printf("Resolved %d secret references into KMS ciphertexts", len(refs))
+	req.SecretRefs = nil
+	// The list is shared with the caller's copy of the request
+	list := make([]protocol.Secret, len(req.SecretList))
+	for i, secret := range req.SecretList {
+		secret.Ref = ""
+		list[i] = secret
+	}
+	req.SecretList = list
+	return nil
+}
+
+// fetch returns the base64 KMS ciphertext behind an ARN and its encryption context
+func (f *SecretFetcher) fetch(arn string, creds sigv4.Credentials) (string, map[string]string, error) {
+	// arn:partition:service:region:account:resource
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return "", nil, fmt.Errorf("invalid ARN %q", arn)
+	}
+	service, region := parts[2], parts[3]
+	if region != "" {
+		creds.Region = region
+	}
+
+	switch service {
+	case "ssm":
+		var out struct {
+			Parameter struct {
+				Type  string `json:"Type"`
+				Value string `json:"Value"`
+				ARN   string `json:"ARN"`
+			} `json:"Parameter"`
+		}
+		err := f.call("ssm", "AmazonSSM.GetParameter", map[string]interface{}{
+			"Name":           arn,
+			"WithDecryption": false,
+		}, creds, &out)
+		if err != nil {
+			return "", nil, err
+		}
+		if out.Parameter.Type != "SecureString" {
+			return "", nil, fmt.Errorf("parameter is %s, not SecureString", out.Parameter.Type)
+		}
+		return out.Parameter.Value, map[string]string{"PARAMETER_ARN": out.Parameter.ARN}, nil
+
+	case "secretsmanager":
+		var out struct {
+			SecretBinary string `json:"SecretBinary"`
+			SecretString string `json:"SecretString"`
+		}
+		err := f.call("secretsmanager", "secretsmanager.GetSecretValue", map[string]interface{}{
+			"SecretId": arn,
+		}, creds, &out)
+		if err != nil {
+			return "", nil, err
+		}
+		if out.SecretBinary != "" {
+			return out.SecretBinary, nil, nil
+		}
+		ciphertext := strings.TrimSpace(out.SecretString)
+		if _, err := base64.StdEncoding.DecodeString(ciphertext); err != nil {
+			return "", nil, fmt.Errorf("secret value is not a base64 KMS ciphertext")
+		}
+		return ciphertext, nil, nil
+
+	default:
+		return "", nil, fmt.Errorf("unsupported secret service %q", service)
+	}
+}
+
+// call invokes an AWS JSON 1.1 API action
+func (f *SecretFetcher) call(service, target string, input interface{}, creds sigv4.Credentials, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("https://%s.%s.amazonaws.com/", service, creds.Region)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	sigv4.SignRequest(req, body, creds, service, time.Now())
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %v", target, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxSecretResponseSize))
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %v", target, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var awsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(respBody, &awsErr)
+		return fmt.Errorf("%s returned %d: %s %s", target, resp.StatusCode, awsErr.Type, awsErr.Message)
+	}
+
+	if err := json.Unmarshal(respBody, output); err != nil {
+		return fmt.Errorf("invalid %s response: %v", target, err)
+	}
+	return nil
+}
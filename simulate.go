@@ -0,0 +1,16 @@
+//go:build simulate
+
+package main
+
+import (
+	"hello-wasm-enclave/internal/enclave"
+	"hello-wasm-enclave/internal/transport"
+)
+
+// startSimulatedEnclave runs the enclave server in this process, listening
+// on t, and returns once it listens. Hosts built without the simulate tag
+// leave out wasmtime entirely.
+func startSimulatedEnclave(args []string, t *transport.Transport) error {
+	enclave.Simulate(args, t)
+	return nil
+}
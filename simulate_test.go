@@ -0,0 +1,84 @@
+//go:build simulate
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"hello-wasm-enclave/internal/drain"
+	"hello-wasm-enclave/internal/transport"
+	"hello-wasm-enclave/internal/wire"
+	"hello-wasm-enclave/pkg/client"
+)
+
+// addModule exports add(i32, i32) i32, as a binary so that the test does not
+// need wat2wasm
+var addModule = base64.StdEncoding.EncodeToString([]byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	0x01, 0x07, 0x01, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f,
+	0x03, 0x02, 0x01, 0x00,
+	0x07, 0x07, 0x01, 0x03, 'a', 'd', 'd', 0x00, 0x00,
+	0x0a, 0x09, 0x01, 0x07, 0x00, 0x20, 0x00, 0x20, 0x01, 0x6a, 0x0b,
+})
+
+// TestSimulatedExecute sends an execute request from a client through the
+// host's JSON listener to an enclave server simulated in process, as
+// -simulate runs it
+func TestSimulatedExecute(t *testing.T) {
+	logger := slog.Default()
+	gcPercent := debug.SetGCPercent(100)
+	debug.SetGCPercent(gcPercent)
+
+	// The enclave's own GC and log flags must not reach the host
+	enclaveTransport := transport.NewInProcess()
+	started := time.Now()
+	args := []string{"-port", "5005", "-tls-port", "0", "-self-test=false", "-gc-percent", "400", "-log-format", "text"}
+	if err := startSimulatedEnclave(args, enclaveTransport); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed >= 2*time.Second {
+		t.Errorf("simulated enclave took %v to start, want no startup delay", elapsed)
+	}
+	if slog.Default() != logger {
+		t.Error("simulated enclave replaced the host's default logger")
+	}
+	if got := debug.SetGCPercent(gcPercent); got != gcPercent {
+		t.Errorf("GC percent = %d after starting the simulated enclave, want the host's %d", got, gcPercent)
+	}
+
+	backends := []backendConfig{{Name: defaultBackendName, CIDs: []uint32{defaultEnclaveCID}, Port: 5005, Modules: []string{anyName}}}
+	enclaves := newEnclaveRouter(backends, enclaveTransport, 2, true, wire.DialOptions{Streams: true}, defaultHostQueueLength, defaultQueueTimeout, defaultRetryAfter)
+	host := NewHostService(enclaves, nil, nil, 0, 5*time.Second)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	drainer := drain.New()
+	drainer.Track(listener)
+	go serveJSON(listener, host, drainer)
+	t.Cleanup(func() { drainer.Drain(time.Second) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	c, err := client.Dial(ctx, client.Options{Address: listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	response, err := c.Execute(ctx, client.Request{WASMCode: addModule, FunctionName: "add", Args: []int32{2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Result != 5 {
+		t.Fatalf("add(2, 3) = %d, want 5", response.Result)
+	}
+	if response.Attestation != "" {
+		t.Error("simulated enclave attested a result without an NSM")
+	}
+}
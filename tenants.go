@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// tenant is one entry of the tenants of the -auth-file: a namespace its
+// clients share, apart from the clients of other tenants. The host keeps
+// their registered modules and cached responses apart and applies the
+// tenant's quotas; the enclave, told the tenant of each request, keeps their
+// sessions, jobs and compiled modules apart, and releases secrets to
+// tenants as its secret policy says. Clients without a tenant share the
+// default one, which has no quotas.
+//
+//	tenants:
+//	  - name: payments
+//	    rate_limit: 50
+//	    rate_burst: 100
+//	    max_modules: 32
+//	    max_fuel: 100000000
+type tenant struct {
+	Name string `yaml:"name"`
+	// Requests per second across the tenant's clients (0 does not limit),
+	// on top of the per-client -rate-limit
+	RateLimit float64 `yaml:"rate_limit"`
+	RateBurst int     `yaml:"rate_burst"`
+	// Modules the tenant may register (0 for -max-modules)
+	MaxModules int `yaml:"max_modules"`
+	// Most fuel an execution of the tenant may use, given to requests that
+	// set none (0 does not limit); needs enclaves that meter fuel
+	MaxFuel uint64 `yaml:"max_fuel"`
+
+	limiter *rateLimiter
+}
+
+// loadTenants checks the tenants of an auth file and the tenants its clients
+// name
+func loadTenants(tenants []*tenant, clients []*authClient) (map[string]*tenant, error) {
+	byName := make(map[string]*tenant, len(tenants))
+	for i, t := range tenants {
+		switch {
+		case t.Name == "":
+			return nil, fmt.Errorf("auth file tenant %d has no name", i+1)
+		case byName[t.Name] != nil:
+			return nil, fmt.Errorf("auth file tenant %s is listed twice", t.Name)
+		case t.RateLimit < 0 || t.MaxModules < 0:
+			return nil, fmt.Errorf("auth file tenant %s has a negative quota", t.Name)
+		}
+		burst := t.RateBurst
+		if burst == 0 {
+			burst = int(t.RateLimit) + 1
+		}
+		t.limiter = newRateLimiter("tenant", t.RateLimit, burst)
+		byName[t.Name] = t
+	}
+	for _, client := range clients {
+		if client.Tenant != "" && byName[client.Tenant] == nil {
+			return nil, fmt.Errorf("auth file client %s belongs to unknown tenant %s", client.Name, client.Tenant)
+		}
+	}
+	return byName, nil
+}
+
+// tenant returns the quotas of the named tenant, or nil when it has none
+func (a *Authenticator) tenant(name string) *tenant {
+	if a == nil {
+		return nil
+	}
+	a.clients.RLock()
+	defer a.clients.RUnlock()
+	return a.tenants[name]
+}
+
+// moduleQuotas returns the tenants that may register a number of modules
+// other than -max-modules
+func (a *Authenticator) moduleQuotas() map[string]int {
+	quotas := make(map[string]int)
+	if a == nil {
+		return quotas
+	}
+	for name, t := range a.tenants {
+		if t.MaxModules > 0 {
+			quotas[name] = t.MaxModules
+		}
+	}
+	return quotas
+}
+
+// tenantOf authenticates a token and returns the tenant of its client
+func (a *Authenticator) tenantOf(token string) (string, error) {
+	client, _, err := a.authenticate(token)
+	if err != nil || client == nil {
+		return "", err
+	}
+	return client.Tenant, nil
+}
+
+// adminOf authenticates a token and returns the tenant of its client, which
+// must be an admin
+func (a *Authenticator) adminOf(token string) (string, error) {
+	client, _, err := a.authenticate(token)
+	if err != nil || client == nil {
+		return "", err
+	}
+	if !client.Admin {
+		return "", forbidden("client %s may not manage module aliases", client.Name)
+	}
+	return client.Tenant, nil
+}
+
+// admit applies the quotas of a tenant to one of its requests, limiting its
+// rate and the fuel of its executions
+func (t *tenant) admit(req *protocol.WASMRequest) error {
+	if t == nil {
+		return nil
+	}
+	if err := t.limiter.allow(t.Name); err != nil {
+		return err
+	}
+	switch req.Type {
+	case "", protocol.RequestTypeSubmitJob, protocol.RequestTypeCreateSession:
+		if t.MaxFuel == 0 {
+			break
+		}
+		if req.MaxFuel > t.MaxFuel {
+			return &protocol.ValidationError{Code: protocol.ErrorCodeResourceLimit, Message: fmt.Sprintf("max_fuel exceeds the %d of tenant %s", t.MaxFuel, t.Name)}
+		}
+		if req.MaxFuel == 0 {
+			req.MaxFuel = t.MaxFuel
+		}
+	}
+	return nil
+}
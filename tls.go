@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// loadServerTLS builds the TLS configuration of the client-facing listeners.
+// It returns nil, serving plaintext, when certFile is empty. With a client CA
+// bundle, clients must present a certificate signed by one of its CAs.
+func loadServerTLS(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" {
+		if clientCAFile != "" {
+			return nil, fmt.Errorf("client certificate authentication requires a server certificate")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile == "" {
+		return config, nil
+	}
+
+	bundle, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA bundle: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("client CA bundle %s holds no PEM certificates", clientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+// tlsIdentity names the client of a connection by its verified certificate:
+// the subject common name, or else its first DNS or URI name. It is empty
+// when the client presented no certificate.
+func tlsIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return ""
+	}
+	cert := state.VerifiedChains[0][0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
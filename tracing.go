@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/internal/tracing"
+)
+
+// Service the host's spans are recorded as
+const traceService = "hello-wasm-host"
+
+// hostTracer exports the spans of the requests the host traces, its own and
+// those the enclaves return: requests that arrive with a sampled
+// traceparent, and a share of those that arrive without one. A request is
+// traced in the phases decode, which covers reading, resolving and
+// validating it, forward, the enclave's own, and respond.
+type hostTracer struct {
+	exporter *tracing.Exporter
+	ratio    float64
+}
+
+// newHostTracer returns a tracer exporting to the OTLP/HTTP collector at
+// endpoint, or nil when endpoint is empty, which traces nothing
+func newHostTracer(endpoint string, ratio float64) (*hostTracer, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("-trace-sample-ratio must be between 0 and 1")
+	}
+	exporter, err := tracing.NewExporter(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &hostTracer{exporter: exporter, ratio: ratio}, nil
+}
+
+// export passes on the spans an enclave returned
+func (t *hostTracer) export(spans []protocol.Span) {
+	if t == nil {
+		return
+	}
+	for _, span := range spans {
+		t.exporter.Record(span)
+	}
+}
+
+// shutdown sends the spans still queued, for up to timeout
+func (t *hostTracer) shutdown(timeout time.Duration) {
+	if t == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	t.exporter.Shutdown(ctx)
+}
+
+// startRequest begins the host's span of a client request that arrived at
+// received, under the client's traceparent if it sent one. Untraced
+// requests get a nil span.
+func (h *HostService) startRequest(ctx context.Context, traceparent string, received time.Time) (context.Context, *tracing.Span) {
+	if h.tracer == nil {
+		return ctx, nil
+	}
+	parent, err := tracing.Parse(traceparent)
+	if err != nil {
+		// Without a usable traceparent the request starts a trace of its own
+		if rand.Float64() >= h.tracer.ratio {
+			return ctx, nil
+		}
+		parent = tracing.NewTrace()
+	}
+	ctx = tracing.WithParent(ctx, parent, traceService, h.tracer.exporter)
+	ctx, span := tracing.StartAt(ctx, "request", received)
+	tracing.Record(ctx, "decode", received, time.Since(received))
+	return ctx, span
+}
+
+// finishRequest times send as the respond phase of a traced request, then
+// ends the request's span, failed with message unless it is empty
+func finishRequest(ctx context.Context, span *tracing.Span, message string, send func()) {
+	_, respond := tracing.Start(ctx, "respond")
+	send()
+	respond.End()
+	span.SetError(message)
+	span.End()
+}
@@ -0,0 +1,17 @@
+(module
+  ;; fd_write output is captured by the enclave and returned to the client
+  ;; as stdout/stderr
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+
+  (memory (export "memory") 1)
+  (data (i32.const 64) "hello from the enclave\n")
+
+  ;; Prints a greeting and returns the argument doubled
+  (func $greet (param $x i32) (result i32)
+    ;; One iovec at offset 0: the greeting at 64, 23 bytes long
+    (i32.store (i32.const 0) (i32.const 64))
+    (i32.store (i32.const 4) (i32.const 23))
+    (drop (call $fd_write (i32.const 1) (i32.const 0) (i32.const 1) (i32.const 8)))
+    (i32.mul (local.get $x) (i32.const 2)))
+
+  (export "greet" (func $greet)))
@@ -0,0 +1,135 @@
+// wasm-admin runs operations on a host through its admin API (-admin-addr)
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"hello-wasm-enclave/internal/config"
+)
+
+// Exit codes, as wasm-client's
+const (
+	exitFailed      = 1
+	exitUsage       = 2
+	exitUnavailable = 3
+	exitDenied      = 5
+)
+
+// command is an operation and the admin API request it makes
+type command struct {
+	usage  string
+	method string
+	path   string
+	args   int
+}
+
+var commands = map[string]command{
+	"modules":            {"list the compiled modules each enclave caches", http.MethodGet, "/admin/modules", 0},
+	"evict":              {"[HASH]  evict the compiled modules of HASH, or all of them", http.MethodDelete, "/admin/modules", -1},
+	"flush-responses":    {"forget the responses the host cached", http.MethodDelete, "/admin/responses", 0},
+	"sessions":           {"list the sessions alive in each enclave", http.MethodGet, "/admin/sessions", 0},
+	"kill-session":       {"HANDLE  end the session of HANDLE, as sessions lists it", http.MethodDelete, "/admin/sessions/", 1},
+	"drain":              {"finish the requests in flight and stop the host", http.MethodPost, "/admin/drain", 0},
+	"metrics":            {"print the host's Prometheus metrics", http.MethodGet, "/admin/metrics", 0},
+	"rotate-signing-key": {"give each enclave a new receipt signing key", http.MethodPost, "/admin/signing-key", 0},
+	"reload-auth":        {"make the host read its -auth-file again", http.MethodPost, "/admin/reload-auth", 0},
+}
+
+func main() {
+	hostURL := flag.String("url", "http://localhost:8083", "URL of the host's admin API")
+	token := flag.String("token", "", "admin token the host's -admin-token-sha256 is the hash of")
+	enclave := flag.String("enclave", "", "replica to run enclave operations on, instead of all of them")
+	timeout := flag.Duration("timeout", time.Minute, "time to wait for the host")
+	flag.Usage = usage
+	if err := config.Parse(flag.CommandLine, "WASM_ADMIN", os.Args[1:]); err != nil {
+		log.Printf("Invalid configuration: %v", err)
+		os.Exit(exitUsage)
+	}
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	args := flag.Args()[1:]
+	if !ok || (cmd.args >= 0 && len(args) != cmd.args) || len(args) > 1 {
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	target, err := url.Parse(strings.TrimSuffix(*hostURL, "/") + cmd.path)
+	if err != nil {
+		log.Printf("Invalid -url: %v", err)
+		os.Exit(exitUsage)
+	}
+	query := url.Values{}
+	if *enclave != "" {
+		query.Set("enclave", *enclave)
+	}
+	switch {
+	case flag.Arg(0) == "kill-session":
+		target.Path += url.PathEscape(args[0])
+	case len(args) == 1:
+		query.Set("module_hash", args[0])
+	}
+	target.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(cmd.method, target.String(), nil)
+	if err != nil {
+		log.Printf("Invalid request: %v", err)
+		os.Exit(exitUsage)
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	resp, err := (&http.Client{Timeout: *timeout}).Do(req)
+	if err != nil {
+		log.Printf("Failed to reach the host: %v", err)
+		os.Exit(exitUnavailable)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("Failed to read the host's response: %v", err)
+		os.Exit(exitUnavailable)
+	}
+	printBody(os.Stdout, resp.Header.Get("Content-Type"), body)
+
+	switch {
+	case resp.StatusCode < 300:
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		os.Exit(exitDenied)
+	case resp.StatusCode == http.StatusBadGateway, resp.StatusCode == http.StatusServiceUnavailable:
+		os.Exit(exitUnavailable)
+	default:
+		os.Exit(exitFailed)
+	}
+}
+
+// printBody writes a response body, indenting JSON
+func printBody(w io.Writer, contentType string, body []byte) {
+	var indented bytes.Buffer
+	if strings.HasPrefix(contentType, "application/json") && json.Indent(&indented, body, "", "  ") == nil {
+		body = append(bytes.TrimRight(indented.Bytes(), "\n"), '\n')
+	}
+	w.Write(body)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-url URL] [-token TOKEN] [-enclave ID] COMMAND [ARG]\n\nCommands:\n", os.Args[0])
+	for _, name := range []string{"modules", "evict", "flush-responses", "sessions", "kill-session", "drain", "metrics", "rotate-signing-key", "reload-auth"} {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nFlags:")
+	flag.PrintDefaults()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/pkg/client"
+)
+
+// attestationPolicy is what -require-attestation and the -expected-pcr flags
+// demand of the attestation documents the client receives
+type attestationPolicy struct {
+	required bool
+	root     *x509.Certificate // nil for the AWS Nitro root
+	pcrs     map[uint][]byte
+}
+
+// newAttestationPolicy returns the policy of the attestation flags, which
+// expecting PCRs makes required
+func newAttestationPolicy(required bool, rootFile string, expected map[uint]string) (*attestationPolicy, error) {
+	policy := &attestationPolicy{required: required, pcrs: make(map[uint][]byte)}
+	for index, value := range expected {
+		if value == "" {
+			continue
+		}
+		pcr, err := hex.DecodeString(value)
+		if err != nil || len(pcr) != 48 {
+			return nil, fmt.Errorf("expected PCR%d must be 96 hex digits", index)
+		}
+		policy.pcrs[index] = pcr
+		policy.required = true
+	}
+	if rootFile != "" {
+		contents, err := ioutil.ReadFile(rootFile)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(contents)
+		if block == nil {
+			return nil, fmt.Errorf("%s holds no PEM certificate", rootFile)
+		}
+		if policy.root, err = x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("invalid attestation root: %v", err)
+		}
+	}
+	return policy, nil
+}
+
+// verify checks an attestation document of what against the policy and
+// expect, then prints what was checked. A document that fails is fatal.
+func (p *attestationPolicy) verify(what, encoded string, expect client.Expectations) {
+	if !p.required {
+		return
+	}
+	if encoded == "" {
+		fatal(exitUnverified, "Attestation required, but the enclave attested no %s", what)
+	}
+	expect.Root = p.root
+	expect.PCRs = p.pcrs
+	report, err := client.VerifyAttestation(encoded, expect)
+	if err != nil {
+		fatal(exitUnverified, "Attestation of the %s failed to verify: %v", what, err)
+	}
+
+	log.Printf("Attestation of the %s:", what)
+	log.Printf("  signed by %s for %s at %s", report.Signer.Subject.CommonName, report.Document.ModuleID, time.UnixMilli(int64(report.Document.Timestamp)).UTC().Format(time.RFC3339))
+	failed := 0
+	for _, check := range report.Checks {
+		if check.Err != nil {
+			log.Printf("  %s: FAILED, %v", check.Name, check.Err)
+			failed++
+		} else {
+			log.Printf("  %s: ok", check.Name)
+		}
+	}
+	if failed > 0 {
+		fatal(exitUnverified, "Attestation of the %s failed %d checks", what, failed)
+	}
+}
+
+// verifyResult checks the attestation of the response to request, which
+// carried nonce. Errors the host answers itself, such as rate limits, carry
+// no document.
+func verifyResult(request protocol.WASMRequest, response protocol.WASMResponse, nonce []byte) {
+	if response.Attestation != "" || response.Error == "" {
+		attestations.verify("result", response.Attestation, client.Expectations{Nonce: nonce, Request: client.AttestedRequest(request), Result: &response})
+	}
+}
+
+// newNonce returns a fresh nonce, which proves that an attestation document
+// was made for the request carrying it
+func newNonce() []byte {
+	nonce, err := client.NewNonce()
+	if err != nil {
+		fatal(exitFailed, "%v", err)
+	}
+	return nonce
+}
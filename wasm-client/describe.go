@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// printDescription prints the imports and exports a describe_module request
+// listed, one per line
+func printDescription(response protocol.WASMResponse) {
+	description := response.Module
+	if description == nil {
+		fatal(errorExitCode(response.ErrorCode), "Enclave did not describe the module: %s", response.Error)
+	}
+	if jsonOutput {
+		printJSON(description)
+		return
+	}
+	fmt.Printf("module %s\n", description.ModuleHash)
+	for _, item := range description.Imports {
+		fmt.Printf("import %s.%s: %s\n", item.Module, item.Name, formatItem(item))
+	}
+	for _, item := range description.Exports {
+		fmt.Printf("export %s: %s\n", item.Name, formatItem(item))
+	}
+}
+
+// formatItem writes the type of an import or export in the manner of WAT,
+// e.g. func (i32 i32) -> (i32), or memory 1 16
+func formatItem(item protocol.ModuleItem) string {
+	var limits string
+	if item.Max != nil {
+		limits = fmt.Sprintf(" %d %d", item.Min, *item.Max)
+	} else {
+		limits = fmt.Sprintf(" %d", item.Min)
+	}
+	switch item.Kind {
+	case protocol.ItemFunc:
+		return fmt.Sprintf("func (%s) -> (%s)", strings.Join(item.Params, " "), strings.Join(item.Results, " "))
+	case protocol.ItemGlobal:
+		if item.Mutable {
+			return fmt.Sprintf("global (mut %s)", item.Type)
+		}
+		return "global " + item.Type
+	case protocol.ItemMemory:
+		if item.Memory64 {
+			return "memory i64" + limits
+		}
+		return "memory" + limits
+	case protocol.ItemTable:
+		return "table" + limits + " " + item.Type
+	}
+	return item.Kind
+}
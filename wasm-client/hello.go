@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/pkg/client"
+)
+
+// hello opens the conversation by exchanging protocol versions. It returns
+// nil for a peer that predates the handshake, which answers with an error.
+func hello(host *client.Client) *protocol.Capabilities {
+	capabilities, err := host.Hello(context.Background())
+	var refused *client.Error
+	if errors.As(err, &refused) {
+		fatal(errorExitCode(refused.Code), "Hello refused (%s): %s", refused.Code, refused.Message)
+	}
+	if err != nil {
+		fatal(exitUnavailable, "%v", err)
+	}
+	if capabilities == nil {
+		log.Printf("Peer does not answer hello; assuming protocol version 0")
+		return nil
+	}
+	log.Printf("Speaking protocol version %d", capabilities.NegotiatedVersion())
+	return capabilities
+}
+
+// checkCapabilities fails a request that uses something its server reported
+// it does not support, rather than letting it fail in the enclave. Servers
+// that did not report their capabilities are given the benefit of the doubt.
+func checkCapabilities(server *protocol.Capabilities, request protocol.WASMRequest) error {
+	if server == nil {
+		return nil
+	}
+	if request.Type == protocol.RequestTypeCreateSession && !server.Supports(protocol.OperationSessions) {
+		return fmt.Errorf("server does not keep sessions")
+	}
+	switch request.Type {
+	case protocol.RequestTypeReadMemory, protocol.RequestTypeWriteMemory:
+		if !server.Supports(protocol.OperationMemory) {
+			return fmt.Errorf("server does not access the memory of sessions")
+		}
+	case protocol.RequestTypeGetGlobal, protocol.RequestTypeSetGlobal:
+		if !server.Supports(protocol.OperationGlobals) {
+			return fmt.Errorf("server does not access the globals of sessions")
+		}
+	case protocol.RequestTypeSubmitJob, protocol.RequestTypeJobStatus, protocol.RequestTypeJobResult, protocol.RequestTypeCancelJob:
+		if !server.Supports(protocol.OperationJobs) {
+			return fmt.Errorf("server does not run jobs")
+		}
+	case protocol.RequestTypeUploadBegin, protocol.RequestTypeUploadChunk, protocol.RequestTypeUploadCommit:
+		if !server.Supports(protocol.OperationUploads) {
+			return fmt.Errorf("server does not take modules in chunks")
+		}
+	}
+	if request.StreamEmits && !server.Supports(protocol.OperationEmits) {
+		return fmt.Errorf("server does not stream what modules emit")
+	}
+	if request.ProgressIntervalMS > 0 && !server.Supports(protocol.OperationProgress) {
+		return fmt.Errorf("server does not report the progress of executions")
+	}
+	if len(request.SecretList) > 0 && !server.Supports(protocol.OperationBoundSecrets) {
+		return fmt.Errorf("server does not bind secrets to modules")
+	}
+	if len(request.Calls) > 0 {
+		if !server.Supports(protocol.OperationBatch) {
+			return fmt.Errorf("server does not run batches of calls")
+		}
+		if len(request.Calls) > server.MaxCalls {
+			return fmt.Errorf("server runs at most %d calls per request", server.MaxCalls)
+		}
+	}
+	for _, call := range request.FunctionCalls() {
+		for _, arg := range call.TypedArgs {
+			if !contains(server.ValueTypes, arg.Type) {
+				return fmt.Errorf("server does not take %s arguments", arg.Type)
+			}
+		}
+		resultType := call.ResultType
+		if call.ResultSpec != nil {
+			resultType = call.ResultSpec.Type
+		}
+		if resultType != "" && !contains(server.ValueTypes, resultType) {
+			return fmt.Errorf("server does not return %s results", resultType)
+		}
+	}
+	if server.WasmtimeVersion == "" {
+		if request.ModuleRef != "" && !server.Supports(protocol.OperationOCI) {
+			return fmt.Errorf("host does not pull modules from OCI registries")
+		}
+		// Only enclaves report WebAssembly features and preloaded modules
+		return nil
+	}
+	if request.Type != "" && !contains(server.RequestTypes, request.Type) {
+		return fmt.Errorf("enclave does not answer %s requests", request.Type)
+	}
+	if request.ModuleName != "" && !contains(server.PreloadedModules, request.ModuleName) {
+		return fmt.Errorf("enclave has not preloaded module %s (has %v)", request.ModuleName, server.PreloadedModules)
+	}
+	if (request.WASMCode != "" || request.ModuleRef != "") && server.ModuleUploadDisabled {
+		return fmt.Errorf("enclave only runs preloaded modules (%v); use -module", server.PreloadedModules)
+	}
+	for _, feature := range request.Features {
+		if !contains(server.WasmFeatures, feature) && !contains(server.OptionalWasmFeatures, feature) {
+			return fmt.Errorf("enclave does not offer WebAssembly feature %s (offers %v and %v)", feature, server.WasmFeatures, server.OptionalWasmFeatures)
+		}
+	}
+	return nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/pkg/client"
+)
+
+// runJob submits a request as a job and, unless detached, polls it until it
+// finishes and returns its result as the response to the request. Detached,
+// it prints the job ID for -job or -cancel-job and exits.
+func runJob(host *client.Client, submit protocol.WASMRequest, detach bool, interval time.Duration) protocol.WASMResponse {
+	response := roundTrip(host, submit)
+	if response.Error != "" {
+		return response
+	}
+	log.Printf("Job %s submitted", response.JobID)
+	if detach {
+		if jsonOutput {
+			printJSON(jsonResult{WASMResponse: response})
+		} else {
+			fmt.Println(response.JobID)
+		}
+		os.Exit(0)
+	}
+	return awaitJob(host, submit.RequestID, response.JobID, interval)
+}
+
+// awaitJob polls a job every interval until it is no longer running, then
+// fetches its result
+func awaitJob(host *client.Client, requestID, jobID string, interval time.Duration) protocol.WASMResponse {
+	for {
+		status := roundTrip(host, protocol.WASMRequest{
+			Type:      protocol.RequestTypeJobStatus,
+			RequestID: requestID + "-status",
+			JobID:     jobID,
+		})
+		if status.Error != "" || status.Job == nil {
+			fatal(errorExitCode(status.ErrorCode), "Failed to get the status of job %s: %s", jobID, status.Error)
+		}
+		if status.Job.State != protocol.JobRunning {
+			log.Printf("Job %s %s after %v", jobID, status.Job.State, time.Duration(status.Job.ElapsedMS)*time.Millisecond)
+			break
+		}
+		if status.Job.FuelConsumed > 0 {
+			log.Printf("Job %s running for %v, %d fuel consumed", jobID, time.Duration(status.Job.ElapsedMS)*time.Millisecond, status.Job.FuelConsumed)
+		}
+		time.Sleep(interval)
+	}
+	return roundTrip(host, protocol.WASMRequest{
+		Type:      protocol.RequestTypeJobResult,
+		RequestID: requestID,
+		JobID:     jobID,
+	})
+}
+
+// printJob waits for a job submitted earlier and prints its result as JSON,
+// since what it called is not known here
+func printJob(host *client.Client, requestID, jobID string, interval time.Duration) {
+	response := awaitJob(host, requestID, jobID, interval)
+	code := exitCode(response)
+	printJSON(jsonResult{WASMResponse: response, ExitCode: code})
+	os.Exit(code)
+}
+
+// cancelJob stops a job and prints the state it ended in
+func cancelJob(host *client.Client, requestID, jobID string) {
+	response := roundTrip(host, protocol.WASMRequest{
+		Type:      protocol.RequestTypeCancelJob,
+		RequestID: requestID,
+		JobID:     jobID,
+	})
+	if response.Error != "" || response.Job == nil {
+		fatal(errorExitCode(response.ErrorCode), "Failed to cancel job %s: %s", jobID, response.Error)
+	}
+	log.Printf("Job %s %s", jobID, response.Job.State)
+	if jsonOutput {
+		printJSON(jsonResult{WASMResponse: response})
+		return
+	}
+	fmt.Printf("job %s: %s\n", jobID, response.Job.State)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// Exit codes, so scripts can tell why the client failed
+const (
+	exitFailed      = 1 // The module or the client failed for another reason
+	exitUsage       = 2 // The command line or the request is invalid
+	exitUnavailable = 3 // The host or enclave is unreachable, busy or shutting down; worth retrying
+	exitLimit       = 4 // A resource limit stopped the execution
+	exitDenied      = 5 // Authentication, authorization or the module policy refused the request
+	exitUnverified  = 6 // An attestation, certificate or receipt did not verify
+)
+
+// jsonOutput makes the client print one jsonResult on stdout instead of
+// human-readable lines; logs still go to stderr
+var jsonOutput bool
+
+// jsonResult is what -json prints: the enclave's response, with what was
+// called and the exit code the client ends with
+type jsonResult struct {
+	protocol.WASMResponse
+	Function    string           `json:"function,omitempty"`
+	Args        []int32          `json:"args,omitempty"`
+	TypedArgs   []protocol.Value `json:"typed_args,omitempty"`
+	RoundTripUS int64            `json:"round_trip_us,omitempty"` // Microseconds between sending the request and its response
+	ExitCode    int              `json:"exit_code"`
+}
+
+// printJSON writes v to stdout as one line of JSON
+func printJSON(v interface{}) {
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		log.Printf("Failed to print result: %v", err)
+	}
+}
+
+// exitCode maps a response to the code the client exits with. A batch whose
+// calls failed fails with the code of the first such call.
+func exitCode(response protocol.WASMResponse) int {
+	if response.Error != "" {
+		return errorExitCode(response.ErrorCode)
+	}
+	for _, result := range response.Results {
+		if result.Error != "" {
+			return errorExitCode(result.ErrorCode)
+		}
+	}
+	return 0
+}
+
+func errorExitCode(errorCode string) int {
+	switch errorCode {
+	case protocol.ErrorCodeTimeout, protocol.ErrorCodeFuelExhausted, protocol.ErrorCodeResourceLimit:
+		return exitLimit
+	case protocol.ErrorCodePolicy, protocol.ErrorCodeUnauthenticated, protocol.ErrorCodeForbidden:
+		return exitDenied
+	case protocol.ErrorCodeOverloaded, protocol.ErrorCodeRateLimited, protocol.ErrorCodeShuttingDown, protocol.ErrorCodeModuleUnavailable, protocol.ErrorCodeEnclaveUnavailable:
+		return exitUnavailable
+	case protocol.ErrorCodeInvalidRequest, protocol.ErrorCodeRequestTooLarge, protocol.ErrorCodeSecretMissing:
+		return exitUsage
+	}
+	return exitFailed
+}
+
+// fatal logs an error and exits with code, printing the error as the result
+// in JSON mode
+func fatal(code int, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Print(message)
+	if jsonOutput {
+		printJSON(jsonResult{WASMResponse: protocol.WASMResponse{Error: message}, ExitCode: code})
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/pkg/client"
+)
+
+// errNoProgress is why an execution that stopped reporting is given up on
+var errNoProgress = errors.New("no progress reported")
+
+// progressRoundTrip sends an execution and relays its progress as it
+// arrives: what the module emits to stdout, or to stderr when stdout is
+// kept for the -json result, and its elapsed time and fuel to the log. An
+// execution that reports nothing for timeout is given up on, unless
+// timeout is zero.
+func progressRoundTrip(host *client.Client, request protocol.WASMRequest, timeout time.Duration) protocol.WASMResponse {
+	out := os.Stdout
+	if jsonOutput {
+		out = os.Stderr
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	reset := func() {}
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() { cancel(errNoProgress) })
+		defer timer.Stop()
+		reset = func() { timer.Reset(timeout) }
+	}
+
+	log.Println("Request sent, waiting for progress and the response...")
+	response, err := host.ExecuteProgress(ctx, request, func(progress protocol.WASMResponse) {
+		reset()
+		elapsed := time.Duration(progress.ElapsedMS) * time.Millisecond
+		switch {
+		case progress.Emit != "":
+			if piece, err := base64.StdEncoding.DecodeString(progress.Emit); err == nil {
+				out.Write(piece)
+			}
+		case progress.FuelConsumed > 0:
+			log.Printf("Running for %v, %d fuel consumed", elapsed, progress.FuelConsumed)
+		default:
+			log.Printf("Running for %v", elapsed)
+		}
+	})
+	if errors.Is(context.Cause(ctx), errNoProgress) {
+		fatal(exitUnavailable, "Gave up after %v without progress", timeout)
+	}
+	var failed *client.Error
+	if err != nil && !errors.As(err, &failed) {
+		fatal(exitUnavailable, "%v", err)
+	}
+	if response.EmitsTruncated {
+		log.Println("Warning: the enclave dropped what the module emitted beyond its limit")
+	}
+	return response
+}
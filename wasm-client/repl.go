@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/pkg/client"
+)
+
+// runREPL creates a session with create and makes one call of it per line
+// of input, e.g. "add 2 3", until the input ends or says exit. The module is
+// sent once; every call runs against the same instance, so state the module
+// keeps in its memory or globals carries over from call to call. Lines
+// starting with a colon access the instance's memory or globals instead
+// (see memoryCommand and globalCommand). An attested session's creation is
+// checked against nonce before any call is made.
+func runREPL(host *client.Client, create protocol.WASMRequest, nonce []byte, input io.Reader) {
+	response := roundTrip(host, create)
+	verifyResult(create, response, nonce)
+	if response.Error != "" {
+		fatal(errorExitCode(response.ErrorCode), "Failed to create session: %s", response.Error)
+	}
+	sessionID := response.SessionID
+	log.Printf("Session %s created; type FUNCTION [ARG...] to call, :read, :write or :load to access memory, :get or :set for globals, exit to quit", sessionID)
+	defer func() {
+		response := roundTrip(host, protocol.WASMRequest{
+			Type:      protocol.RequestTypeDestroySession,
+			RequestID: create.RequestID + "-destroy",
+			SessionID: sessionID,
+		})
+		if response.Error != "" {
+			log.Printf("Failed to destroy session: %s", response.Error)
+		}
+	}()
+
+	scanner := bufio.NewScanner(input)
+	for n := 1; ; n++ {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "exit" || fields[0] == "quit" {
+			return
+		}
+		if strings.HasPrefix(fields[0], ":") {
+			requestID := fmt.Sprintf("%s-%d", create.RequestID, n)
+			command := memoryCommand
+			if fields[0] == ":get" || fields[0] == ":set" {
+				command = globalCommand
+			}
+			if err := command(host, requestID, sessionID, scanner.Text()); err != nil {
+				fmt.Println(err)
+			}
+			continue
+		}
+		args, typedArgs, err := parseArgs(fields[1:])
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		target, err := parseTarget(fields[0])
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		call := protocol.WASMRequest{
+			Type:          protocol.RequestTypeCallSession,
+			RequestID:     fmt.Sprintf("%s-%d", create.RequestID, n),
+			CorrelationID: create.CorrelationID,
+			SessionID:     sessionID,
+			FunctionName:  fields[0],
+			Args:          args,
+			TypedArgs:     typedArgs,
+			ResultType:    create.ResultType,
+			ResultSpec:    create.ResultSpec,
+		}
+		if target.Table != "" {
+			// Only calls in a batch go through tables
+			target.Args, target.TypedArgs = args, typedArgs
+			target.ResultType, target.ResultSpec = create.ResultType, create.ResultSpec
+			call.FunctionName, call.Args, call.TypedArgs, call.ResultType, call.ResultSpec = "", nil, nil, "", nil
+			call.Calls = []protocol.Call{target}
+		}
+		response := roundTrip(host, call)
+		if len(response.Results) == 1 && response.Error == "" {
+			result := response.Results[0]
+			response.Result, response.ResultValue, response.Error, response.ErrorCode = result.Result, result.ResultValue, result.Error, result.ErrorCode
+		}
+		printOutput(response)
+		switch {
+		case response.Error != "":
+			fmt.Printf("%s(%s) failed: %s\n", fields[0], formatArgs(args, typedArgs), response.Error)
+		case response.ResultValue != nil:
+			fmt.Printf("%s(%s) = %s (%s)\n", fields[0], formatArgs(args, typedArgs), response.ResultValue.Value, response.ResultValue.Type)
+		default:
+			fmt.Printf("%s(%s) = %d\n", fields[0], formatArgs(args, typedArgs), response.Result)
+		}
+
+		// The enclave ends a session that hit a limit
+		switch response.ErrorCode {
+		case protocol.ErrorCodeTimeout, protocol.ErrorCodeFuelExhausted, protocol.ErrorCodeResourceLimit:
+			fatal(exitLimit, "Session ended (%s)", response.ErrorCode)
+		}
+	}
+}
+
+// memoryCommand runs a REPL line that accesses the session's exported
+// memory:
+//
+//	:read OFFSET LENGTH   prints LENGTH bytes at OFFSET
+//	:write OFFSET TEXT    writes the rest of the line at OFFSET
+//	:load OFFSET FILE     writes a file at OFFSET, in as many requests as it takes
+func memoryCommand(host *client.Client, requestID, sessionID, line string) error {
+	fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+	if len(fields) != 3 {
+		return fmt.Errorf("usage: :read OFFSET LENGTH, :write OFFSET TEXT or :load OFFSET FILE")
+	}
+	offset, err := strconv.ParseUint(fields[1], 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid offset %q", fields[1])
+	}
+	request := protocol.WASMRequest{RequestID: requestID, SessionID: sessionID, Offset: offset}
+
+	switch fields[0] {
+	case ":read":
+		length, err := strconv.ParseUint(strings.TrimSpace(fields[2]), 0, 32)
+		if err != nil {
+			return fmt.Errorf("invalid length %q", fields[2])
+		}
+		request.Type = protocol.RequestTypeReadMemory
+		request.Length = uint32(length)
+		response := roundTrip(host, request)
+		if response.Error != "" {
+			return fmt.Errorf("read failed: %s", response.Error)
+		}
+		data, err := base64.StdEncoding.DecodeString(response.Data)
+		if err != nil {
+			return fmt.Errorf("invalid data from enclave: %v", err)
+		}
+		fmt.Print(hex.Dump(data))
+		return nil
+	case ":write":
+		return writeMemory(host, request, []byte(fields[2]))
+	case ":load":
+		data, err := os.ReadFile(strings.TrimSpace(fields[2]))
+		if err != nil {
+			return err
+		}
+		return writeMemory(host, request, data)
+	}
+	return fmt.Errorf("unknown command %s", fields[0])
+}
+
+// writeMemory writes data into the session's memory at request.Offset, in
+// chunks of at most protocol.MaxMemoryAccess bytes
+func writeMemory(host *client.Client, request protocol.WASMRequest, data []byte) error {
+	request.Type = protocol.RequestTypeWriteMemory
+	start := request.Offset
+	for written := 0; written < len(data); {
+		chunk := data[written:]
+		if len(chunk) > protocol.MaxMemoryAccess {
+			chunk = chunk[:protocol.MaxMemoryAccess]
+		}
+		request.Offset = start + uint64(written)
+		request.Data = base64.StdEncoding.EncodeToString(chunk)
+		response := roundTrip(host, request)
+		if response.Error != "" {
+			return fmt.Errorf("write at offset %d failed: %s", request.Offset, response.Error)
+		}
+		written += len(chunk)
+	}
+	fmt.Printf("wrote %d bytes at offset %d\n", len(data), start)
+	return nil
+}
+
+// globalCommand runs a REPL line that accesses an exported global of the
+// session:
+//
+//	:get NAME          prints the value of global NAME
+//	:set NAME VALUE    sets it to VALUE, typed as function arguments are
+func globalCommand(host *client.Client, requestID, sessionID, line string) error {
+	fields := strings.Fields(line)
+	request := protocol.WASMRequest{RequestID: requestID, SessionID: sessionID}
+	switch {
+	case fields[0] == ":get" && len(fields) == 2:
+		request.Type = protocol.RequestTypeGetGlobal
+	case fields[0] == ":set" && len(fields) == 3:
+		request.Type = protocol.RequestTypeSetGlobal
+		args, typedArgs, err := parseArgs(fields[2:])
+		if err != nil {
+			return err
+		}
+		if len(typedArgs) == 0 {
+			typedArgs = protocol.I32Values(args)
+		}
+		request.GlobalValue = &typedArgs[0]
+	default:
+		return fmt.Errorf("usage: :get NAME or :set NAME VALUE")
+	}
+	request.Global = fields[1]
+
+	response := roundTrip(host, request)
+	if response.Error != "" || response.ResultValue == nil {
+		return fmt.Errorf("%s failed: %s", request.Type, response.Error)
+	}
+	fmt.Printf("%s = %s (%s)\n", request.Global, response.ResultValue.Value, response.ResultValue.Type)
+	return nil
+}
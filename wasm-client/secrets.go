@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"hello-wasm-enclave/internal/protocol"
+)
+
+// loadSecrets gathers the secrets to send from a JSON or YAML file of names
+// and values, the environment variables starting with envPrefix, and the
+// -secret flags, each overriding the one before
+func loadSecrets(path, envPrefix string, environ []string, flags map[string]string) (map[string]string, error) {
+	secrets := map[string]string{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secrets file: %v", err)
+		}
+		// JSON is YAML, so one decoder reads both
+		if err := yaml.Unmarshal(data, &secrets); err != nil {
+			return nil, fmt.Errorf("failed to parse secrets file %s: %v", path, err)
+		}
+	}
+	if envPrefix != "" {
+		for _, entry := range environ {
+			name, value, _ := strings.Cut(entry, "=")
+			if name, ok := strings.CutPrefix(name, envPrefix); ok && name != "" {
+				secrets[name] = value
+			}
+		}
+	}
+	for name, value := range flags {
+		secrets[name] = value
+	}
+	for name := range secrets {
+		if name == "" {
+			return nil, fmt.Errorf("secret names must not be empty")
+		}
+	}
+	return secrets, nil
+}
+
+// sealSecrets encrypts the secrets map to the enclave's public key so the
+// host only ever forwards ciphertext. The envelope layout matches the
+// enclave's EnclaveKey: RSA-OAEP-SHA256(aes_key) || nonce || AES-256-GCM(json).
+// With a module digest, every secret is sealed together with it, so the
+// enclave injects it into no other module whatever the host does.
+func sealSecrets(encodedPublicKey string, secrets map[string]string, module string) (string, error) {
+	publicDER, err := base64.StdEncoding.DecodeString(encodedPublicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key encoding: %v", err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(publicDER)
+	if err != nil {
+		return "", fmt.Errorf("invalid enclave public key: %v", err)
+	}
+	publicKey, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return "", fmt.Errorf("unexpected enclave public key type %T", parsed)
+	}
+
+	var payload interface{} = secrets
+	if module != "" {
+		bound := make(map[string]protocol.Secret, len(secrets))
+		for name, value := range secrets {
+			bound[name] = protocol.Secret{Name: name, Value: value, ModuleSHA256: module}
+		}
+		payload = bound
+	}
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode secrets: %v", err)
+	}
+
+	aesKey := make([]byte, 32)
+	if _, err := rand.Read(aesKey); err != nil {
+		return "", fmt.Errorf("failed to generate secrets key: %v", err)
+	}
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, aesKey, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap secrets key: %v", err)
+	}
+
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	sealed := append(wrappedKey, nonce...)
+	sealed = gcm.Seal(sealed, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// bindSecrets moves the plaintext secrets and secret references of request
+// into its secret list, bound to its module. Sealed secrets, whose names are
+// those of sealed, and KMS secrets keep their values where they are and are
+// bound by name. KMS secrets are also decrypted under an encryption context
+// naming the module, which seals the binding if they were encrypted under it.
+func bindSecrets(request *protocol.WASMRequest, sealed map[string]string) {
+	module := moduleSHA256(request.WASMCode)
+	entries := map[string]protocol.Secret{}
+	for name, value := range request.Secrets {
+		entries[name] = protocol.Secret{Name: name, Value: value, ModuleSHA256: module}
+	}
+	for name, arn := range request.SecretRefs {
+		entries[name] = protocol.Secret{Name: name, Ref: arn, ModuleSHA256: module}
+	}
+	for name := range request.KMSSecrets {
+		entries[name] = protocol.Secret{Name: name, ModuleSHA256: module}
+		if request.KMSEncryptionContexts == nil {
+			request.KMSEncryptionContexts = make(map[string]map[string]string)
+		}
+		request.KMSEncryptionContexts[name] = map[string]string{protocol.KMSModuleContextKey: module}
+	}
+	if request.EncryptedSecrets != "" {
+		for name := range sealed {
+			entries[name] = protocol.Secret{Name: name, ModuleSHA256: module}
+		}
+	}
+
+	// In order, so that equal requests encode alike for the host's cache
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	request.SecretList = nil
+	for _, name := range names {
+		request.SecretList = append(request.SecretList, entries[name])
+	}
+	request.Secrets = nil
+	request.SecretRefs = nil
+}
+
+// moduleSHA256 returns the hex digest secrets are bound to wasmCode by
+func moduleSHA256(wasmCode string) string {
+	digest := sha256.Sum256([]byte(wasmCode))
+	return hex.EncodeToString(digest[:])
+}
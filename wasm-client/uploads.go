@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/pkg/client"
+)
+
+// uploadModule sends a module file to the enclave in chunks and returns
+// the upload ID the request runs it by
+func uploadModule(host *client.Client, servers []*protocol.Capabilities, file *os.File) string {
+	for _, server := range servers {
+		if err := checkCapabilities(server, protocol.WASMRequest{Type: protocol.RequestTypeUploadBegin}); err != nil {
+			fatal(exitUsage, "Unsupported request: %v", err)
+		}
+	}
+	info, err := file.Stat()
+	if err != nil {
+		fatal(exitUsage, "Failed to read WASM file: %v", err)
+	}
+
+	log.Printf("Uploading %d bytes in chunks of up to %d", info.Size(), protocol.MaxUploadChunk)
+	id, err := host.UploadModule(context.Background(), file, info.Size())
+	var refused *client.Error
+	if errors.As(err, &refused) {
+		fatal(errorExitCode(refused.Code), "Upload refused: %v", refused)
+	}
+	if err != nil {
+		fatal(exitUnavailable, "Upload failed: %v", err)
+	}
+	log.Printf("Uploaded module as %s", id)
+	return id
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"hello-wasm-enclave/internal/protocol"
+	"hello-wasm-enclave/pkg/client"
+)
+
+// validateCalls asks the enclave to describe the module and checks the
+// request's calls against its exports, so that a call the module cannot
+// take fails before the execution is sent, with its secrets
+func validateCalls(host *client.Client, servers []*protocol.Capabilities, request protocol.WASMRequest) {
+	describe := protocol.WASMRequest{
+		Type:            protocol.RequestTypeDescribeModule,
+		RequestID:       request.RequestID + "-describe",
+		CorrelationID:   request.CorrelationID,
+		WASMCode:        request.WASMCode,
+		ModuleName:      request.ModuleName,
+		ModuleRef:       request.ModuleRef,
+		ModuleSignature: request.ModuleSignature,
+		Features:        request.Features,
+		MaxMemoryPages:  request.MaxMemoryPages,
+	}
+	for _, server := range servers {
+		if err := checkCapabilities(server, describe); err != nil {
+			fatal(exitUsage, "Unsupported request: -validate needs modules described: %v", err)
+		}
+	}
+	response := roundTrip(host, describe)
+	if response.Module == nil {
+		fatal(errorExitCode(response.ErrorCode), "Failed to describe the module: %s", response.Error)
+	}
+	calls := request.FunctionCalls()
+	for _, call := range calls {
+		if err := checkCall(response.Module, call); err != nil {
+			fatal(exitUsage, "Invalid call: %v", err)
+		}
+	}
+	log.Println("The calls match the module's exports")
+}
+
+// checkCall checks that the module exports what a call calls, and that its
+// arguments and result type fit the function as the enclave passes them
+func checkCall(module *protocol.ModuleDescription, call protocol.Call) error {
+	if call.Table != "" {
+		table := findExport(module, call.Table, protocol.ItemTable)
+		switch {
+		case table == nil:
+			return fmt.Errorf("the module exports no table %q", call.Table)
+		case table.Type != protocol.ValueFuncref:
+			return fmt.Errorf("table %q holds %s, not functions", call.Table, table.Type)
+		}
+		// The element's type is only known once the table is filled
+		return nil
+	}
+
+	function := findExport(module, call.FunctionName, protocol.ItemFunc)
+	if function == nil {
+		exported := exportNames(module, protocol.ItemFunc)
+		if len(exported) == 0 {
+			return fmt.Errorf("the module exports no functions")
+		}
+		return fmt.Errorf("the module exports no function %q; it exports %s", call.FunctionName, strings.Join(exported, ", "))
+	}
+
+	// String and bytes arguments are passed as a (pointer, length) pair
+	var params []string
+	buffers := false
+	for _, value := range call.Values() {
+		if value.Type == protocol.ValueString || value.Type == protocol.ValueBytes {
+			params = append(params, protocol.ValueI32, protocol.ValueI32)
+			buffers = true
+		} else {
+			params = append(params, value.Type)
+		}
+	}
+	if strings.Join(params, " ") != strings.Join(function.Params, " ") {
+		return fmt.Errorf("%s takes (%s), the arguments give (%s)", call.FunctionName, strings.Join(function.Params, " "), strings.Join(params, " "))
+	}
+	if buffers && findExport(module, "memory", protocol.ItemMemory) == nil {
+		return fmt.Errorf("string and bytes arguments require an exported \"memory\" memory")
+	}
+
+	spec := call.Result()
+	switch {
+	case spec.Buffer():
+		// The layout decides what the function returns
+	case len(function.Results) > 1:
+		return fmt.Errorf("%s returns several values (%s); set the result type to string or bytes to read a (pointer, length) pair", call.FunctionName, strings.Join(function.Results, " "))
+	case spec.Type == "":
+	case len(function.Results) == 0:
+		return fmt.Errorf("%s returns nothing, not the requested %s", call.FunctionName, spec.Type)
+	case function.Results[0] != spec.Type:
+		return fmt.Errorf("%s returns %s, not the requested %s", call.FunctionName, function.Results[0], spec.Type)
+	}
+	return nil
+}
+
+// findExport returns the export of a kind with a name, or nil
+func findExport(module *protocol.ModuleDescription, name, kind string) *protocol.ModuleItem {
+	for i, item := range module.Exports {
+		if item.Name == name && item.Kind == kind {
+			return &module.Exports[i]
+		}
+	}
+	return nil
+}
+
+// exportNames returns the names of the exports of a kind
+func exportNames(module *protocol.ModuleDescription, kind string) []string {
+	var names []string
+	for _, item := range module.Exports {
+		if item.Kind == kind {
+			names = append(names, item.Name)
+		}
+	}
+	return names
+}